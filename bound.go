@@ -0,0 +1,161 @@
+package tokenizer
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"unicode/utf8"
+)
+
+// UpperBoundMode selects how UpperBound bounds the token count of a text.
+type UpperBoundMode int
+
+const (
+	// UpperBoundConfident counts ASCII as the estimator does and every other
+	// rune as one token per UTF-8 byte, the worst case of byte-level BPE for
+	// scripts it has no merges for. This is the default.
+	UpperBoundConfident UpperBoundMode = iota
+	// UpperBoundGuaranteed counts one token per input byte. No byte-level
+	// BPE encoding of the text can be longer.
+	UpperBoundGuaranteed
+)
+
+const (
+	// Mode names accepted by ParseUpperBoundMode.
+	BoundNameConfident  = "confident"
+	BoundNameGuaranteed = "guaranteed"
+
+	percentScale         = 100.0
+	minimumSafetyMargin  = 0.0
+	boundRoundingEpsilon = 1e-9
+
+	errUnknownBoundMsg  = "unknown upper-bound mode"
+	errUnknownBoundFmt  = "%w %q (want confident or guaranteed)"
+	errInvalidMarginMsg = "safety margin must be zero or positive"
+	errInvalidMarginFmt = "%w: %v"
+)
+
+var (
+	// ErrUnknownUpperBoundMode is returned by ParseUpperBoundMode.
+	ErrUnknownUpperBoundMode = errors.New(errUnknownBoundMsg)
+	// ErrInvalidSafetyMargin is returned by ValidateSafetyMargin.
+	ErrInvalidSafetyMargin = errors.New(errInvalidMarginMsg)
+)
+
+// WithUpperBoundMode sets how UpperBound bounds the count.
+func WithUpperBoundMode(mode UpperBoundMode) Option {
+	return func(t *Tokenizer) {
+		t.boundMode = mode
+	}
+}
+
+// WithSafetyMargin makes UpperBound add percent on top of the bound, for
+// budgets that must hold even when the bound is off. Negative values count
+// as zero.
+func WithSafetyMargin(percent float64) Option {
+	return func(t *Tokenizer) {
+		t.safetyMargin = max(percent, minimumSafetyMargin)
+	}
+}
+
+// ParseUpperBoundMode converts a mode name to its value.
+func ParseUpperBoundMode(name string) (UpperBoundMode, error) {
+	switch name {
+	case BoundNameConfident:
+		return UpperBoundConfident, nil
+	case BoundNameGuaranteed:
+		return UpperBoundGuaranteed, nil
+	default:
+		return UpperBoundConfident, fmt.Errorf(errUnknownBoundFmt, ErrUnknownUpperBoundMode, name)
+	}
+}
+
+// String returns the name accepted by ParseUpperBoundMode.
+func (m UpperBoundMode) String() string {
+	if m == UpperBoundGuaranteed {
+		return BoundNameGuaranteed
+	}
+
+	return BoundNameConfident
+}
+
+// ValidateSafetyMargin rejects negative and non-finite margins, for callers
+// that take the margin from user input.
+func ValidateSafetyMargin(percent float64) error {
+	if percent < minimumSafetyMargin || math.IsNaN(percent) || math.IsInf(percent, 0) {
+		return fmt.Errorf(errInvalidMarginFmt, ErrInvalidSafetyMargin, percent)
+	}
+
+	return nil
+}
+
+// UpperBound returns a count the text should not exceed, never below
+// EstimateTokens, plus the safety margin. With a model encoder the exact
+// count is its own bound. Special tokens follow the SpecialTokenPolicy.
+func (t *Tokenizer) UpperBound(text string) int {
+	bound := t.EstimateTokens(text)
+
+	if t.encoder == nil {
+		bound = max(bound, t.boundWithoutMargin(text))
+	}
+
+	return t.applySafetyMargin(bound)
+}
+
+func (t *Tokenizer) boundWithoutMargin(text string) int {
+	count := t.boundConfident
+	if t.boundMode == UpperBoundGuaranteed {
+		count = boundGuaranteed
+	}
+
+	if t.specialPolicy != SpecialTokenEscape {
+		if matches := t.findSpecialTokens(text); len(matches) > 0 {
+			return countAroundSpecialTokens(text, matches, count)
+		}
+	}
+
+	return count(text)
+}
+
+// applySafetyMargin scales n up by the margin, rounding up. The epsilon
+// keeps exact products such as 10 * 1.1 from rounding to 12.
+func (t *Tokenizer) applySafetyMargin(n int) int {
+	if t.safetyMargin == 0 {
+		return n
+	}
+
+	return int(math.Ceil(float64(n)*(1+t.safetyMargin/percentScale) - boundRoundingEpsilon))
+}
+
+func boundGuaranteed(text string) int {
+	return len(text)
+}
+
+// boundConfident counts ASCII letter and digit runs at CharsPerToken, each
+// ASCII symbol as one token and every other rune, or invalid byte, as one
+// token per byte.
+func (t *Tokenizer) boundConfident(text string) int {
+	count, run := 0, 0
+
+	for i := 0; i < len(text); {
+		r, size := utf8.DecodeRuneInString(text[i:])
+		i += size
+
+		if r <= maxASCII && !isSpecialChar(r) {
+			run++
+
+			continue
+		}
+
+		count += t.addAccumulatedCharTokens(run)
+		run = 0
+
+		if r <= maxASCII {
+			count++
+		} else {
+			count += size
+		}
+	}
+
+	return count + t.addAccumulatedCharTokens(run)
+}
@@ -0,0 +1,117 @@
+package tokenizer_test
+
+import (
+	"errors"
+	"math"
+	"strings"
+	"testing"
+
+	tokenizer "github.com/nnikolov3/ai-tokenizer"
+)
+
+const (
+	BoundFormat       = "%v, margin %v: UpperBound(%q) = %d, want %d"
+	BoundEncodeFormat = "UpperBound(%q) = %d, want the exact count %d"
+	BoundModeFormat   = "ParseUpperBoundMode(%q) = %v, %v; want %v"
+	BoundMarginFormat = "ValidateSafetyMargin(%v) = %v, want %v"
+
+	Cyrillic = "Привет мир"
+)
+
+type BoundTestCase struct {
+	name     string
+	input    string
+	mode     tokenizer.UpperBoundMode
+	margin   float64
+	expected int
+}
+
+func getBoundTestCases() []BoundTestCase {
+	return []BoundTestCase{
+		{"empty", "", tokenizer.UpperBoundConfident, 0, 0},
+		{"ascii matches estimate", HelloWorld, tokenizer.UpperBoundConfident, 0, 7},
+		{"guaranteed counts bytes", HelloWorld, tokenizer.UpperBoundGuaranteed, 0, 11},
+		// The estimate drops Cyrillic to 1 token; the bound counts its bytes.
+		{"non-ascii counts bytes", Cyrillic, tokenizer.UpperBoundConfident, 0, 19},
+		{"invalid byte is one token", "a\xffb", tokenizer.UpperBoundConfident, 0, 3},
+		{"margin rounds up", HelloWorld, tokenizer.UpperBoundConfident, 10, 8},
+		{"exact margin", strings.Repeat("a", 20), tokenizer.UpperBoundConfident, 10, 11},
+		{"negative margin is zero", HelloWorld, tokenizer.UpperBoundConfident, -50, 7},
+		// Two markers plus "user\nHi", 4 tokens or 7 bytes.
+		{"special tokens confident", ChatPrompt, tokenizer.UpperBoundConfident, 0, 6},
+		{"special tokens guaranteed", ChatPrompt, tokenizer.UpperBoundGuaranteed, 0, 9},
+	}
+}
+
+func TestUpperBound(t *testing.T) {
+	t.Parallel()
+
+	for _, testCase := range getBoundTestCases() {
+		t.Run(testCase.name, func(t *testing.T) {
+			t.Parallel()
+
+			tok := tokenizer.NewTokenizer(
+				tokenizer.WithUpperBoundMode(testCase.mode),
+				tokenizer.WithSafetyMargin(testCase.margin),
+			)
+
+			got := tok.UpperBound(testCase.input)
+			if got != testCase.expected {
+				t.Errorf(BoundFormat, testCase.mode, testCase.margin, testCase.input, got, testCase.expected)
+			}
+
+			if estimate := tok.EstimateTokens(testCase.input); got < estimate {
+				t.Errorf(BoundFormat, testCase.mode, testCase.margin, testCase.input, got, estimate)
+			}
+		})
+	}
+}
+
+func TestUpperBoundWithEncoder(t *testing.T) {
+	t.Parallel()
+
+	tok := tokenizer.NewTokenizer(
+		tokenizer.WithEncoder("bert", loadWordPiece(t)),
+		tokenizer.WithUpperBoundMode(tokenizer.UpperBoundGuaranteed),
+	)
+
+	exact := tok.EstimateTokens(HelloWorld)
+	if got := tok.UpperBound(HelloWorld); got != exact {
+		t.Errorf(BoundEncodeFormat, HelloWorld, got, exact)
+	}
+}
+
+func TestParseUpperBoundMode(t *testing.T) {
+	t.Parallel()
+
+	for _, mode := range []tokenizer.UpperBoundMode{
+		tokenizer.UpperBoundConfident, tokenizer.UpperBoundGuaranteed,
+	} {
+		got, err := tokenizer.ParseUpperBoundMode(mode.String())
+		if err != nil || got != mode {
+			t.Errorf(BoundModeFormat, mode.String(), got, err, mode)
+		}
+	}
+
+	_, err := tokenizer.ParseUpperBoundMode("loose")
+	if !errors.Is(err, tokenizer.ErrUnknownUpperBoundMode) {
+		t.Errorf(BoundModeFormat, "loose", nil, err, tokenizer.ErrUnknownUpperBoundMode)
+	}
+}
+
+func TestValidateSafetyMargin(t *testing.T) {
+	t.Parallel()
+
+	for _, margin := range []float64{0, 12.5} {
+		if err := tokenizer.ValidateSafetyMargin(margin); err != nil {
+			t.Errorf(BoundMarginFormat, margin, err, nil)
+		}
+	}
+
+	for _, margin := range []float64{-1, math.NaN(), math.Inf(1)} {
+		err := tokenizer.ValidateSafetyMargin(margin)
+		if !errors.Is(err, tokenizer.ErrInvalidSafetyMargin) {
+			t.Errorf(BoundMarginFormat, margin, err, tokenizer.ErrInvalidSafetyMargin)
+		}
+	}
+}
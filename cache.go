@@ -0,0 +1,214 @@
+package tokenizer
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	// DefaultCacheEntries is a cache size for callers without a better
+	// figure. Entries do not hold the text, so this is about 10 MiB.
+	DefaultCacheEntries = 65536
+
+	cacheKeySeparator = "\x00"
+)
+
+// cacheKey is the SHA-256 of a tokenizer's configuration and a text.
+type cacheKey [sha256.Size]byte
+
+// cacheEntry is the list element value of one cached count.
+type cacheEntry struct {
+	key   cacheKey
+	count int
+}
+
+// Cache is a concurrency-safe LRU cache of EstimateTokens results, keyed by
+// a hash of the text, the model name and the options that change counts.
+// One Cache can be shared by many tokenizers. Tokenizers sharing a Cache
+// must not give the same model name to different encoders.
+type Cache struct {
+	mu         sync.Mutex
+	entries    map[cacheKey]*list.Element
+	order      *list.List
+	maxEntries int
+	disabled   bool
+	hits       uint64
+	misses     uint64
+	evictions  uint64
+}
+
+// CacheStats is a snapshot of a cache's counters.
+type CacheStats struct {
+	Hits       uint64
+	Misses     uint64
+	Evictions  uint64
+	Entries    int
+	MaxEntries int
+}
+
+// HitRate returns the fraction of lookups that were hits, or 0 before any.
+func (s CacheStats) HitRate() float64 {
+	lookups := s.Hits + s.Misses
+	if lookups == 0 {
+		return 0
+	}
+
+	return float64(s.Hits) / float64(lookups)
+}
+
+// NewCache returns an empty cache holding at most maxEntries counts. A
+// cache with maxEntries of zero or less stores nothing.
+func NewCache(maxEntries int) *Cache {
+	return &Cache{
+		mu:         sync.Mutex{},
+		entries:    make(map[cacheKey]*list.Element),
+		order:      list.New(),
+		maxEntries: max(maxEntries, 0),
+		disabled:   false,
+		hits:       0,
+		misses:     0,
+		evictions:  0,
+	}
+}
+
+// WithCache makes EstimateTokens look counts up in c before computing
+// them. A nil cache disables caching.
+func WithCache(c *Cache) Option {
+	return func(t *Tokenizer) {
+		t.cache = c
+	}
+}
+
+// SetEnabled turns the cache on or off. A disabled cache keeps its entries
+// but neither looks them up nor counts hits and misses.
+func (c *Cache) SetEnabled(enabled bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.disabled = !enabled
+}
+
+// Purge removes every entry and resets the counters.
+func (c *Cache) Purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	clear(c.entries)
+	c.order.Init()
+	c.hits, c.misses, c.evictions = 0, 0, 0
+}
+
+// Stats returns the current counters.
+func (c *Cache) Stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return CacheStats{
+		Hits:       c.hits,
+		Misses:     c.misses,
+		Evictions:  c.evictions,
+		Entries:    c.order.Len(),
+		MaxEntries: c.maxEntries,
+	}
+}
+
+// active reports whether lookups are worth hashing the text for.
+func (c *Cache) active() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return !c.disabled && c.maxEntries > 0
+}
+
+// get returns the cached count for key, marking it most recently used.
+func (c *Cache) get(key cacheKey) (int, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.entries[key]
+	if !ok {
+		c.misses++
+
+		return 0, false
+	}
+
+	c.hits++
+	c.order.MoveToFront(elem)
+
+	entry, _ := elem.Value.(*cacheEntry)
+
+	return entry.count, true
+}
+
+// add stores a count, evicting the least recently used entries over the
+// limit.
+func (c *Cache) add(key cacheKey, count int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.entries[key]; ok {
+		c.order.MoveToFront(elem)
+
+		return
+	}
+
+	c.entries[key] = c.order.PushFront(&cacheEntry{key: key, count: count})
+
+	for c.order.Len() > c.maxEntries {
+		oldest := c.order.Back()
+		entry, _ := c.order.Remove(oldest).(*cacheEntry)
+
+		delete(c.entries, entry.key)
+		c.evictions++
+	}
+}
+
+// cacheScope encodes the configuration that changes counts, so tokenizers
+// with different options never share entries.
+func (t *Tokenizer) cacheScope() string {
+	parts := []string{
+		t.model,
+		strconv.Itoa(int(t.invalidUTF8)),
+		strconv.Itoa(int(t.specialPolicy)),
+	}
+
+	return strings.Join(append(parts, t.specials...), cacheKeySeparator) + cacheKeySeparator
+}
+
+func (t *Tokenizer) cacheKey(text string) cacheKey {
+	hash := sha256.New()
+
+	// Writes to a hash.Hash never fail.
+	_, _ = io.WriteString(hash, t.scope)
+	_, _ = io.WriteString(hash, text)
+
+	var key cacheKey
+
+	hash.Sum(key[:0])
+
+	return key
+}
+
+// cachedEstimate returns the cached count of text, computing and storing
+// it on a miss.
+func (t *Tokenizer) cachedEstimate(text string) int {
+	if !t.cache.active() {
+		return t.estimateTokens(text)
+	}
+
+	key := t.cacheKey(text)
+
+	count, found := t.cache.get(key)
+	if !found {
+		count = t.estimateTokens(text)
+		t.cache.add(key, count)
+	}
+
+	t.traceCache(text, found, count)
+
+	return count
+}
@@ -0,0 +1,162 @@
+package tokenizer_test
+
+import (
+	"strings"
+	"sync"
+	"testing"
+
+	tokenizer "github.com/nnikolov3/ai-tokenizer"
+)
+
+const (
+	CacheStatsFormat   = "Stats() = %+v, want %+v"
+	CacheHitRateFormat = "HitRate() = %v, want %v"
+
+	CacheWorkers = 8
+	CacheRounds  = 100
+)
+
+func TestCacheHitsAndMisses(t *testing.T) {
+	t.Parallel()
+
+	cache := tokenizer.NewCache(10)
+	tok := tokenizer.NewTokenizer(tokenizer.WithCache(cache))
+
+	for range 3 {
+		if got := tok.EstimateTokens(HelloWorld); got != 7 {
+			t.Errorf(EstimateTokensErrorFormat, HelloWorld, got, 7)
+		}
+	}
+
+	// Empty text is never looked up.
+	_ = tok.EstimateTokens("")
+
+	want := tokenizer.CacheStats{Hits: 2, Misses: 1, Evictions: 0, Entries: 1, MaxEntries: 10}
+	if got := cache.Stats(); got != want {
+		t.Errorf(CacheStatsFormat, got, want)
+	}
+
+	if got := cache.Stats().HitRate(); got != 2.0/3.0 {
+		t.Errorf(CacheHitRateFormat, got, 2.0/3.0)
+	}
+
+	cache.Purge()
+
+	want = tokenizer.CacheStats{Hits: 0, Misses: 0, Evictions: 0, Entries: 0, MaxEntries: 10}
+	if got := cache.Stats(); got != want {
+		t.Errorf(CacheStatsFormat, got, want)
+	}
+}
+
+func TestCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	t.Parallel()
+
+	cache := tokenizer.NewCache(2)
+	tok := tokenizer.NewTokenizer(tokenizer.WithCache(cache))
+
+	tok.EstimateTokens("a")
+	tok.EstimateTokens("b")
+	tok.EstimateTokens("a") // hit; "b" is now least recently used
+	tok.EstimateTokens("c") // evicts "b"
+	tok.EstimateTokens("a") // hit
+	tok.EstimateTokens("b") // miss, evicts "c"
+
+	want := tokenizer.CacheStats{Hits: 2, Misses: 4, Evictions: 2, Entries: 2, MaxEntries: 2}
+	if got := cache.Stats(); got != want {
+		t.Errorf(CacheStatsFormat, got, want)
+	}
+}
+
+func TestCacheDisabled(t *testing.T) {
+	t.Parallel()
+
+	cache := tokenizer.NewCache(10)
+	tok := tokenizer.NewTokenizer(tokenizer.WithCache(cache))
+
+	cache.SetEnabled(false)
+	tok.EstimateTokens(HelloWorld)
+	cache.SetEnabled(true)
+	tok.EstimateTokens(HelloWorld)
+
+	want := tokenizer.CacheStats{Hits: 0, Misses: 1, Evictions: 0, Entries: 1, MaxEntries: 10}
+	if got := cache.Stats(); got != want {
+		t.Errorf(CacheStatsFormat, got, want)
+	}
+
+	empty := tokenizer.NewCache(0)
+	tokenizer.NewTokenizer(tokenizer.WithCache(empty)).EstimateTokens(HelloWorld)
+
+	want = tokenizer.CacheStats{Hits: 0, Misses: 0, Evictions: 0, Entries: 0, MaxEntries: 0}
+	if got := empty.Stats(); got != want {
+		t.Errorf(CacheStatsFormat, got, want)
+	}
+}
+
+func TestCacheSeparatesOptions(t *testing.T) {
+	t.Parallel()
+
+	cache := tokenizer.NewCache(10)
+	allow := tokenizer.NewTokenizer(tokenizer.WithCache(cache))
+	escape := tokenizer.NewTokenizer(
+		tokenizer.WithCache(cache),
+		tokenizer.WithSpecialTokenPolicy(tokenizer.SpecialTokenEscape),
+	)
+	bert := tokenizer.NewTokenizer(
+		tokenizer.WithCache(cache),
+		tokenizer.WithEncoder("bert", loadWordPiece(t)),
+	)
+
+	bertCount := tokenizer.NewTokenizer(tokenizer.WithEncoder("bert", loadWordPiece(t))).EstimateTokens(ChatPrompt)
+
+	for _, check := range []struct {
+		tok  *tokenizer.Tokenizer
+		want int
+	}{{allow, 6}, {escape, 21}, {bert, bertCount}, {allow, 6}} {
+		if got := check.tok.EstimateTokens(ChatPrompt); got != check.want {
+			t.Errorf(EstimateTokensErrorFormat, ChatPrompt, got, check.want)
+		}
+	}
+
+	if got := cache.Stats(); got.Hits != 1 || got.Entries != 3 {
+		t.Errorf(CacheStatsFormat, got, "1 hit, 3 entries")
+	}
+}
+
+func TestCacheConcurrentUse(t *testing.T) {
+	t.Parallel()
+
+	cache := tokenizer.NewCache(4)
+	tok := tokenizer.NewTokenizer(tokenizer.WithCache(cache))
+	texts := []string{"a", "bb", "ccc ccc", "dddd", HelloWorld, strings.Repeat("e", 99)}
+
+	var wg sync.WaitGroup
+
+	for range CacheWorkers {
+		wg.Go(func() {
+			for i := range CacheRounds {
+				text := texts[i%len(texts)]
+				if got, want := tok.EstimateTokens(text), tokenizer.NewTokenizer().EstimateTokens(text); got != want {
+					t.Errorf(EstimateTokensErrorFormat, text, got, want)
+				}
+			}
+		})
+	}
+
+	wg.Wait()
+
+	stats := cache.Stats()
+	if stats.Hits+stats.Misses != CacheWorkers*CacheRounds || stats.Entries > 4 {
+		t.Errorf(CacheStatsFormat, stats, "every lookup counted, at most 4 entries")
+	}
+}
+
+func BenchmarkTokenizerEstimateCached(b *testing.B) {
+	tok := tokenizer.NewTokenizer(tokenizer.WithCache(tokenizer.NewCache(tokenizer.DefaultCacheEntries)))
+	text := BenchmarkEstimateText
+
+	b.ResetTimer()
+
+	for range b.N {
+		_ = tok.EstimateTokens(text)
+	}
+}
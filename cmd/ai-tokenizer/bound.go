@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+
+	tokenizer "github.com/nnikolov3/ai-tokenizer"
+)
+
+const (
+	MsgUpperBoundFmt       = "Upper Bound: %d (%s)\n"
+	MsgUpperBoundMarginFmt = "Upper Bound: %d (%s, +%g%% safety margin)\n"
+
+	ColUpperBound = "upperBound"
+)
+
+// parseSafetyMargin reads a -safety-margin percentage such as 10 or 12.5.
+func parseSafetyMargin(value string) (float64, error) {
+	margin, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0, err
+	}
+
+	return margin, tokenizer.ValidateSafetyMargin(margin)
+}
+
+// attachUpperBound adds the upper bound when -upper-bound or
+// -safety-margin is set.
+func attachUpperBound(flags *cliFlags, tok *tokenizer.Tokenizer, result *TokenResult, input string) {
+	if !flags.upperBound {
+		return
+	}
+
+	result.UpperBound = tok.UpperBound(input)
+	result.UpperBoundMode = flags.boundMode.String()
+	result.SafetyMargin = flags.safetyMargin
+}
+
+func writeUpperBound(w io.Writer, r *TokenResult) error {
+	if r.SafetyMargin > 0 {
+		_, err := fmt.Fprintf(w, MsgUpperBoundMarginFmt, r.UpperBound, r.UpperBoundMode, r.SafetyMargin)
+
+		return err
+	}
+
+	_, err := fmt.Fprintf(w, MsgUpperBoundFmt, r.UpperBound, r.UpperBoundMode)
+
+	return err
+}
+
+func hasUpperBound(results []*TokenResult) bool {
+	for _, r := range results {
+		if r.UpperBoundMode != "" {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,76 @@
+package main
+
+import "testing"
+
+// boundInput is 7 tokens for the heuristic and 11 bytes.
+const boundInput = "hello world"
+
+func TestRunMainUpperBound(t *testing.T) {
+	t.Parallel()
+
+	// "Привет мир" is 1 token for the heuristic and 19 UTF-8 bytes.
+	tests := []runMainTestCase{
+		{
+			name:       "confident bound",
+			args:       []string{"-upper-bound", "confident", "Привет мир"},
+			wantStdout: "Token Count: 1\nModel: simple\nUpper Bound: 19 (confident)\n",
+			wantCode:   ExitOK,
+		},
+		{
+			name:       "guaranteed bound",
+			args:       []string{"-upper-bound", "guaranteed", boundInput},
+			wantStdout: "Upper Bound: 11 (guaranteed)\n",
+			wantCode:   ExitOK,
+		},
+		{
+			name:       "margin implies bound",
+			args:       []string{"-safety-margin", "10", boundInput},
+			wantStdout: "Upper Bound: 8 (confident, +10% safety margin)\n",
+			wantCode:   ExitOK,
+		},
+		{
+			name:       "json reports both",
+			args:       []string{"-json", "-safety-margin", "10", boundInput},
+			wantStdout: "\"tokenCount\": 7,\n  \"upperBound\": 8,\n  \"upperBoundMode\": \"confident\",\n  \"safetyMargin\": 10\n",
+			wantCode:   ExitOK,
+		},
+		{
+			name:       "csv column",
+			args:       []string{"-format", "csv", "-upper-bound", "guaranteed", boundInput},
+			wantStdout: "args,simple,7,hello world,,11\n",
+			wantCode:   ExitOK,
+		},
+		{
+			name:       "limit checks the bound",
+			args:       []string{"-upper-bound", "guaranteed", "-max-tokens", "8", boundInput},
+			wantStdout: "Token Count: 7",
+			wantStderr: "args may have up to 11 tokens (limit 8)",
+			wantCode:   ExitLimitExceeded,
+		},
+		{
+			name:       "limit without bound",
+			args:       []string{"-max-tokens", "8", boundInput},
+			wantStdout: "Token Count: 7",
+			wantCode:   ExitOK,
+		},
+		{
+			name:       "negative margin",
+			args:       []string{"-safety-margin", "-5", boundInput},
+			wantStderr: "safety margin must be zero or positive",
+			wantCode:   ExitInputError,
+		},
+		{
+			name:       "unknown mode",
+			args:       []string{"-upper-bound", "loose", boundInput},
+			wantStderr: "unknown upper-bound mode",
+			wantCode:   ExitInputError,
+		},
+	}
+
+	for _, testCase := range tests {
+		t.Run(testCase.name, func(t *testing.T) {
+			t.Parallel()
+			runMainTest(t, testCase)
+		})
+	}
+}
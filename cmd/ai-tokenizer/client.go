@@ -0,0 +1,267 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	tokenizer "github.com/nnikolov3/ai-tokenizer"
+)
+
+const (
+	FlagNameMethod = "method"
+	FlagNameModel  = "model"
+
+	FlagHelpMethod      = "Call to make: estimate, normalize or encode"
+	FlagHelpClientModel = "Model: registered name or model file (default: " + tokenizer.DefaultModel + ")"
+	FlagHelpClientFile  = "Input file path (default: arguments or stdin)"
+
+	// ClientDialTimeout bounds the wait for a daemon before counting in
+	// process; a listening socket answers at once.
+	ClientDialTimeout = time.Second
+	// ClientCallTimeout bounds a call to a daemon that accepted it.
+	ClientCallTimeout = time.Minute
+
+	UsageClientFmt = "" +
+		"Usage: %s client [options] [text]\n\n" +
+		"Calls the daemon on its socket, or does the same work in process when\n" +
+		"no daemon is listening. Output is the count, the normalized text or\n" +
+		"the token IDs; -json prints the whole result.\n\n" +
+		"Options:\n"
+
+	errNoDaemonMsg      = "no daemon listening"
+	ErrUnknownMethodMsg = "unknown method"
+	ErrUnknownMethodFmt = "%w %q (want %s)"
+	ErrDaemonMsg        = "daemon error"
+	ErrDaemonFmt        = "%w: %s"
+	ErrWrapCallDaemon   = "call daemon: %w"
+)
+
+var (
+	// ErrUnknownMethod is returned for a -method the daemon does not have.
+	ErrUnknownMethod = errors.New(ErrUnknownMethodMsg)
+	// ErrDaemon is returned when the daemon answers a call with an error.
+	ErrDaemon = errors.New(ErrDaemonMsg)
+)
+
+// clientFlags collects the flags of the client command.
+type clientFlags struct {
+	socket string
+	method string
+	model  string
+	file   string
+	json   bool
+	text   []string
+}
+
+// runClient implements the client command.
+func runClient(args []string, streams cliStreams) error {
+	flags, err := parseClientFlags(args, streams.stderr)
+	if err != nil {
+		return reportedInputError(err)
+	}
+
+	if !slices.Contains(daemonMethods(), flags.method) {
+		return inputError(fmt.Errorf(ErrUnknownMethodFmt, ErrUnknownMethod, flags.method, methodList()))
+	}
+
+	text, err := readClientInput(flags, streams.stdin)
+	if err != nil {
+		return err
+	}
+
+	params := daemonParams{Text: text, Model: absModelPath(flags.model)}
+
+	result, err := callDaemon(flags.socket, flags.method, params)
+	if errors.Is(err, errNoDaemon) {
+		result, err = callModel(newModelCache(nil), flags.method, params)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if flags.json {
+		return ioError(writeJSON(streams.stdout, result))
+	}
+
+	return ioError(writeClientResult(streams.stdout, result))
+}
+
+func parseClientFlags(args []string, stderr io.Writer) (*clientFlags, error) {
+	fs := flag.NewFlagSet(ExecutableDefault+" "+CommandClient, flag.ContinueOnError)
+	fs.SetOutput(stderr)
+
+	socket := fs.String(FlagNameSocket, defaultSocketPath(), FlagHelpSocket)
+	method := fs.String(FlagNameMethod, DaemonMethodEstimate, FlagHelpMethod)
+	model := fs.String(FlagNameModel, "", FlagHelpClientModel)
+	file := fs.String(FlagNameFile, "", FlagHelpClientFile)
+	outputJSON := fs.Bool(FlagNameJSON, false, FlagHelpJSON)
+
+	fs.Usage = func() { _ = printCommandUsage(stderr, fs, UsageClientFmt) }
+
+	err := fs.Parse(args)
+	if err != nil {
+		return nil, err
+	}
+
+	return &clientFlags{
+		socket: *socket,
+		method: *method,
+		model:  *model,
+		file:   *file,
+		json:   *outputJSON,
+		text:   fs.Args(),
+	}, nil
+}
+
+// readClientInput takes the text from -file, the arguments or stdin.
+func readClientInput(flags *clientFlags, stdin io.Reader) (string, error) {
+	switch {
+	case flags.file != "":
+		text, err := readFile(flags.file)
+		if err != nil {
+			return "", fileError(err)
+		}
+
+		return text, nil
+	case len(flags.text) > 0:
+		return strings.Join(flags.text, " "), nil
+	default:
+		text, err := readStdin(stdin)
+		if err != nil {
+			return "", ioError(err)
+		}
+
+		return text, nil
+	}
+}
+
+// errNoDaemon marks a socket nobody listens on, so the client falls back.
+var errNoDaemon = errors.New(errNoDaemonMsg)
+
+// callDaemon makes one call on the socket. It returns errNoDaemon when the
+// socket cannot be dialled, or when checkSocket finds that another user
+// could have put it there, so the text is never sent to their process.
+func callDaemon(socket, method string, params daemonParams) (any, error) {
+	if checkSocket(socket) != nil {
+		return nil, errNoDaemon
+	}
+
+	conn, err := net.DialTimeout(SocketNetwork, socket, ClientDialTimeout)
+	if err != nil {
+		return nil, errNoDaemon
+	}
+
+	defer func() { _ = conn.Close() }()
+
+	err = conn.SetDeadline(time.Now().Add(ClientCallTimeout))
+	if err != nil {
+		return nil, ioError(fmt.Errorf(ErrWrapCallDaemon, err))
+	}
+
+	raw, err := callRPC(conn, method, params)
+	if err != nil {
+		return nil, err
+	}
+
+	return decodeDaemonResult(method, raw)
+}
+
+// callRPC sends a request with id 1 on conn and reads its response line.
+func callRPC(conn io.ReadWriter, method string, params any) (json.RawMessage, error) {
+	data, err := json.Marshal(params)
+	if err != nil {
+		return nil, fmt.Errorf(ErrWrapCallDaemon, err)
+	}
+
+	err = writeRPC(conn, rpcRequest{JSONRPC: RPCVersion, ID: json.RawMessage("1"), Method: method, Params: data})
+	if err != nil {
+		return nil, ioError(fmt.Errorf(ErrWrapCallDaemon, err))
+	}
+
+	line, err := bufio.NewReader(conn).ReadBytes('\n')
+	if err != nil {
+		return nil, ioError(fmt.Errorf(ErrWrapCallDaemon, err))
+	}
+
+	var resp struct {
+		Result json.RawMessage `json:"result"`
+		Error  *rpcError       `json:"error"`
+	}
+
+	err = json.Unmarshal(line, &resp)
+	if err != nil {
+		return nil, ioError(fmt.Errorf(ErrWrapCallDaemon, err))
+	}
+
+	if resp.Error != nil {
+		return nil, inputError(fmt.Errorf(ErrDaemonFmt, ErrDaemon, resp.Error.Message))
+	}
+
+	return resp.Result, nil
+}
+
+// decodeDaemonResult decodes a result into the type callModel returns
+// for method, so both paths print alike.
+func decodeDaemonResult(method string, raw json.RawMessage) (any, error) {
+	var (
+		result any
+		err    error
+	)
+
+	switch method {
+	case DaemonMethodNormalize:
+		var r NormalizeResult
+		err = json.Unmarshal(raw, &r)
+		result = r
+	case DaemonMethodEncode:
+		var r EncodeResult
+		err = json.Unmarshal(raw, &r)
+		result = r
+	default:
+		var r CountResult
+		err = json.Unmarshal(raw, &r)
+		result = r
+	}
+
+	if err != nil {
+		return nil, ioError(fmt.Errorf(ErrWrapCallDaemon, err))
+	}
+
+	return result, nil
+}
+
+// writeClientResult prints the plain form of a result.
+func writeClientResult(w io.Writer, result any) error {
+	var line string
+
+	switch r := result.(type) {
+	case NormalizeResult:
+		line = r.Text
+	case EncodeResult:
+		ids := make([]string, 0, len(r.IDs))
+		for _, id := range r.IDs {
+			ids = append(ids, strconv.Itoa(id))
+		}
+
+		line = strings.Join(ids, " ")
+	case CountResult:
+		line = strconv.Itoa(r.Tokens)
+	}
+
+	_, err := fmt.Fprintln(w, line)
+	if err != nil {
+		return fmt.Errorf(ErrWrapWriteOutput, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,58 @@
+package main
+
+const (
+	// CommandEval measures estimate error against reference counts.
+	CommandEval = "eval"
+	// CommandMCP serves the token tools over the Model Context Protocol.
+	CommandMCP = "mcp"
+	// CommandDaemon serves estimates on a Unix socket with models kept loaded.
+	CommandDaemon = "daemon"
+	// CommandClient calls the daemon, or counts in process without one.
+	CommandClient = "client"
+	// CommandLSP shows token counts in editors over the Language Server Protocol.
+	CommandLSP = "lsp"
+	// CommandGit reports token growth between two revisions of a repository.
+	CommandGit = "git"
+	// CommandDiff counts the tokens of the lines of a unified diff.
+	CommandDiff = "diff"
+
+	UsageCommands = "" +
+		"Commands:\n" +
+		"  eval    Measure estimate error against reference token counts (eval -h)\n" +
+		"  mcp     Serve token tools to AI assistants over MCP on stdio (mcp -h)\n" +
+		"  daemon  Serve estimates on a Unix socket with models kept loaded (daemon -h)\n" +
+		"  client  Ask the daemon, or count in process without one (client -h)\n" +
+		"  lsp     Show token counts, costs and budget warnings in editors (lsp -h)\n" +
+		"  git     Report token growth of changed files between revisions (git -h)\n" +
+		"  diff    Count added, removed and context tokens of a unified diff (diff -h)\n\n"
+)
+
+// subcommand runs a command with the arguments that follow its name.
+type subcommand func(args []string, streams cliStreams) error
+
+// lookupSubcommand returns the command named by the first argument. Text
+// that happens to be a command name can still be counted with -text.
+func lookupSubcommand(args []string) (subcommand, bool) {
+	if len(args) == 0 {
+		return nil, false
+	}
+
+	switch args[0] {
+	case CommandEval:
+		return runEval, true
+	case CommandMCP:
+		return runMCP, true
+	case CommandDaemon:
+		return runDaemon, true
+	case CommandClient:
+		return runClient, true
+	case CommandLSP:
+		return runLSP, true
+	case CommandGit:
+		return runGit, true
+	case CommandDiff:
+		return runDiff, true
+	default:
+		return nil, false
+	}
+}
@@ -0,0 +1,303 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	tokenizer "github.com/nnikolov3/ai-tokenizer"
+)
+
+// ModelCount is one model's count of an input in a comparison. Delta and
+// DeltaPercent are relative to the baseline model; Cost is set when the
+// price table lists the model.
+type ModelCount struct {
+	Model        string   `json:"model"`
+	TokenCount   int      `json:"tokenCount"`
+	Delta        int      `json:"delta"`
+	DeltaPercent float64  `json:"deltaPercent"`
+	Cost         *float64 `json:"cost,omitempty"`
+}
+
+const (
+	// ModelListSeparator splits the -models value.
+	ModelListSeparator = ","
+	// TokensPerPrice is the token count prices are quoted for: USD per
+	// million input tokens.
+	TokensPerPrice = 1_000_000
+
+	CompareTableHeader = "MODEL\tTOKENS\tDELTA\tDELTA%\tCOST\n"
+	CompareTableRowFmt = "%s\t%d\t%+d\t%+.1f%%\t%s\n"
+	CompareBaselineFmt = "%s (baseline)"
+	CompareCostPrefix  = "$"
+	CompareNoCost      = "-"
+
+	ColBaseline     = "baseline"
+	ColDelta        = "delta"
+	ColDeltaPercent = "deltaPercent"
+	ColCost         = "cost"
+
+	ErrUnknownBaselineMsg = "baseline is not one of the compared models"
+	ErrUnknownBaselineFmt = "%w: %q"
+	ErrReadPricesFmt      = "read price table %q: %w"
+	ErrParsePricesFmt     = "parse price table %q: %w"
+	ErrWrapCompareTable   = "write comparison table: %w"
+)
+
+// ErrUnknownBaseline is returned when -baseline names a model that is not
+// being compared.
+var ErrUnknownBaseline = errors.New(ErrUnknownBaselineMsg)
+
+// comparison counts every input under several models.
+type comparison struct {
+	tokenizers []*tokenizer.Tokenizer
+	baseline   *tokenizer.Tokenizer
+	prices     map[string]float64
+}
+
+// newComparison resolves -models, -baseline and -prices. It returns nil
+// when none is set; without -models only the current model is listed.
+// current is the tokenizer built from the other flags and is reused when
+// -models names it.
+func newComparison(flags *cliFlags, current *tokenizer.Tokenizer) (*comparison, error) {
+	if flags.models == "" && flags.baseline == "" && flags.prices == "" {
+		return nil, nil
+	}
+
+	names := splitModelList(flags.models)
+	if len(names) == 0 {
+		names = []string{current.GetModel()}
+	}
+
+	comp := &comparison{tokenizers: make([]*tokenizer.Tokenizer, 0, len(names)), baseline: nil, prices: nil}
+
+	for _, name := range names {
+		tok, err := resolveModel(name, current, tokenizerOptions(flags), wordPieceOptions(flags)...)
+		if err != nil {
+			return nil, err
+		}
+
+		comp.tokenizers = append(comp.tokenizers, tok)
+	}
+
+	err := comp.selectBaseline(flags.baseline, names)
+	if err != nil {
+		return nil, err
+	}
+
+	comp.prices, err = loadPrices(flags.prices)
+	if err != nil {
+		return nil, err
+	}
+
+	return comp, nil
+}
+
+func splitModelList(value string) []string {
+	var names []string
+
+	for name := range strings.SplitSeq(value, ModelListSeparator) {
+		name = strings.TrimSpace(name)
+		if name != "" && !slices.Contains(names, name) {
+			names = append(names, name)
+		}
+	}
+
+	return names
+}
+
+// resolveModel accepts a registered model name or a model file path.
+// current is returned as is when it already has that name.
+func resolveModel(
+	name string,
+	current *tokenizer.Tokenizer,
+	opts []tokenizer.Option,
+	wordPiece ...tokenizer.WordPieceOption,
+) (*tokenizer.Tokenizer, error) {
+	if name == current.GetModel() {
+		return current, nil
+	}
+
+	if isModelFile(name) {
+		return newFileTokenizer(name, opts, wordPiece...)
+	}
+
+	tok, err := tokenizer.NewTokenizerForModel(name, opts...)
+	if err != nil {
+		return nil, inputError(err)
+	}
+
+	return tok, nil
+}
+
+// selectBaseline picks the model named by -baseline, as written in -models
+// or by its resolved name, or else the first model.
+func (c *comparison) selectBaseline(baseline string, names []string) error {
+	if baseline == "" {
+		c.baseline = c.tokenizers[0]
+
+		return nil
+	}
+
+	for i, tok := range c.tokenizers {
+		if names[i] == baseline || tok.GetModel() == baseline {
+			c.baseline = tok
+
+			return nil
+		}
+	}
+
+	return inputError(fmt.Errorf(ErrUnknownBaselineFmt, ErrUnknownBaseline, baseline))
+}
+
+// loadPrices reads a JSON object of model name to USD per million tokens.
+func loadPrices(path string) (map[string]float64, error) {
+	if path == "" {
+		return nil, nil
+	}
+
+	data, err := readFile(path)
+	if err != nil {
+		return nil, fileError(fmt.Errorf(ErrReadPricesFmt, path, err))
+	}
+
+	var prices map[string]float64
+
+	err = json.Unmarshal([]byte(data), &prices)
+	if err != nil {
+		return nil, inputError(fmt.Errorf(ErrParsePricesFmt, path, err))
+	}
+
+	return prices, nil
+}
+
+// count returns every model's count of text, in -models order.
+func (c *comparison) count(text string) []ModelCount {
+	base := c.baseline.EstimateTokens(text)
+	counts := make([]ModelCount, 0, len(c.tokenizers))
+
+	for _, tok := range c.tokenizers {
+		n := tok.EstimateTokens(text)
+		entry := ModelCount{
+			Model:        tok.GetModel(),
+			TokenCount:   n,
+			Delta:        n - base,
+			DeltaPercent: deltaPercent(n, base),
+			Cost:         nil,
+		}
+
+		if price, ok := c.prices[entry.Model]; ok {
+			cost := float64(n) * price / TokensPerPrice
+			entry.Cost = &cost
+		}
+
+		counts = append(counts, entry)
+	}
+
+	return counts
+}
+
+func deltaPercent(n, base int) float64 {
+	if base == 0 {
+		return 0
+	}
+
+	return math.Round(float64(n-base)/float64(base)*ReportPercentScale*ReportPercentRound) / ReportPercentRound
+}
+
+// attachComparison adds the per-model counts when comparing.
+func attachComparison(comp *comparison, result *TokenResult, input string) {
+	if comp == nil {
+		return
+	}
+
+	result.Baseline = comp.baseline.GetModel()
+	result.Comparison = comp.count(input)
+}
+
+// writeComparisonTable prints the per-model counts of one result.
+func writeComparisonTable(w io.Writer, r *TokenResult) error {
+	tw := tabwriter.NewWriter(
+		w,
+		ReportTableMinWidth,
+		ReportTableTabWidth,
+		ReportTablePadding,
+		ReportTablePadChar,
+		0,
+	)
+
+	_, err := fmt.Fprint(tw, CompareTableHeader)
+
+	for i := 0; err == nil && i < len(r.Comparison); i++ {
+		entry := r.Comparison[i]
+
+		model := entry.Model
+		if model == r.Baseline {
+			model = fmt.Sprintf(CompareBaselineFmt, model)
+		}
+
+		_, err = fmt.Fprintf(tw, CompareTableRowFmt,
+			model, entry.TokenCount, entry.Delta, entry.DeltaPercent, formatCost(entry.Cost))
+	}
+
+	if err == nil {
+		err = tw.Flush()
+	}
+
+	if err != nil {
+		return fmt.Errorf(ErrWrapCompareTable, err)
+	}
+
+	return nil
+}
+
+func formatCost(cost *float64) string {
+	if cost == nil {
+		return CompareNoCost
+	}
+
+	return CompareCostPrefix + strconv.FormatFloat(*cost, 'f', -1, 64)
+}
+
+func comparisonRows(results []*TokenResult) ([]string, [][]string) {
+	header := []string{ColSource, ColModel, ColBaseline, ColTokenCount, ColDelta, ColDeltaPercent, ColCost}
+
+	var rows [][]string
+
+	for _, r := range results {
+		for _, entry := range r.Comparison {
+			cost := ""
+			if entry.Cost != nil {
+				cost = strconv.FormatFloat(*entry.Cost, 'f', -1, 64)
+			}
+
+			rows = append(rows, []string{
+				r.Source,
+				entry.Model,
+				r.Baseline,
+				strconv.Itoa(entry.TokenCount),
+				strconv.Itoa(entry.Delta),
+				strconv.FormatFloat(entry.DeltaPercent, 'f', PercentFmtDigit, 64),
+				cost,
+			})
+		}
+	}
+
+	return header, rows
+}
+
+func hasComparison(results []*TokenResult) bool {
+	for _, r := range results {
+		if len(r.Comparison) > 0 {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const (
+	compareModels = "simple," + testModelFile
+	testPrices    = `{"simple": 1.5, "unigram": 0.25}`
+)
+
+func writePrices(t *testing.T, content string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "prices.json")
+
+	err := os.WriteFile(path, []byte(content), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+func TestRunMainCompareModels(t *testing.T) {
+	t.Parallel()
+
+	prices := writePrices(t, testPrices)
+	badPrices := writePrices(t, "{")
+
+	// "hello world" is 7 tokens for the heuristic and 2 for unigram.model.
+	tests := []runMainTestCase{
+		{
+			name:       "first model is baseline",
+			args:       []string{"-models", compareModels, "hello world"},
+			wantStdout: "Token Count: 7\nModel: simple",
+			wantCode:   ExitOK,
+		},
+		{
+			name:       "table marks baseline",
+			args:       []string{"-models", compareModels, "hello world"},
+			wantStdout: "simple (baseline)  7       +0     +0.0%   -",
+			wantCode:   ExitOK,
+		},
+		{
+			name:       "relative delta",
+			args:       []string{"-models", compareModels, "hello world"},
+			wantStdout: "2       -5     -71.4%",
+			wantCode:   ExitOK,
+		},
+		{
+			name:       "table cost",
+			args:       []string{"-models", compareModels, "-prices", prices, "hello world"},
+			wantStdout: "-71.4%  $0.0000005",
+			wantCode:   ExitOK,
+		},
+		{
+			name:       "chosen baseline",
+			args:       []string{"-models", compareModels, "-baseline", "unigram", "hello world"},
+			wantStdout: "Token Count: 2\nModel: unigram",
+			wantCode:   ExitOK,
+		},
+		{
+			name: "json entries with cost",
+			args: []string{"-models", compareModels, "-prices", prices, "-json", "hello world"},
+			wantStdout: `"model": "unigram",
+      "tokenCount": 2,
+      "delta": -5,
+      "deltaPercent": -71.43,
+      "cost": 5e-7`,
+			wantCode: ExitOK,
+		},
+		{
+			name:       "csv rows per model",
+			args:       []string{"-models", compareModels, "-prices", prices, "-format", "csv", "hello world"},
+			wantStdout: "args,simple,simple,7,0,0.00,0.0000105\nargs,unigram,simple,2,-5,-71.43,0.0000005\n",
+			wantCode:   ExitOK,
+		},
+		{
+			name:       "unknown model",
+			args:       []string{"-models", "simple,gpt-9", hello},
+			wantStderr: "unknown model: \"gpt-9\"",
+			wantCode:   ExitInputError,
+		},
+		{
+			name:       "unknown baseline",
+			args:       []string{"-models", compareModels, "-baseline", "gpt-9", hello},
+			wantStderr: ErrUnknownBaselineMsg,
+			wantCode:   ExitInputError,
+		},
+		{
+			name:       "malformed price table",
+			args:       []string{"-prices", badPrices, hello},
+			wantStderr: "parse price table",
+			wantCode:   ExitInputError,
+		},
+	}
+
+	for _, testCase := range tests {
+		t.Run(testCase.name, func(t *testing.T) {
+			t.Parallel()
+			runMainTest(t, testCase)
+		})
+	}
+}
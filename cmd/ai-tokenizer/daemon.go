@@ -0,0 +1,442 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	tokenizer "github.com/nnikolov3/ai-tokenizer"
+	"github.com/nnikolov3/ai-tokenizer/metrics"
+)
+
+const (
+	// DaemonMethodEstimate counts the tokens of a text.
+	DaemonMethodEstimate = "estimate"
+	// DaemonMethodNormalize returns the text the model counts.
+	DaemonMethodNormalize = "normalize"
+	// DaemonMethodEncode returns the token IDs of a model file.
+	DaemonMethodEncode = "encode"
+
+	FlagNameSocket      = "socket"
+	FlagNameMetricsAddr = "metrics-addr"
+
+	FlagHelpSocket       = "Unix socket path (default: $XDG_RUNTIME_DIR/ai-tokenizer.sock)"
+	FlagHelpDaemonModels = "Comma-separated models to load at start: simple or model file paths"
+	FlagHelpMetricsAddr  = "Serve Prometheus metrics at /metrics on this TCP address, such as 127.0.0.1:9464"
+
+	// MetricsPath is where the daemon serves its metrics registry.
+	MetricsPath = "/metrics"
+	// metricsReadHeaderTimeout bounds how long a scrape may take to send
+	// its headers.
+	metricsReadHeaderTimeout = 5 * time.Second
+
+	SocketNetwork  = "unix"
+	MetricsNetwork = "tcp"
+	SocketFileName = ExecutableDefault + ".sock"
+	// SocketDirUserFmt names the private directory of the socket in the
+	// shared temp directory after the user, when there is no
+	// XDG_RUNTIME_DIR.
+	SocketDirUserFmt = ExecutableDefault + "-%d"
+	EnvRuntimeDir    = "XDG_RUNTIME_DIR"
+
+	UsageDaemonFmt = "" +
+		"Usage: %s daemon [options]\n\n" +
+		"Serves line-delimited JSON-RPC on a Unix socket with the methods\n" +
+		"estimate, normalize and encode, each taking {\"text\": ..., \"model\": ...}.\n" +
+		"Models stay loaded between calls. Stop it with SIGINT or SIGTERM.\n\n" +
+		"Options:\n"
+
+	ErrDaemonRunningMsg = "daemon already running"
+	ErrDaemonRunningFmt = "%w on %s"
+	ErrNotSocketFmt     = "%s exists and is not a socket"
+	ErrUnsafeSocketMsg  = "unsafe socket"
+	ErrSocketOwnerFmt   = "%w: %s is not owned by uid %d"
+	ErrSocketDirFmt     = "%w: %s is not a directory only its owner can write to"
+	ErrWrapListen       = "listen on %s: %w"
+	ErrWrapAccept       = "accept: %w"
+	ErrWrapMetrics      = "serve metrics on %s: %w"
+)
+
+var (
+	// ErrDaemonRunning is returned when another daemon answers on the
+	// socket.
+	ErrDaemonRunning = errors.New(ErrDaemonRunningMsg)
+	// ErrUnsafeSocket is returned when another user could own the socket
+	// or replace it.
+	ErrUnsafeSocket = errors.New(ErrUnsafeSocketMsg)
+)
+
+// daemonParams are the params of every daemon method. Model is a
+// registered name or an absolute model file path, the default model when
+// empty.
+type daemonParams struct {
+	Text  string `json:"text"`
+	Model string `json:"model,omitempty"`
+}
+
+// EncodeResult is the result of the encode method.
+type EncodeResult struct {
+	Model string `json:"model"`
+	IDs   []int  `json:"ids"`
+}
+
+// daemonMethods lists the methods in the order of the usage text.
+func daemonMethods() []string {
+	return []string{DaemonMethodEstimate, DaemonMethodNormalize, DaemonMethodEncode}
+}
+
+// modelCache keeps tokenizers loaded by model name or model file path, so
+// vocabularies are read once per process. With a metrics registry, each
+// tokenizer also caches counts, and its cache and estimates are recorded
+// in reg. It is safe for concurrent use.
+type modelCache struct {
+	mu         sync.Mutex
+	tokenizers map[string]*tokenizer.Tokenizer
+	reg        *metrics.Registry
+}
+
+// newModelCache returns an empty cache; reg may be nil.
+func newModelCache(reg *metrics.Registry) *modelCache {
+	return &modelCache{mu: sync.Mutex{}, tokenizers: make(map[string]*tokenizer.Tokenizer), reg: reg}
+}
+
+// get returns the tokenizer of name, loading it on first use. An empty
+// name is the default model.
+func (c *modelCache) get(name string) (*tokenizer.Tokenizer, error) {
+	if name == "" {
+		name = tokenizer.DefaultModel
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if tok, ok := c.tokenizers[name]; ok {
+		return tok, nil
+	}
+
+	var (
+		tok   *tokenizer.Tokenizer
+		err   error
+		opts  []tokenizer.Option
+		cache *tokenizer.Cache
+	)
+
+	if c.reg != nil {
+		cache = tokenizer.NewCache(tokenizer.DefaultCacheEntries)
+		opts = append(opts, tokenizer.WithCache(cache))
+	}
+
+	if isModelFile(name) {
+		tok, err = newFileTokenizer(name, opts)
+	} else {
+		tok, err = tokenizer.NewTokenizerForModel(name, opts...)
+		err = inputError(err)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if cache != nil {
+		c.reg.AddCache(tok.GetModel(), cache)
+	}
+
+	c.tokenizers[name] = tok
+
+	return tok, nil
+}
+
+// estimate counts text with tok, recording the estimate when there is a
+// registry.
+func (c *modelCache) estimate(tok *tokenizer.Tokenizer, text string) int {
+	if c.reg == nil {
+		return tok.EstimateTokens(text)
+	}
+
+	return c.reg.Estimate(tok, text)
+}
+
+// put stores a tokenizer loaded elsewhere under name.
+func (c *modelCache) put(name string, tok *tokenizer.Tokenizer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.tokenizers[name] = tok
+}
+
+// callModel runs a daemon method in this process. The client uses it
+// directly when no daemon is listening.
+func callModel(models *modelCache, method string, params daemonParams) (any, error) {
+	tok, err := models.get(params.Model)
+	if err != nil {
+		return nil, err
+	}
+
+	switch method {
+	case DaemonMethodNormalize:
+		return NormalizeResult{Model: tok.GetModel(), Text: tok.Normalize(params.Text)}, nil
+	case DaemonMethodEncode:
+		ids, err := tok.Encode(params.Text)
+		if err != nil {
+			return nil, inputError(err)
+		}
+
+		return EncodeResult{Model: tok.GetModel(), IDs: ids}, nil
+	default:
+		return CountResult{Model: tok.GetModel(), Tokens: models.estimate(tok, params.Text)}, nil
+	}
+}
+
+// daemonHandler answers daemon methods from models.
+func daemonHandler(models *modelCache) rpcHandler {
+	return func(method string, raw json.RawMessage) (any, *rpcError) {
+		if !slices.Contains(daemonMethods(), method) {
+			return nil, methodNotFound(method)
+		}
+
+		var params daemonParams
+
+		if rpcErr := decodeParams(raw, &params); rpcErr != nil {
+			return nil, rpcErr
+		}
+
+		result, err := callModel(models, method, params)
+		if err != nil {
+			return nil, invalidParams(err)
+		}
+
+		return result, nil
+	}
+}
+
+// daemonFlags collects the flags of the daemon command.
+type daemonFlags struct {
+	socket      string
+	models      string
+	metricsAddr string
+}
+
+// runDaemon implements the daemon command.
+func runDaemon(args []string, streams cliStreams) error {
+	flags, err := parseDaemonFlags(args, streams.stderr)
+	if err != nil {
+		return reportedInputError(err)
+	}
+
+	var reg *metrics.Registry
+	if flags.metricsAddr != "" {
+		reg = metrics.New()
+	}
+
+	cache := newModelCache(reg)
+	for _, name := range splitModelList(flags.models) {
+		_, err = cache.get(absModelPath(name))
+		if err != nil {
+			return err
+		}
+	}
+
+	ln, err := listenSocket(flags.socket)
+	if err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if reg != nil {
+		err = serveMetrics(ctx, flags.metricsAddr, reg)
+		if err != nil {
+			_ = ln.Close()
+
+			return err
+		}
+	}
+
+	return ioError(serveDaemon(ctx, ln, cache))
+}
+
+func parseDaemonFlags(args []string, stderr io.Writer) (*daemonFlags, error) {
+	fs := flag.NewFlagSet(ExecutableDefault+" "+CommandDaemon, flag.ContinueOnError)
+	fs.SetOutput(stderr)
+
+	socket := fs.String(FlagNameSocket, defaultSocketPath(), FlagHelpSocket)
+	models := fs.String(FlagNameModels, "", FlagHelpDaemonModels)
+	metricsAddr := fs.String(FlagNameMetricsAddr, "", FlagHelpMetricsAddr)
+
+	fs.Usage = func() { _ = printCommandUsage(stderr, fs, UsageDaemonFmt) }
+
+	err := fs.Parse(args)
+	if err != nil {
+		return nil, err
+	}
+
+	return &daemonFlags{socket: *socket, models: *models, metricsAddr: *metricsAddr}, nil
+}
+
+// serveMetrics serves reg at MetricsPath on addr in the background until
+// ctx is done. Listening happens before it returns, so a busy address is
+// reported at start.
+func serveMetrics(ctx context.Context, addr string, reg *metrics.Registry) error {
+	ln, err := net.Listen(MetricsNetwork, addr)
+	if err != nil {
+		return ioError(fmt.Errorf(ErrWrapMetrics, addr, err))
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle(MetricsPath, reg)
+
+	srv := &http.Server{Handler: mux, ReadHeaderTimeout: metricsReadHeaderTimeout}
+
+	context.AfterFunc(ctx, func() { _ = srv.Close() })
+
+	go func() { _ = srv.Serve(ln) }()
+
+	return nil
+}
+
+// defaultSocketPath is the per-user socket both commands agree on. Without
+// XDG_RUNTIME_DIR it lives in a directory of the temp directory that the
+// daemon creates private to the user.
+func defaultSocketPath() string {
+	if dir := os.Getenv(EnvRuntimeDir); dir != "" {
+		return filepath.Join(dir, SocketFileName)
+	}
+
+	return filepath.Join(os.TempDir(), fmt.Sprintf(SocketDirUserFmt, os.Getuid()), SocketFileName)
+}
+
+// absModelPath makes a model file path absolute, so the daemon and its
+// clients name it the same from any working directory.
+func absModelPath(name string) string {
+	if !isModelFile(name) {
+		return name
+	}
+
+	abs, err := filepath.Abs(name)
+	if err != nil {
+		return name
+	}
+
+	return abs
+}
+
+// listenSocket listens on path, replacing a socket left by a daemon that
+// did not shut down but refusing one that still answers. The directory of
+// path is created private to the user when missing, and must not let other
+// users replace the socket.
+func listenSocket(path string) (net.Listener, error) {
+	err := prepareSocketDir(path)
+	if err != nil {
+		return nil, err
+	}
+
+	ln, err := net.Listen(SocketNetwork, path)
+	if err == nil {
+		return ln, nil
+	}
+
+	info, statErr := os.Lstat(path)
+	if statErr != nil {
+		return nil, ioError(fmt.Errorf(ErrWrapListen, path, err))
+	}
+
+	if info.Mode().Type() != fs.ModeSocket {
+		return nil, inputError(fmt.Errorf(ErrWrapListen, path, fmt.Errorf(ErrNotSocketFmt, path)))
+	}
+
+	err = checkOwner(path, info)
+	if err != nil {
+		return nil, inputError(fmt.Errorf(ErrWrapListen, path, err))
+	}
+
+	conn, dialErr := net.Dial(SocketNetwork, path)
+	if dialErr == nil {
+		_ = conn.Close()
+
+		return nil, inputError(fmt.Errorf(ErrDaemonRunningFmt, ErrDaemonRunning, path))
+	}
+
+	err = os.Remove(path)
+	if err == nil {
+		ln, err = net.Listen(SocketNetwork, path)
+	}
+
+	if err != nil {
+		return nil, ioError(fmt.Errorf(ErrWrapListen, path, err))
+	}
+
+	return ln, nil
+}
+
+// serveDaemon answers each connection on ln in its own goroutine until ctx
+// is done, then closes the listener, which removes its socket, and every
+// open connection.
+func serveDaemon(ctx context.Context, ln net.Listener, models *modelCache) error {
+	var (
+		wg    sync.WaitGroup
+		mu    sync.Mutex
+		conns = make(map[net.Conn]struct{})
+	)
+
+	stop := context.AfterFunc(ctx, func() {
+		_ = ln.Close()
+
+		mu.Lock()
+		defer mu.Unlock()
+
+		for conn := range conns {
+			_ = conn.Close()
+		}
+	})
+	defer stop()
+
+	handle := daemonHandler(models)
+
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			wg.Wait()
+
+			if ctx.Err() != nil {
+				return nil
+			}
+
+			return fmt.Errorf(ErrWrapAccept, err)
+		}
+
+		mu.Lock()
+		conns[conn] = struct{}{}
+		mu.Unlock()
+
+		wg.Go(func() {
+			// A client that hangs up mid-request only ends its own connection.
+			_ = serveRPC(conn, conn, handle)
+
+			mu.Lock()
+			delete(conns, conn)
+			mu.Unlock()
+
+			_ = conn.Close()
+		})
+	}
+}
+
+// methodList names the methods for error messages.
+func methodList() string {
+	return strings.Join(daemonMethods(), ", ")
+}
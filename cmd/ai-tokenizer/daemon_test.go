@@ -0,0 +1,285 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/nnikolov3/ai-tokenizer/metrics"
+)
+
+const (
+	fmtDaemonServe  = "serveDaemon() = %v, want nil"
+	fmtDaemonSocket = "socket %s still exists after shutdown: %v"
+	fmtDaemonEncode = "encode result = %+v, want %d ids from %s"
+	fmtListenErr    = "listenSocket() error = %v, want %v"
+	fmtDaemonMetric = "daemon metrics lack %q:\n%s"
+	fmtSocketDir    = "socket directory %s mode = %v, %v; want %v"
+	fmtCallUnsafe   = "callDaemon() on %s = %v, %v; want %v"
+)
+
+// socketPath returns a socket path short enough for sun_path, which test
+// temp directories can exceed.
+func socketPath(t *testing.T) string {
+	t.Helper()
+
+	dir, err := os.MkdirTemp("", "ait")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { _ = os.RemoveAll(dir) })
+
+	return filepath.Join(dir, SocketFileName)
+}
+
+// startDaemon serves on a fresh socket until the test ends.
+func startDaemon(t *testing.T) string {
+	t.Helper()
+
+	path := socketPath(t)
+
+	ln, err := listenSocket(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+
+	go func() { done <- serveDaemon(ctx, ln, newModelCache(nil)) }()
+
+	t.Cleanup(func() {
+		cancel()
+
+		if err := <-done; err != nil {
+			t.Errorf(fmtDaemonServe, err)
+		}
+
+		if _, err := os.Lstat(path); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf(fmtDaemonSocket, path, err)
+		}
+	})
+
+	return path
+}
+
+func TestRunMainClient(t *testing.T) {
+	t.Parallel()
+
+	socket := startDaemon(t)
+	missing := socketPath(t)
+
+	tests := []runMainTestCase{
+		{
+			name:       "estimate via daemon",
+			args:       []string{CommandClient, "-" + FlagNameSocket, socket, boundInput},
+			wantStdout: "7\n",
+			wantCode:   ExitOK,
+		},
+		{
+			name:       "normalize via daemon",
+			args:       []string{CommandClient, "-" + FlagNameSocket, socket, "-" + FlagNameMethod, DaemonMethodNormalize, "café"},
+			wantStdout: "cafe\n",
+			wantCode:   ExitOK,
+		},
+		{
+			name:       "model file via daemon",
+			args:       []string{CommandClient, "-" + FlagNameSocket, socket, "-" + FlagNameModel, testModelFile, "-" + FlagNameJSON, boundInput},
+			wantStdout: `"model": "unigram"`,
+			wantCode:   ExitOK,
+		},
+		{
+			name:       "stdin via daemon",
+			args:       []string{CommandClient, "-" + FlagNameSocket, socket},
+			stdin:      boundInput,
+			wantStdout: "7\n",
+			wantCode:   ExitOK,
+		},
+		{
+			name:       "fallback without daemon",
+			args:       []string{CommandClient, "-" + FlagNameSocket, missing, boundInput},
+			wantStdout: "7\n",
+			wantCode:   ExitOK,
+		},
+		{
+			name:       "encode without encoder",
+			args:       []string{CommandClient, "-" + FlagNameSocket, socket, "-" + FlagNameMethod, DaemonMethodEncode, boundInput},
+			wantStderr: ErrDaemonMsg,
+			wantCode:   ExitInputError,
+		},
+		{
+			name:       "unknown method",
+			args:       []string{CommandClient, "-" + FlagNameMethod, "count", boundInput},
+			wantStderr: ErrUnknownMethodMsg,
+			wantCode:   ExitInputError,
+		},
+	}
+
+	for _, tt := range tests {
+		runMainTest(t, tt)
+	}
+}
+
+func TestClientEncodeMatchesFallback(t *testing.T) {
+	t.Parallel()
+
+	socket := startDaemon(t)
+	params := daemonParams{Text: boundInput, Model: absModelPath(testModelFile)}
+
+	viaDaemon, err := callDaemon(socket, DaemonMethodEncode, params)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	inProcess, err := callModel(newModelCache(nil), DaemonMethodEncode, params)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, ok := viaDaemon.(EncodeResult)
+	if want, _ := inProcess.(EncodeResult); !ok || len(got.IDs) == 0 || len(got.IDs) != len(want.IDs) {
+		t.Errorf(fmtDaemonEncode, viaDaemon, len(want.IDs), testModelFile)
+	}
+}
+
+func TestDaemonRecordsMetrics(t *testing.T) {
+	t.Parallel()
+
+	reg := metrics.New()
+	models := newModelCache(reg)
+	params := daemonParams{Text: boundInput, Model: ""}
+
+	for range 2 {
+		_, err := callModel(models, DaemonMethodEstimate, params)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var out bytes.Buffer
+
+	_, err := reg.WriteTo(&out)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{
+		metrics.MetricRequests + `{model="simple"} 2`,
+		metrics.MetricCacheHits + `{cache="simple"} 1`,
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf(fmtDaemonMetric, want, out.String())
+		}
+	}
+}
+
+func TestDaemonRejectsUnknownMethod(t *testing.T) {
+	t.Parallel()
+
+	conn, err := net.Dial(SocketNetwork, startDaemon(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer func() { _ = conn.Close() }()
+
+	_, err = callRPC(conn, "tokenize", daemonParams{Text: boundInput, Model: ""})
+	if !errors.Is(err, ErrDaemon) || !strings.Contains(err.Error(), methodNotFound("tokenize").Message) {
+		t.Errorf(fmtListenErr, err, ErrDaemon)
+	}
+}
+
+func TestListenSocket(t *testing.T) {
+	t.Parallel()
+
+	// A socket left behind by a daemon that died is replaced.
+	stale := socketPath(t)
+
+	ln, err := net.Listen(SocketNetwork, stale)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ln.(*net.UnixListener).SetUnlinkOnClose(false)
+	_ = ln.Close()
+
+	ln, err = listenSocket(stale)
+	if err != nil {
+		t.Fatalf(fmtListenErr, err, nil)
+	}
+
+	// One that still answers is refused.
+	_, err = listenSocket(stale)
+	if !errors.Is(err, ErrDaemonRunning) {
+		t.Errorf(fmtListenErr, err, ErrDaemonRunning)
+	}
+
+	_ = ln.Close()
+
+	// Other files are left alone.
+	plain := filepath.Join(t.TempDir(), "plain")
+
+	err = os.WriteFile(plain, nil, 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = listenSocket(plain)
+	if exitCode(err) != ExitInputError {
+		t.Errorf(fmtListenErr, err, ErrNotSocketFmt)
+	}
+}
+
+func TestListenSocketMakesPrivateDir(t *testing.T) {
+	t.Parallel()
+
+	dir := filepath.Join(filepath.Dir(socketPath(t)), "private")
+
+	ln, err := listenSocket(filepath.Join(dir, SocketFileName))
+	if err != nil {
+		t.Fatalf(fmtListenErr, err, nil)
+	}
+
+	_ = ln.Close()
+
+	info, err := os.Stat(dir)
+	if err != nil || info.Mode().Perm() != socketDirPerm {
+		t.Errorf(fmtSocketDir, dir, info, err, os.FileMode(socketDirPerm))
+	}
+}
+
+func TestSocketInSharedDirIsRefused(t *testing.T) {
+	t.Parallel()
+
+	// A directory anyone can write to, without the sticky bit of /tmp,
+	// lets another user swap the socket.
+	path := socketPath(t)
+
+	ln, err := listenSocket(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer func() { _ = ln.Close() }()
+
+	err = os.Chmod(filepath.Dir(path), 0o777)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = listenSocket(path)
+	if !errors.Is(err, ErrUnsafeSocket) {
+		t.Errorf(fmtListenErr, err, ErrUnsafeSocket)
+	}
+
+	result, err := callDaemon(path, DaemonMethodEstimate, daemonParams{Text: boundInput, Model: ""})
+	if !errors.Is(err, errNoDaemon) {
+		t.Errorf(fmtCallUnsafe, path, result, err, errNoDaemon)
+	}
+}
@@ -0,0 +1,295 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+
+	tokenizer "github.com/nnikolov3/ai-tokenizer"
+)
+
+// DiffReport is the output of the diff command: the tokens of the added,
+// removed and context lines of every file and hunk of a unified diff, and
+// of the whole diff as sent, with its cost when prices are given.
+type DiffReport struct {
+	Source  string     `json:"source"`
+	Model   string     `json:"model"`
+	Tokens  int        `json:"tokens"`
+	Cost    *float64   `json:"cost,omitempty"`
+	Added   int        `json:"added"`
+	Removed int        `json:"removed"`
+	Context int        `json:"context"`
+	Files   []DiffFile `json:"files"`
+}
+
+// DiffFile sums the hunks of one file. OldPath is set for renames.
+type DiffFile struct {
+	Path    string     `json:"path"`
+	OldPath string     `json:"oldPath,omitempty"`
+	Status  string     `json:"status"`
+	Binary  bool       `json:"binary,omitempty"`
+	Added   int        `json:"added"`
+	Removed int        `json:"removed"`
+	Context int        `json:"context"`
+	Hunks   []DiffHunk `json:"hunks"`
+}
+
+// DiffHunk is one hunk with the line ranges of its header.
+type DiffHunk struct {
+	OldStart int    `json:"oldStart"`
+	OldLines int    `json:"oldLines"`
+	NewStart int    `json:"newStart"`
+	NewLines int    `json:"newLines"`
+	Section  string `json:"section,omitempty"`
+	Added    int    `json:"added"`
+	Removed  int    `json:"removed"`
+	Context  int    `json:"context"`
+}
+
+const (
+	FlagHelpDiffFile      = "Unified diff to read (default: argument or stdin)"
+	FlagHelpDiffModelFile = "Count with a local model file (default: " + tokenizer.DefaultModel + ")"
+	FlagHelpDiffPrices    = "JSON price table of model name to USD per million tokens, for the cost of the diff"
+	FlagHelpDiffFormat    = "Output format: text or json"
+
+	UsageDiffFmt = "" +
+		"Usage: %s diff [options] [file.diff]\n\n" +
+		"Counts the tokens of the added, removed and context lines of every hunk\n" +
+		"and file of a unified diff, and of the whole diff as it would be sent,\n" +
+		"for example to a review bot.\n\n" +
+		"Options:\n"
+
+	DiffSummaryFmt  = "Diff %s: %d tokens as sent, model %s, cost %s\n\n"
+	DiffTableHeader = "STATUS\tFILE\tHUNK\tADDED\tREMOVED\tCONTEXT\n"
+	DiffFileRowFmt  = "%s\t%s\t\t%d\t%d\t%d\n"
+	DiffHunkRowFmt  = "\t\t%s\t%d\t%d\t%d\n"
+	DiffHunkFmt     = "-%d,%d +%d,%d"
+	DiffBinaryLabel = "binary"
+	DiffTotalLabel  = "TOTAL"
+
+	ErrDiffArgsMsg         = "give one diff, as -file or as an argument"
+	ErrUnknownDiffFormatFs = "%w %q (want text or json)"
+	ErrWrapDiffTable       = "write diff report: %w"
+)
+
+// ErrDiffArgs is returned for more than one diff: -file together with an
+// argument, or several arguments.
+var ErrDiffArgs = errors.New(ErrDiffArgsMsg)
+
+// diffFlags collects the flags of the diff command.
+type diffFlags struct {
+	file      string
+	args      []string
+	modelFile string
+	prices    string
+	format    string
+}
+
+// runDiff implements the diff command.
+func runDiff(args []string, streams cliStreams) error {
+	flags, err := parseDiffFlags(args, streams.stderr)
+	if err != nil {
+		return reportedInputError(err)
+	}
+
+	if len(flags.args) > 1 || (flags.file != "" && len(flags.args) > 0) {
+		return inputError(ErrDiffArgs)
+	}
+
+	if flags.file == "" && len(flags.args) > 0 {
+		flags.file = flags.args[0]
+	}
+
+	if flags.format != FormatText && flags.format != FormatJSON {
+		return inputError(fmt.Errorf(ErrUnknownDiffFormatFs, ErrUnknownFormat, flags.format))
+	}
+
+	tok := tokenizer.NewTokenizer()
+	if flags.modelFile != "" {
+		tok, err = newFileTokenizer(flags.modelFile, nil)
+		if err != nil {
+			return err
+		}
+	}
+
+	prices, err := loadPrices(flags.prices)
+	if err != nil {
+		return err
+	}
+
+	source, text, err := readDiff(flags.file, streams.stdin)
+	if err != nil {
+		return err
+	}
+
+	files, err := parseUnifiedDiff(strings.NewReader(text))
+	if err != nil {
+		return err
+	}
+
+	report := countDiff(tok, files)
+	report.Source = source
+	report.Tokens = tok.EstimateTokens(text)
+
+	if price, ok := prices[tok.GetModel()]; ok {
+		cost := float64(report.Tokens) * price / TokensPerPrice
+		report.Cost = &cost
+	}
+
+	if flags.format == FormatJSON {
+		return ioError(writeJSON(streams.stdout, report))
+	}
+
+	return ioError(writeDiffReport(streams.stdout, report))
+}
+
+func parseDiffFlags(args []string, stderr io.Writer) (*diffFlags, error) {
+	fs := flag.NewFlagSet(ExecutableDefault+" "+CommandDiff, flag.ContinueOnError)
+	fs.SetOutput(stderr)
+
+	file := fs.String(FlagNameFile, "", FlagHelpDiffFile)
+	modelFile := fs.String(FlagNameModelFile, "", FlagHelpDiffModelFile)
+	prices := fs.String(FlagNamePrices, "", FlagHelpDiffPrices)
+	format := fs.String(FlagNameFormat, FormatText, FlagHelpDiffFormat)
+	outputJSON := fs.Bool(FlagNameJSON, false, FlagHelpJSON)
+
+	fs.Usage = func() { _ = printCommandUsage(stderr, fs, UsageDiffFmt) }
+
+	err := fs.Parse(args)
+	if err != nil {
+		return nil, err
+	}
+
+	if *outputJSON {
+		*format = FormatJSON
+	}
+
+	return &diffFlags{file: *file, args: fs.Args(), modelFile: *modelFile, prices: *prices, format: *format}, nil
+}
+
+// countDiff counts each kind of line of every hunk on its own, so that a
+// hunk's added tokens are what its new lines cost without the others.
+func countDiff(tok *tokenizer.Tokenizer, files []diffFile) *DiffReport {
+	report := &DiffReport{
+		Source:  "",
+		Model:   tok.GetModel(),
+		Tokens:  0,
+		Cost:    nil,
+		Added:   0,
+		Removed: 0,
+		Context: 0,
+		Files:   make([]DiffFile, 0, len(files)),
+	}
+
+	for i := range files {
+		file := countDiffFile(tok, &files[i])
+
+		report.Added += file.Added
+		report.Removed += file.Removed
+		report.Context += file.Context
+		report.Files = append(report.Files, file)
+	}
+
+	return report
+}
+
+// countDiffFile counts each kind of line in every hunk of f. The lines are
+// counted without the tokens a model adds around every input, which would
+// otherwise be charged once per hunk and column.
+func countDiffFile(tok *tokenizer.Tokenizer, f *diffFile) DiffFile {
+	delta := fileDelta(f.newPath, f.oldPath, diffFileStatus(f), 0, 0)
+
+	file := DiffFile{
+		Path:    delta.Path,
+		OldPath: delta.OldPath,
+		Status:  delta.Status,
+		Binary:  f.binary,
+		Added:   0,
+		Removed: 0,
+		Context: 0,
+		Hunks:   make([]DiffHunk, 0, len(f.hunks)),
+	}
+
+	for i := range f.hunks {
+		h := &f.hunks[i]
+
+		hunk := DiffHunk{
+			OldStart: h.oldStart,
+			OldLines: h.oldLines,
+			NewStart: h.newStart,
+			NewLines: h.newLines,
+			Section:  h.section,
+			Added:    tok.EstimateContentTokens(h.text(DiffAdded)),
+			Removed:  tok.EstimateContentTokens(h.text(DiffRemoved)),
+			Context:  tok.EstimateContentTokens(h.text(DiffContext)),
+		}
+
+		file.Added += hunk.Added
+		file.Removed += hunk.Removed
+		file.Context += hunk.Context
+		file.Hunks = append(file.Hunks, hunk)
+	}
+
+	return file
+}
+
+// writeDiffReport prints a row per file, followed by a row per hunk.
+func writeDiffReport(w io.Writer, r *DiffReport) error {
+	_, err := fmt.Fprintf(w, DiffSummaryFmt, r.Source, r.Tokens, r.Model, formatCost(r.Cost))
+
+	tw := tabwriter.NewWriter(
+		w,
+		ReportTableMinWidth,
+		ReportTableTabWidth,
+		ReportTablePadding,
+		ReportTablePadChar,
+		0,
+	)
+
+	if err == nil {
+		_, err = fmt.Fprint(tw, DiffTableHeader)
+	}
+
+	for i := 0; err == nil && i < len(r.Files); i++ {
+		err = writeDiffFileRows(tw, &r.Files[i])
+	}
+
+	if err == nil {
+		_, err = fmt.Fprintf(tw, DiffFileRowFmt, DiffTotalLabel, "", r.Added, r.Removed, r.Context)
+	}
+
+	if err == nil {
+		err = tw.Flush()
+	}
+
+	if err != nil {
+		return fmt.Errorf(ErrWrapDiffTable, err)
+	}
+
+	return nil
+}
+
+func writeDiffFileRows(w io.Writer, f *DiffFile) error {
+	name := f.Path
+	if f.OldPath != "" {
+		name = fmt.Sprintf(GitRenameFmt, f.OldPath, f.Path)
+	}
+
+	status := f.Status
+	if f.Binary {
+		status = DiffBinaryLabel
+	}
+
+	_, err := fmt.Fprintf(w, DiffFileRowFmt, status, name, f.Added, f.Removed, f.Context)
+
+	for i := 0; err == nil && i < len(f.Hunks); i++ {
+		h := f.Hunks[i]
+		_, err = fmt.Fprintf(w, DiffHunkRowFmt,
+			fmt.Sprintf(DiffHunkFmt, h.OldStart, h.OldLines, h.NewStart, h.NewLines), h.Added, h.Removed, h.Context)
+	}
+
+	return err
+}
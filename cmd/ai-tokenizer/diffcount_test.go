@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	tokenizer "github.com/nnikolov3/ai-tokenizer"
+)
+
+const (
+	fmtDiffHunk   = "file %d hunk %d = %+v, want added %d removed %d context %d"
+	fmtDiffReport = "diff report = %+v, want %s"
+)
+
+func runDiffReport(t *testing.T, args ...string) DiffReport {
+	t.Helper()
+
+	var stdout bytes.Buffer
+
+	code := runMain(append([]string{CommandDiff, "-" + FlagNameJSON}, args...), cliStreams{
+		stdin:  strings.NewReader(testGitDiff),
+		stdout: &stdout,
+		stderr: io.Discard,
+	})
+	if code != ExitOK {
+		t.Fatalf(fmtRunMainCode, CommandDiff, code, ExitOK, "")
+	}
+
+	var report DiffReport
+
+	err := json.Unmarshal(stdout.Bytes(), &report)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return report
+}
+
+func TestDiffCounts(t *testing.T) {
+	t.Parallel()
+
+	// vocab.txt wraps every input in [CLS] and [SEP]; the lines of a hunk
+	// are counted without them, so the columns do not grow with the number
+	// of hunks.
+	for _, model := range []string{"", "../../testdata/vocab.txt"} {
+		tok := tokenizer.NewTokenizer()
+		args := []string{}
+
+		if model != "" {
+			var err error
+
+			tok, err = newFileTokenizer(model, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			args = append(args, "-"+FlagNameModelFile, model)
+		}
+
+		checkDiffCounts(t, tok, runDiffReport(t, args...))
+	}
+}
+
+func checkDiffCounts(t *testing.T, tok *tokenizer.Tokenizer, report DiffReport) {
+	t.Helper()
+
+	hunks := []struct {
+		file    int
+		hunk    int
+		added   string
+		removed string
+		context string
+	}{
+		{file: 0, hunk: 0, added: "world\n", removed: "-- dashes\n", context: "hello\nend\n"},
+		{file: 0, hunk: 1, added: "last\nmore\n", removed: "last", context: ""},
+		{file: 1, hunk: 0, added: "fresh\n", removed: "", context: ""},
+		{file: 2, hunk: 0, added: "", removed: "gone\n", context: ""},
+	}
+
+	added, removed, context := 0, 0, 0
+
+	for _, h := range hunks {
+		wantAdded := tok.EstimateContentTokens(h.added)
+		wantRemoved := tok.EstimateContentTokens(h.removed)
+		wantContext := tok.EstimateContentTokens(h.context)
+		added, removed, context = added+wantAdded, removed+wantRemoved, context+wantContext
+
+		got := report.Files[h.file].Hunks[h.hunk]
+		if got.Added != wantAdded || got.Removed != wantRemoved || got.Context != wantContext {
+			t.Errorf(fmtDiffHunk, h.file, h.hunk, got, wantAdded, wantRemoved, wantContext)
+		}
+	}
+
+	if report.Added != added || report.Removed != removed || report.Context != context {
+		t.Errorf(fmtDiffReport, report, "totals of the hunks")
+	}
+
+	if report.Tokens != tok.EstimateTokens(testGitDiff) || report.Source != SourceStdin || report.Cost != nil {
+		t.Errorf(fmtDiffReport, report, "the whole diff counted without a cost")
+	}
+
+	if f := report.Files[4]; !f.Binary || len(f.Hunks) != 0 || report.Files[3].OldPath != "a.md" {
+		t.Errorf(fmtDiffReport, report.Files, "a rename and a binary file")
+	}
+}
+
+func TestDiffCost(t *testing.T) {
+	t.Parallel()
+
+	prices := filepath.Join(t.TempDir(), "prices.json")
+
+	err := os.WriteFile(prices, []byte(`{"simple": 2}`), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	report := runDiffReport(t, "-"+FlagNamePrices, prices)
+
+	want := float64(report.Tokens) * 2 / TokensPerPrice
+	if report.Cost == nil || *report.Cost != want {
+		t.Errorf(fmtDiffReport, report, formatCost(&want))
+	}
+}
+
+func TestRunMainDiff(t *testing.T) {
+	t.Parallel()
+
+	tests := []runMainTestCase{
+		{
+			name:       "table",
+			args:       []string{CommandDiff},
+			stdin:      testGitDiff,
+			wantStdout: "modified  prompt.md",
+			wantCode:   ExitOK,
+		},
+		{
+			name:       "hunk rows",
+			args:       []string{CommandDiff},
+			stdin:      testGitDiff,
+			wantStdout: "-10,1 +10,2",
+			wantCode:   ExitOK,
+		},
+		{
+			name:       "binary",
+			args:       []string{CommandDiff},
+			stdin:      testGitDiff,
+			wantStdout: DiffBinaryLabel + "    logo.png",
+			wantCode:   ExitOK,
+		},
+		{
+			name:       "malformed diff",
+			args:       []string{CommandDiff},
+			stdin:      "--- a\n+++ b\n@@ -1,2 +1 @@\n",
+			wantStderr: ErrBadDiffMsg,
+			wantCode:   ExitInputError,
+		},
+		{
+			name:     "missing file",
+			args:     []string{CommandDiff, "missing.diff"},
+			wantCode: ExitInputError,
+		},
+		{
+			name:       "file and argument",
+			args:       []string{CommandDiff, "-" + FlagNameFile, "a.diff", "b.diff"},
+			wantStderr: ErrDiffArgsMsg,
+			wantCode:   ExitInputError,
+		},
+		{
+			name:       "two arguments",
+			args:       []string{CommandDiff, "a.diff", "b.diff"},
+			wantStderr: ErrDiffArgsMsg,
+			wantCode:   ExitInputError,
+		},
+		{
+			name:       "unknown format",
+			args:       []string{CommandDiff, "-" + FlagNameFormat, FormatCSV},
+			wantStderr: ErrUnknownFormatMsg,
+			wantCode:   ExitInputError,
+		},
+	}
+
+	for _, tt := range tests {
+		runMainTest(t, tt)
+	}
+}
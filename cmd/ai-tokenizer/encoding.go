@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/unicode"
+)
+
+const (
+	// Canonical encoding names accepted by -encoding and reported in
+	// TokenResult.Encoding.
+	EncodingAuto        = "auto"
+	EncodingUTF8        = "utf-8"
+	EncodingUTF16       = "utf-16"
+	EncodingUTF16LE     = "utf-16le"
+	EncodingUTF16BE     = "utf-16be"
+	EncodingLatin1      = "latin1"
+	EncodingWindows1252 = "windows-1252"
+
+	// utf16SniffLen bounds how much input the UTF-16 heuristic inspects.
+	utf16SniffLen = 4096
+	// utf16NULRatio is the share of NUL bytes in one byte lane that marks
+	// BOM-less UTF-16: mostly-ASCII text leaves every other byte zero.
+	utf16NULRatio = 0.3
+	// utf16MinSniff is the shortest input the UTF-16 heuristic trusts.
+	utf16MinSniff = 4
+	// utf16LaneSkew is how many times more NULs the zero-heavy lane must
+	// hold than the other; code points like U+0100 put a few NULs there too.
+	utf16LaneSkew = 10
+
+	ErrUnknownEncodingMsg = "unknown encoding"
+	ErrUnknownEncodingFmt = "%w %q (want auto, utf-8, utf-16, utf-16le, utf-16be, latin1 or windows-1252)"
+	ErrWrapDecodeFmt      = "decode %s as %s: %w"
+)
+
+// ErrUnknownEncoding is returned for an unsupported -encoding value.
+var ErrUnknownEncoding = errors.New(ErrUnknownEncodingMsg)
+
+var (
+	bomUTF8    = []byte{0xEF, 0xBB, 0xBF}
+	bomUTF16LE = []byte{0xFF, 0xFE}
+	bomUTF16BE = []byte{0xFE, 0xFF}
+
+	// encodingAliases maps accepted spellings to canonical names.
+	encodingAliases = map[string]string{
+		EncodingAuto:        EncodingAuto,
+		EncodingUTF8:        EncodingUTF8,
+		"utf8":              EncodingUTF8,
+		EncodingUTF16:       EncodingUTF16,
+		"utf16":             EncodingUTF16,
+		EncodingUTF16LE:     EncodingUTF16LE,
+		"utf16le":           EncodingUTF16LE,
+		EncodingUTF16BE:     EncodingUTF16BE,
+		"utf16be":           EncodingUTF16BE,
+		EncodingLatin1:      EncodingLatin1,
+		"latin-1":           EncodingLatin1,
+		"iso-8859-1":        EncodingLatin1,
+		EncodingWindows1252: EncodingWindows1252,
+		"cp1252":            EncodingWindows1252,
+	}
+)
+
+// parseEncoding resolves an -encoding value to its canonical name. Names
+// are matched case-insensitively, so "UTF-8" and "Latin1" are accepted.
+func parseEncoding(name string) (string, error) {
+	canonical, ok := encodingAliases[strings.ToLower(name)]
+	if !ok {
+		return "", fmt.Errorf(ErrUnknownEncodingFmt, ErrUnknownEncoding, name)
+	}
+
+	return canonical, nil
+}
+
+// decodeInput transcodes raw input to UTF-8. With EncodingAuto it sniffs a
+// byte order mark, then falls back to heuristics. It returns the text and
+// the encoding that was used.
+func decodeInput(raw []byte, name, source string) (string, string, error) {
+	if name == EncodingAuto {
+		name = detectEncoding(raw)
+	}
+
+	if name == EncodingUTF8 {
+		return string(bytes.TrimPrefix(raw, bomUTF8)), name, nil
+	}
+
+	decoded, err := encodingFor(name).NewDecoder().Bytes(raw)
+	if err != nil {
+		return "", name, fmt.Errorf(ErrWrapDecodeFmt, source, name, err)
+	}
+
+	return string(decoded), name, nil
+}
+
+// encodingFor returns the x/text encoding for a canonical non-UTF-8 name.
+// The UTF-16 variants honour a byte order mark when one is present.
+func encodingFor(name string) encoding.Encoding {
+	switch name {
+	case EncodingUTF16BE:
+		return unicode.UTF16(unicode.BigEndian, unicode.UseBOM)
+	case EncodingUTF16, EncodingUTF16LE:
+		return unicode.UTF16(unicode.LittleEndian, unicode.UseBOM)
+	case EncodingLatin1:
+		return charmap.ISO8859_1
+	default:
+		return charmap.Windows1252
+	}
+}
+
+// detectEncoding picks an encoding for input without an explicit -encoding.
+// Order: byte order mark, BOM-less UTF-16, then UTF-8. UTF-16 goes before
+// UTF-8 because ASCII in UTF-16 is also valid UTF-8. Invalid UTF-8 is never
+// guessed to be an 8-bit code page, so the invalid UTF-8 policy sees corrupt
+// and truncated documents; legacy code pages must be named with -encoding.
+func detectEncoding(raw []byte) string {
+	switch {
+	case bytes.HasPrefix(raw, bomUTF8):
+		return EncodingUTF8
+	case bytes.HasPrefix(raw, bomUTF16LE):
+		return EncodingUTF16LE
+	case bytes.HasPrefix(raw, bomUTF16BE):
+		return EncodingUTF16BE
+	}
+
+	if lane := utf16Lane(raw); lane != "" {
+		return lane
+	}
+
+	return EncodingUTF8
+}
+
+// utf16Lane detects BOM-less UTF-16 from NUL bytes concentrated in the odd
+// (little-endian) or even (big-endian) positions.
+func utf16Lane(raw []byte) string {
+	sample := raw[:min(len(raw), utf16SniffLen)]
+	if len(sample) < utf16MinSniff || len(sample)%2 != 0 {
+		return ""
+	}
+
+	evenNUL, oddNUL := 0, 0
+
+	for i, b := range sample {
+		if b != 0 {
+			continue
+		}
+
+		if i%2 == 0 {
+			evenNUL++
+		} else {
+			oddNUL++
+		}
+	}
+
+	lane := float64(len(sample) / 2)
+
+	switch {
+	case float64(oddNUL) >= lane*utf16NULRatio && evenNUL*utf16LaneSkew <= oddNUL:
+		return EncodingUTF16LE
+	case float64(evenNUL) >= lane*utf16NULRatio && oddNUL*utf16LaneSkew <= evenNUL:
+		return EncodingUTF16BE
+	default:
+		return ""
+	}
+}
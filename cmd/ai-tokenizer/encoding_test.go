@@ -0,0 +1,93 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+const (
+	fmtDecodeInput    = "decodeInput(%q, %q) = %q, %q, %v; want %q, %q"
+	fmtParseEncoding  = "parseEncoding(%q) = %q, %v; want %q"
+	fmtEncodingSource = "test input"
+)
+
+type decodeTestCase struct {
+	name         string
+	raw          string
+	encodingName string
+	wantText     string
+	wantEncoding string
+}
+
+func getDecodeTestCases() []decodeTestCase {
+	return []decodeTestCase{
+		{"plain utf-8", "café", EncodingAuto, "café", EncodingUTF8},
+		{"utf-8 bom stripped", "\xef\xbb\xbfhi", EncodingAuto, "hi", EncodingUTF8},
+		{"utf-16le bom", "\xff\xfeh\x00\xe9\x00", EncodingAuto, "hé", EncodingUTF16LE},
+		{"utf-16be bom", "\xfe\xff\x00h\x00\xe9", EncodingAuto, "hé", EncodingUTF16BE},
+		{"utf-16le without bom", "h\x00i\x00!\x00", EncodingAuto, "hi!", EncodingUTF16LE},
+		{"utf-16be without bom", "\x00h\x00i\x00!", EncodingAuto, "hi!", EncodingUTF16BE},
+		{"explicit utf-16 defaults to little endian", "h\x00i\x00", EncodingUTF16, "hi", EncodingUTF16},
+		{"explicit latin1", "caf\xe9 \x93", EncodingLatin1, "café \u0093", EncodingLatin1},
+		{"explicit windows-1252", "\x80", EncodingWindows1252, "€", EncodingWindows1252},
+		// Invalid UTF-8 is left to -invalid-utf8, even when it reads as cp1252.
+		{"corrupt utf-8 kept", "é\xff", EncodingAuto, "é\xff", EncodingUTF8},
+		{"8-bit text kept as utf-8", "caf\xe9 \x93q\x94", EncodingAuto, "caf\xe9 \x93q\x94", EncodingUTF8},
+		{"truncated utf-8 kept", "ab\xffcd", EncodingAuto, "ab\xffcd", EncodingUTF8},
+	}
+}
+
+func TestDecodeInput(t *testing.T) {
+	t.Parallel()
+
+	for _, testCase := range getDecodeTestCases() {
+		t.Run(testCase.name, func(t *testing.T) {
+			t.Parallel()
+			runDecodeTest(t, testCase)
+		})
+	}
+}
+
+func runDecodeTest(t *testing.T, testCase decodeTestCase) {
+	t.Helper()
+
+	text, used, err := decodeInput([]byte(testCase.raw), testCase.encodingName, fmtEncodingSource)
+	if err != nil || text != testCase.wantText || used != testCase.wantEncoding {
+		t.Errorf(fmtDecodeInput, testCase.raw, testCase.encodingName, text, used, err,
+			testCase.wantText, testCase.wantEncoding)
+	}
+}
+
+func TestDecodeInputOddUTF16(t *testing.T) {
+	t.Parallel()
+
+	// A dangling byte decodes to U+FFFD rather than failing.
+	raw := "h\x00i"
+
+	text, _, err := decodeInput([]byte(raw), EncodingUTF16LE, fmtEncodingSource)
+	if err != nil || text != "h\uFFFD" {
+		t.Errorf(fmtDecodeInput, raw, EncodingUTF16LE, text, EncodingUTF16LE, err, "h\uFFFD", EncodingUTF16LE)
+	}
+}
+
+func TestParseEncoding(t *testing.T) {
+	t.Parallel()
+
+	aliases := map[string]string{
+		"utf8":       EncodingUTF8,
+		"UTF16LE":    EncodingUTF16LE,
+		"UTF-8":      EncodingUTF8,
+		"Latin1":     EncodingLatin1,
+		"ebcdic":     "",
+		"cp1252":     EncodingWindows1252,
+		"iso-8859-1": EncodingLatin1,
+		"auto":       EncodingAuto,
+	}
+
+	for name, want := range aliases {
+		got, err := parseEncoding(name)
+		if got != want || (want == "") != errors.Is(err, ErrUnknownEncoding) {
+			t.Errorf(fmtParseEncoding, name, got, err, want)
+		}
+	}
+}
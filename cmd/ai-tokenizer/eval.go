@@ -0,0 +1,592 @@
+package main
+
+import (
+	"bufio"
+	"cmp"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"unicode"
+	"unicode/utf8"
+
+	tokenizer "github.com/nnikolov3/ai-tokenizer"
+)
+
+// EvalReport is the output of the eval command: error metrics of every
+// model against the reference counts in a JSONL file.
+type EvalReport struct {
+	Source  string      `json:"source"`
+	Samples int         `json:"samples"`
+	Models  []ModelEval `json:"models"`
+}
+
+// ModelEval is one model's error against the reference counts, overall and
+// grouped by dominant script and by length.
+type ModelEval struct {
+	Model    string       `json:"model"`
+	Overall  ErrorStats   `json:"overall"`
+	ByScript []ErrorStats `json:"byScript"`
+	ByLength []ErrorStats `json:"byLength"`
+	Worst    []EvalCase   `json:"worst"`
+}
+
+// ErrorStats summarises estimate error over a group of samples. Errors are
+// estimate minus reference, so a positive bias means overcounting. The
+// percentages skip samples whose reference count is zero.
+type ErrorStats struct {
+	Group       string  `json:"group,omitempty"`
+	Samples     int     `json:"samples"`
+	MAE         float64 `json:"meanAbsoluteError"`
+	MAPE        float64 `json:"meanAbsolutePercentError"`
+	Bias        float64 `json:"bias"`
+	BiasPercent float64 `json:"biasPercent"`
+}
+
+// EvalCase is one sample with a large error.
+type EvalCase struct {
+	Line      int    `json:"line"`
+	Reference int    `json:"reference"`
+	Estimate  int    `json:"estimate"`
+	Error     int    `json:"error"`
+	Preview   string `json:"preview"`
+}
+
+const (
+	FlagNameWorst = "worst"
+
+	FlagHelpEvalFile   = "JSONL file of {\"text\": ..., \"tokens\": N} samples (default: argument or stdin)"
+	FlagHelpEvalModels = "Comma-separated models to evaluate: simple or model file paths (default: simple)"
+	FlagHelpEvalFormat = "Output format: text or json"
+	FlagHelpWorst      = "Number of worst cases to list per model"
+
+	DefaultWorstCases = 5
+	EvalPreviewMax    = 40
+	EvalScanBuffer    = 64 * 1024
+	EvalMaxLine       = 64 * 1024 * 1024
+
+	// ScriptCommon groups samples without letters, such as numbers or code
+	// made only of punctuation.
+	ScriptCommon = "Common"
+	// ScriptUnknown groups letters outside every Unicode script table.
+	ScriptUnknown = "Unknown"
+
+	UsageEvalFmt = "" +
+		"Usage: %s eval [options] [file.jsonl]\n\n" +
+		"Compares token estimates with reference counts, one JSON object per\n" +
+		"line: {\"text\": \"Hello, world!\", \"tokens\": 4}\n\n" +
+		"Options:\n"
+
+	MsgEvalSamplesFmt = "Samples: %d\n"
+	MsgEvalMAEFmt     = "Mean absolute error: %.2f tokens\n"
+	MsgEvalMAPEFmt    = "Mean absolute percent error: %.2f%%\n"
+	MsgEvalBiasFmt    = "Bias: %+.2f tokens (%+.2f%%)\n"
+	EvalStatsHeader   = "%s\tSAMPLES\tMAE\tMAPE\tBIAS\tBIAS%%\n"
+	EvalStatsRowFmt   = "%s\t%d\t%.2f\t%.2f%%\t%+.2f\t%+.2f%%\n"
+	EvalWorstHeader   = "LINE\tREFERENCE\tESTIMATE\tERROR\tTEXT\n"
+	EvalWorstRowFmt   = "%d\t%d\t%d\t%+d\t%s\n"
+	EvalColScript     = "SCRIPT"
+	EvalColLength     = "RUNES"
+
+	ErrNoEvalSamplesMsg    = "no evaluation samples"
+	ErrInvalidEvalMsg      = "invalid evaluation sample"
+	ErrMissingTextMsg      = "missing \"text\""
+	ErrMissingTokensMsg    = "missing \"tokens\""
+	ErrNegativeTokensMsg   = "negative \"tokens\""
+	ErrEvalLineFmt         = "%s:%d: %w: %w"
+	ErrUnknownEvalFormatFs = "%w %q (want text or json)"
+	ErrWrapReadEval        = "read %s: %w"
+	ErrWrapWriteEval       = "write eval report: %w"
+)
+
+var (
+	// ErrNoEvalSamples is returned when the eval input has no samples.
+	ErrNoEvalSamples = errors.New(ErrNoEvalSamplesMsg)
+	// ErrInvalidEvalSample is returned for a malformed JSONL line.
+	ErrInvalidEvalSample = errors.New(ErrInvalidEvalMsg)
+)
+
+// lengthBucket groups samples whose rune count is below limit; the last
+// bucket has no limit.
+type lengthBucket struct {
+	label string
+	limit int
+}
+
+func lengthBuckets() []lengthBucket {
+	return []lengthBucket{
+		{label: "<100", limit: 100},
+		{label: "100-999", limit: 1000},
+		{label: "1000-9999", limit: 10000},
+		{label: ">=10000", limit: math.MaxInt},
+	}
+}
+
+// commonScripts are checked before the full Unicode script table, which
+// is a map and slower to scan.
+func commonScripts() []string {
+	return []string{
+		"Latin", "Cyrillic", "Greek", "Han", "Hiragana", "Katakana",
+		"Hangul", "Arabic", "Hebrew", "Devanagari", "Thai",
+	}
+}
+
+// evalFlags collects the flags of the eval command.
+type evalFlags struct {
+	file   string
+	models string
+	format string
+	worst  int
+}
+
+// evalSample is one reference count with its precomputed groups.
+type evalSample struct {
+	text      string
+	line      int
+	reference int
+	script    string
+	bucket    int
+}
+
+// evalLine is the JSON shape of one input line. Pointers tell a missing
+// field from an empty text or a zero count.
+type evalLine struct {
+	Text   *string `json:"text"`
+	Tokens *int    `json:"tokens"`
+}
+
+// runEval implements the eval command.
+func runEval(args []string, streams cliStreams) error {
+	flags, err := parseEvalFlags(args, streams.stderr)
+	if err != nil {
+		return reportedInputError(err)
+	}
+
+	if flags.format != FormatText && flags.format != FormatJSON {
+		return inputError(fmt.Errorf(ErrUnknownEvalFormatFs, ErrUnknownFormat, flags.format))
+	}
+
+	tokenizers, err := evalTokenizers(flags.models)
+	if err != nil {
+		return err
+	}
+
+	source, samples, err := readEvalSamples(flags.file, streams.stdin)
+	if err != nil {
+		return err
+	}
+
+	report := EvalReport{Source: source, Samples: len(samples), Models: make([]ModelEval, 0, len(tokenizers))}
+	for _, tok := range tokenizers {
+		report.Models = append(report.Models, evaluateModel(tok, samples, flags.worst))
+	}
+
+	if flags.format == FormatJSON {
+		return ioError(writeJSON(streams.stdout, report))
+	}
+
+	return ioError(writeEvalReport(streams.stdout, &report))
+}
+
+func parseEvalFlags(args []string, stderr io.Writer) (*evalFlags, error) {
+	fs := flag.NewFlagSet(ExecutableDefault+" "+CommandEval, flag.ContinueOnError)
+	fs.SetOutput(stderr)
+
+	file := fs.String(FlagNameFile, "", FlagHelpEvalFile)
+	models := fs.String(FlagNameModels, "", FlagHelpEvalModels)
+	format := fs.String(FlagNameFormat, FormatText, FlagHelpEvalFormat)
+	outputJSON := fs.Bool(FlagNameJSON, false, FlagHelpJSON)
+	worst := fs.Int(FlagNameWorst, DefaultWorstCases, FlagHelpWorst)
+
+	fs.Usage = func() { _ = printCommandUsage(stderr, fs, UsageEvalFmt) }
+
+	err := fs.Parse(args)
+	if err != nil {
+		return nil, err
+	}
+
+	if *outputJSON {
+		*format = FormatJSON
+	}
+
+	if *file == "" {
+		*file = fs.Arg(0)
+	}
+
+	return &evalFlags{file: *file, models: *models, format: *format, worst: max(*worst, 0)}, nil
+}
+
+// evalTokenizers resolves -models, or every registered model by default.
+func evalTokenizers(models string) ([]*tokenizer.Tokenizer, error) {
+	names := splitModelList(models)
+	if len(names) == 0 {
+		names = tokenizer.Models()
+	}
+
+	current := tokenizer.NewTokenizer()
+	tokenizers := make([]*tokenizer.Tokenizer, 0, len(names))
+
+	for _, name := range names {
+		tok, err := resolveModel(name, current, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		tokenizers = append(tokenizers, tok)
+	}
+
+	return tokenizers, nil
+}
+
+// readEvalSamples reads the JSONL samples from path, or from stdin when
+// path is empty or "-". It returns the source name for the report.
+func readEvalSamples(path string, stdin io.Reader) (string, []evalSample, error) {
+	if path == "" || path == "-" {
+		samples, err := parseEvalSamples(SourceStdin, stdin)
+
+		return SourceStdin, samples, err
+	}
+
+	data, err := readFile(path)
+	if err != nil {
+		return path, nil, fileError(err)
+	}
+
+	samples, err := parseEvalSamples(path, strings.NewReader(data))
+
+	return path, samples, err
+}
+
+// parseEvalSamples decodes one sample per non-blank line.
+func parseEvalSamples(source string, r io.Reader) ([]evalSample, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, EvalScanBuffer), EvalMaxLine)
+
+	var samples []evalSample
+
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := scanner.Bytes()
+		if len(strings.TrimSpace(string(line))) == 0 {
+			continue
+		}
+
+		sample, err := parseEvalLine(line)
+		if err != nil {
+			return nil, inputError(fmt.Errorf(ErrEvalLineFmt, source, lineNo, ErrInvalidEvalSample, err))
+		}
+
+		sample.line = lineNo
+		samples = append(samples, sample)
+	}
+
+	err := scanner.Err()
+	if err != nil {
+		return nil, ioError(fmt.Errorf(ErrWrapReadEval, source, err))
+	}
+
+	if len(samples) == 0 {
+		return nil, inputError(ErrNoEvalSamples)
+	}
+
+	return samples, nil
+}
+
+func parseEvalLine(line []byte) (evalSample, error) {
+	var decoded evalLine
+
+	err := json.Unmarshal(line, &decoded)
+	if err != nil {
+		return evalSample{}, err
+	}
+
+	switch {
+	case decoded.Text == nil:
+		return evalSample{}, errors.New(ErrMissingTextMsg)
+	case decoded.Tokens == nil:
+		return evalSample{}, errors.New(ErrMissingTokensMsg)
+	case *decoded.Tokens < 0:
+		return evalSample{}, errors.New(ErrNegativeTokensMsg)
+	}
+
+	text := *decoded.Text
+
+	return evalSample{
+		text:      text,
+		line:      0,
+		reference: *decoded.Tokens,
+		script:    dominantScript(text),
+		bucket:    lengthBucketOf(utf8.RuneCountInString(text)),
+	}, nil
+}
+
+// dominantScript names the Unicode script with the most letters in text,
+// preferring the alphabetically first on a tie.
+func dominantScript(text string) string {
+	counts := make(map[string]int)
+
+	for _, r := range text {
+		if unicode.IsLetter(r) {
+			counts[runeScript(r)]++
+		}
+	}
+
+	best := ScriptCommon
+	for script, n := range counts {
+		if n > counts[best] || (n == counts[best] && script < best) {
+			best = script
+		}
+	}
+
+	return best
+}
+
+func runeScript(r rune) string {
+	for _, name := range commonScripts() {
+		if unicode.Is(unicode.Scripts[name], r) {
+			return name
+		}
+	}
+
+	for name, table := range unicode.Scripts {
+		if unicode.Is(table, r) {
+			return name
+		}
+	}
+
+	return ScriptUnknown
+}
+
+func lengthBucketOf(runes int) int {
+	buckets := lengthBuckets()
+
+	return slices.IndexFunc(buckets, func(b lengthBucket) bool { return runes < b.limit })
+}
+
+// errorAccumulator sums the errors of one group of samples.
+type errorAccumulator struct {
+	samples          int
+	percentSamples   int
+	absSum           float64
+	signedSum        float64
+	absPercentSum    float64
+	signedPercentSum float64
+}
+
+func (a *errorAccumulator) add(reference, estimate int) {
+	diff := float64(estimate - reference)
+
+	a.samples++
+	a.absSum += math.Abs(diff)
+	a.signedSum += diff
+
+	if reference > 0 {
+		percent := diff / float64(reference) * ReportPercentScale
+
+		a.percentSamples++
+		a.absPercentSum += math.Abs(percent)
+		a.signedPercentSum += percent
+	}
+}
+
+func (a *errorAccumulator) stats(group string) ErrorStats {
+	return ErrorStats{
+		Group:       group,
+		Samples:     a.samples,
+		MAE:         roundStat(a.absSum, a.samples),
+		MAPE:        roundStat(a.absPercentSum, a.percentSamples),
+		Bias:        roundStat(a.signedSum, a.samples),
+		BiasPercent: roundStat(a.signedPercentSum, a.percentSamples),
+	}
+}
+
+// roundStat returns sum/n rounded to two decimals, or 0 when n is 0.
+func roundStat(sum float64, n int) float64 {
+	if n == 0 {
+		return 0
+	}
+
+	return math.Round(sum/float64(n)*ReportPercentRound) / ReportPercentRound
+}
+
+// evaluateModel estimates every sample with tok and aggregates the error.
+func evaluateModel(tok *tokenizer.Tokenizer, samples []evalSample, worst int) ModelEval {
+	var overall errorAccumulator
+
+	scripts := make(map[string]*errorAccumulator)
+	lengths := make([]errorAccumulator, len(lengthBuckets()))
+	cases := make([]EvalCase, 0, len(samples))
+
+	for _, sample := range samples {
+		estimate := tok.EstimateTokens(sample.text)
+
+		overall.add(sample.reference, estimate)
+		lengths[sample.bucket].add(sample.reference, estimate)
+
+		if scripts[sample.script] == nil {
+			scripts[sample.script] = &errorAccumulator{}
+		}
+
+		scripts[sample.script].add(sample.reference, estimate)
+
+		cases = append(cases, EvalCase{
+			Line:      sample.line,
+			Reference: sample.reference,
+			Estimate:  estimate,
+			Error:     estimate - sample.reference,
+			Preview:   truncateText(singleLine(sample.text), EvalPreviewMax),
+		})
+	}
+
+	return ModelEval{
+		Model:    tok.GetModel(),
+		Overall:  overall.stats(""),
+		ByScript: scriptStats(scripts),
+		ByLength: lengthStats(lengths),
+		Worst:    worstCases(cases, worst),
+	}
+}
+
+// scriptStats lists the script groups, largest first.
+func scriptStats(scripts map[string]*errorAccumulator) []ErrorStats {
+	stats := make([]ErrorStats, 0, len(scripts))
+	for script, acc := range scripts {
+		stats = append(stats, acc.stats(script))
+	}
+
+	slices.SortFunc(stats, func(a, b ErrorStats) int {
+		return cmp.Or(b.Samples-a.Samples, strings.Compare(a.Group, b.Group))
+	})
+
+	return stats
+}
+
+// lengthStats lists the non-empty length buckets, shortest first.
+func lengthStats(lengths []errorAccumulator) []ErrorStats {
+	var stats []ErrorStats
+
+	for i, bucket := range lengthBuckets() {
+		if lengths[i].samples > 0 {
+			stats = append(stats, lengths[i].stats(bucket.label))
+		}
+	}
+
+	return stats
+}
+
+// worstCases returns the n cases with the largest absolute error, earliest
+// line first on a tie.
+func worstCases(cases []EvalCase, n int) []EvalCase {
+	slices.SortStableFunc(cases, func(a, b EvalCase) int {
+		return cmp.Compare(absInt(b.Error), absInt(a.Error))
+	})
+
+	return cases[:min(n, len(cases))]
+}
+
+func absInt(n int) int {
+	if n < 0 {
+		return -n
+	}
+
+	return n
+}
+
+// writeEvalReport prints the summary and tables of every model.
+func writeEvalReport(w io.Writer, report *EvalReport) error {
+	_, err := fmt.Fprintf(w, MsgEvalSamplesFmt, report.Samples)
+
+	for i := 0; err == nil && i < len(report.Models); i++ {
+		_, err = fmt.Fprint(w, MsgResultSep)
+		if err == nil {
+			err = writeModelEval(w, &report.Models[i])
+		}
+	}
+
+	if err != nil {
+		return fmt.Errorf(ErrWrapWriteEval, err)
+	}
+
+	return nil
+}
+
+func writeModelEval(w io.Writer, eval *ModelEval) error {
+	_, err := fmt.Fprintf(w, MsgModelFmt, eval.Model)
+	if err == nil {
+		_, err = fmt.Fprintf(w, MsgEvalMAEFmt, eval.Overall.MAE)
+	}
+
+	if err == nil {
+		_, err = fmt.Fprintf(w, MsgEvalMAPEFmt, eval.Overall.MAPE)
+	}
+
+	if err == nil {
+		_, err = fmt.Fprintf(w, MsgEvalBiasFmt, eval.Overall.Bias, eval.Overall.BiasPercent)
+	}
+
+	if err == nil {
+		err = writeStatsTable(w, EvalColScript, eval.ByScript)
+	}
+
+	if err == nil {
+		err = writeStatsTable(w, EvalColLength, eval.ByLength)
+	}
+
+	if err == nil && len(eval.Worst) > 0 {
+		err = writeWorstTable(w, eval.Worst)
+	}
+
+	return err
+}
+
+func newEvalTable(w io.Writer) *tabwriter.Writer {
+	return tabwriter.NewWriter(
+		w,
+		ReportTableMinWidth,
+		ReportTableTabWidth,
+		ReportTablePadding,
+		ReportTablePadChar,
+		0,
+	)
+}
+
+func writeStatsTable(w io.Writer, column string, stats []ErrorStats) error {
+	tw := newEvalTable(w)
+
+	_, err := fmt.Fprint(tw, MsgResultSep)
+	if err == nil {
+		_, err = fmt.Fprintf(tw, EvalStatsHeader, column)
+	}
+
+	for i := 0; err == nil && i < len(stats); i++ {
+		s := stats[i]
+		_, err = fmt.Fprintf(tw, EvalStatsRowFmt, s.Group, s.Samples, s.MAE, s.MAPE, s.Bias, s.BiasPercent)
+	}
+
+	if err == nil {
+		err = tw.Flush()
+	}
+
+	return err
+}
+
+func writeWorstTable(w io.Writer, cases []EvalCase) error {
+	tw := newEvalTable(w)
+
+	_, err := fmt.Fprint(tw, MsgResultSep+EvalWorstHeader)
+
+	for i := 0; err == nil && i < len(cases); i++ {
+		c := cases[i]
+		_, err = fmt.Fprintf(tw, EvalWorstRowFmt, c.Line, c.Reference, c.Estimate, c.Error, c.Preview)
+	}
+
+	if err == nil {
+		err = tw.Flush()
+	}
+
+	return err
+}
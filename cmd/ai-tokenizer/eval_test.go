@@ -0,0 +1,171 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const (
+	// "hello world" is 7 tokens for the heuristic, "Привет мир" is 1 and
+	// "12345" is 3.
+	evalSamples = `{"text": "hello world", "tokens": 2}
+
+{"text": "Привет мир", "tokens": 4}
+{"text": "12345", "tokens": 2, "source": "ignored"}
+`
+
+	fmtDominantScript = "dominantScript(%q) = %q, want %q"
+)
+
+func writeEvalSamples(t *testing.T, content string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "samples.jsonl")
+
+	err := os.WriteFile(path, []byte(content), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+func TestRunMainEval(t *testing.T) {
+	t.Parallel()
+
+	samples := writeEvalSamples(t, evalSamples)
+
+	tests := []runMainTestCase{
+		{
+			name:       "overall metrics",
+			args:       []string{"eval", "-models", "simple", samples},
+			wantStdout: "Samples: 3\n\nModel: simple\nMean absolute error: 3.00 tokens\nMean absolute percent error: 125.00%\nBias: +1.00 tokens (+75.00%)\n",
+			wantCode:   ExitOK,
+		},
+		{
+			name:       "by script",
+			args:       []string{"eval", "-models", "simple", "-file", samples},
+			wantStdout: "Cyrillic  1        3.00  75.00%   -3.00  -75.00%",
+			wantCode:   ExitOK,
+		},
+		{
+			name:       "by length",
+			args:       []string{"eval", "-models", "simple", samples},
+			wantStdout: "<100   3        3.00  125.00%  +1.00  +75.00%",
+			wantCode:   ExitOK,
+		},
+		{
+			name:       "worst case first",
+			args:       []string{"eval", "-models", "simple", "-worst", "1", samples},
+			wantStdout: "LINE  REFERENCE  ESTIMATE  ERROR  TEXT\n1     2          7         +5     hello world\n",
+			wantCode:   ExitOK,
+		},
+		{
+			name:       "model file",
+			args:       []string{"eval", "-models", testModelFile, samples},
+			wantStdout: "Model: unigram\nMean absolute error: 0.00 tokens",
+			wantCode:   ExitOK,
+		},
+		{
+			name:       "json",
+			args:       []string{"eval", "-json", "-models", "simple", samples},
+			wantStdout: `"meanAbsolutePercentError": 125,`,
+			wantCode:   ExitOK,
+		},
+		{
+			name:       "stdin",
+			args:       []string{"eval", "-models", "simple"},
+			stdin:      evalSamples,
+			wantStdout: "Samples: 3",
+			wantCode:   ExitOK,
+		},
+		{
+			name:       "eval as text",
+			args:       []string{"-text", "eval"},
+			wantStdout: "Token Count: 2",
+			wantCode:   ExitOK,
+		},
+	}
+
+	for _, testCase := range tests {
+		t.Run(testCase.name, func(t *testing.T) {
+			t.Parallel()
+			runMainTest(t, testCase)
+		})
+	}
+}
+
+func TestRunMainEvalErrors(t *testing.T) {
+	t.Parallel()
+
+	tests := []runMainTestCase{
+		{
+			name:       "malformed line",
+			args:       []string{"eval"},
+			stdin:      "{\"text\": \"a\", \"tokens\": 1}\n{\"text\": \"b\"}\n",
+			wantStderr: "stdin:2: invalid evaluation sample: missing \"tokens\"",
+			wantCode:   ExitInputError,
+		},
+		{
+			name:       "negative count",
+			args:       []string{"eval"},
+			stdin:      `{"text": "a", "tokens": -1}`,
+			wantStderr: "negative \"tokens\"",
+			wantCode:   ExitInputError,
+		},
+		{
+			name:       "no samples",
+			args:       []string{"eval"},
+			stdin:      "\n\n",
+			wantStderr: ErrNoEvalSamplesMsg,
+			wantCode:   ExitInputError,
+		},
+		{
+			name:       "missing file",
+			args:       []string{"eval", missingModelFile},
+			wantStderr: "failed to open file",
+			wantCode:   ExitInputError,
+		},
+		{
+			name:       "unknown model",
+			args:       []string{"eval", "-models", "nope"},
+			wantStderr: "nope",
+			wantCode:   ExitInputError,
+		},
+		{
+			name:       "unknown format",
+			args:       []string{"eval", "-format", "csv"},
+			wantStderr: "want text or json",
+			wantCode:   ExitInputError,
+		},
+	}
+
+	for _, testCase := range tests {
+		t.Run(testCase.name, func(t *testing.T) {
+			t.Parallel()
+			runMainTest(t, testCase)
+		})
+	}
+}
+
+func TestDominantScript(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]string{
+		"hello":         "Latin",
+		"Привет, world": "Cyrillic",
+		"日本語のテキスト":      "Katakana",
+		"日本語":           "Han",
+		"12345 + 67":    ScriptCommon,
+		"ab αβγ":        "Greek",
+		"":              ScriptCommon,
+		"a б":           "Cyrillic",
+	}
+
+	for input, want := range tests {
+		if got := dominantScript(input); got != want {
+			t.Errorf(fmtDominantScript, input, got, want)
+		}
+	}
+}
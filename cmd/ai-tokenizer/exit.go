@@ -0,0 +1,116 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"io/fs"
+	"syscall"
+)
+
+// Exit codes returned by the CLI. They are part of the documented interface
+// so scripts can tell bad input from environment failures.
+const (
+	// ExitOK means success, including a reader closing the output pipe early.
+	ExitOK = 0
+	// ExitInternalError means an unexpected failure inside the tool.
+	ExitInternalError = 1
+	// ExitInputError means invalid flags, missing files, or empty input.
+	ExitInputError = 2
+	// ExitIOError means reading input or writing output failed.
+	ExitIOError = 3
+	// ExitLimitExceeded means an input exceeded the -max-tokens budget, or
+	// git token growth exceeded -max-growth.
+	ExitLimitExceeded = 4
+
+	ErrLimitExceededMsg = "token limit exceeded"
+	ErrLimitDetailFmt   = "%w: %s has %d tokens (limit %d)"
+	ErrLimitBoundFmt    = "%w: %s may have up to %d tokens (limit %d)"
+)
+
+// ErrLimitExceeded is returned when a result is over the -max-tokens budget.
+var ErrLimitExceeded = errors.New(ErrLimitExceededMsg)
+
+// exitError attaches an exit code to an error returned from run. reported
+// marks errors that were already printed, such as flag parse failures.
+type exitError struct {
+	err      error
+	code     int
+	reported bool
+}
+
+func (e *exitError) Error() string { return e.err.Error() }
+func (e *exitError) Unwrap() error { return e.err }
+
+func withExitCode(code int, err error) error {
+	if err == nil {
+		return nil
+	}
+
+	return &exitError{err: err, code: code}
+}
+
+func inputError(err error) error { return withExitCode(ExitInputError, err) }
+func ioError(err error) error    { return withExitCode(ExitIOError, err) }
+func limitError(err error) error { return withExitCode(ExitLimitExceeded, err) }
+
+// reportedInputError is an input error the flag package already printed.
+func reportedInputError(err error) error {
+	return reportedError(ExitInputError, err)
+}
+
+// reportedError attaches an exit code to an error that was already
+// printed or logged, so runMain does not print it again.
+func reportedError(code int, err error) error {
+	if err == nil {
+		return nil
+	}
+
+	return &exitError{err: err, code: code, reported: true}
+}
+
+// fileError classifies a file read failure: paths that do not exist or
+// cannot be opened are input errors, anything else is an I/O error.
+func fileError(err error) error {
+	if errors.Is(err, fs.ErrNotExist) || errors.Is(err, fs.ErrPermission) {
+		return inputError(err)
+	}
+
+	return ioError(err)
+}
+
+// exitCode maps an error from run to the process exit code.
+func exitCode(err error) int {
+	if err == nil || isBrokenPipe(err) || errors.Is(err, flag.ErrHelp) {
+		return ExitOK
+	}
+
+	var coded *exitError
+	if errors.As(err, &coded) {
+		return coded.code
+	}
+
+	return ExitInternalError
+}
+
+// shouldReport reports whether runMain still needs to print err.
+func shouldReport(err error) bool {
+	var coded *exitError
+	if errors.As(err, &coded) {
+		return !coded.reported
+	}
+
+	return true
+}
+
+// isBrokenPipe reports whether err comes from writing to a closed pipe,
+// e.g. when output is piped into head.
+func isBrokenPipe(err error) bool {
+	return errors.Is(err, syscall.EPIPE)
+}
+
+// isBrokenPipeOver reports whether err is a broken pipe that limit, a
+// threshold the output crossed, takes precedence over: a reader such as
+// head stopping early must not turn exit status 4 into 0.
+func isBrokenPipeOver(err, limit error) bool {
+	return limit != nil && isBrokenPipe(err)
+}
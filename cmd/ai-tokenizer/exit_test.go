@@ -0,0 +1,210 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+const (
+	fmtRunMainCode   = "runMain(%q) exit code = %d, want %d\nstderr: %s"
+	fmtRunMainStdout = "runMain(%q) stdout missing %q:\n%s"
+	fmtRunMainStderr = "runMain(%q) stderr = %q, want it to contain %q"
+	fmtStderrNoise   = "runMain(%q) wrote to stderr on broken pipe: %q"
+)
+
+// failingWriter rejects every write with err.
+type failingWriter struct {
+	err error
+}
+
+func (w failingWriter) Write([]byte) (int, error) { return 0, w.err }
+
+type runMainTestCase struct {
+	name       string
+	args       []string
+	stdin      string
+	wantStdout string
+	wantStderr string
+	wantCode   int
+}
+
+func TestRunMainExitCodes(t *testing.T) {
+	t.Parallel()
+
+	tests := []runMainTestCase{
+		{
+			name:       "success",
+			args:       []string{helloWorld},
+			wantStdout: "Token Count: 9",
+			wantCode:   ExitOK,
+		},
+		{
+			name:       "stdin input",
+			stdin:      helloWorld,
+			wantStdout: "Token Count: 9",
+			wantCode:   ExitOK,
+		},
+		{name: "empty input", stdin: " ", wantStderr: ErrNoInputMsg, wantCode: ExitInputError},
+		{name: "unknown flag", args: []string{"-bogus"}, wantCode: ExitInputError},
+		{
+			name:       "missing file",
+			args:       []string{"-file", invalidPath},
+			wantStderr: invalidPath,
+			wantCode:   ExitInputError,
+		},
+		{
+			name:       "unknown format",
+			args:       []string{"-format", "xml", hello},
+			wantStderr: ErrUnknownFormatMsg,
+			wantCode:   ExitInputError,
+		},
+		{
+			name:       "over limit",
+			args:       []string{"-max-tokens", "2", helloWorld},
+			wantStdout: "Token Count: 9",
+			wantStderr: ErrLimitExceededMsg,
+			wantCode:   ExitLimitExceeded,
+		},
+		{
+			name:       "malformed utf-8 rejected",
+			args:       []string{"-invalid-utf8", "error"},
+			stdin:      "ab\xffcd",
+			wantStderr: "invalid UTF-8",
+			wantCode:   ExitInputError,
+		},
+		{
+			name:       "malformed utf-8 counted as bytes",
+			args:       []string{"-invalid-utf8", "bytes", "-json"},
+			stdin:      "ab\xff\xfecd",
+			wantStdout: `"invalidUtf8Runs": 1`,
+			wantCode:   ExitOK,
+		},
+		{
+			name:       "utf-16 stdin transcoded",
+			args:       []string{"-json"},
+			stdin:      "\xff\xfeh\x00i\x00",
+			wantStdout: `"encoding": "utf-16le"`,
+			wantCode:   ExitOK,
+		},
+		{
+			name:       "unknown encoding",
+			args:       []string{"-encoding", "ebcdic", hello},
+			wantStderr: ErrUnknownEncodingMsg,
+			wantCode:   ExitInputError,
+		},
+		{name: "help", args: []string{"-help"}, wantCode: ExitOK},
+	}
+
+	for _, testCase := range tests {
+		t.Run(testCase.name, func(t *testing.T) {
+			t.Parallel()
+			runMainTest(t, testCase)
+		})
+	}
+}
+
+func runMainTest(t *testing.T, testCase runMainTestCase) {
+	t.Helper()
+
+	var stdout, stderr bytes.Buffer
+
+	code := runMain(testCase.args, cliStreams{
+		stdin:  strings.NewReader(testCase.stdin),
+		stdout: &stdout,
+		stderr: &stderr,
+	})
+
+	if code != testCase.wantCode {
+		t.Errorf(fmtRunMainCode, testCase.args, code, testCase.wantCode, stderr.String())
+	}
+
+	if !strings.Contains(stdout.String(), testCase.wantStdout) {
+		t.Errorf(fmtRunMainStdout, testCase.args, testCase.wantStdout, stdout.String())
+	}
+
+	if !strings.Contains(stderr.String(), testCase.wantStderr) {
+		t.Errorf(fmtRunMainStderr, testCase.args, stderr.String(), testCase.wantStderr)
+	}
+}
+
+func TestRunMainBrokenPipeIsClean(t *testing.T) {
+	t.Parallel()
+
+	args := []string{helloWorld}
+
+	var stderr bytes.Buffer
+
+	code := runMain(args, cliStreams{
+		stdin:  strings.NewReader(""),
+		stdout: failingWriter{err: fmt.Errorf("write: %w", syscall.EPIPE)},
+		stderr: &stderr,
+	})
+
+	if code != ExitOK {
+		t.Errorf(fmtRunMainCode, args, code, ExitOK, stderr.String())
+	}
+
+	if stderr.Len() != 0 {
+		t.Errorf(fmtStderrNoise, args, stderr.String())
+	}
+}
+
+func TestRunMainLimitOverBrokenPipe(t *testing.T) {
+	t.Parallel()
+
+	// As in "ai-tokenizer -max-tokens 2 big.txt | head -c1": the reader
+	// goes away, but the input is still over the limit.
+	tests := []struct {
+		name       string
+		args       []string
+		stdin      string
+		wantStderr string
+	}{
+		{name: "max tokens", args: []string{"-" + FlagNameMaxTokens, "2", helloWorld}, stdin: "", wantStderr: ErrLimitExceededMsg},
+		{name: "max growth", args: []string{CommandGit, "-" + FlagNameDiff, "-", "-" + FlagNameMaxGrowth, "1"}, stdin: testGitDiff, wantStderr: ErrGrowthExceededMsg},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			var stderr bytes.Buffer
+
+			code := runMain(tt.args, cliStreams{
+				stdin:  strings.NewReader(tt.stdin),
+				stdout: failingWriter{err: fmt.Errorf("write: %w", syscall.EPIPE)},
+				stderr: &stderr,
+			})
+
+			if code != ExitLimitExceeded {
+				t.Errorf(fmtRunMainCode, tt.args, code, ExitLimitExceeded, stderr.String())
+			}
+
+			if !strings.Contains(stderr.String(), tt.wantStderr) {
+				t.Errorf(fmtRunMainStderr, tt.args, stderr.String(), tt.wantStderr)
+			}
+		})
+	}
+}
+
+func TestRunMainWriteFailureIsIOError(t *testing.T) {
+	t.Parallel()
+
+	args := []string{"-format", FormatCSV, helloWorld}
+
+	var stderr bytes.Buffer
+
+	code := runMain(args, cliStreams{
+		stdin:  strings.NewReader(""),
+		stdout: failingWriter{err: errors.New("disk full")},
+		stderr: &stderr,
+	})
+
+	if code != ExitIOError {
+		t.Errorf(fmtRunMainCode, args, code, ExitIOError, stderr.String())
+	}
+}
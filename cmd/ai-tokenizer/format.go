@@ -0,0 +1,632 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"text/template"
+)
+
+// Formatter renders token results to a writer. Every output mode, single
+// input or batch, goes through a Formatter so they stay consistent.
+type Formatter interface {
+	Format(w io.Writer, results []*TokenResult) error
+}
+
+const (
+	// Output formats accepted by the -format flag.
+	FormatText     = "text"
+	FormatJSON     = "json"
+	FormatNDJSON   = "ndjson"
+	FormatCSV      = "csv"
+	FormatTSV      = "tsv"
+	FormatYAML     = "yaml"
+	FormatTable    = "table"
+	FormatTemplate = "template"
+
+	// Column names shared by the tabular formats.
+	ColSource     = "source"
+	ColModel      = "model"
+	ColTokenCount = "tokenCount"
+	ColText       = "text"
+	ColNormalized = "normalizedText"
+	ColUnit       = "unit"
+	ColIndex      = "index"
+	ColStartLine  = "startLine"
+	ColEndLine    = "endLine"
+	ColCumulative = "cumulative"
+	ColPercent    = "percent"
+	ColLabel      = "label"
+
+	// Plain-text extras for multi-input output.
+	MsgSourceFmt    = "Source: %s\n"
+	MsgResultSep    = "\n"
+	TemplateName    = "result"
+	TSVSeparator    = '\t'
+	PercentFmtDigit = 2
+
+	// YAML layout.
+	yamlIndent     = "  "
+	yamlListMarker = "- "
+	yamlEmptyList  = "[]"
+	yamlEmptyMap   = "{}"
+	yamlNull       = "null"
+	jsonTagName    = "json"
+	jsonOmitEmpty  = "omitempty"
+	jsonTagSkip    = "-"
+
+	ErrUnknownFormatMsg   = "unknown output format"
+	ErrTemplateMissingMsg = "-format template requires -template"
+	ErrUnknownFormatFmt   = "%w %q (want text, json, ndjson, csv, tsv, yaml, table or template)"
+	ErrWrapParseTemplate  = "parse template: %w"
+	ErrWrapRenderTemplate = "render template: %w"
+	ErrWrapWriteOutput    = "write output: %w"
+	ErrWrapEncodeYAML     = "encode yaml: %w"
+)
+
+var (
+	// ErrUnknownFormat is returned for an unsupported -format value.
+	ErrUnknownFormat = errors.New(ErrUnknownFormatMsg)
+	// ErrTemplateMissing is returned when -format template has no template.
+	ErrTemplateMissing = errors.New(ErrTemplateMissingMsg)
+)
+
+// newFormatter returns the Formatter registered for name. tmpl is only used
+// by the template format.
+func newFormatter(name, tmpl string) (Formatter, error) {
+	switch name {
+	case FormatText:
+		return textFormatter{}, nil
+	case FormatJSON:
+		return jsonFormatter{}, nil
+	case FormatNDJSON:
+		return ndjsonFormatter{}, nil
+	case FormatCSV:
+		return delimitedFormatter{comma: ',', escape: false}, nil
+	case FormatTSV:
+		return delimitedFormatter{comma: TSVSeparator, escape: true}, nil
+	case FormatYAML:
+		return yamlFormatter{}, nil
+	case FormatTable:
+		return tableFormatter{}, nil
+	case FormatTemplate:
+		return newTemplateFormatter(tmpl)
+	default:
+		return nil, fmt.Errorf(ErrUnknownFormatFmt, ErrUnknownFormat, name)
+	}
+}
+
+// textFormatter is the default human-friendly output.
+type textFormatter struct{}
+
+func (textFormatter) Format(w io.Writer, results []*TokenResult) error {
+	multi := len(results) > 1
+
+	for i, r := range results {
+		err := writeTextResult(w, r, multi, i > 0)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func writeTextResult(w io.Writer, r *TokenResult, showSource, separate bool) error {
+	if separate {
+		_, err := io.WriteString(w, MsgResultSep)
+		if err != nil {
+			return fmt.Errorf(ErrWrapWriteOutput, err)
+		}
+	}
+
+	if showSource {
+		_, err := fmt.Fprintf(w, MsgSourceFmt, r.Source)
+		if err != nil {
+			return fmt.Errorf(ErrWrapWriteOutput, err)
+		}
+	}
+
+	err := writePlain(w, r)
+	if err != nil {
+		return err
+	}
+
+	if len(r.Comparison) > 0 {
+		err = writeComparisonTable(w, r)
+		if err != nil {
+			return err
+		}
+	}
+
+	if r.ReportUnit == "" {
+		return nil
+	}
+
+	return writeBreakdownTable(w, r.ReportUnit, r.Breakdown)
+}
+
+// writePlain prints a human-friendly representation of one result.
+func writePlain(w io.Writer, result *TokenResult) error {
+	_, err := fmt.Fprintf(w, MsgTextFmt, truncateText(result.Text, DefaultPreviewMax))
+	if err == nil {
+		_, err = fmt.Fprintf(w, MsgTokenCountFmt, result.TokenCount)
+	}
+
+	if err == nil {
+		_, err = fmt.Fprintf(w, MsgModelFmt, result.Model)
+	}
+
+	if err == nil && result.UpperBoundMode != "" {
+		err = writeUpperBound(w, result)
+	}
+
+	if err == nil && result.NormalizedText != "" {
+		_, err = fmt.Fprintf(
+			w,
+			MsgNormalizedFmt,
+			truncateText(result.NormalizedText, DefaultPreviewMax),
+		)
+	}
+
+	if err == nil && result.Encoding != "" && result.Encoding != EncodingUTF8 {
+		_, err = fmt.Fprintf(w, MsgEncodingFmt, result.Encoding)
+	}
+
+	if err == nil && result.SpecialTokenCount > 0 {
+		_, err = fmt.Fprintf(w, MsgSpecialFmt, result.SpecialTokenCount, result.SpecialTokens)
+	}
+
+	if err == nil && result.InvalidUTF8Runs > 0 {
+		_, err = fmt.Fprintf(w, MsgInvalidFmt, result.InvalidUTF8Runs, result.InvalidUTF8RunOffsets)
+	}
+
+	if err != nil {
+		return fmt.Errorf(ErrWrapWriteOutput, err)
+	}
+
+	return nil
+}
+
+// jsonFormatter writes one indented object, or an array for several inputs.
+type jsonFormatter struct{}
+
+func (jsonFormatter) Format(w io.Writer, results []*TokenResult) error {
+	if len(results) == 1 {
+		return writeJSON(w, results[0])
+	}
+
+	return writeJSON(w, results)
+}
+
+// writeJSON pretty-prints v as JSON and wraps errors.
+func writeJSON(w io.Writer, v any) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", MsgJSONIndent)
+
+	err := enc.Encode(v)
+	if err != nil {
+		return fmt.Errorf(ErrWrapEncodeJSON, err)
+	}
+
+	return nil
+}
+
+// ndjsonFormatter writes one compact JSON object per line.
+type ndjsonFormatter struct{}
+
+func (ndjsonFormatter) Format(w io.Writer, results []*TokenResult) error {
+	enc := json.NewEncoder(w)
+
+	for _, r := range results {
+		err := enc.Encode(r)
+		if err != nil {
+			return fmt.Errorf(ErrWrapEncodeJSON, err)
+		}
+	}
+
+	return nil
+}
+
+// delimitedFormatter writes CSV or TSV with a header row. When any result
+// carries a breakdown or comparison, rows are its entries instead of whole
+// inputs.
+type delimitedFormatter struct {
+	comma  rune
+	escape bool
+}
+
+func (f delimitedFormatter) Format(w io.Writer, results []*TokenResult) error {
+	cw := csv.NewWriter(w)
+	cw.Comma = f.comma
+
+	header, rows := tabularRows(results, false)
+
+	err := cw.Write(header)
+	for i := 0; err == nil && i < len(rows); i++ {
+		err = cw.Write(f.escapeRow(rows[i]))
+	}
+
+	if err != nil {
+		return fmt.Errorf(ErrWrapWriteOutput, err)
+	}
+
+	cw.Flush()
+
+	err = cw.Error()
+	if err != nil {
+		return fmt.Errorf(ErrWrapWriteOutput, err)
+	}
+
+	return nil
+}
+
+// escapeRow keeps TSV one record per line by escaping control whitespace.
+func (f delimitedFormatter) escapeRow(row []string) []string {
+	if !f.escape {
+		return row
+	}
+
+	replacer := strings.NewReplacer("\\", `\\`, "\t", `\t`, "\n", `\n`, "\r", `\r`)
+	out := make([]string, len(row))
+
+	for i, cell := range row {
+		out[i] = replacer.Replace(cell)
+	}
+
+	return out
+}
+
+// tableFormatter writes an aligned, human-oriented table.
+type tableFormatter struct{}
+
+func (tableFormatter) Format(w io.Writer, results []*TokenResult) error {
+	tw := tabwriter.NewWriter(
+		w,
+		ReportTableMinWidth,
+		ReportTableTabWidth,
+		ReportTablePadding,
+		ReportTablePadChar,
+		0,
+	)
+
+	header, rows := tabularRows(results, true)
+
+	_, err := fmt.Fprintln(tw, strings.ToUpper(strings.Join(header, "\t")))
+	for i := 0; err == nil && i < len(rows); i++ {
+		_, err = fmt.Fprintln(tw, strings.Join(rows[i], "\t"))
+	}
+
+	if err == nil {
+		err = tw.Flush()
+	}
+
+	if err != nil {
+		return fmt.Errorf(ErrWrapWriteOutput, err)
+	}
+
+	return nil
+}
+
+// tabularRows flattens results into a header and rows. preview shortens
+// free-text columns for terminal display.
+func tabularRows(results []*TokenResult, preview bool) ([]string, [][]string) {
+	if hasBreakdown(results) {
+		return breakdownRows(results)
+	}
+
+	if hasComparison(results) {
+		return comparisonRows(results)
+	}
+
+	header := []string{ColSource, ColModel, ColTokenCount, ColText, ColNormalized}
+	rows := make([][]string, 0, len(results))
+	bound := hasUpperBound(results)
+
+	if bound {
+		header = append(header, ColUpperBound)
+	}
+
+	for _, r := range results {
+		text, normalized := r.Text, r.NormalizedText
+		if preview {
+			text = truncateText(singleLine(text), DefaultPreviewMax)
+			normalized = truncateText(singleLine(normalized), DefaultPreviewMax)
+		}
+
+		row := []string{r.Source, r.Model, strconv.Itoa(r.TokenCount), text, normalized}
+		if bound {
+			row = append(row, strconv.Itoa(r.UpperBound))
+		}
+
+		rows = append(rows, row)
+	}
+
+	return header, rows
+}
+
+func breakdownRows(results []*TokenResult) ([]string, [][]string) {
+	header := []string{
+		ColSource, ColModel, ColUnit, ColIndex, ColStartLine, ColEndLine,
+		ColTokenCount, ColCumulative, ColPercent, ColLabel,
+	}
+
+	var rows [][]string
+
+	for _, r := range results {
+		for _, e := range r.Breakdown {
+			rows = append(rows, []string{
+				r.Source,
+				r.Model,
+				r.ReportUnit,
+				strconv.Itoa(e.Index),
+				strconv.Itoa(e.StartLine),
+				strconv.Itoa(e.EndLine),
+				strconv.Itoa(e.TokenCount),
+				strconv.Itoa(e.Cumulative),
+				strconv.FormatFloat(e.Percent, 'f', PercentFmtDigit, 64),
+				e.Label,
+			})
+		}
+	}
+
+	return header, rows
+}
+
+func hasBreakdown(results []*TokenResult) bool {
+	for _, r := range results {
+		if len(r.Breakdown) > 0 {
+			return true
+		}
+	}
+
+	return false
+}
+
+func singleLine(text string) string {
+	return strings.Join(strings.Fields(text), " ")
+}
+
+// templateFormatter renders each result with a user-supplied text/template.
+type templateFormatter struct {
+	tmpl *template.Template
+}
+
+func newTemplateFormatter(text string) (Formatter, error) {
+	if text == "" {
+		return nil, ErrTemplateMissing
+	}
+
+	tmpl, err := template.New(TemplateName).Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf(ErrWrapParseTemplate, err)
+	}
+
+	return templateFormatter{tmpl: tmpl}, nil
+}
+
+func (f templateFormatter) Format(w io.Writer, results []*TokenResult) error {
+	for _, r := range results {
+		err := f.tmpl.Execute(w, r)
+		if err != nil {
+			return fmt.Errorf(ErrWrapRenderTemplate, err)
+		}
+
+		_, err = io.WriteString(w, "\n")
+		if err != nil {
+			return fmt.Errorf(ErrWrapWriteOutput, err)
+		}
+	}
+
+	return nil
+}
+
+// yamlFormatter writes results as a YAML sequence. Field names and
+// omission rules follow the json struct tags so both formats agree.
+type yamlFormatter struct{}
+
+func (yamlFormatter) Format(w io.Writer, results []*TokenResult) error {
+	var b strings.Builder
+
+	writeYAMLSeq(&b, reflect.ValueOf(results), "")
+
+	_, err := io.WriteString(w, b.String())
+	if err != nil {
+		return fmt.Errorf(ErrWrapEncodeYAML, err)
+	}
+
+	return nil
+}
+
+// writeYAMLValue writes v after a "key:" prefix. Scalars stay on the same
+// line; mappings and sequences open a block indented by indent.
+func writeYAMLValue(b *strings.Builder, v reflect.Value, indent string) {
+	v = yamlDeref(v)
+
+	switch {
+	case !v.IsValid():
+		b.WriteString(" " + yamlNull + "\n")
+	case isYAMLMapping(v):
+		b.WriteString("\n")
+		writeYAMLMapping(b, v, indent, indent)
+	case isYAMLSeq(v) && v.Len() == 0:
+		b.WriteString(" " + yamlEmptyList + "\n")
+	case isYAMLSeq(v):
+		b.WriteString("\n")
+		writeYAMLSeq(b, v, indent)
+	default:
+		b.WriteString(" " + yamlScalar(v) + "\n")
+	}
+}
+
+// writeYAMLSeq writes each element as a "- " item. Mapping items start on
+// the marker line in the usual compact style.
+func writeYAMLSeq(b *strings.Builder, v reflect.Value, indent string) {
+	for i := range v.Len() {
+		item := yamlDeref(v.Index(i))
+
+		switch {
+		case !item.IsValid():
+			b.WriteString(indent + yamlListMarker + yamlNull + "\n")
+		case isYAMLMapping(item):
+			writeYAMLMapping(b, item, indent+yamlIndent, indent+yamlListMarker)
+		default:
+			b.WriteString(indent + yamlListMarker + yamlScalar(item) + "\n")
+		}
+	}
+}
+
+// writeYAMLMapping writes struct fields or map entries. The first key uses
+// firstPrefix so it can share a line with a list marker.
+func writeYAMLMapping(b *strings.Builder, v reflect.Value, indent, firstPrefix string) {
+	keys, values := yamlEntries(v)
+	if len(keys) == 0 {
+		b.WriteString(firstPrefix + yamlEmptyMap + "\n")
+
+		return
+	}
+
+	for i, key := range keys {
+		prefix := indent
+		if i == 0 {
+			prefix = firstPrefix
+		}
+
+		b.WriteString(prefix + key + ":")
+		writeYAMLValue(b, values[i], indent+yamlIndent)
+	}
+}
+
+// yamlEntries lists the keys and values of a struct or map in output order.
+// Map keys are quoted like string values, since a key such as "[INST]" or
+// "a: b" would otherwise be read back as a different node; field names come
+// from json tags and need no quoting.
+func yamlEntries(v reflect.Value) ([]string, []reflect.Value) {
+	var (
+		keys   []string
+		values []reflect.Value
+	)
+
+	if v.Kind() == reflect.Map {
+		mapKeys := v.MapKeys()
+		sort.Slice(mapKeys, func(i, j int) bool {
+			return fmt.Sprint(mapKeys[i].Interface()) < fmt.Sprint(mapKeys[j].Interface())
+		})
+
+		for _, k := range mapKeys {
+			keys = append(keys, yamlQuote(fmt.Sprint(k.Interface())))
+			values = append(values, v.MapIndex(k))
+		}
+
+		return keys, values
+	}
+
+	for i := range v.NumField() {
+		name, omitEmpty, ok := yamlFieldName(v.Type().Field(i))
+		field := v.Field(i)
+
+		if !ok || (omitEmpty && isJSONEmpty(field)) {
+			continue
+		}
+
+		keys = append(keys, name)
+		values = append(values, field)
+	}
+
+	return keys, values
+}
+
+// yamlDeref follows pointers and interfaces; nil yields an invalid Value.
+func yamlDeref(v reflect.Value) reflect.Value {
+	for v.Kind() == reflect.Pointer || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return reflect.Value{}
+		}
+
+		v = v.Elem()
+	}
+
+	return v
+}
+
+func isYAMLMapping(v reflect.Value) bool {
+	return v.Kind() == reflect.Struct || v.Kind() == reflect.Map
+}
+
+func isYAMLSeq(v reflect.Value) bool {
+	return v.Kind() == reflect.Slice || v.Kind() == reflect.Array
+}
+
+// yamlFieldName resolves the output key for a struct field from its json
+// tag. ok is false for unexported or explicitly skipped fields.
+func yamlFieldName(f reflect.StructField) (string, bool, bool) {
+	if !f.IsExported() {
+		return "", false, false
+	}
+
+	tag := f.Tag.Get(jsonTagName)
+	if tag == jsonTagSkip {
+		return "", false, false
+	}
+
+	name, opts, _ := strings.Cut(tag, ",")
+	if name == "" {
+		name = f.Name
+	}
+
+	return name, strings.Contains(opts, jsonOmitEmpty), true
+}
+
+// isJSONEmpty is the omitempty rule of encoding/json: false, zero numbers,
+// nil pointers and interfaces, and empty strings, slices, arrays and maps.
+// Structs are never empty.
+func isJSONEmpty(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Bool,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+		reflect.Float32, reflect.Float64:
+		return v.IsZero()
+	case reflect.Interface, reflect.Pointer:
+		return v.IsNil()
+	default:
+		return false
+	}
+}
+
+// yamlScalar formats a scalar. Strings are double-quoted with JSON string
+// escaping, which YAML double-quoted scalars accept, so both formats show
+// the same text.
+func yamlScalar(v reflect.Value) string {
+	switch v.Kind() {
+	case reflect.String:
+		return yamlQuote(v.String())
+	case reflect.Bool:
+		return strconv.FormatBool(v.Bool())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(v.Int(), 10)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.FormatUint(v.Uint(), 10)
+	case reflect.Float32, reflect.Float64:
+		return strconv.FormatFloat(v.Float(), 'g', -1, 64)
+	default:
+		return yamlQuote(fmt.Sprint(v.Interface()))
+	}
+}
+
+// yamlQuote quotes s as encoding/json does. Marshalling a string cannot
+// fail; invalid UTF-8 becomes U+FFFD as in the JSON output.
+func yamlQuote(s string) string {
+	quoted, _ := json.Marshal(s)
+
+	return string(quoted)
+}
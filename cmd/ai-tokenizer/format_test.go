@@ -0,0 +1,208 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+const (
+	fmtFormatterErr    = "newFormatter(%q) unexpected error: %v"
+	fmtFormatErr       = "%s Format() unexpected error: %v"
+	fmtFormatOutput    = "%s output =\n%s\nwant\n%s"
+	fmtFormatIsErr     = "newFormatter(%q, %q) error = %v, want %v"
+	fmtNDJSONLineErr   = "ndjson line %d is not valid JSON: %v"
+	fmtNDJSONLineCount = "ndjson produced %d lines, want %d"
+
+	sourceA = "a.txt"
+	sourceB = "b.txt"
+)
+
+func sampleResults() []*TokenResult {
+	return []*TokenResult{
+		{Source: sourceA, Text: "a\tb\n", Model: simpleText, TokenCount: 4},
+		{Source: sourceB, Text: "say \"hi\"", Model: simpleText, TokenCount: 6},
+	}
+}
+
+type formatTestCase struct {
+	name   string
+	format string
+	tmpl   string
+	want   string
+}
+
+func TestFormatters(t *testing.T) {
+	t.Parallel()
+
+	tests := []formatTestCase{
+		{
+			name:   "csv quotes text",
+			format: FormatCSV,
+			want: "source,model,tokenCount,text,normalizedText\n" +
+				"a.txt,simple,4,\"a\tb\n\",\n" +
+				"b.txt,simple,6,\"say \"\"hi\"\"\",\n",
+		},
+		{
+			name:   "tsv escapes control whitespace",
+			format: FormatTSV,
+			want: "source\tmodel\ttokenCount\ttext\tnormalizedText\n" +
+				"a.txt\tsimple\t4\ta\\tb\\n\t\n" +
+				"b.txt\tsimple\t6\t\"say \"\"hi\"\"\"\t\n",
+		},
+		{
+			name:   "yaml sequence of mappings",
+			format: FormatYAML,
+			want: "- source: \"a.txt\"\n  text: \"a\\tb\\n\"\n  model: \"simple\"\n" +
+				"  tokenCount: 4\n" +
+				"- source: \"b.txt\"\n  text: \"say \\\"hi\\\"\"\n  model: \"simple\"\n" +
+				"  tokenCount: 6\n",
+		},
+		{
+			name:   "template per result",
+			format: FormatTemplate,
+			tmpl:   "{{.Source}}={{.TokenCount}}",
+			want:   "a.txt=4\nb.txt=6\n",
+		},
+		{
+			name:   "table aligns columns",
+			format: FormatTable,
+			want: "SOURCE  MODEL   TOKENCOUNT  TEXT      NORMALIZEDTEXT\n" +
+				"a.txt   simple  4           a b       \n" +
+				"b.txt   simple  6           say \"hi\"  \n",
+		},
+	}
+
+	for _, testCase := range tests {
+		t.Run(testCase.name, func(t *testing.T) {
+			t.Parallel()
+			runFormatTest(t, testCase)
+		})
+	}
+}
+
+func runFormatTest(t *testing.T, testCase formatTestCase) {
+	t.Helper()
+
+	formatter, err := newFormatter(testCase.format, testCase.tmpl)
+	if err != nil {
+		t.Fatalf(fmtFormatterErr, testCase.format, err)
+	}
+
+	var buf bytes.Buffer
+
+	err = formatter.Format(&buf, sampleResults())
+	if err != nil {
+		t.Fatalf(fmtFormatErr, testCase.format, err)
+	}
+
+	if buf.String() != testCase.want {
+		t.Errorf(fmtFormatOutput, testCase.format, buf.String(), testCase.want)
+	}
+}
+
+func TestYAMLMatchesJSON(t *testing.T) {
+	t.Parallel()
+
+	// Control characters and invalid UTF-8 use JSON escapes, and an empty
+	// non-nil slice is omitted as encoding/json omits it.
+	results := []*TokenResult{{Source: sourceA, Text: "bell\a\xff<", Model: simpleText, TokenCount: 3, InvalidUTF8RunOffsets: []int{}}}
+	want := "- source: \"a.txt\"\n  text: \"bell\\u0007\ufffd\\u003c\"\n  model: \"simple\"\n  tokenCount: 3\n"
+
+	formatter, err := newFormatter(FormatYAML, "")
+	if err != nil {
+		t.Fatalf(fmtFormatterErr, FormatYAML, err)
+	}
+
+	var buf bytes.Buffer
+
+	err = formatter.Format(&buf, results)
+	if err != nil {
+		t.Fatalf(fmtFormatErr, FormatYAML, err)
+	}
+
+	if buf.String() != want {
+		t.Errorf(fmtFormatOutput, FormatYAML, buf.String(), want)
+	}
+}
+
+func TestYAMLQuotesMapKeys(t *testing.T) {
+	t.Parallel()
+
+	// Unquoted, these keys would start a comment, a flow sequence or a
+	// nested mapping, or lose their leading space.
+	results := []*TokenResult{{
+		Source: sourceA, Text: "x", Model: simpleText, TokenCount: 1,
+		SpecialTokens: map[string]int{"#x": 1, "[INST]": 2, "a:b": 3, " lead": 4},
+	}}
+	want := "- source: \"a.txt\"\n  text: \"x\"\n  model: \"simple\"\n" +
+		"  specialTokens:\n    \" lead\": 4\n    \"#x\": 1\n    \"[INST]\": 2\n    \"a:b\": 3\n" +
+		"  tokenCount: 1\n"
+
+	formatter, err := newFormatter(FormatYAML, "")
+	if err != nil {
+		t.Fatalf(fmtFormatterErr, FormatYAML, err)
+	}
+
+	var buf bytes.Buffer
+
+	err = formatter.Format(&buf, results)
+	if err != nil {
+		t.Fatalf(fmtFormatErr, FormatYAML, err)
+	}
+
+	if buf.String() != want {
+		t.Errorf(fmtFormatOutput, FormatYAML, buf.String(), want)
+	}
+}
+
+func TestNDJSONFormatterOneObjectPerLine(t *testing.T) {
+	t.Parallel()
+
+	formatter, err := newFormatter(FormatNDJSON, "")
+	if err != nil {
+		t.Fatalf(fmtFormatterErr, FormatNDJSON, err)
+	}
+
+	var buf bytes.Buffer
+
+	err = formatter.Format(&buf, sampleResults())
+	if err != nil {
+		t.Fatalf(fmtFormatErr, FormatNDJSON, err)
+	}
+
+	lines := bytes.Split(bytes.TrimSpace(buf.Bytes()), []byte("\n"))
+	if len(lines) != len(sampleResults()) {
+		t.Fatalf(fmtNDJSONLineCount, len(lines), len(sampleResults()))
+	}
+
+	for i, line := range lines {
+		var r TokenResult
+
+		err = json.Unmarshal(line, &r)
+		if err != nil {
+			t.Errorf(fmtNDJSONLineErr, i, err)
+		}
+	}
+}
+
+func TestNewFormatterErrors(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		format string
+		tmpl   string
+		want   error
+	}{
+		{format: "xml", tmpl: "", want: ErrUnknownFormat},
+		{format: FormatTemplate, tmpl: "", want: ErrTemplateMissing},
+	}
+
+	for _, tc := range tests {
+		_, err := newFormatter(tc.format, tc.tmpl)
+		if !errors.Is(err, tc.want) {
+			t.Errorf(fmtFormatIsErr, tc.format, tc.tmpl, err, tc.want)
+		}
+	}
+}
@@ -0,0 +1,682 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"text/tabwriter"
+
+	tokenizer "github.com/nnikolov3/ai-tokenizer"
+)
+
+// GitReport is the output of the git command: the token counts of every
+// changed file before and after, and the growth over all of them. Base and
+// Head name the revisions; Diff names the diff read instead of running git.
+type GitReport struct {
+	Base         string      `json:"base,omitempty"`
+	Head         string      `json:"head,omitempty"`
+	Diff         string      `json:"diff,omitempty"`
+	Model        string      `json:"model"`
+	Files        []FileDelta `json:"files"`
+	Before       int         `json:"before"`
+	After        int         `json:"after"`
+	Delta        int         `json:"delta"`
+	DeltaPercent float64     `json:"deltaPercent"`
+	MaxGrowth    int         `json:"maxGrowth,omitempty"`
+}
+
+// FileDelta is one changed file. OldPath is set for renames and copies.
+type FileDelta struct {
+	Path         string  `json:"path"`
+	OldPath      string  `json:"oldPath,omitempty"`
+	Status       string  `json:"status"`
+	Before       int     `json:"before"`
+	After        int     `json:"after"`
+	Delta        int     `json:"delta"`
+	DeltaPercent float64 `json:"deltaPercent"`
+}
+
+const (
+	FlagNameBase      = "base"
+	FlagNameHead      = "head"
+	FlagNameRepo      = "repo"
+	FlagNameDiff      = "diff"
+	FlagNameMaxGrowth = "max-growth"
+
+	FlagHelpBase         = "Revision to count from"
+	FlagHelpHead         = "Revision to count to (default: the working tree)"
+	FlagHelpRepo         = "Directory of the local git repository"
+	FlagHelpGitDiff      = "Read a unified diff from this file, or - for stdin, instead of running git"
+	FlagHelpMaxGrowth    = "Exit with status 4 when the total grows by more than this many tokens (0 = no limit)"
+	FlagHelpGitModelFile = "Count with a local model file (default: " + tokenizer.DefaultModel + ")"
+	FlagHelpGitFormat    = "Output format: text or json"
+
+	DefaultGitBase = "HEAD"
+	GitExecutable  = "git"
+	// GitNoLazyFetch stops git from fetching missing objects of a partial
+	// clone, so counting a revision never goes to the network.
+	GitNoLazyFetch = "GIT_NO_LAZY_FETCH=1"
+	GitWorkTree    = "working tree"
+
+	// Statuses of a FileDelta.
+	FileStatusAdded    = "added"
+	FileStatusModified = "modified"
+	FileStatusDeleted  = "deleted"
+	FileStatusRenamed  = "renamed"
+	FileStatusCopied   = "copied"
+
+	// gitZeroHash is the blob git reports for working tree content it has
+	// not hashed.
+	gitZeroHash = "0000000000000000000000000000000000000000"
+	// gitModeFile is the mode git gives a regular file.
+	gitModeFile = "100644"
+	// gitBinaryProbe is how much of a blob git itself checks for NUL bytes
+	// to call it binary.
+	gitBinaryProbe = 8000
+
+	UsageGitFmt = "" +
+		"Usage: %s git [options] [pathspec...]\n\n" +
+		"Reports the token count of every file changed between two revisions of\n" +
+		"the local repository, before and after, with the total growth. Without\n" +
+		"-head the working tree is compared. With -diff, a unified diff is read\n" +
+		"instead and only the lines of its hunks are counted.\n\n" +
+		"Options:\n"
+
+	GitRevisionsFmt = "Tokens from %s to %s, model %s\n\n"
+	GitDiffFmt      = "Tokens in the hunks of %s, model %s\n\n"
+	GitTableHeader  = "STATUS\tFILE\tBEFORE\tAFTER\tDELTA\tCHANGE\n"
+	GitTableRowFmt  = "%s\t%s\t%d\t%d\t%+d\t%s\n"
+	GitPercentFmt   = "%+.2f%%"
+	GitNoPercent    = "-"
+	GitTotalLabel   = "TOTAL"
+	GitRenameFmt    = "%s -> %s"
+
+	ErrGitMsg             = "git failed"
+	ErrGitFmt             = "%w: git %s: %s"
+	ErrGitNotFoundMsg     = "git executable not found"
+	ErrGitRawFmt          = "%w: unexpected git diff --raw output %q"
+	ErrGitDiffArgsMsg     = "pathspecs need git, not -diff"
+	ErrGrowthExceededMsg  = "token growth exceeded"
+	ErrGrowthDetailFmt    = "%w: total grew by %d tokens (limit %d)"
+	ErrUnknownGitFormatFs = "%w %q (want text or json)"
+	ErrWrapReadWorkTree   = "read %s: %w"
+	ErrWrapGitTable       = "write git report: %w"
+)
+
+var (
+	// ErrGit is returned when git fails, for example on an unknown
+	// revision or outside a repository.
+	ErrGit = errors.New(ErrGitMsg)
+	// ErrGitNotFound is returned when no git executable is on the PATH.
+	ErrGitNotFound = errors.New(ErrGitNotFoundMsg)
+	// ErrGitDiffArgs is returned for pathspecs together with -diff.
+	ErrGitDiffArgs = errors.New(ErrGitDiffArgsMsg)
+	// ErrGrowthExceeded is returned when the total grows past -max-growth.
+	ErrGrowthExceeded = errors.New(ErrGrowthExceededMsg)
+)
+
+// gitFlags collects the flags of the git command.
+type gitFlags struct {
+	base      string
+	head      string
+	repo      string
+	diff      string
+	modelFile string
+	maxGrowth int
+	format    string
+	pathspecs []string
+}
+
+// gitChange is one entry of git diff --raw: the blobs of both sides, with
+// an empty path for a side that does not exist.
+type gitChange struct {
+	status  string
+	oldPath string
+	newPath string
+	oldMode string
+	newMode string
+	oldBlob string
+	newBlob string
+}
+
+// gitRepo runs the local git executable in one repository. It never talks
+// to a remote.
+type gitRepo struct {
+	dir string
+}
+
+// runGit implements the git command.
+func runGit(args []string, streams cliStreams) error {
+	flags, err := parseGitFlags(args, streams.stderr)
+	if err != nil {
+		return reportedInputError(err)
+	}
+
+	if flags.format != FormatText && flags.format != FormatJSON {
+		return inputError(fmt.Errorf(ErrUnknownGitFormatFs, ErrUnknownFormat, flags.format))
+	}
+
+	if flags.diff != "" && len(flags.pathspecs) > 0 {
+		return inputError(ErrGitDiffArgs)
+	}
+
+	tok := tokenizer.NewTokenizer()
+	if flags.modelFile != "" {
+		tok, err = newFileTokenizer(flags.modelFile, nil)
+		if err != nil {
+			return err
+		}
+	}
+
+	var report *GitReport
+	if flags.diff != "" {
+		report, err = diffDeltas(flags.diff, tok, streams.stdin)
+	} else {
+		report, err = revisionDeltas(flags, tok)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	report.MaxGrowth = flags.maxGrowth
+
+	var limit error
+	if flags.maxGrowth > 0 && report.Delta > flags.maxGrowth {
+		limit = limitError(fmt.Errorf(ErrGrowthDetailFmt, ErrGrowthExceeded, report.Delta, flags.maxGrowth))
+	}
+
+	if flags.format == FormatJSON {
+		err = writeJSON(streams.stdout, report)
+	} else {
+		err = writeGitReport(streams.stdout, report)
+	}
+
+	if err != nil && !isBrokenPipeOver(err, limit) {
+		return ioError(err)
+	}
+
+	return limit
+}
+
+func parseGitFlags(args []string, stderr io.Writer) (*gitFlags, error) {
+	fs := flag.NewFlagSet(ExecutableDefault+" "+CommandGit, flag.ContinueOnError)
+	fs.SetOutput(stderr)
+
+	base := fs.String(FlagNameBase, DefaultGitBase, FlagHelpBase)
+	head := fs.String(FlagNameHead, "", FlagHelpHead)
+	repo := fs.String(FlagNameRepo, ".", FlagHelpRepo)
+	diff := fs.String(FlagNameDiff, "", FlagHelpGitDiff)
+	modelFile := fs.String(FlagNameModelFile, "", FlagHelpGitModelFile)
+	maxGrowth := fs.Int(FlagNameMaxGrowth, 0, FlagHelpMaxGrowth)
+	format := fs.String(FlagNameFormat, FormatText, FlagHelpGitFormat)
+	outputJSON := fs.Bool(FlagNameJSON, false, FlagHelpJSON)
+
+	fs.Usage = func() { _ = printCommandUsage(stderr, fs, UsageGitFmt) }
+
+	err := fs.Parse(args)
+	if err != nil {
+		return nil, err
+	}
+
+	if *outputJSON {
+		*format = FormatJSON
+	}
+
+	return &gitFlags{
+		base:      *base,
+		head:      *head,
+		repo:      *repo,
+		diff:      *diff,
+		modelFile: *modelFile,
+		maxGrowth: max(*maxGrowth, 0),
+		format:    *format,
+		pathspecs: fs.Args(),
+	}, nil
+}
+
+// revisionDeltas counts every text file git reports as changed between the
+// revisions, in full on both sides.
+func revisionDeltas(flags *gitFlags, tok *tokenizer.Tokenizer) (*GitReport, error) {
+	repo := gitRepo{dir: flags.repo}
+
+	// Outside a repository git diff falls back to comparing two paths, so
+	// the repository is checked first.
+	top, err := repo.topLevel()
+	if err != nil {
+		return nil, err
+	}
+
+	changes, err := repo.changes(flags.base, flags.head, flags.pathspecs)
+	if err != nil {
+		return nil, err
+	}
+
+	head := flags.head
+	if head == "" {
+		head = GitWorkTree
+	}
+
+	report := newGitReport(tok)
+	report.Base, report.Head = flags.base, head
+
+	if flags.head == "" {
+		untracked, err := repo.untracked(top, flags.pathspecs)
+		if err != nil {
+			return nil, err
+		}
+
+		// Untracked files go among the changes in path order, as git
+		// itself would list them once added.
+		changes = append(changes, untracked...)
+		slices.SortStableFunc(changes, func(a, b gitChange) int {
+			return strings.Compare(a.path(), b.path())
+		})
+	} else {
+		top = ""
+	}
+
+	for _, change := range changes {
+		before, binary, err := repo.count(tok, change.oldPath, change.oldMode, change.oldBlob, "")
+		if err != nil {
+			return nil, err
+		}
+
+		after, newBinary, err := repo.count(tok, change.newPath, change.newMode, change.newBlob, top)
+		if err != nil {
+			return nil, err
+		}
+
+		if binary || newBinary {
+			continue
+		}
+
+		report.add(fileDelta(change.newPath, change.oldPath, change.status, before, after))
+	}
+
+	return report, nil
+}
+
+// diffDeltas counts the two sides of every hunk of a unified diff, each
+// without the tokens a model adds around every input. Lines outside the
+// hunks are not in a diff, so before and after cover only the hunks, and
+// the delta can differ from that of whole files where a token spans the
+// edge of a hunk.
+func diffDeltas(path string, tok *tokenizer.Tokenizer, stdin io.Reader) (*GitReport, error) {
+	source, text, err := readDiff(path, stdin)
+	if err != nil {
+		return nil, err
+	}
+
+	files, err := parseUnifiedDiff(strings.NewReader(text))
+	if err != nil {
+		return nil, err
+	}
+
+	report := newGitReport(tok)
+	report.Diff = source
+
+	for _, file := range files {
+		if file.binary {
+			continue
+		}
+
+		before, after := 0, 0
+		for i := range file.hunks {
+			before += tok.EstimateContentTokens(file.hunks[i].side(DiffRemoved))
+			after += tok.EstimateContentTokens(file.hunks[i].side(DiffAdded))
+		}
+
+		report.add(fileDelta(file.newPath, file.oldPath, diffFileStatus(&file), before, after))
+	}
+
+	return report, nil
+}
+
+// readDiff reads a diff file, or stdin for "" or "-", and returns the
+// source name for the report.
+func readDiff(path string, stdin io.Reader) (string, string, error) {
+	if path == "" || path == "-" {
+		text, err := readStdin(stdin)
+		if err != nil {
+			return SourceStdin, "", ioError(err)
+		}
+
+		return SourceStdin, text, nil
+	}
+
+	text, err := readFile(path)
+	if err != nil {
+		return path, "", fileError(err)
+	}
+
+	return path, text, nil
+}
+
+func newGitReport(tok *tokenizer.Tokenizer) *GitReport {
+	return &GitReport{
+		Base:         "",
+		Head:         "",
+		Diff:         "",
+		Model:        tok.GetModel(),
+		Files:        []FileDelta{},
+		Before:       0,
+		After:        0,
+		Delta:        0,
+		DeltaPercent: 0,
+		MaxGrowth:    0,
+	}
+}
+
+func (r *GitReport) add(file FileDelta) {
+	r.Files = append(r.Files, file)
+	r.Before += file.Before
+	r.After += file.After
+	r.Delta = r.After - r.Before
+	r.DeltaPercent = deltaPercent(r.After, r.Before)
+}
+
+// fileDelta names a file by its new path, or its old one when deleted, and
+// keeps the old path only when it differs.
+func fileDelta(newPath, oldPath, status string, before, after int) FileDelta {
+	path := newPath
+	if path == "" {
+		path, oldPath = oldPath, ""
+	}
+
+	if oldPath == path {
+		oldPath = ""
+	}
+
+	return FileDelta{
+		Path:         path,
+		OldPath:      oldPath,
+		Status:       status,
+		Before:       before,
+		After:        after,
+		Delta:        after - before,
+		DeltaPercent: deltaPercent(after, before),
+	}
+}
+
+func diffFileStatus(f *diffFile) string {
+	switch {
+	case f.oldPath == "":
+		return FileStatusAdded
+	case f.newPath == "":
+		return FileStatusDeleted
+	case f.oldPath != f.newPath:
+		return FileStatusRenamed
+	default:
+		return FileStatusModified
+	}
+}
+
+// path names a change by its new path, or its old one when deleted.
+func (c *gitChange) path() string {
+	if c.newPath == "" {
+		return c.oldPath
+	}
+
+	return c.newPath
+}
+
+// changes lists what git diff --raw reports between base and head, or
+// between base and the working tree when head is empty.
+func (g gitRepo) changes(base, head string, pathspecs []string) ([]gitChange, error) {
+	// --end-of-options keeps a revision such as --output=file from being
+	// read as an option.
+	args := []string{"diff", "--raw", "-z", "--no-abbrev", "--no-ext-diff", "--no-textconv", "-M", "--end-of-options", base}
+	if head != "" {
+		args = append(args, head)
+	}
+
+	out, err := g.run(append(append(args, "--"), pathspecs...)...)
+	if err != nil {
+		return nil, err
+	}
+
+	return parseRawDiff(out)
+}
+
+// untracked lists the files below top that git neither tracks nor ignores,
+// which git diff leaves out, as added changes read from the working tree.
+func (g gitRepo) untracked(top string, pathspecs []string) ([]gitChange, error) {
+	args := []string{"ls-files", "--others", "--exclude-standard", "--full-name", "-z", "--"}
+
+	out, err := g.run(append(args, pathspecs...)...)
+	if err != nil {
+		return nil, err
+	}
+
+	var changes []gitChange
+
+	for _, path := range strings.Split(string(out), "\x00") {
+		if path == "" {
+			continue
+		}
+
+		info, err := os.Lstat(filepath.Join(top, filepath.FromSlash(path)))
+		if err != nil {
+			return nil, fileError(fmt.Errorf(ErrWrapReadWorkTree, path, err))
+		}
+
+		// Only the prefix of a mode matters to count, so any regular file
+		// is 100644 and anything else is skipped like a symlink.
+		mode := ""
+		if info.Mode().IsRegular() {
+			mode = gitModeFile
+		}
+
+		changes = append(changes, gitChange{
+			status:  FileStatusAdded,
+			oldPath: "",
+			newPath: path,
+			oldMode: "",
+			newMode: mode,
+			oldBlob: "",
+			newBlob: gitZeroHash,
+		})
+	}
+
+	return changes, nil
+}
+
+// parseRawDiff reads the NUL-separated entries of git diff --raw -z:
+// ":oldmode newmode oldblob newblob status", then one path, or two for
+// renames and copies.
+func parseRawDiff(out []byte) ([]gitChange, error) {
+	fields := strings.Split(strings.TrimSuffix(string(out), "\x00"), "\x00")
+
+	var changes []gitChange
+
+	for i := 0; i < len(fields) && fields[i] != ""; {
+		meta := strings.Fields(strings.TrimPrefix(fields[i], ":"))
+		if len(meta) != 5 || i+1 >= len(fields) {
+			return nil, ioError(fmt.Errorf(ErrGitRawFmt, ErrGit, fields[i]))
+		}
+
+		change := gitChange{
+			status:  "",
+			oldPath: fields[i+1],
+			newPath: fields[i+1],
+			oldMode: meta[0],
+			newMode: meta[1],
+			oldBlob: meta[2],
+			newBlob: meta[3],
+		}
+		i += 2
+
+		switch meta[4][0] {
+		case 'A':
+			change.status, change.oldPath = FileStatusAdded, ""
+		case 'D':
+			change.status, change.newPath = FileStatusDeleted, ""
+		case 'R', 'C':
+			if i >= len(fields) {
+				return nil, ioError(fmt.Errorf(ErrGitRawFmt, ErrGit, meta[4]))
+			}
+
+			change.status, change.newPath = FileStatusRenamed, fields[i]
+			if meta[4][0] == 'C' {
+				change.status = FileStatusCopied
+			}
+
+			i++
+		default:
+			change.status = FileStatusModified
+		}
+
+		changes = append(changes, change)
+	}
+
+	return changes, nil
+}
+
+// count returns the tokens of one side of a change, zero for a side that
+// does not exist or is not a regular file, such as a symlink or submodule.
+// A blob git has not hashed is read from the working tree below top. The
+// second result reports binary content, which is not counted.
+func (g gitRepo) count(tok *tokenizer.Tokenizer, path, mode, blob, top string) (int, bool, error) {
+	if path == "" || !strings.HasPrefix(mode, "100") {
+		return 0, false, nil
+	}
+
+	var (
+		raw []byte
+		err error
+	)
+
+	if blob == gitZeroHash && top != "" {
+		raw, err = os.ReadFile(filepath.Join(top, filepath.FromSlash(path)))
+		if err != nil {
+			return 0, false, fileError(fmt.Errorf(ErrWrapReadWorkTree, path, err))
+		}
+	} else {
+		raw, err = g.run("cat-file", "blob", blob)
+		if err != nil {
+			return 0, false, err
+		}
+	}
+
+	if isBinary(raw) {
+		return 0, true, nil
+	}
+
+	in, err := decodeSource(path, string(raw), EncodingAuto)
+	if err != nil {
+		return 0, false, err
+	}
+
+	return tok.EstimateTokens(in.text), false, nil
+}
+
+// isBinary applies git's test, a NUL byte near the start, to content that
+// is not UTF-16, whose ASCII characters are half NUL bytes.
+func isBinary(raw []byte) bool {
+	switch detectEncoding(raw) {
+	case EncodingUTF16LE, EncodingUTF16BE:
+		return false
+	default:
+		return bytes.IndexByte(raw[:min(len(raw), gitBinaryProbe)], 0) >= 0
+	}
+}
+
+// topLevel is the root of the working tree, which raw diff paths are
+// relative to.
+func (g gitRepo) topLevel() (string, error) {
+	out, err := g.run("rev-parse", "--show-toplevel")
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimSpace(string(out)), nil
+}
+
+// run runs git in the repository and returns its standard output. Git's
+// own message, such as an unknown revision or an object a partial clone
+// lacks, becomes an input error.
+func (g gitRepo) run(args ...string) ([]byte, error) {
+	cmd := exec.Command(GitExecutable, append([]string{"-C", g.dir}, args...)...)
+	cmd.Env = append(os.Environ(), GitNoLazyFetch)
+
+	out, err := cmd.Output()
+	if err == nil {
+		return out, nil
+	}
+
+	var exitErr *exec.ExitError
+
+	switch {
+	case errors.Is(err, exec.ErrNotFound):
+		return nil, inputError(ErrGitNotFound)
+	case errors.As(err, &exitErr):
+		return nil, inputError(fmt.Errorf(ErrGitFmt, ErrGit, args[0], strings.TrimSpace(string(exitErr.Stderr))))
+	default:
+		return nil, ioError(fmt.Errorf(ErrGitFmt, ErrGit, args[0], err))
+	}
+}
+
+// writeGitReport prints the changed files and the total as a table.
+func writeGitReport(w io.Writer, r *GitReport) error {
+	var err error
+	if r.Diff != "" {
+		_, err = fmt.Fprintf(w, GitDiffFmt, r.Diff, r.Model)
+	} else {
+		_, err = fmt.Fprintf(w, GitRevisionsFmt, r.Base, r.Head, r.Model)
+	}
+
+	tw := tabwriter.NewWriter(
+		w,
+		ReportTableMinWidth,
+		ReportTableTabWidth,
+		ReportTablePadding,
+		ReportTablePadChar,
+		0,
+	)
+
+	if err == nil {
+		_, err = fmt.Fprint(tw, GitTableHeader)
+	}
+
+	for i := 0; err == nil && i < len(r.Files); i++ {
+		f := r.Files[i]
+
+		name := f.Path
+		if f.OldPath != "" {
+			name = fmt.Sprintf(GitRenameFmt, f.OldPath, f.Path)
+		}
+
+		_, err = fmt.Fprintf(tw, GitTableRowFmt, f.Status, name, f.Before, f.After, f.Delta, gitPercent(f.Before, f.DeltaPercent))
+	}
+
+	if err == nil {
+		_, err = fmt.Fprintf(tw, GitTableRowFmt, GitTotalLabel, "", r.Before, r.After, r.Delta, gitPercent(r.Before, r.DeltaPercent))
+	}
+
+	if err == nil {
+		err = tw.Flush()
+	}
+
+	if err != nil {
+		return fmt.Errorf(ErrWrapGitTable, err)
+	}
+
+	return nil
+}
+
+// gitPercent formats a change, which has no percentage for a new file.
+func gitPercent(before int, percent float64) string {
+	if before == 0 {
+		return GitNoPercent
+	}
+
+	return fmt.Sprintf(GitPercentFmt, percent)
+}
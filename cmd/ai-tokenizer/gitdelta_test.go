@@ -0,0 +1,283 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	tokenizer "github.com/nnikolov3/ai-tokenizer"
+)
+
+const (
+	testNewFile = "a brand new file"
+
+	fmtGitFiles  = "git report files = %+v, want %+v"
+	fmtGitReport = "git report = %+v, want before %d after %d"
+	fmtGitOutput = "git output = %q, want it to contain %q"
+	fmtGitRun    = "git %v: %v: %s"
+)
+
+// testRepo creates a repository with two commits, tagged base and head:
+// keep.md grows, gone.md is deleted, moved.md is renamed from old.md,
+// new.md and a binary file are added. The working tree then changes
+// keep.md again and adds draft.md, untracked, and scratch.md, excluded.
+func testRepo(t *testing.T) string {
+	t.Helper()
+
+	_, err := exec.LookPath(GitExecutable)
+	if err != nil {
+		t.Skip(err)
+	}
+
+	dir := t.TempDir()
+
+	git := func(args ...string) {
+		t.Helper()
+
+		cmd := exec.Command(GitExecutable, append([]string{"-C", dir, "-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)...)
+		cmd.Env = append(os.Environ(), "GIT_CONFIG_GLOBAL=/dev/null", "GIT_CONFIG_NOSYSTEM=1")
+
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Fatalf(fmtGitRun, args, err, out)
+		}
+	}
+
+	write := func(name, content string) {
+		t.Helper()
+
+		err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o600)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	git("init", "-q")
+	write("keep.md", boundInput)
+	write("gone.md", "hi")
+	write("old.md", strings.Repeat("a moved prompt line\n", 10))
+	git("add", ".")
+	git("commit", "-q", "-m", "base")
+	git("tag", "base")
+
+	write("keep.md", boundInput+" again")
+	write("new.md", testNewFile)
+	write("logo.png", "\x89PNG\x00\x00\x00")
+	git("rm", "-q", "gone.md")
+	git("mv", "old.md", "moved.md")
+	git("add", ".")
+	git("commit", "-q", "-m", "head")
+	git("tag", "head")
+
+	write("keep.md", boundInput+" again and again")
+	write("draft.md", testNewFile)
+	write("scratch.md", testNewFile)
+	write(filepath.Join(".git", "info", "exclude"), "scratch.md\n")
+
+	return dir
+}
+
+func runGitReport(t *testing.T, args ...string) (GitReport, int, string) {
+	t.Helper()
+
+	var stdout, stderr bytes.Buffer
+
+	code := runMain(append([]string{CommandGit, "-" + FlagNameJSON}, args...), cliStreams{stdin: nil, stdout: &stdout, stderr: &stderr})
+
+	var report GitReport
+	if code == ExitOK || code == ExitLimitExceeded {
+		err := json.Unmarshal(stdout.Bytes(), &report)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return report, code, stderr.String()
+}
+
+func TestGitRevisions(t *testing.T) {
+	t.Parallel()
+
+	dir := testRepo(t)
+	tok := tokenizer.NewTokenizer()
+	moved := tok.EstimateTokens(strings.Repeat("a moved prompt line\n", 10))
+	added := tok.EstimateTokens(testNewFile)
+
+	// "hello world" is 7 tokens, "hi" 1 and "hello world again" 11.
+	report, code, stderr := runGitReport(t, "-"+FlagNameRepo, dir, "-"+FlagNameBase, "base", "-"+FlagNameHead, "head")
+	if code != ExitOK {
+		t.Fatalf(fmtRunMainCode, CommandGit, code, ExitOK, stderr)
+	}
+
+	want := []FileDelta{
+		{Path: "gone.md", OldPath: "", Status: FileStatusDeleted, Before: 1, After: 0, Delta: -1, DeltaPercent: -100},
+		{Path: "keep.md", OldPath: "", Status: FileStatusModified, Before: 7, After: 11, Delta: 4, DeltaPercent: deltaPercent(11, 7)},
+		{Path: "moved.md", OldPath: "old.md", Status: FileStatusRenamed, Before: moved, After: moved, Delta: 0, DeltaPercent: 0},
+		{Path: "new.md", OldPath: "", Status: FileStatusAdded, Before: 0, After: added, Delta: added, DeltaPercent: 0},
+	}
+
+	if len(report.Files) != len(want) {
+		t.Fatalf(fmtGitFiles, report.Files, want)
+	}
+
+	for i := range want {
+		if report.Files[i] != want[i] {
+			t.Errorf(fmtGitFiles, report.Files[i], want[i])
+		}
+	}
+
+	before, after := 8+moved, 11+moved+added
+	if report.Before != before || report.After != after || report.Delta != after-before || report.Base != "base" || report.Head != "head" {
+		t.Errorf(fmtGitReport, report, before, after)
+	}
+}
+
+func TestGitWorkTreeAndThreshold(t *testing.T) {
+	t.Parallel()
+
+	dir := testRepo(t)
+
+	// keep.md is "hello world again and again" in the working tree.
+	after := tokenizer.NewTokenizer().EstimateTokens(boundInput + " again and again")
+
+	tests := []struct {
+		name     string
+		args     []string
+		wantCode int
+	}{
+		{name: "under", args: []string{"-" + FlagNameMaxGrowth, strconv.Itoa(after - 11)}, wantCode: ExitOK},
+		{name: "over", args: []string{"-" + FlagNameMaxGrowth, strconv.Itoa(after - 12)}, wantCode: ExitLimitExceeded},
+	}
+
+	for _, tt := range tests {
+		args := append([]string{"-" + FlagNameRepo, dir, "-" + FlagNameBase, "head"}, tt.args...)
+
+		report, code, stderr := runGitReport(t, append(args, "keep.md")...)
+		if code != tt.wantCode {
+			t.Errorf(fmtRunMainCode, tt.name, code, tt.wantCode, stderr)
+		}
+
+		if len(report.Files) != 1 || report.Head != GitWorkTree || report.Files[0].After != after {
+			t.Errorf(fmtGitReport, report, 11, after)
+		}
+	}
+}
+
+func TestGitWorkTreeUntracked(t *testing.T) {
+	t.Parallel()
+
+	dir := testRepo(t)
+	tok := tokenizer.NewTokenizer()
+	added := tok.EstimateTokens(testNewFile)
+	after := tok.EstimateTokens(boundInput + " again and again")
+
+	report, code, stderr := runGitReport(t, "-"+FlagNameRepo, dir, "-"+FlagNameBase, "head")
+	if code != ExitOK {
+		t.Fatalf(fmtRunMainCode, CommandGit, code, ExitOK, stderr)
+	}
+
+	want := []FileDelta{
+		{Path: "draft.md", OldPath: "", Status: FileStatusAdded, Before: 0, After: added, Delta: added, DeltaPercent: 0},
+		{Path: "keep.md", OldPath: "", Status: FileStatusModified, Before: 11, After: after, Delta: after - 11, DeltaPercent: deltaPercent(after, 11)},
+	}
+
+	if len(report.Files) != len(want) {
+		t.Fatalf(fmtGitFiles, report.Files, want)
+	}
+
+	for i := range want {
+		if report.Files[i] != want[i] {
+			t.Errorf(fmtGitFiles, report.Files[i], want[i])
+		}
+	}
+}
+
+func TestGitRunNoLazyFetch(t *testing.T) {
+	t.Parallel()
+
+	repo := gitRepo{dir: testRepo(t)}
+
+	out, err := repo.run("-c", "alias.lazyfetch=!printenv GIT_NO_LAZY_FETCH", "lazyfetch")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := strings.TrimSpace(string(out)); got != "1" {
+		t.Errorf(fmtGitOutput, got, "1")
+	}
+}
+
+func TestGitDiffFromStdin(t *testing.T) {
+	t.Parallel()
+
+	var stdout bytes.Buffer
+
+	code := runMain([]string{CommandGit, "-" + FlagNameDiff, "-"}, cliStreams{
+		stdin:  strings.NewReader(testGitDiff),
+		stdout: &stdout,
+		stderr: io.Discard,
+	})
+	if code != ExitOK {
+		t.Fatalf(fmtRunMainCode, CommandGit, code, ExitOK, "")
+	}
+
+	for _, want := range []string{"Tokens in the hunks of stdin", "modified  prompt.md", "renamed   a.md -> b.md", "TOTAL"} {
+		if !strings.Contains(stdout.String(), want) {
+			t.Errorf(fmtGitOutput, stdout.String(), want)
+		}
+	}
+
+	if strings.Contains(stdout.String(), "logo.png") {
+		t.Errorf(fmtGitOutput, stdout.String(), "no binary file")
+	}
+}
+
+func TestRunMainGitErrors(t *testing.T) {
+	t.Parallel()
+
+	dir := testRepo(t)
+
+	tests := []runMainTestCase{
+		{
+			name:       "unknown revision",
+			args:       []string{CommandGit, "-" + FlagNameRepo, dir, "-" + FlagNameBase, "nope"},
+			wantStderr: ErrGitMsg,
+			wantCode:   ExitInputError,
+		},
+		{
+			name:       "revision like an option",
+			args:       []string{CommandGit, "-" + FlagNameRepo, dir, "-" + FlagNameBase, "--output=" + filepath.Join(dir, "out")},
+			wantStderr: ErrGitMsg,
+			wantCode:   ExitInputError,
+		},
+		{
+			name:       "not a repository",
+			args:       []string{CommandGit, "-" + FlagNameRepo, t.TempDir()},
+			wantStderr: ErrGitMsg,
+			wantCode:   ExitInputError,
+		},
+		{
+			name:       "pathspecs with diff",
+			args:       []string{CommandGit, "-" + FlagNameDiff, "-", "prompts/"},
+			wantStderr: ErrGitDiffArgsMsg,
+			wantCode:   ExitInputError,
+		},
+		{
+			name:       "malformed diff",
+			args:       []string{CommandGit, "-" + FlagNameDiff, "-"},
+			stdin:      "--- a\n+++ b\n@@ -1,2 +1 @@\n",
+			wantStderr: ErrBadDiffMsg,
+			wantCode:   ExitInputError,
+		},
+	}
+
+	for _, tt := range tests {
+		runMainTest(t, tt)
+	}
+}
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// JSON-RPC 2.0 error codes.
+const (
+	RPCParseError     = -32700
+	RPCInvalidRequest = -32600
+	RPCMethodNotFound = -32601
+	RPCInvalidParams  = -32602
+	RPCInternalError  = -32603
+
+	RPCVersion = "2.0"
+
+	ErrRPCParseMsg         = "parse error"
+	ErrRPCInvalidMsg       = "invalid request"
+	ErrRPCMethodFmt        = "method not found: %s"
+	ErrRPCInvalidParamsFmt = "invalid params: %v"
+	ErrWrapReadRPC         = "read request: %w"
+	ErrWrapWriteRPC        = "write message: %w"
+
+	// Base protocol framing of the Language Server Protocol: headers, a
+	// blank line, then Content-Length bytes of JSON.
+	HeaderContentLength = "Content-Length"
+	HeaderSeparator     = ":"
+	FrameHeaderFmt      = HeaderContentLength + ": %d\r\n\r\n"
+	// RPCMaxFrame caps the body a Content-Length header may announce, so a
+	// bad header cannot make the server allocate without bound.
+	RPCMaxFrame = 64 * 1024 * 1024
+
+	ErrBadFrameMsg       = "malformed message header"
+	ErrBadFrameFmt       = "%w %q"
+	ErrMissingLengthFmt  = "%w: no " + HeaderContentLength
+	ErrFrameTooLargeFmt  = "%w: " + HeaderContentLength + " %d exceeds %d bytes"
+	ErrWrapReadFrameBody = "read message body: %w"
+)
+
+// ErrBadFrame is returned for a framed message with unreadable headers.
+var ErrBadFrame = errors.New(ErrBadFrameMsg)
+
+// rpcRequest is a JSON-RPC request, or a notification when it has no id.
+type rpcRequest struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+}
+
+// rpcResponse carries either a result or an error.
+type rpcResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  any             `json:"result"`
+	Error   *rpcError       `json:"error"`
+}
+
+// MarshalJSON writes result, even when it is null, unless the response is
+// an error; JSON-RPC requires exactly one of the two members.
+func (r rpcResponse) MarshalJSON() ([]byte, error) {
+	if r.Error != nil {
+		return json.Marshal(struct {
+			JSONRPC string          `json:"jsonrpc"`
+			ID      json.RawMessage `json:"id"`
+			Error   *rpcError       `json:"error"`
+		}{JSONRPC: r.JSONRPC, ID: r.ID, Error: r.Error})
+	}
+
+	return json.Marshal(struct {
+		JSONRPC string          `json:"jsonrpc"`
+		ID      json.RawMessage `json:"id"`
+		Result  any             `json:"result"`
+	}{JSONRPC: r.JSONRPC, ID: r.ID, Result: r.Result})
+}
+
+// rpcNotification is a message from the server that expects no response.
+type rpcNotification struct {
+	JSONRPC string `json:"jsonrpc"`
+	Method  string `json:"method"`
+	Params  any    `json:"params"`
+}
+
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// rpcHandler answers one method call with a result or an error.
+type rpcHandler func(method string, params json.RawMessage) (any, *rpcError)
+
+// jsonNull is the id of responses to requests whose id could not be read.
+var jsonNull = json.RawMessage("null")
+
+func methodNotFound(method string) *rpcError {
+	return &rpcError{Code: RPCMethodNotFound, Message: fmt.Sprintf(ErrRPCMethodFmt, method)}
+}
+
+func invalidParams(err error) *rpcError {
+	return &rpcError{Code: RPCInvalidParams, Message: fmt.Sprintf(ErrRPCInvalidParamsFmt, err)}
+}
+
+// decodeParams unmarshals params into v, treating absent params as {}.
+func decodeParams(params json.RawMessage, v any) *rpcError {
+	if len(params) == 0 {
+		return nil
+	}
+
+	err := json.Unmarshal(params, v)
+	if err != nil {
+		return invalidParams(err)
+	}
+
+	return nil
+}
+
+// serveRPC reads newline-delimited JSON-RPC messages from r and writes one
+// response line per request to w, in order, until r ends. Notifications
+// are handled without a response.
+func serveRPC(r io.Reader, w io.Writer, handle rpcHandler) error {
+	reader := bufio.NewReader(r)
+
+	for {
+		line, readErr := reader.ReadBytes('\n')
+		if readErr != nil && !errors.Is(readErr, io.EOF) {
+			return fmt.Errorf(ErrWrapReadRPC, readErr)
+		}
+
+		if response := dispatchRPC(bytes.TrimSpace(line), handle); response != nil {
+			err := writeRPC(w, response)
+			if err != nil {
+				return err
+			}
+		}
+
+		if readErr != nil {
+			return nil
+		}
+	}
+}
+
+// dispatchRPC handles one message and returns its response, or nil for
+// blank lines and notifications.
+func dispatchRPC(line []byte, handle rpcHandler) *rpcResponse {
+	if len(line) == 0 {
+		return nil
+	}
+
+	var req rpcRequest
+
+	err := json.Unmarshal(line, &req)
+	if err != nil {
+		return &rpcResponse{
+			JSONRPC: RPCVersion,
+			ID:      jsonNull,
+			Result:  nil,
+			Error:   &rpcError{Code: RPCParseError, Message: ErrRPCParseMsg},
+		}
+	}
+
+	if req.JSONRPC != RPCVersion || req.Method == "" {
+		return &rpcResponse{
+			JSONRPC: RPCVersion,
+			ID:      idOrNull(req.ID),
+			Result:  nil,
+			Error:   &rpcError{Code: RPCInvalidRequest, Message: ErrRPCInvalidMsg},
+		}
+	}
+
+	result, rpcErr := handle(req.Method, req.Params)
+	if len(req.ID) == 0 {
+		return nil
+	}
+
+	return &rpcResponse{JSONRPC: RPCVersion, ID: req.ID, Result: result, Error: rpcErr}
+}
+
+func idOrNull(id json.RawMessage) json.RawMessage {
+	if len(id) == 0 {
+		return jsonNull
+	}
+
+	return id
+}
+
+// writeRPC writes a request or response as one line.
+func writeRPC(w io.Writer, message any) error {
+	data, err := json.Marshal(message)
+	if err != nil {
+		return fmt.Errorf(ErrWrapWriteRPC, err)
+	}
+
+	_, err = w.Write(append(data, '\n'))
+	if err != nil {
+		return fmt.Errorf(ErrWrapWriteRPC, err)
+	}
+
+	return nil
+}
+
+// readFrame reads one Content-Length framed message body. It returns
+// io.EOF when r ends before a message starts, and ErrBadFrame for headers it
+// cannot read or a Content-Length over RPCMaxFrame.
+func readFrame(r *bufio.Reader) ([]byte, error) {
+	length := -1
+
+	for first := true; ; first = false {
+		line, err := r.ReadString('\n')
+		if errors.Is(err, io.EOF) && first && line == "" {
+			return nil, io.EOF
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf(ErrWrapReadRPC, err)
+		}
+
+		line = strings.TrimRight(line, "\r\n")
+		if line == "" {
+			break
+		}
+
+		name, value, ok := strings.Cut(line, HeaderSeparator)
+		if !ok {
+			return nil, fmt.Errorf(ErrBadFrameFmt, ErrBadFrame, line)
+		}
+
+		if strings.EqualFold(strings.TrimSpace(name), HeaderContentLength) {
+			length, err = strconv.Atoi(strings.TrimSpace(value))
+			if err != nil || length < 0 {
+				return nil, fmt.Errorf(ErrBadFrameFmt, ErrBadFrame, line)
+			}
+		}
+	}
+
+	if length < 0 {
+		return nil, fmt.Errorf(ErrMissingLengthFmt, ErrBadFrame)
+	}
+
+	if length > RPCMaxFrame {
+		return nil, fmt.Errorf(ErrFrameTooLargeFmt, ErrBadFrame, length, RPCMaxFrame)
+	}
+
+	body := make([]byte, length)
+
+	_, err := io.ReadFull(r, body)
+	if err != nil {
+		return nil, fmt.Errorf(ErrWrapReadFrameBody, err)
+	}
+
+	return body, nil
+}
+
+// writeFrame writes a message with a Content-Length header.
+func writeFrame(w io.Writer, message any) error {
+	data, err := json.Marshal(message)
+	if err != nil {
+		return fmt.Errorf(ErrWrapWriteRPC, err)
+	}
+
+	_, err = fmt.Fprintf(w, FrameHeaderFmt, len(data))
+	if err == nil {
+		_, err = w.Write(data)
+	}
+
+	if err != nil {
+		return fmt.Errorf(ErrWrapWriteRPC, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"time"
+
+	tokenizer "github.com/nnikolov3/ai-tokenizer"
+)
+
+const (
+	// Log formats accepted by -log-format.
+	LogFormatText = "text"
+	LogFormatJSON = "json"
+
+	// Attribute keys of the CLI's log records, on top of the tokenizer's
+	// LogKeyModel, LogKeyBytes and LogKeyTokens. They are stable.
+	LogKeySource     = "source"
+	LogKeyEncoding   = "encoding"
+	LogKeyDurationMS = "duration_ms"
+	LogKeyError      = "error"
+	LogKeyExitCode   = "exit_code"
+
+	LogMsgInputRead    = "input read"
+	LogMsgInputCounted = "input counted"
+	LogMsgRunFailed    = "run failed"
+
+	ErrUnknownLogFormatMsg = "unknown log format"
+	ErrLogFormatFmt        = "%w: %q (want text or json)"
+
+	millisecondsPerSecond = 1000
+)
+
+// ErrUnknownLogFormat is returned for a -log-format other than text or json.
+var ErrUnknownLogFormat = errors.New(ErrUnknownLogFormatMsg)
+
+// parseLogLevel reads a -log-level: debug, info, warn or error.
+func parseLogLevel(value string) (slog.Level, error) {
+	var level slog.Level
+
+	err := level.UnmarshalText([]byte(value))
+
+	return level, err
+}
+
+func parseLogFormat(value string) (string, error) {
+	if value != LogFormatText && value != LogFormatJSON {
+		return "", fmt.Errorf(ErrLogFormatFmt, ErrUnknownLogFormat, value)
+	}
+
+	return value, nil
+}
+
+// newLogger returns a logger writing records at level and above to w.
+func newLogger(w io.Writer, level slog.Level, format string) *slog.Logger {
+	opts := &slog.HandlerOptions{AddSource: false, Level: level, ReplaceAttr: nil}
+
+	if format == LogFormatJSON {
+		return slog.New(slog.NewJSONHandler(w, opts))
+	}
+
+	return slog.New(slog.NewTextHandler(w, opts))
+}
+
+// logInput records one processed input: where it came from at debug, and
+// what it counted to at info.
+func logInput(logger *slog.Logger, tok *tokenizer.Tokenizer, in inputSource, result *TokenResult, start time.Time) {
+	if logger == nil {
+		return
+	}
+
+	elapsed := time.Since(start)
+
+	logger.Debug(LogMsgInputRead,
+		slog.String(LogKeySource, in.name),
+		slog.Int(tokenizer.LogKeyBytes, len(in.text)),
+		slog.String(LogKeyEncoding, in.encoding),
+	)
+	logger.Info(LogMsgInputCounted,
+		slog.String(LogKeySource, in.name),
+		slog.String(tokenizer.LogKeyModel, tok.GetModel()),
+		slog.Int(tokenizer.LogKeyBytes, len(in.text)),
+		slog.Int(tokenizer.LogKeyTokens, result.TokenCount),
+		slog.Float64(LogKeyDurationMS, elapsed.Seconds()*millisecondsPerSecond),
+	)
+}
+
+// logFailure logs err as a structured record instead of the plain line
+// runMain would print, and marks it reported. Without a logger, errors are
+// printed as before.
+func logFailure(logger *slog.Logger, err error) error {
+	code := exitCode(err)
+	if logger == nil || code == ExitOK || !shouldReport(err) {
+		return err
+	}
+
+	logger.Error(LogMsgRunFailed, slog.String(LogKeyError, err.Error()), slog.Int(LogKeyExitCode, code))
+
+	return reportedError(code, err)
+}
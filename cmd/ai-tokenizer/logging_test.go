@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+const fmtLogLines = "%v: stderr has %d lines, want %d:\n%s"
+
+func TestRunMainLogging(t *testing.T) {
+	t.Parallel()
+
+	tests := []runMainTestCase{
+		{
+			name:       "json info record",
+			args:       []string{"-log-format", "json", boundInput},
+			wantStdout: "Token Count: 7",
+			wantStderr: `"level":"INFO","msg":"input counted","source":"args","model":"simple","bytes":11,"tokens":7,"duration_ms":`,
+			wantCode:   ExitOK,
+		},
+		{
+			name:       "debug traces the library",
+			args:       []string{"-log-level", "debug", boundInput},
+			wantStderr: `level=DEBUG msg="estimate tokens" method=heuristic bytes=11 special_tokens=0 tokens=7 model=simple`,
+			wantCode:   ExitOK,
+		},
+		{
+			name:       "debug input record",
+			args:       []string{"-log-level", "debug", "-log-format", "json", "-file", "../../testdata/vocab.txt"},
+			wantStderr: `"msg":"input read","source":"../../testdata/vocab.txt",`,
+			wantCode:   ExitOK,
+		},
+		{
+			name:       "errors as records",
+			args:       []string{"-log-format", "json", "-file", "missing.txt"},
+			wantStderr: `"level":"ERROR","msg":"run failed","error":"failed to open file \"missing.txt\"`,
+			wantCode:   ExitInputError,
+		},
+		{
+			name:       "limit errors keep their code",
+			args:       []string{"-log-level", "warn", "-max-tokens", "1", boundInput},
+			wantStderr: `level=ERROR msg="run failed" error="token limit exceeded: args has 7 tokens (limit 1)" exit_code=4`,
+			wantCode:   ExitLimitExceeded,
+		},
+		{
+			name:       "unknown format",
+			args:       []string{"-log-format", "xml", boundInput},
+			wantStderr: "unknown log format",
+			wantCode:   ExitInputError,
+		},
+		{
+			name:       "unknown level",
+			args:       []string{"-log-level", "loud", boundInput},
+			wantStderr: "invalid value \"loud\" for flag -log-level",
+			wantCode:   ExitInputError,
+		},
+	}
+
+	for _, testCase := range tests {
+		t.Run(testCase.name, func(t *testing.T) {
+			t.Parallel()
+			runMainTest(t, testCase)
+		})
+	}
+}
+
+func TestRunMainLoggingQuiet(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		args      []string
+		wantLines int
+	}{
+		// Without logging flags stderr stays empty on success.
+		{args: []string{boundInput}, wantLines: 0},
+		// Above info, a successful run logs nothing.
+		{args: []string{"-log-level", "warn", boundInput}, wantLines: 0},
+		// A logged error is not printed again as a plain line.
+		{args: []string{"-log-level", "error", "-file", "missing.txt"}, wantLines: 1},
+	}
+
+	for _, tt := range tests {
+		var stdout, stderr bytes.Buffer
+
+		runMain(tt.args, cliStreams{stdin: strings.NewReader(""), stdout: &stdout, stderr: &stderr})
+
+		if got := strings.Count(stderr.String(), "\n"); got != tt.wantLines {
+			t.Errorf(fmtLogLines, tt.args, got, tt.wantLines, stderr.String())
+		}
+	}
+}
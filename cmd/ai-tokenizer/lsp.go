@@ -0,0 +1,497 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+
+	tokenizer "github.com/nnikolov3/ai-tokenizer"
+)
+
+const (
+	LSPMethodInitialize  = "initialize"
+	LSPMethodInitialized = "initialized"
+	LSPMethodShutdown    = "shutdown"
+	LSPMethodExit        = "exit"
+	LSPMethodDidOpen     = "textDocument/didOpen"
+	LSPMethodDidChange   = "textDocument/didChange"
+	LSPMethodDidClose    = "textDocument/didClose"
+	LSPMethodHover       = "textDocument/hover"
+	LSPMethodInlayHint   = "textDocument/inlayHint"
+	LSPMethodCodeLens    = "textDocument/codeLens"
+	LSPMethodDiagnostics = "textDocument/publishDiagnostics"
+	LSPSyncIncremental   = 2
+	LSPSeverityWarning   = 2
+	LSPMarkupMarkdown    = "markdown"
+	LSPDiagnosticSource  = ExecutableDefault
+
+	FlagHelpLSPModelFile = "Count with a local model file (default: " + tokenizer.DefaultModel + ")"
+	FlagHelpLSPMaxTokens = "Warn when a document exceeds this many tokens (0 = no budget)"
+	FlagHelpLSPPrices    = "JSON price table of model name to USD per million tokens, for hover costs"
+
+	UsageLSPFmt = "" +
+		"Usage: %s lsp [options]\n\n" +
+		"Speaks the Language Server Protocol over stdin and stdout. Editors get\n" +
+		"token counts per document and Markdown section as code lenses and inlay\n" +
+		"hints, costs on hover, and a warning when a document is over budget.\n" +
+		"initializationOptions {\"maxTokens\": N} overrides -max-tokens.\n\n" +
+		"Options:\n"
+
+	LSPTokensFmt        = "%d tokens"
+	LSPDocumentLensFmt  = "Document: %d tokens"
+	LSPBudgetSuffixFmt  = " of %d"
+	LSPHoverSectionFmt  = "**%s**: %d tokens (%.1f%%)"
+	LSPHoverDocumentFmt = "**Document**: %d tokens, model %s"
+	LSPHoverCostFmt     = ", %s"
+	LSPHoverBudgetFmt   = ", budget %d"
+	LSPOverBudgetFmt    = "Document has %d tokens, %d over the budget of %d"
+	LSPHoverSeparator   = "\n\n"
+
+	ErrExitWithoutShutdownMsg = "exit before shutdown"
+	ErrWrapServeLSP           = "serve lsp: %w"
+)
+
+// ErrExitWithoutShutdown makes the server exit with status 1, as the
+// protocol asks when a client sends exit without shutdown.
+var ErrExitWithoutShutdown = errors.New(ErrExitWithoutShutdownMsg)
+
+// lspServer keeps the open documents counted and answers editor requests
+// about them. Requests are handled one at a time in arrival order.
+type lspServer struct {
+	tok      *tokenizer.Tokenizer
+	budget   int
+	price    *float64
+	encoding string
+	docs     map[string]*lspDocument
+	out      io.Writer
+	writeErr error
+	shutdown bool
+	exited   bool
+}
+
+type lspFlags struct {
+	modelFile string
+	maxTokens int
+	prices    string
+}
+
+type textDocumentItem struct {
+	URI     string `json:"uri"`
+	Version int    `json:"version"`
+	Text    string `json:"text"`
+}
+
+type textDocumentIdentifier struct {
+	URI string `json:"uri"`
+}
+
+type contentChange struct {
+	Range *lspRange `json:"range"`
+	Text  string    `json:"text"`
+}
+
+type textDocumentParams struct {
+	TextDocument textDocumentIdentifier `json:"textDocument"`
+	Position     lspPosition            `json:"position"`
+	Range        *lspRange              `json:"range"`
+}
+
+type lspDiagnostic struct {
+	Range    lspRange `json:"range"`
+	Severity int      `json:"severity"`
+	Source   string   `json:"source"`
+	Message  string   `json:"message"`
+}
+
+type publishDiagnosticsParams struct {
+	URI         string          `json:"uri"`
+	Version     int             `json:"version"`
+	Diagnostics []lspDiagnostic `json:"diagnostics"`
+}
+
+type markupContent struct {
+	Kind  string `json:"kind"`
+	Value string `json:"value"`
+}
+
+type lspHover struct {
+	Contents markupContent `json:"contents"`
+	Range    lspRange      `json:"range"`
+}
+
+type inlayHint struct {
+	Position    lspPosition `json:"position"`
+	Label       string      `json:"label"`
+	PaddingLeft bool        `json:"paddingLeft"`
+}
+
+type lspCommand struct {
+	Title   string `json:"title"`
+	Command string `json:"command"`
+}
+
+type codeLens struct {
+	Range   lspRange   `json:"range"`
+	Command lspCommand `json:"command"`
+}
+
+// runLSP implements the lsp command.
+func runLSP(args []string, streams cliStreams) error {
+	flags, err := parseLSPFlags(args, streams.stderr)
+	if err != nil {
+		return reportedInputError(err)
+	}
+
+	server, err := newLSPServer(flags, streams.stdout)
+	if err != nil {
+		return err
+	}
+
+	return server.serve(streams.stdin)
+}
+
+func parseLSPFlags(args []string, stderr io.Writer) (*lspFlags, error) {
+	fs := flag.NewFlagSet(ExecutableDefault+" "+CommandLSP, flag.ContinueOnError)
+	fs.SetOutput(stderr)
+
+	modelFile := fs.String(FlagNameModelFile, "", FlagHelpLSPModelFile)
+	maxTokens := fs.Int(FlagNameMaxTokens, 0, FlagHelpLSPMaxTokens)
+	prices := fs.String(FlagNamePrices, "", FlagHelpLSPPrices)
+
+	fs.Usage = func() { _ = printCommandUsage(stderr, fs, UsageLSPFmt) }
+
+	err := fs.Parse(args)
+	if err != nil {
+		return nil, err
+	}
+
+	return &lspFlags{modelFile: *modelFile, maxTokens: max(*maxTokens, 0), prices: *prices}, nil
+}
+
+// newLSPServer loads the model and prices. Its tokenizer caches counts, so
+// sections an edit did not touch are not counted again.
+func newLSPServer(flags *lspFlags, out io.Writer) (*lspServer, error) {
+	opts := []tokenizer.Option{tokenizer.WithCache(tokenizer.NewCache(tokenizer.DefaultCacheEntries))}
+
+	tok := tokenizer.NewTokenizer(opts...)
+	if flags.modelFile != "" {
+		var err error
+
+		tok, err = newFileTokenizer(flags.modelFile, opts)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	prices, err := loadPrices(flags.prices)
+	if err != nil {
+		return nil, err
+	}
+
+	var price *float64
+	if p, ok := prices[tok.GetModel()]; ok {
+		price = &p
+	}
+
+	return &lspServer{
+		tok:      tok,
+		budget:   flags.maxTokens,
+		price:    price,
+		encoding: PositionEncodingUTF16,
+		docs:     make(map[string]*lspDocument),
+		out:      out,
+		writeErr: nil,
+		shutdown: false,
+		exited:   false,
+	}, nil
+}
+
+// serve answers framed messages from r until exit or the end of input.
+func (s *lspServer) serve(r io.Reader) error {
+	reader := bufio.NewReader(r)
+
+	for !s.exited {
+		body, err := readFrame(reader)
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+
+		if err != nil {
+			return ioError(fmt.Errorf(ErrWrapServeLSP, err))
+		}
+
+		if response := dispatchRPC(body, s.handle); response != nil {
+			s.send(response)
+		}
+
+		if s.writeErr != nil {
+			return ioError(fmt.Errorf(ErrWrapServeLSP, s.writeErr))
+		}
+	}
+
+	if !s.shutdown {
+		return withExitCode(ExitInternalError, ErrExitWithoutShutdown)
+	}
+
+	return nil
+}
+
+// send writes a message, keeping the first write error for serve.
+func (s *lspServer) send(message any) {
+	if s.writeErr == nil {
+		s.writeErr = writeFrame(s.out, message)
+	}
+}
+
+// handle is the rpcHandler of the server. Unknown notifications, such as
+// $/cancelRequest or didSave, get method-not-found answers that
+// dispatchRPC drops.
+func (s *lspServer) handle(method string, params json.RawMessage) (any, *rpcError) {
+	switch method {
+	case LSPMethodInitialize:
+		return s.initialize(params)
+	case LSPMethodInitialized:
+		return nil, nil
+	case LSPMethodShutdown:
+		s.shutdown = true
+
+		return nil, nil
+	case LSPMethodExit:
+		s.exited = true
+
+		return nil, nil
+	case LSPMethodDidOpen, LSPMethodDidChange, LSPMethodDidClose:
+		return nil, s.sync(method, params)
+	case LSPMethodHover, LSPMethodInlayHint, LSPMethodCodeLens:
+		return s.query(method, params)
+	default:
+		return nil, methodNotFound(method)
+	}
+}
+
+func (s *lspServer) initialize(params json.RawMessage) (any, *rpcError) {
+	var req struct {
+		Capabilities struct {
+			General struct {
+				PositionEncodings []string `json:"positionEncodings"`
+			} `json:"general"`
+		} `json:"capabilities"`
+		InitializationOptions struct {
+			MaxTokens *int `json:"maxTokens"`
+		} `json:"initializationOptions"`
+	}
+
+	if rpcErr := decodeParams(params, &req); rpcErr != nil {
+		return nil, rpcErr
+	}
+
+	if slices.Contains(req.Capabilities.General.PositionEncodings, PositionEncodingUTF8) {
+		s.encoding = PositionEncodingUTF8
+	}
+
+	if n := req.InitializationOptions.MaxTokens; n != nil {
+		s.budget = max(*n, 0)
+	}
+
+	return map[string]any{
+		"capabilities": map[string]any{
+			"positionEncoding":  s.encoding,
+			"textDocumentSync":  map[string]any{"openClose": true, "change": LSPSyncIncremental},
+			"hoverProvider":     true,
+			"inlayHintProvider": true,
+			"codeLensProvider":  map[string]any{"resolveProvider": false},
+		},
+		"serverInfo": map[string]string{
+			"name":    ExecutableDefault,
+			"version": resolveVersionAndTime().Revision,
+		},
+	}, nil
+}
+
+// sync applies an open, change or close notification and publishes the
+// document's diagnostics.
+func (s *lspServer) sync(method string, raw json.RawMessage) *rpcError {
+	var params struct {
+		Item           textDocumentItem `json:"textDocument"`
+		ContentChanges []contentChange  `json:"contentChanges"`
+	}
+
+	if rpcErr := decodeParams(raw, &params); rpcErr != nil {
+		return rpcErr
+	}
+
+	uri := params.Item.URI
+
+	switch method {
+	case LSPMethodDidOpen:
+		s.docs[uri] = &lspDocument{uri: uri, version: params.Item.Version, text: params.Item.Text, sections: nil, total: 0}
+	case LSPMethodDidClose:
+		delete(s.docs, uri)
+		s.publishDiagnostics(uri, params.Item.Version, nil)
+
+		return nil
+	}
+
+	doc, ok := s.docs[uri]
+	if !ok {
+		return nil
+	}
+
+	for _, change := range params.ContentChanges {
+		doc.applyChange(change.Range, change.Text, s.encoding)
+	}
+
+	doc.version = params.Item.Version
+	doc.recount(s.tok)
+	s.publishDiagnostics(uri, doc.version, s.diagnostics(doc))
+
+	return nil
+}
+
+// diagnostics warns about a document over budget, from the first byte
+// past the budget to the end of the document. The check uses the whole-text
+// count the lens shows, and the cut counts prefixes the same way.
+func (s *lspServer) diagnostics(doc *lspDocument) []lspDiagnostic {
+	if s.budget == 0 {
+		return nil
+	}
+
+	total := doc.total
+	if total <= s.budget {
+		return nil
+	}
+
+	cut := len(s.tok.Truncate(doc.text, s.budget))
+
+	return []lspDiagnostic{{
+		Range:    lspRange{Start: doc.position(cut, s.encoding), End: doc.position(len(doc.text), s.encoding)},
+		Severity: LSPSeverityWarning,
+		Source:   LSPDiagnosticSource,
+		Message:  fmt.Sprintf(LSPOverBudgetFmt, total, total-s.budget, s.budget),
+	}}
+}
+
+func (s *lspServer) publishDiagnostics(uri string, version int, diagnostics []lspDiagnostic) {
+	if diagnostics == nil {
+		diagnostics = []lspDiagnostic{}
+	}
+
+	s.send(rpcNotification{
+		JSONRPC: RPCVersion,
+		Method:  LSPMethodDiagnostics,
+		Params:  publishDiagnosticsParams{URI: uri, Version: version, Diagnostics: diagnostics},
+	})
+}
+
+// query answers hover, inlay hint and code lens requests. Documents that
+// are not open have no results.
+func (s *lspServer) query(method string, raw json.RawMessage) (any, *rpcError) {
+	var params textDocumentParams
+
+	if rpcErr := decodeParams(raw, &params); rpcErr != nil {
+		return nil, rpcErr
+	}
+
+	doc, ok := s.docs[params.TextDocument.URI]
+
+	switch {
+	case method == LSPMethodHover && ok:
+		return s.hover(doc, params.Position), nil
+	case method == LSPMethodHover:
+		return nil, nil
+	case method == LSPMethodInlayHint && ok:
+		return s.inlayHints(doc, params.Range), nil
+	case method == LSPMethodCodeLens && ok:
+		return s.codeLenses(doc), nil
+	default:
+		return []struct{}{}, nil
+	}
+}
+
+// hover shows the count and cost of the section under the cursor and of
+// the document.
+func (s *lspServer) hover(doc *lspDocument, pos lspPosition) any {
+	section, ok := doc.section(pos.Line)
+	if !ok {
+		return nil
+	}
+
+	var b strings.Builder
+
+	fmt.Fprintf(&b, LSPHoverSectionFmt, section.label, section.tokens, percentOf(section.tokens, doc.total))
+	s.writeCost(&b, section.tokens)
+	b.WriteString(LSPHoverSeparator)
+	fmt.Fprintf(&b, LSPHoverDocumentFmt, doc.total, s.tok.GetModel())
+	s.writeCost(&b, doc.total)
+
+	if s.budget > 0 {
+		fmt.Fprintf(&b, LSPHoverBudgetFmt, s.budget)
+	}
+
+	return lspHover{
+		Contents: markupContent{Kind: LSPMarkupMarkdown, Value: b.String()},
+		Range: lspRange{
+			Start: lspPosition{Line: section.startLine, Character: 0},
+			End:   doc.lineEnd(section.endLine, s.encoding),
+		},
+	}
+}
+
+func (s *lspServer) writeCost(b *strings.Builder, tokens int) {
+	if s.price == nil {
+		return
+	}
+
+	cost := float64(tokens) * *s.price / TokensPerPrice
+	fmt.Fprintf(b, LSPHoverCostFmt, formatCost(&cost))
+}
+
+// inlayHints puts each section's count after its first line, for sections
+// that start in r or everywhere when r is nil.
+func (s *lspServer) inlayHints(doc *lspDocument, r *lspRange) []inlayHint {
+	hints := make([]inlayHint, 0, len(doc.sections))
+
+	for _, section := range doc.sections {
+		if r != nil && (section.startLine < r.Start.Line || section.startLine > r.End.Line) {
+			continue
+		}
+
+		hints = append(hints, inlayHint{
+			Position:    doc.lineEnd(section.startLine, s.encoding),
+			Label:       fmt.Sprintf(LSPTokensFmt, section.tokens),
+			PaddingLeft: true,
+		})
+	}
+
+	return hints
+}
+
+// codeLenses puts the document total, against the budget when there is
+// one, on the first line and each section's count above its heading.
+func (s *lspServer) codeLenses(doc *lspDocument) []codeLens {
+	title := fmt.Sprintf(LSPDocumentLensFmt, doc.total)
+	if s.budget > 0 {
+		title += fmt.Sprintf(LSPBudgetSuffixFmt, s.budget)
+	}
+
+	lenses := []codeLens{s.lens(0, title)}
+
+	for _, section := range doc.sections {
+		if section.label != ReportPreambleLabel {
+			lenses = append(lenses, s.lens(section.startLine, fmt.Sprintf(LSPTokensFmt, section.tokens)))
+		}
+	}
+
+	return lenses
+}
+
+func (s *lspServer) lens(line int, title string) codeLens {
+	pos := lspPosition{Line: line, Character: 0}
+
+	return codeLens{Range: lspRange{Start: pos, End: pos}, Command: lspCommand{Title: title, Command: ""}}
+}
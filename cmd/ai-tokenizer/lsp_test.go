@@ -0,0 +1,433 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	tokenizer "github.com/nnikolov3/ai-tokenizer"
+)
+
+const (
+	lspTestURI = "file:///prompt.md"
+	// lspTestDoc has a section per heading; "é" is one UTF-16 unit and
+	// two bytes, the emoji two units and four bytes.
+	lspTestDoc = "# Intro\nhello world\n\n## Setup\ncafé 🙂 ok\n"
+
+	fmtLSPGot      = "%s = %v, want %v"
+	fmtLSPContains = "%s = %q, want it to contain %q"
+	fmtLSPExit     = "lsp exit code = %d, want %d"
+	fmtLSPOffset   = "offset(%v, %s) = %d, want %d"
+	fmtLSPPosition = "position(%d, %s) = %v, want %v"
+)
+
+// lspMessage is any message from the server.
+type lspMessage struct {
+	ID     *int            `json:"id"`
+	Method string          `json:"method"`
+	Params json.RawMessage `json:"params"`
+	Result json.RawMessage `json:"result"`
+	Error  *rpcError       `json:"error"`
+}
+
+// lspSession drives runMain lsp through in-memory pipes.
+type lspSession struct {
+	t      *testing.T
+	stdin  *io.PipeWriter
+	stdout *bufio.Reader
+	done   chan int
+	nextID int
+}
+
+func startLSP(t *testing.T, args ...string) *lspSession {
+	t.Helper()
+
+	inR, inW := io.Pipe()
+	outR, outW := io.Pipe()
+
+	s := &lspSession{t: t, stdin: inW, stdout: bufio.NewReader(outR), done: make(chan int, 1), nextID: 0}
+
+	go func() {
+		code := runMain(append([]string{CommandLSP}, args...), cliStreams{stdin: inR, stdout: outW, stderr: io.Discard})
+		_ = outW.Close()
+		s.done <- code
+	}()
+
+	return s
+}
+
+func (s *lspSession) write(message any) {
+	s.t.Helper()
+
+	err := writeFrame(s.stdin, message)
+	if err != nil {
+		s.t.Fatal(err)
+	}
+}
+
+func (s *lspSession) read() lspMessage {
+	s.t.Helper()
+
+	body, err := readFrame(s.stdout)
+	if err != nil {
+		s.t.Fatal(err)
+	}
+
+	var msg lspMessage
+
+	err = json.Unmarshal(body, &msg)
+	if err != nil {
+		s.t.Fatal(err)
+	}
+
+	return msg
+}
+
+func (s *lspSession) notify(method string, params any) {
+	s.t.Helper()
+	s.write(rpcNotification{JSONRPC: RPCVersion, Method: method, Params: params})
+}
+
+// request sends a request and decodes its result into out.
+func (s *lspSession) request(method string, params, out any) lspMessage {
+	s.t.Helper()
+
+	s.nextID++
+	s.write(map[string]any{"jsonrpc": RPCVersion, "id": s.nextID, "method": method, "params": params})
+
+	msg := s.read()
+	if msg.ID == nil || *msg.ID != s.nextID || msg.Error != nil {
+		s.t.Fatalf(fmtLSPGot, method, msg, s.nextID)
+	}
+
+	if out != nil {
+		err := json.Unmarshal(msg.Result, out)
+		if err != nil {
+			s.t.Fatal(err)
+		}
+	}
+
+	return msg
+}
+
+// diagnostics reads the diagnostics published after a sync notification.
+func (s *lspSession) diagnostics() []lspDiagnostic {
+	s.t.Helper()
+
+	msg := s.read()
+
+	var params publishDiagnosticsParams
+
+	err := json.Unmarshal(msg.Params, &params)
+	if err != nil || msg.Method != LSPMethodDiagnostics {
+		s.t.Fatalf(fmtLSPGot, "notification", msg.Method, LSPMethodDiagnostics)
+	}
+
+	return params.Diagnostics
+}
+
+func (s *lspSession) open(text string) []lspDiagnostic {
+	s.t.Helper()
+
+	s.request(LSPMethodInitialize, map[string]any{"capabilities": map[string]any{}}, nil)
+	s.notify(LSPMethodInitialized, map[string]any{})
+	s.notify(LSPMethodDidOpen, map[string]any{
+		"textDocument": textDocumentItem{URI: lspTestURI, Version: 1, Text: text},
+	})
+
+	return s.diagnostics()
+}
+
+// close shuts the server down and waits for it to exit.
+func (s *lspSession) close(wantCode int) {
+	s.t.Helper()
+
+	if wantCode == ExitOK {
+		s.request(LSPMethodShutdown, nil, nil)
+	}
+
+	s.notify(LSPMethodExit, nil)
+
+	if code := <-s.done; code != wantCode {
+		s.t.Errorf(fmtLSPExit, code, wantCode)
+	}
+
+	_ = s.stdin.Close()
+}
+
+func documentParams() map[string]any {
+	return map[string]any{"textDocument": textDocumentIdentifier{URI: lspTestURI}}
+}
+
+func TestLSPLifecycle(t *testing.T) {
+	t.Parallel()
+
+	s := startLSP(t)
+
+	var init struct {
+		Capabilities map[string]json.RawMessage `json:"capabilities"`
+	}
+
+	s.request(LSPMethodInitialize, map[string]any{
+		"capabilities": map[string]any{"general": map[string]any{"positionEncodings": []string{"utf-8", "utf-16"}}},
+	}, &init)
+
+	for _, capability := range []string{"hoverProvider", "inlayHintProvider", "codeLensProvider", "textDocumentSync"} {
+		if init.Capabilities[capability] == nil {
+			t.Errorf(fmtLSPGot, capability, nil, "set")
+		}
+	}
+
+	if got := string(init.Capabilities["positionEncoding"]); got != `"utf-8"` {
+		t.Errorf(fmtLSPGot, "positionEncoding", got, PositionEncodingUTF8)
+	}
+
+	// shutdown answers with a null result, not an empty response.
+	if msg := s.request(LSPMethodShutdown, nil, nil); string(msg.Result) != "null" {
+		t.Errorf(fmtLSPGot, LSPMethodShutdown, string(msg.Result), "null")
+	}
+
+	s.notify(LSPMethodExit, nil)
+
+	if code := <-s.done; code != ExitOK {
+		t.Errorf(fmtLSPExit, code, ExitOK)
+	}
+
+	_ = s.stdin.Close()
+}
+
+func TestLSPExitWithoutShutdown(t *testing.T) {
+	t.Parallel()
+
+	s := startLSP(t)
+	s.close(ExitInternalError)
+}
+
+func TestReadFrameRejectsBadHeaders(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name  string
+		input string
+	}{
+		{name: "no separator", input: "Content-Length 2\r\n\r\n{}"},
+		{name: "negative length", input: "Content-Length: -1\r\n\r\n"},
+		{name: "no length", input: "Content-Type: x\r\n\r\n{}"},
+		{name: "length over the cap", input: fmt.Sprintf(FrameHeaderFmt, RPCMaxFrame+1)},
+		{name: "huge length", input: "Content-Length: 9223372036854775807\r\n\r\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			_, err := readFrame(bufio.NewReader(strings.NewReader(tt.input)))
+			if !errors.Is(err, ErrBadFrame) {
+				t.Errorf(fmtLSPGot, "readFrame error", err, ErrBadFrame)
+			}
+		})
+	}
+}
+
+func TestLSPCountsAndCosts(t *testing.T) {
+	t.Parallel()
+
+	prices := filepath.Join(t.TempDir(), "prices.json")
+
+	err := os.WriteFile(prices, []byte(`{"simple": 2}`), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tok := tokenizer.NewTokenizer()
+	intro := tok.EstimateTokens("# Intro\nhello world\n\n")
+	setup := tok.EstimateTokens("## Setup\ncafé 🙂 ok\n")
+
+	s := startLSP(t, "-"+FlagNamePrices, prices)
+	defer s.close(ExitOK)
+
+	if diags := s.open(lspTestDoc); len(diags) != 0 {
+		t.Errorf(fmtLSPGot, "diagnostics", diags, "none")
+	}
+
+	var lenses []codeLens
+	s.request(LSPMethodCodeLens, documentParams(), &lenses)
+
+	want := []string{fmt.Sprintf(LSPDocumentLensFmt, intro+setup), fmt.Sprintf(LSPTokensFmt, intro), fmt.Sprintf(LSPTokensFmt, setup)}
+	if len(lenses) != len(want) || lenses[0].Command.Title != want[0] || lenses[2].Command.Title != want[2] || lenses[2].Range.Start.Line != 3 {
+		t.Errorf(fmtLSPGot, LSPMethodCodeLens, lenses, want)
+	}
+
+	var hints []inlayHint
+	s.request(LSPMethodInlayHint, documentParams(), &hints)
+
+	// The hint follows "## Setup", 8 units into line 3.
+	if len(hints) != 2 || hints[1].Label != want[2] || hints[1].Position != (lspPosition{Line: 3, Character: 8}) {
+		t.Errorf(fmtLSPGot, LSPMethodInlayHint, hints, want[1:])
+	}
+
+	var hover lspHover
+
+	params := documentParams()
+	params["position"] = lspPosition{Line: 4, Character: 2}
+	s.request(LSPMethodHover, params, &hover)
+
+	price := float64(setup) * 2 / TokensPerPrice
+	cost := formatCost(&price)
+	for _, part := range []string{"## Setup", fmt.Sprintf(LSPTokensFmt, setup), cost, "model simple"} {
+		if !strings.Contains(hover.Contents.Value, part) {
+			t.Errorf(fmtLSPContains, LSPMethodHover, hover.Contents.Value, part)
+		}
+	}
+}
+
+func TestLSPIncrementalEditsAndBudget(t *testing.T) {
+	t.Parallel()
+
+	tok := tokenizer.NewTokenizer()
+	budget := tok.EstimateTokens(lspTestDoc) - 1
+
+	s := startLSP(t, "-"+FlagNameMaxTokens, fmt.Sprint(budget))
+	defer s.close(ExitOK)
+
+	diags := s.open(lspTestDoc)
+	if len(diags) != 1 || diags[0].Severity != LSPSeverityWarning || !strings.Contains(diags[0].Message, "1 over the budget") {
+		t.Fatalf(fmtLSPGot, "diagnostics", diags, "one over-budget warning")
+	}
+
+	// Replace "ok" after the emoji, at UTF-16 column 8, with "fine", and
+	// drop "world" on line 1.
+	s.notify(LSPMethodDidChange, map[string]any{
+		"textDocument": map[string]any{"uri": lspTestURI, "version": 2},
+		"contentChanges": []contentChange{
+			{Range: &lspRange{Start: lspPosition{Line: 4, Character: 8}, End: lspPosition{Line: 4, Character: 10}}, Text: "fine"},
+			{Range: &lspRange{Start: lspPosition{Line: 1, Character: 5}, End: lspPosition{Line: 1, Character: 11}}, Text: ""},
+		},
+	})
+
+	edited := "# Intro\nhello\n\n## Setup\ncafé 🙂 fine\n"
+
+	if diags = s.diagnostics(); len(diags) != 0 {
+		t.Errorf(fmtLSPGot, "diagnostics after edit", diags, "none")
+	}
+
+	var lenses []codeLens
+	s.request(LSPMethodCodeLens, documentParams(), &lenses)
+
+	if want := fmt.Sprintf(LSPDocumentLensFmt+LSPBudgetSuffixFmt, tok.EstimateTokens(edited), budget); lenses[0].Command.Title != want {
+		t.Errorf(fmtLSPGot, LSPMethodCodeLens, lenses[0].Command.Title, want)
+	}
+
+	// didClose clears the document's diagnostics.
+	s.notify(LSPMethodDidClose, documentParams())
+
+	if diags = s.diagnostics(); len(diags) != 0 {
+		t.Errorf(fmtLSPGot, "diagnostics after close", diags, "none")
+	}
+}
+
+func TestLSPBudgetCountsWholeText(t *testing.T) {
+	t.Parallel()
+
+	// The unigram model counts the sections of lspTestDoc to more than the
+	// whole text, and vocab.txt wraps every input in [CLS] and [SEP]. The
+	// lens, hover and budget all use the whole text; sections leave out
+	// the wrapping.
+	for _, model := range []string{testModelFile, "../../testdata/vocab.txt"} {
+		tok, err := newFileTokenizer(model, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		whole := tok.EstimateTokens(lspTestDoc)
+		sections := tok.EstimateContentTokens("# Intro\nhello world\n\n") + tok.EstimateContentTokens("## Setup\ncafé 🙂 ok\n")
+
+		for budget, want := range map[int]int{whole: 0, whole - 1: 1} {
+			s := startLSP(t, "-"+FlagNameModelFile, model, "-"+FlagNameMaxTokens, fmt.Sprint(budget))
+
+			diags := s.open(lspTestDoc)
+			if len(diags) != want || (want > 0 && !strings.Contains(diags[0].Message, "1 over the budget")) {
+				t.Errorf(fmtLSPGot, "diagnostics", diags, want)
+			}
+
+			var lenses []codeLens
+			s.request(LSPMethodCodeLens, documentParams(), &lenses)
+
+			title := fmt.Sprintf(LSPDocumentLensFmt, whole)
+			if len(lenses) != 3 || !strings.HasPrefix(lenses[0].Command.Title, title) ||
+				countSectionLens(t, lenses[1])+countSectionLens(t, lenses[2]) != sections {
+				t.Errorf(fmtLSPGot, LSPMethodCodeLens, lenses, title)
+			}
+
+			var hover lspHover
+			s.request(LSPMethodHover, documentParams(), &hover)
+
+			if !strings.Contains(hover.Contents.Value, fmt.Sprintf(LSPHoverDocumentFmt, whole, tok.GetModel())) {
+				t.Errorf(fmtLSPContains, LSPMethodHover, hover.Contents.Value, whole)
+			}
+
+			s.close(ExitOK)
+		}
+
+		if model != testModelFile && sections != whole-tok.SpecialTokenCount() {
+			t.Errorf(fmtLSPGot, "section sum", sections, whole-tok.SpecialTokenCount())
+		}
+	}
+}
+
+// countSectionLens reads the count back from a section lens title.
+func countSectionLens(t *testing.T, lens codeLens) int {
+	t.Helper()
+
+	var n int
+
+	_, err := fmt.Sscanf(lens.Command.Title, LSPTokensFmt, &n)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return n
+}
+
+func TestLSPDocumentPositions(t *testing.T) {
+	t.Parallel()
+
+	doc := &lspDocument{uri: lspTestURI, version: 1, text: lspTestDoc, sections: nil, total: 0}
+	emoji := strings.Index(lspTestDoc, "🙂")
+
+	tests := []struct {
+		pos      lspPosition
+		encoding string
+		offset   int
+		clamped  bool
+	}{
+		{pos: lspPosition{Line: 4, Character: 5}, encoding: PositionEncodingUTF16, offset: emoji, clamped: false},
+		{pos: lspPosition{Line: 4, Character: 7}, encoding: PositionEncodingUTF16, offset: emoji + 4, clamped: false},
+		{pos: lspPosition{Line: 4, Character: 6}, encoding: PositionEncodingUTF8, offset: emoji, clamped: false},
+		// Columns outside the line clamp to it; lines past the end to the end.
+		{pos: lspPosition{Line: 0, Character: 99}, encoding: PositionEncodingUTF16, offset: len("# Intro"), clamped: true},
+		{pos: lspPosition{Line: 9, Character: 0}, encoding: PositionEncodingUTF16, offset: len(lspTestDoc), clamped: true},
+		{pos: lspPosition{Line: 1, Character: -3}, encoding: PositionEncodingUTF8, offset: len("# Intro\n"), clamped: true},
+		{pos: lspPosition{Line: -1, Character: -1}, encoding: PositionEncodingUTF16, offset: 0, clamped: true},
+	}
+
+	for _, tt := range tests {
+		if got := doc.offset(tt.pos, tt.encoding); got != tt.offset {
+			t.Errorf(fmtLSPOffset, tt.pos, tt.encoding, got, tt.offset)
+		}
+
+		if tt.clamped {
+			continue
+		}
+
+		if got := doc.position(tt.offset, tt.encoding); got != tt.pos {
+			t.Errorf(fmtLSPPosition, tt.offset, tt.encoding, got, tt.pos)
+		}
+	}
+}
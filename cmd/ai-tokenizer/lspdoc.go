@@ -0,0 +1,176 @@
+package main
+
+import (
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	tokenizer "github.com/nnikolov3/ai-tokenizer"
+)
+
+// Position encodings a client may offer; the server prefers UTF-8, which
+// needs no conversion, and falls back to the protocol default of UTF-16.
+const (
+	PositionEncodingUTF8  = "utf-8"
+	PositionEncodingUTF16 = "utf-16"
+)
+
+// lspPosition is a zero-based line and a column in the negotiated
+// position encoding.
+type lspPosition struct {
+	Line      int `json:"line"`
+	Character int `json:"character"`
+}
+
+type lspRange struct {
+	Start lspPosition `json:"start"`
+	End   lspPosition `json:"end"`
+}
+
+// lspSection is one Markdown section of a document with its count. Lines
+// are zero-based, as LSP numbers them.
+type lspSection struct {
+	label     string
+	startLine int
+	endLine   int
+	tokens    int
+}
+
+// lspDocument is an open document with its sections counted. Edits only
+// change the sections they touch, and the tokenizer's cache answers the
+// others, so a keystroke costs a count of the section it lands in and one
+// of the whole text.
+type lspDocument struct {
+	uri      string
+	version  int
+	text     string
+	sections []lspSection
+	total    int
+}
+
+// recount counts the whole text, which the lens, hover and diagnostics all
+// report, and each section without the tokens a model adds around every
+// input, such as [CLS] and [SEP], which belong to no section.
+func (d *lspDocument) recount(tok *tokenizer.Tokenizer) {
+	units := splitBySection(splitLinesKeepEnds(d.text))
+
+	d.sections = d.sections[:0]
+	d.total = tok.EstimateTokens(d.text)
+
+	for _, u := range units {
+		d.sections = append(d.sections, lspSection{
+			label:     u.label,
+			startLine: u.startLine - 1,
+			endLine:   u.endLine - 1,
+			tokens:    tok.EstimateContentTokens(u.text),
+		})
+	}
+}
+
+// section returns the section holding line, or false past the end.
+func (d *lspDocument) section(line int) (lspSection, bool) {
+	for _, s := range d.sections {
+		if line >= s.startLine && line <= s.endLine {
+			return s, true
+		}
+	}
+
+	return lspSection{label: "", startLine: 0, endLine: 0, tokens: 0}, false
+}
+
+// applyChange replaces the text in r, or the whole text when r is nil.
+func (d *lspDocument) applyChange(r *lspRange, text, encoding string) {
+	if r == nil {
+		d.text = text
+
+		return
+	}
+
+	start := d.offset(r.Start, encoding)
+	end := max(start, d.offset(r.End, encoding))
+	d.text = d.text[:start] + text + d.text[end:]
+}
+
+// offset converts a position to a byte offset, clamping positions past a
+// line end or the document end as the protocol asks.
+func (d *lspDocument) offset(pos lspPosition, encoding string) int {
+	lineStart := 0
+
+	for range pos.Line {
+		i := strings.IndexByte(d.text[lineStart:], '\n')
+		if i < 0 {
+			return len(d.text)
+		}
+
+		lineStart += i + 1
+	}
+
+	line := d.text[lineStart:]
+	if i := strings.IndexByte(line, '\n'); i >= 0 {
+		line = line[:i]
+	}
+
+	return lineStart + columnOffset(line, pos.Character, encoding)
+}
+
+// position converts a byte offset to a position.
+func (d *lspDocument) position(offset int, encoding string) lspPosition {
+	before := d.text[:offset]
+	lineStart := strings.LastIndexByte(before, '\n') + 1
+
+	return lspPosition{
+		Line:      strings.Count(before, "\n"),
+		Character: columnWidth(before[lineStart:], encoding),
+	}
+}
+
+// lineEnd is the position at the end of line, before its newline.
+func (d *lspDocument) lineEnd(line int, encoding string) lspPosition {
+	start := d.offset(lspPosition{Line: line, Character: 0}, encoding)
+	text := d.text[start:]
+
+	if i := strings.IndexByte(text, '\n'); i >= 0 {
+		text = text[:i]
+	}
+
+	return lspPosition{Line: line, Character: columnWidth(strings.TrimSuffix(text, "\r"), encoding)}
+}
+
+// columnOffset is the byte offset of a column within line. Columns before
+// the line start are clamped to it, as those past its end are to the end.
+func columnOffset(line string, column int, encoding string) int {
+	column = max(column, 0)
+
+	if encoding == PositionEncodingUTF8 {
+		return min(column, len(line))
+	}
+
+	units := 0
+
+	for i, r := range line {
+		if units >= column {
+			return i
+		}
+
+		units += utf16.RuneLen(r)
+	}
+
+	return len(line)
+}
+
+// columnWidth is the column at the end of text.
+func columnWidth(text, encoding string) int {
+	if encoding == PositionEncodingUTF8 {
+		return len(text)
+	}
+
+	units := 0
+
+	for len(text) > 0 {
+		r, size := utf8.DecodeRuneInString(text)
+		units += utf16.RuneLen(r)
+		text = text[size:]
+	}
+
+	return units
+}
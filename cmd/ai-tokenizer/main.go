@@ -3,26 +3,44 @@
 package main
 
 import (
-	"encoding/json"
+	"cmp"
 	"errors"
 	"flag"
 	"fmt"
 	"io"
+	"log/slog"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"runtime/debug"
 	"strings"
+	"syscall"
+	"time"
 
 	tokenizer "github.com/nnikolov3/ai-tokenizer"
+	"github.com/nnikolov3/ai-tokenizer/metrics"
 )
 
 // TokenResult is the output payload for tokenization results.
 type TokenResult struct {
-	Text           string `json:"text"`
-	Model          string `json:"model"`
-	OriginalText   string `json:"originalText,omitempty"`
-	NormalizedText string `json:"normalizedText,omitempty"`
-	TokenCount     int    `json:"tokenCount"`
+	Source                string           `json:"source,omitempty"`
+	Encoding              string           `json:"encoding,omitempty"`
+	Text                  string           `json:"text"`
+	Model                 string           `json:"model"`
+	OriginalText          string           `json:"originalText,omitempty"`
+	NormalizedText        string           `json:"normalizedText,omitempty"`
+	ReportUnit            string           `json:"reportUnit,omitempty"`
+	Breakdown             []BreakdownEntry `json:"breakdown,omitempty"`
+	InvalidUTF8RunOffsets []int            `json:"invalidUtf8RunOffsets,omitempty"`
+	InvalidUTF8Runs       int              `json:"invalidUtf8Runs,omitempty"`
+	SpecialTokens         map[string]int   `json:"specialTokens,omitempty"`
+	SpecialTokenCount     int              `json:"specialTokenCount,omitempty"`
+	Baseline              string           `json:"baseline,omitempty"`
+	Comparison            []ModelCount     `json:"comparison,omitempty"`
+	TokenCount            int              `json:"tokenCount"`
+	UpperBound            int              `json:"upperBound,omitempty"`
+	UpperBoundMode        string           `json:"upperBoundMode,omitempty"`
+	SafetyMargin          float64          `json:"safetyMargin,omitempty"`
 }
 
 const (
@@ -36,16 +54,20 @@ const (
 	MsgTokenCountFmt = "Token Count: %d\n"
 	MsgModelFmt      = "Model: %s\n"
 	MsgNormalizedFmt = "Normalized: %s\n"
+	MsgInvalidFmt    = "Invalid UTF-8: %d run(s) starting at byte offsets %v\n"
+	MsgEncodingFmt   = "Encoding: %s (transcoded to UTF-8)\n"
+	MsgSpecialFmt    = "Special Tokens: %d %v\n"
 	MsgJSONIndent    = "  "
 	FmtGenericErr    = "%v"
 
 	// Error wrappers/messages.
-	ErrWrapTokenize   = "tokenize: %w"
-	ErrWrapEncodeJSON = "encode json: %w"
-	ErrWrapReadStdin  = "read stdin: %w"
-	ErrOpenFileFmt    = "failed to open file %q: %w"
-	ErrReadFileFmt    = "failed to read file %q: %w"
-	ErrNoInputMsg     = "no input"
+	ErrWrapTokenize       = "tokenize: %w"
+	ErrWrapTokenizeSource = "tokenize %s: %w"
+	ErrWrapEncodeJSON     = "encode json: %w"
+	ErrWrapReadStdin      = "read stdin: %w"
+	ErrOpenFileFmt        = "failed to open file %q: %w"
+	ErrReadFileFmt        = "failed to read file %q: %w"
+	ErrNoInputMsg         = "no input"
 
 	// Flag names and help strings.
 	FlagNameVersion    = "version"
@@ -53,12 +75,50 @@ const (
 	FlagNameFile       = "file"
 	FlagNameText       = "text"
 	FlagNameNormalized = "normalized"
+	FlagNameReport     = "report"
+	FlagNameSort       = "sort"
+	FlagNameFormat     = "format"
+	FlagNameTemplate   = "template"
+	FlagNameMaxTokens  = "max-tokens"
+	FlagNameInvalid    = "invalid-utf8"
+	FlagNameEncoding   = "encoding"
+	FlagNameModelFile  = "model-file"
+	FlagNameCased      = "cased"
+	FlagNameSpecial    = "special-tokens"
+	FlagNameAddSpecial = "special-token"
+	FlagNameModels     = "models"
+	FlagNameBaseline   = "baseline"
+	FlagNamePrices     = "prices"
+	FlagNameBound      = "upper-bound"
+	FlagNameMargin     = "safety-margin"
+	FlagNameMetrics    = "metrics"
+	FlagNameLogLevel   = "log-level"
+	FlagNameLogFormat  = "log-format"
 
 	FlagHelpVersion    = "Show version information"
-	FlagHelpJSON       = "Output in JSON format"
-	FlagHelpInputFile  = "Input file path (default: stdin)"
+	FlagHelpJSON       = "Output in JSON format (same as -format json)"
+	FlagHelpInputFile  = "Input file path, repeatable (default: stdin)"
 	FlagHelpText       = "Text to tokenize"
 	FlagHelpNormalized = "Show normalized text in output"
+	FlagHelpReport     = "Break counts down per line, paragraph or section"
+	FlagHelpSort       = "Report order: order (document) or tokens (largest first)"
+	FlagHelpFormat     = "Output format: text, json, ndjson, csv, tsv, yaml, table or template"
+	FlagHelpTemplate   = "Go text/template applied to each result with -format template"
+	FlagHelpMaxTokens  = "Exit with status 4 if any input exceeds this many tokens (0 = no limit)"
+	FlagHelpEncoding   = "Input encoding for files and stdin: auto, utf-8, utf-16, utf-16le, utf-16be, latin1 or windows-1252"
+	FlagHelpModelFile  = "Count exactly with a local model file (SentencePiece .model, tokenizer.json or WordPiece vocab.txt)"
+	FlagHelpCased      = "Keep case and accents with a WordPiece vocab.txt, for cased BERT models"
+	FlagHelpInvalid    = "Invalid UTF-8 policy: skip (default), replace, bytes (1 token per byte) or error"
+	FlagHelpSpecial    = "Special-token policy: allow (1 token each, default), error or escape (count as text)"
+	FlagHelpAddSpecial = "Extra special token such as [INST], repeatable"
+	FlagHelpModels     = "Compare comma-separated models: simple (the built-in estimator) or model file paths"
+	FlagHelpBaseline   = "Model the comparison deltas are relative to (default: first of -models)"
+	FlagHelpPrices     = "JSON price table of model name to USD per million tokens"
+	FlagHelpBound      = "Also report an upper bound: confident (non-ASCII at 1 token per byte) or guaranteed (1 token per byte)"
+	FlagHelpMargin     = "Percentage added to the upper bound; implies -upper-bound confident"
+	FlagHelpMetrics    = "Print Prometheus-format metrics for the run to stderr when it ends"
+	FlagHelpLogLevel   = "Log structured records to stderr at this level: debug, info, warn or error"
+	FlagHelpLogFormat  = "Log record format: text or json; implies -log-level info"
 
 	// Usage text (lines wrapped to meet 80-char limit).
 	UsageHeader = "" +
@@ -77,7 +137,37 @@ const (
 		"  %s -json \"Hello, world!\"\n" +
 		"  %s -file input.txt\n" +
 		"  echo \"Hello, world!\" | %s\n" +
-		"  %s -text \"café\" -normalized\n"
+		"  %s -text \"café\" -normalized\n" +
+		"  %s -file prompt.md -report section -sort tokens\n" +
+		"  %s -file a.txt -file b.txt -format csv\n" +
+		"  %s -format template -template '{{.TokenCount}}' \"Hello\"\n" +
+		"  %s -file upload.bin -invalid-utf8 error\n" +
+		"  %s -file export.txt -encoding windows-1252\n" +
+		"  %s -model-file llama.model -file prompt.md\n" +
+		"  %s -special-tokens error -file user_input.txt\n" +
+		"  %s -models simple,llama.model -prices prices.json -file prompt.md\n" +
+		"  %s -upper-bound confident -safety-margin 10 -max-tokens 4096 -file prompt.md\n" +
+		"  %s -metrics -file a.txt -file b.txt -format ndjson\n" +
+		"  %s -log-level debug -log-format json -file prompt.md\n" +
+		"  %s eval -models simple,llama.model samples.jsonl\n" +
+		"  %s mcp -model-file llama.model\n" +
+		"  %s client -model llama.model -file prompt.md\n" +
+		"  %s lsp -max-tokens 4096 -prices prices.json\n" +
+		"  %s -watch -max-tokens 4096 prompts/\n" +
+		"  %s git -base main -max-growth 500 prompts/\n" +
+		"  git diff main | %s diff -prices prices.json\n"
+	UsageExitCodes = "" +
+		"\nExit codes:\n" +
+		"  0  success (also when the output pipe is closed early)\n" +
+		"  1  internal error\n" +
+		"  2  input error: invalid flags, missing file, empty or malformed input\n" +
+		"  3  I/O error reading input or writing output\n" +
+		"  4  an input, or its upper bound when reported, exceeded -max-tokens,\n" +
+		"     or git token growth exceeded -max-growth\n"
+
+	// Source names for inputs that are not files.
+	SourceArgs  = "args"
+	SourceStdin = "stdin"
 
 	// CLI preview defaults and constants for helpers.
 	DefaultPreviewMax = 100
@@ -96,55 +186,153 @@ type VersionInfo struct {
 // ErrNoInput is returned when no input text is provided.
 var ErrNoInput = errors.New(ErrNoInputMsg)
 
+// cliStreams holds the streams the CLI reads from and writes to, so tests
+// can substitute buffers for the process's standard streams.
+type cliStreams struct {
+	stdin  io.Reader
+	stdout io.Writer
+	stderr io.Writer
+}
+
 // cliFlags collects parsed CLI flags for the CLI program.
 type cliFlags struct {
-	inputFile      string
+	inputFiles     stringList
+	args           []string
 	text           string
+	format         string
+	template       string
+	reportUnit     string
+	sortOrder      string
+	maxTokens      int
+	invalidUTF8    tokenizer.InvalidUTF8Policy
+	specialPolicy  tokenizer.SpecialTokenPolicy
+	specialTokens  stringList
+	encoding       string
+	modelFile      string
+	cased          bool
+	models         string
+	baseline       string
+	prices         string
+	boundMode      tokenizer.UpperBoundMode
+	safetyMargin   float64
+	upperBound     bool
 	showVersion    bool
 	outputJSON     bool
 	showNormalized bool
+	metrics        bool
+	watch          bool
+	watchInterval  time.Duration
+	logger         *slog.Logger
 }
 
 func main() {
-	err := run()
+	// Report EPIPE as a write error instead of dying on SIGPIPE, so a
+	// closed output pipe ends the run cleanly.
+	signal.Ignore(syscall.SIGPIPE)
+
+	os.Exit(runMain(os.Args[1:], cliStreams{
+		stdin:  os.Stdin,
+		stdout: os.Stdout,
+		stderr: os.Stderr,
+	}))
+}
+
+// runMain runs the CLI, reports any error on stderr, and returns the exit
+// code documented in UsageExitCodes.
+func runMain(args []string, streams cliStreams) int {
+	err := run(args, streams)
+
+	code := exitCode(err)
+	if code != ExitOK && shouldReport(err) {
+		// Nothing more can be done if stderr itself is unwritable.
+		_, _ = fmt.Fprintf(streams.stderr, FmtGenericErr+"\n", err)
+	}
+
+	return code
+}
+
+func run(args []string, streams cliStreams) error {
+	if command, ok := lookupSubcommand(args); ok {
+		return command(args[1:], streams)
+	}
+
+	flags, fs, err := parseFlags(args, streams.stderr)
 	if err != nil {
-		printError(FmtGenericErr+"\n", err)
-		os.Exit(1)
+		return reportedInputError(err)
 	}
+
+	return logFailure(flags.logger, runCount(flags, fs, streams))
 }
 
-func run() error {
-	flags := parseFlags()
+// runCount counts the inputs named by parsed flags.
+func runCount(flags *cliFlags, fs *flag.FlagSet, streams cliStreams) error {
 	// Handle --version early to keep branching
 	if flags.showVersion {
-		printVersion()
+		return ioError(printVersion(streams.stdout))
+	}
 
-		return nil
+	formatter, err := newFormatter(flags.format, flags.template)
+	if err != nil {
+		return inputError(err)
 	}
-	//
-	input, err := requireInput(flags)
+
+	tok, err := newTokenizer(flags)
 	if err != nil {
 		return err
 	}
 
-	return process(flags, input)
-}
+	if flags.watch {
+		return runWatch(flags, tok, streams)
+	}
 
-func requireInput(flags *cliFlags) (string, error) {
-	textInput, err := obtainInput(flags)
+	comp, err := newComparison(flags, tok)
 	if err != nil {
-		return "", err
+		return err
+	}
+
+	if comp != nil {
+		tok = comp.baseline
 	}
 
-	err = ensureNonEmpty(textInput)
+	inputs, err := requireInputs(flags, fs, streams)
 	if err != nil {
-		printError(FmtGenericErr+"\n", err)
-		printUsage()
+		return err
+	}
+
+	return process(flags, tok, comp, formatter, inputs, streams)
+}
+
+// inputSource is one named piece of input text. encoding records how file
+// or stdin bytes were decoded; it is empty for command-line text.
+type inputSource struct {
+	name     string
+	text     string
+	encoding string
+}
+
+func requireInputs(
+	flags *cliFlags,
+	fs *flag.FlagSet,
+	streams cliStreams,
+) ([]inputSource, error) {
+	inputs, err := obtainInputs(flags, streams.stdin)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, in := range inputs {
+		err = ensureNonEmpty(in.text)
+		if err != nil {
+			usageErr := printUsage(streams.stderr, fs)
+			if usageErr != nil {
+				return nil, ioError(usageErr)
+			}
 
-		return "", err
+			return nil, inputError(err)
+		}
 	}
 
-	return textInput, nil
+	return inputs, nil
 }
 
 func resolveVersionAndTime() VersionInfo {
@@ -164,9 +352,49 @@ func resolveVersionAndTime() VersionInfo {
 	return VersionInfo{Revision: revision, BuildTimestamp: buildTimestamp}
 }
 
-// obtainInput resolves the input text using flags, args, or stdin.
-func obtainInput(flags *cliFlags) (string, error) {
-	return readInputNonText(flags)
+// obtainInputs resolves the input texts using flags, args, or stdin.
+func obtainInputs(flags *cliFlags, stdin io.Reader) ([]inputSource, error) {
+	if len(flags.inputFiles) > 0 {
+		return readInputFiles(flags.inputFiles, flags.encoding)
+	}
+
+	in, err := readInputNonText(flags, stdin)
+	if err != nil {
+		return nil, err
+	}
+
+	return []inputSource{in}, nil
+}
+
+// readInputFiles reads and decodes every -file argument in the order given.
+func readInputFiles(paths []string, encodingName string) ([]inputSource, error) {
+	inputs := make([]inputSource, 0, len(paths))
+
+	for _, path := range paths {
+		raw, err := readFile(path)
+		if err != nil {
+			return nil, fileError(err)
+		}
+
+		in, err := decodeSource(path, raw, encodingName)
+		if err != nil {
+			return nil, err
+		}
+
+		inputs = append(inputs, in)
+	}
+
+	return inputs, nil
+}
+
+// decodeSource transcodes raw file or stdin content to UTF-8.
+func decodeSource(name, raw, encodingName string) (inputSource, error) {
+	text, used, err := decodeInput([]byte(raw), encodingName, name)
+	if err != nil {
+		return inputSource{}, inputError(err)
+	}
+
+	return inputSource{name: name, text: text, encoding: used}, nil
 }
 
 func ensureNonEmpty(inputStr string) error {
@@ -177,60 +405,279 @@ func ensureNonEmpty(inputStr string) error {
 	return nil
 }
 
-// New helper: the pipeline after we have validated input.
-func process(flags *cliFlags, input string) error {
-	result, err := buildResult(flags, input)
+// process runs the pipeline for every validated input, renders the
+// results together, prints -metrics, and then enforces -max-tokens, even
+// when the reader of the output went away early.
+func process(
+	flags *cliFlags,
+	tok *tokenizer.Tokenizer,
+	comp *comparison,
+	formatter Formatter,
+	inputs []inputSource,
+	streams cliStreams,
+) error {
+	results := make([]*TokenResult, 0, len(inputs))
+	reg := newRunMetrics(flags)
+
+	for _, in := range inputs {
+		start := time.Now()
+
+		result, err := processInput(flags, tok, comp, reg, in)
+		if err != nil {
+			return err
+		}
+
+		logInput(flags.logger, tok, in, result, start)
+
+		results = append(results, result)
+	}
+
+	limit := checkLimit(results, flags.maxTokens)
+
+	err := formatter.Format(streams.stdout, results)
+	if err != nil && !isBrokenPipeOver(err, limit) {
+		return ioError(err)
+	}
+
+	err = writeRunMetrics(reg, streams.stderr)
+	if err != nil {
+		return ioError(err)
+	}
+
+	return limit
+}
+
+// checkLimit returns a limit error for the first result over maxTokens.
+func checkLimit(results []*TokenResult, maxTokens int) error {
+	if maxTokens <= 0 {
+		return nil
+	}
+
+	for _, r := range results {
+		if r.TokenCount > maxTokens {
+			return limitError(fmt.Errorf(
+				ErrLimitDetailFmt, ErrLimitExceeded, r.Source, r.TokenCount, maxTokens,
+			))
+		}
+
+		if r.UpperBound > maxTokens {
+			return limitError(fmt.Errorf(
+				ErrLimitBoundFmt, ErrLimitExceeded, r.Source, r.UpperBound, maxTokens,
+			))
+		}
+	}
+
+	return nil
+}
+
+// processInput counts one input and adds the extra reports the flags ask
+// for. reg, when set, records the count alone, not the extra reports.
+func processInput(
+	flags *cliFlags,
+	tok *tokenizer.Tokenizer,
+	comp *comparison,
+	reg *metrics.Registry,
+	in inputSource,
+) (*TokenResult, error) {
+	start := time.Now()
+
+	result, err := buildResult(flags, tok, in.text)
+	if err != nil {
+		return nil, inputError(fmt.Errorf(ErrWrapTokenizeSource, in.name, err))
+	}
+
+	observeInput(reg, tok, in, result, start)
+
+	result.Source = in.name
+	result.Encoding = in.encoding
+
+	attachUpperBound(flags, tok, result, in.text)
+
+	err = attachBreakdown(flags, tok, result, in.text)
 	if err != nil {
-		return fmt.Errorf(ErrWrapTokenize, err)
+		return nil, inputError(err)
 	}
 
-	return emitResult(flags, result)
+	attachComparison(comp, result, in.text)
+
+	return result, nil
 }
 
-// parseFlags defines and parses CLI flags, returning a structured result.
-func parseFlags() *cliFlags {
-	showVersion := flag.Bool(FlagNameVersion, false, FlagHelpVersion)
-	outputJSON := flag.Bool(FlagNameJSON, false, FlagHelpJSON)
-	inputFile := flag.String(FlagNameFile, "", FlagHelpInputFile)
-	text := flag.String(FlagNameText, "", FlagHelpText)
-	showNormalized := flag.Bool(FlagNameNormalized, false, FlagHelpNormalized)
+// parseFlags defines and parses CLI flags, returning a structured result
+// and the flag set for usage output. Usage and parse errors go to stderr.
+func parseFlags(args []string, stderr io.Writer) (*cliFlags, *flag.FlagSet, error) {
+	fs := flag.NewFlagSet(ExecutableDefault, flag.ContinueOnError)
+	fs.SetOutput(stderr)
+
+	showVersion := fs.Bool(FlagNameVersion, false, FlagHelpVersion)
+	outputJSON := fs.Bool(FlagNameJSON, false, FlagHelpJSON)
+	format := fs.String(FlagNameFormat, FormatText, FlagHelpFormat)
+	tmpl := fs.String(FlagNameTemplate, "", FlagHelpTemplate)
+	text := fs.String(FlagNameText, "", FlagHelpText)
+	showNormalized := fs.Bool(FlagNameNormalized, false, FlagHelpNormalized)
+	reportUnit := fs.String(FlagNameReport, "", FlagHelpReport)
+	sortOrder := fs.String(FlagNameSort, SortOrderDocument, FlagHelpSort)
+	maxTokens := fs.Int(FlagNameMaxTokens, 0, FlagHelpMaxTokens)
+	modelFile := fs.String(FlagNameModelFile, "", FlagHelpModelFile)
+	cased := fs.Bool(FlagNameCased, false, FlagHelpCased)
+	models := fs.String(FlagNameModels, "", FlagHelpModels)
+	baseline := fs.String(FlagNameBaseline, "", FlagHelpBaseline)
+	prices := fs.String(FlagNamePrices, "", FlagHelpPrices)
+	runMetrics := fs.Bool(FlagNameMetrics, false, FlagHelpMetrics)
+	watch := fs.Bool(FlagNameWatch, false, FlagHelpWatch)
+	watchInterval := fs.Duration(FlagNameWatchInterval, DefaultWatchInterval, FlagHelpWatchInterval)
+
+	var inputFiles, specialTokens stringList
+
+	fs.Var(&inputFiles, FlagNameFile, FlagHelpInputFile)
+	fs.Var(&specialTokens, FlagNameAddSpecial, FlagHelpAddSpecial)
+
+	encodingName := EncodingAuto
+
+	fs.Func(FlagNameEncoding, FlagHelpEncoding, func(value string) error {
+		canonical, err := parseEncoding(value)
+		encodingName = canonical
+
+		return err
+	})
+
+	invalidUTF8 := tokenizer.InvalidUTF8Skip
+
+	fs.Func(FlagNameInvalid, FlagHelpInvalid, func(value string) error {
+		policy, err := tokenizer.ParseInvalidUTF8Policy(value)
+		invalidUTF8 = policy
+
+		return err
+	})
+
+	boundMode, upperBound := tokenizer.UpperBoundConfident, false
+
+	fs.Func(FlagNameBound, FlagHelpBound, func(value string) error {
+		mode, err := tokenizer.ParseUpperBoundMode(value)
+		boundMode, upperBound = mode, true
 
-	flag.Usage = func() { printUsage() }
-	flag.Parse()
+		return err
+	})
+
+	safetyMargin := 0.0
+
+	fs.Func(FlagNameMargin, FlagHelpMargin, func(value string) error {
+		margin, err := parseSafetyMargin(value)
+		safetyMargin, upperBound = margin, true
+
+		return err
+	})
+
+	logLevel, logFormat, logging := slog.LevelInfo, LogFormatText, false
+
+	fs.Func(FlagNameLogLevel, FlagHelpLogLevel, func(value string) error {
+		level, err := parseLogLevel(value)
+		logLevel, logging = level, true
+
+		return err
+	})
+
+	fs.Func(FlagNameLogFormat, FlagHelpLogFormat, func(value string) error {
+		format, err := parseLogFormat(value)
+		logFormat, logging = format, true
+
+		return err
+	})
+
+	specialPolicy := tokenizer.SpecialTokenAllow
+
+	fs.Func(FlagNameSpecial, FlagHelpSpecial, func(value string) error {
+		policy, err := tokenizer.ParseSpecialTokenPolicy(value)
+		specialPolicy = policy
+
+		return err
+	})
+
+	// The flag package reports parse errors itself; usage write failures
+	// surface through the returned parse error instead.
+	fs.Usage = func() { _ = printUsage(stderr, fs) }
+
+	err := fs.Parse(args)
+	if err != nil {
+		return nil, fs, err
+	}
+
+	if *outputJSON {
+		*format = FormatJSON
+	}
+
+	var logger *slog.Logger
+	if logging {
+		logger = newLogger(stderr, logLevel, logFormat)
+	}
 
 	return &cliFlags{
+		args:           fs.Args(),
 		showVersion:    *showVersion,
 		outputJSON:     *outputJSON,
-		inputFile:      *inputFile,
+		inputFiles:     inputFiles,
+		format:         *format,
+		template:       *tmpl,
 		text:           *text,
 		showNormalized: *showNormalized,
-	}
+		reportUnit:     *reportUnit,
+		sortOrder:      *sortOrder,
+		maxTokens:      *maxTokens,
+		invalidUTF8:    invalidUTF8,
+		specialPolicy:  specialPolicy,
+		specialTokens:  specialTokens,
+		encoding:       encodingName,
+		modelFile:      *modelFile,
+		cased:          *cased,
+		models:         *models,
+		baseline:       *baseline,
+		prices:         *prices,
+		boundMode:      boundMode,
+		safetyMargin:   safetyMargin,
+		upperBound:     upperBound,
+		metrics:        *runMetrics,
+		watch:          *watch,
+		watchInterval:  cmp.Or(max(*watchInterval, 0), DefaultWatchInterval),
+		logger:         logger,
+	}, fs, nil
 }
 
 // buildResult selects tokenization mode based on flags and returns a result.
-func buildResult(flags *cliFlags, input string) (*TokenResult, error) {
+func buildResult(flags *cliFlags, tok *tokenizer.Tokenizer, input string) (*TokenResult, error) {
 	if flags.showNormalized {
-		return tokenizeNormalized(input)
+		return tokenizeNormalized(tok, input)
 	}
 
-	return tokenize(input)
+	return tokenize(tok, input)
 }
 
-// emitResult chooses output mode based on flags and writes the result.
-func emitResult(flags *cliFlags, r *TokenResult) error {
-	if flags.outputJSON {
-		return writeJSON(r)
+// attachBreakdown adds the per-unit report when -report is set.
+func attachBreakdown(flags *cliFlags, tok *tokenizer.Tokenizer, result *TokenResult, input string) error {
+	if flags.reportUnit == "" {
+		return nil
+	}
+
+	entries, err := buildBreakdown(tok, input, flags.reportUnit, flags.sortOrder)
+	if err != nil {
+		return err
 	}
 
-	writePlain(r)
+	result.ReportUnit = flags.reportUnit
+	result.Breakdown = entries
 
 	return nil
 }
 
 // printVersion prints version metadata derived from embedded build info.
-func printVersion() {
+func printVersion(w io.Writer) error {
 	versionInfo := resolveVersionAndTime()
-	printOutput(MsgVersionFmt, versionInfo.Revision, versionInfo.BuildTimestamp)
+
+	_, err := fmt.Fprintf(w, MsgVersionFmt, versionInfo.Revision, versionInfo.BuildTimestamp)
+	if err != nil {
+		return fmt.Errorf(ErrWrapWriteOutput, err)
+	}
+
+	return nil
 }
 
 // readBuildInfo retrieves build info if available.
@@ -257,57 +704,79 @@ func buildSettingsMap(info *debug.BuildInfo) map[string]string {
 	return settingsMap
 }
 
-// readInputNonText considers file, args, then stdin in that order.
-
-func readInputNonText(flags *cliFlags) (string, error) {
-	if flags.inputFile != "" {
-		return readFile(flags.inputFile)
+// readInputNonText considers the -text flag, args, then stdin in that order.
+// Only stdin is decoded; command-line text is already in the locale's UTF-8.
+func readInputNonText(flags *cliFlags, stdin io.Reader) (inputSource, error) {
+	if flags.text != "" {
+		return inputSource{name: SourceArgs, text: flags.text, encoding: ""}, nil
 	}
 
-	joined := strings.Join(flag.Args(), " ")
+	joined := strings.Join(flags.args, " ")
 	if joined != "" {
-		return joined, nil
+		return inputSource{name: SourceArgs, text: joined, encoding: ""}, nil
 	}
 
-	return readStdin()
+	raw, err := readStdin(stdin)
+	if err != nil {
+		return inputSource{}, ioError(err)
+	}
+
+	return decodeSource(SourceStdin, raw, flags.encoding)
 }
 
 // tokenize returns a TokenResult without normalization.
-func tokenize(text string) (*TokenResult, error) {
-	tok := tokenizer.NewTokenizer()
+func tokenize(tok *tokenizer.Tokenizer, text string) (*TokenResult, error) {
+	analysis, err := tok.Analyze(text)
+	if err != nil {
+		return nil, err
+	}
 
 	return &TokenResult{
-		Text:           text,
-		Model:          tok.GetModel(),
-		OriginalText:   "",
-		NormalizedText: "",
-		TokenCount:     tok.EstimateTokens(text),
+		Text:                  text,
+		Model:                 tok.GetModel(),
+		OriginalText:          "",
+		NormalizedText:        "",
+		InvalidUTF8RunOffsets: analysis.InvalidUTF8Runs,
+		InvalidUTF8Runs:       len(analysis.InvalidUTF8Runs),
+		SpecialTokens:         analysis.SpecialTokens,
+		SpecialTokenCount:     sumCounts(analysis.SpecialTokens),
+		TokenCount:            analysis.TokenCount,
 	}, nil
 }
 
-// tokenizeNormalized returns a TokenResult with normalization.
-func tokenizeNormalized(text string) (*TokenResult, error) {
-	tok := tokenizer.NewTokenizer()
-	norm := tok.Normalize(text)
+// sumCounts totals the values of a count map.
+func sumCounts(counts map[string]int) int {
+	total := 0
+	for _, n := range counts {
+		total += n
+	}
 
-	return &TokenResult{
-		Text:           text,
-		Model:          tok.GetModel(),
-		OriginalText:   text,
-		NormalizedText: norm,
-		TokenCount:     tok.EstimateTokens(text),
-	}, nil
+	return total
 }
 
-// tokenizeText is kept for test compatibility; delegates to explicit variants.
-//
+// tokenizeNormalized returns a TokenResult with normalization.
+func tokenizeNormalized(tok *tokenizer.Tokenizer, text string) (*TokenResult, error) {
+	result, err := tokenize(tok, text)
+	if err != nil {
+		return nil, err
+	}
+
+	result.OriginalText = text
+	result.NormalizedText = tok.Normalize(text)
 
+	return result, nil
+}
+
+// tokenizeText is kept for test compatibility; delegates to explicit
+// variants with the default tokenizer.
 func tokenizeText(text string, showNormalized bool) (*TokenResult, error) {
+	tok := tokenizer.NewTokenizer()
+
 	if showNormalized {
-		return tokenizeNormalized(text)
+		return tokenizeNormalized(tok, text)
 	}
 
-	return tokenize(text)
+	return tokenize(tok, text)
 }
 
 // readFile reads the entire file content after sanitizing the provided path.
@@ -323,8 +792,8 @@ func readFile(filename string) (string, error) {
 }
 
 // readStdin reads all data from standard input and wraps errors with context.
-func readStdin() (string, error) {
-	data, err := io.ReadAll(os.Stdin)
+func readStdin(stdin io.Reader) (string, error) {
+	data, err := io.ReadAll(stdin)
 	if err != nil {
 		return "", fmt.Errorf(ErrWrapReadStdin, err)
 	}
@@ -332,26 +801,9 @@ func readStdin() (string, error) {
 	return string(data), nil
 }
 
-// printError writes formatted output to stderr; critical failure if writing fails.
-func printError(format string, args ...any) {
-	_, e := fmt.Fprintf(os.Stderr, format, args...)
-	if e != nil {
-		// If unable to write to stderr, terminate loudly.
-		panic(e)
-	}
-}
-
-// printOutput writes formatted output to stdout; critical failure if writing fails.
-func printOutput(format string, args ...any) {
-	_, e := fmt.Fprintf(os.Stdout, format, args...)
-	if e != nil {
-		// If unable to write to stdout, terminate loudly.
-		panic(e)
-	}
-}
-
-// printUsage prints the CLI usage text with examples and flag defaults.
-func printUsage() {
+// printUsage writes the CLI usage text with examples, flag defaults, and
+// exit codes to w.
+func printUsage(w io.Writer, fs *flag.FlagSet) error {
 	exe := ExecutableDefault
 
 	path, execErr := os.Executable()
@@ -359,12 +811,32 @@ func printUsage() {
 		exe = filepath.Base(path)
 	}
 
-	printOutput(UsageHeader)
-	printOutput(UsageUsageFmt, exe)
-	printOutput(UsageRules)
-	printOutput(UsageOptions)
-	flag.PrintDefaults()
-	printOutput(UsageExamplesFmt, exe, exe, exe, exe, exe)
+	_, err := fmt.Fprint(w, UsageHeader)
+	if err == nil {
+		_, err = fmt.Fprintf(w, UsageUsageFmt, exe)
+	}
+
+	if err == nil {
+		_, err = fmt.Fprint(w, UsageRules+UsageCommands+UsageOptions)
+	}
+
+	if err != nil {
+		return fmt.Errorf(ErrWrapWriteOutput, err)
+	}
+
+	fs.SetOutput(w)
+	fs.PrintDefaults()
+
+	_, err = fmt.Fprintf(w, UsageExamplesFmt, exe, exe, exe, exe, exe, exe, exe, exe, exe, exe, exe, exe, exe, exe, exe, exe, exe, exe, exe, exe, exe, exe, exe)
+	if err == nil {
+		_, err = fmt.Fprint(w, UsageExitCodes)
+	}
+
+	if err != nil {
+		return fmt.Errorf(ErrWrapWriteOutput, err)
+	}
+
+	return nil
 }
 
 // truncateText returns a shortened representation with ellipsis if needed.
@@ -380,29 +852,15 @@ func truncateText(text string, maxLen int) string {
 	return text[:maxLen-EllipsisLen] + "..."
 }
 
-// writeJSON pretty-prints the TokenResult as JSON to stdout and wraps errors.
-func writeJSON(result *TokenResult) error {
-	enc := json.NewEncoder(os.Stdout)
-	enc.SetIndent("", MsgJSONIndent)
-
-	err := enc.Encode(result)
-	if err != nil {
-		return fmt.Errorf(ErrWrapEncodeJSON, err)
-	}
+// stringList is a repeatable string flag.
+type stringList []string
 
-	return nil
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
 }
 
-// writePlain prints a human-friendly representation to stdout.
-func writePlain(result *TokenResult) {
-	printOutput(MsgTextFmt, truncateText(result.Text, DefaultPreviewMax))
-	printOutput(MsgTokenCountFmt, result.TokenCount)
-	printOutput(MsgModelFmt, result.Model)
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
 
-	if result.NormalizedText != "" {
-		printOutput(
-			MsgNormalizedFmt,
-			truncateText(result.NormalizedText, DefaultPreviewMax),
-		)
-	}
+	return nil
 }
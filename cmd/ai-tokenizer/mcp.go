@@ -0,0 +1,443 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+
+	tokenizer "github.com/nnikolov3/ai-tokenizer"
+)
+
+const (
+	// MCPProtocolVersion is the newest Model Context Protocol revision the
+	// server speaks; it answers older supported revisions in kind.
+	MCPProtocolVersion = "2025-06-18"
+
+	MCPMethodInitialize  = "initialize"
+	MCPMethodInitialized = "notifications/initialized"
+	MCPMethodPing        = "ping"
+	MCPMethodToolsList   = "tools/list"
+	MCPMethodToolsCall   = "tools/call"
+
+	ToolCountTokens = "count_tokens"
+	ToolTruncate    = "truncate_to_tokens"
+	ToolChunk       = "chunk_text"
+	ToolNormalize   = "normalize_text"
+
+	MCPContentText = "text"
+
+	FlagHelpMCPModelFile = "Serve a local model file as the default model (default: " + tokenizer.DefaultModel + ")"
+
+	UsageMCPFmt = "" +
+		"Usage: %s mcp [options]\n\n" +
+		"Serves the Model Context Protocol over stdin and stdout, one JSON-RPC\n" +
+		"message per line, with the tools count_tokens, truncate_to_tokens,\n" +
+		"chunk_text and normalize_text.\n\n" +
+		"Options:\n"
+
+	ErrUnknownToolMsg    = "unknown tool"
+	ErrUnknownToolFmt    = "%w %q"
+	ErrMissingArgFmt     = "missing %q"
+	ErrMCPUnknownModelFs = "unknown model %q (want one of %v)"
+	ErrWrapServeMCP      = "serve mcp: %w"
+)
+
+// ErrUnknownTool is returned for a tools/call naming no tool.
+var ErrUnknownTool = errors.New(ErrUnknownToolMsg)
+
+// supportedMCPVersions are the protocol revisions the server accepts from
+// a client's initialize request.
+func supportedMCPVersions() []string {
+	return []string{"2024-11-05", "2025-03-26", MCPProtocolVersion}
+}
+
+// mcpServer answers MCP requests with the tokenizer of each tool call's
+// model, built on first use.
+type mcpServer struct {
+	defaultModel string
+	models       *modelCache
+}
+
+// mcpTool describes a tool in tools/list.
+type mcpTool struct {
+	Name        string     `json:"name"`
+	Description string     `json:"description"`
+	InputSchema jsonSchema `json:"inputSchema"`
+}
+
+// jsonSchema is the subset of JSON Schema the tool inputs need.
+type jsonSchema struct {
+	Type        string                `json:"type"`
+	Description string                `json:"description,omitempty"`
+	Properties  map[string]jsonSchema `json:"properties,omitempty"`
+	Required    []string              `json:"required,omitempty"`
+	Enum        []string              `json:"enum,omitempty"`
+	Minimum     *int                  `json:"minimum,omitempty"`
+}
+
+// mcpToolArgs are the arguments of every tool; each uses a subset.
+// Pointers tell a missing field from an empty text or a zero budget.
+type mcpToolArgs struct {
+	Text      *string `json:"text"`
+	MaxTokens *int    `json:"max_tokens"`
+	Model     string  `json:"model"`
+}
+
+// mcpToolResult is the result of tools/call. Failures of a tool on its
+// arguments are results with isError set, so the model calling the tool
+// can read them; JSON-RPC errors are kept for protocol errors.
+type mcpToolResult struct {
+	Content           []mcpContent `json:"content"`
+	StructuredContent any          `json:"structuredContent,omitempty"`
+	IsError           bool         `json:"isError"`
+}
+
+type mcpContent struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+// CountResult is the structured result of count_tokens.
+type CountResult struct {
+	Model  string `json:"model"`
+	Tokens int    `json:"tokens"`
+}
+
+// TruncateResult is the structured result of truncate_to_tokens.
+type TruncateResult struct {
+	Model     string `json:"model"`
+	Text      string `json:"text"`
+	Tokens    int    `json:"tokens"`
+	Truncated bool   `json:"truncated"`
+}
+
+// ChunkResult is the structured result of chunk_text.
+type ChunkResult struct {
+	Model  string      `json:"model"`
+	Chunks []TextChunk `json:"chunks"`
+}
+
+// TextChunk is one piece of chunked text with its count.
+type TextChunk struct {
+	Text   string `json:"text"`
+	Tokens int    `json:"tokens"`
+}
+
+// NormalizeResult is the structured result of normalize_text.
+type NormalizeResult struct {
+	Model string `json:"model"`
+	Text  string `json:"text"`
+}
+
+// runMCP implements the mcp command.
+func runMCP(args []string, streams cliStreams) error {
+	modelFile, err := parseMCPFlags(args, streams.stderr)
+	if err != nil {
+		return reportedInputError(err)
+	}
+
+	server, err := newMCPServer(modelFile)
+	if err != nil {
+		return err
+	}
+
+	err = serveRPC(streams.stdin, streams.stdout, server.handle)
+	if err != nil {
+		return ioError(fmt.Errorf(ErrWrapServeMCP, err))
+	}
+
+	return nil
+}
+
+func parseMCPFlags(args []string, stderr io.Writer) (string, error) {
+	fs := flag.NewFlagSet(ExecutableDefault+" "+CommandMCP, flag.ContinueOnError)
+	fs.SetOutput(stderr)
+
+	modelFile := fs.String(FlagNameModelFile, "", FlagHelpMCPModelFile)
+
+	fs.Usage = func() { _ = printCommandUsage(stderr, fs, UsageMCPFmt) }
+
+	err := fs.Parse(args)
+	if err != nil {
+		return "", err
+	}
+
+	return *modelFile, nil
+}
+
+// printCommandUsage writes a command's usage text, which names the
+// executable once, and its flag defaults.
+func printCommandUsage(w io.Writer, fs *flag.FlagSet, usageFmt string) error {
+	exe := ExecutableDefault
+
+	path, execErr := os.Executable()
+	if execErr == nil && path != "" {
+		exe = filepath.Base(path)
+	}
+
+	_, err := fmt.Fprintf(w, usageFmt, exe)
+	if err != nil {
+		return fmt.Errorf(ErrWrapWriteOutput, err)
+	}
+
+	fs.SetOutput(w)
+	fs.PrintDefaults()
+
+	return nil
+}
+
+// newMCPServer loads the model file, if any, as the default model, so a
+// bad file fails at startup rather than on the first call.
+func newMCPServer(modelFile string) (*mcpServer, error) {
+	server := &mcpServer{defaultModel: tokenizer.DefaultModel, models: newModelCache(nil)}
+	if modelFile == "" {
+		return server, nil
+	}
+
+	tok, err := newFileTokenizer(modelFile, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	server.defaultModel = tok.GetModel()
+	server.models.put(server.defaultModel, tok)
+
+	return server, nil
+}
+
+// handle is the rpcHandler of the server.
+func (s *mcpServer) handle(method string, params json.RawMessage) (any, *rpcError) {
+	switch method {
+	case MCPMethodInitialize:
+		return s.initialize(params)
+	case MCPMethodInitialized:
+		return nil, nil
+	case MCPMethodPing:
+		return struct{}{}, nil
+	case MCPMethodToolsList:
+		return map[string][]mcpTool{"tools": s.tools()}, nil
+	case MCPMethodToolsCall:
+		return s.callTool(params)
+	default:
+		return nil, methodNotFound(method)
+	}
+}
+
+func (s *mcpServer) initialize(params json.RawMessage) (any, *rpcError) {
+	var req struct {
+		ProtocolVersion string `json:"protocolVersion"`
+	}
+
+	if rpcErr := decodeParams(params, &req); rpcErr != nil {
+		return nil, rpcErr
+	}
+
+	version := MCPProtocolVersion
+	if slices.Contains(supportedMCPVersions(), req.ProtocolVersion) {
+		version = req.ProtocolVersion
+	}
+
+	return map[string]any{
+		"protocolVersion": version,
+		"capabilities":    map[string]any{"tools": map[string]any{}},
+		"serverInfo": map[string]string{
+			"name":    ExecutableDefault,
+			"version": resolveVersionAndTime().Revision,
+		},
+	}, nil
+}
+
+// modelNames lists the names tool calls accept, the default first.
+func (s *mcpServer) modelNames() []string {
+	names := []string{s.defaultModel}
+	for _, name := range tokenizer.Models() {
+		if name != s.defaultModel {
+			names = append(names, name)
+		}
+	}
+
+	return names
+}
+
+func (s *mcpServer) tools() []mcpTool {
+	one := 1
+	text := jsonSchema{Type: "string", Description: "Text to process", Properties: nil, Required: nil, Enum: nil, Minimum: nil}
+	maxTokens := jsonSchema{Type: "integer", Description: "Token budget", Properties: nil, Required: nil, Enum: nil, Minimum: &one}
+	model := jsonSchema{
+		Type:        "string",
+		Description: "Model whose tokenizer counts the text (default: " + s.defaultModel + ")",
+		Properties:  nil,
+		Required:    nil,
+		Enum:        s.modelNames(),
+		Minimum:     nil,
+	}
+
+	schema := func(required ...string) jsonSchema {
+		props := map[string]jsonSchema{"text": text, "model": model}
+		if slices.Contains(required, "max_tokens") {
+			props["max_tokens"] = maxTokens
+		}
+
+		return jsonSchema{Type: "object", Description: "", Properties: props, Required: required, Enum: nil, Minimum: nil}
+	}
+
+	return []mcpTool{
+		{
+			Name:        ToolCountTokens,
+			Description: "Count the tokens of a text",
+			InputSchema: schema("text"),
+		},
+		{
+			Name:        ToolTruncate,
+			Description: "Cut a text to its longest prefix within a token budget",
+			InputSchema: schema("text", "max_tokens"),
+		},
+		{
+			Name:        ToolChunk,
+			Description: "Split a text into pieces within a token budget, at paragraph, line or word breaks where possible",
+			InputSchema: schema("text", "max_tokens"),
+		},
+		{
+			Name:        ToolNormalize,
+			Description: "Show the text the tokenizer counts after Unicode normalization",
+			InputSchema: schema("text"),
+		},
+	}
+}
+
+func (s *mcpServer) callTool(params json.RawMessage) (any, *rpcError) {
+	var req struct {
+		Name      string          `json:"name"`
+		Arguments json.RawMessage `json:"arguments"`
+	}
+
+	if rpcErr := decodeParams(params, &req); rpcErr != nil {
+		return nil, rpcErr
+	}
+
+	run, ok := s.toolFuncs()[req.Name]
+	if !ok {
+		return nil, invalidParams(fmt.Errorf(ErrUnknownToolFmt, ErrUnknownTool, req.Name))
+	}
+
+	var args mcpToolArgs
+
+	if rpcErr := decodeParams(req.Arguments, &args); rpcErr != nil {
+		return nil, rpcErr
+	}
+
+	result, err := s.runTool(run, args)
+	if err != nil {
+		return toolError(err), nil
+	}
+
+	return toolResult(result)
+}
+
+// toolFunc runs a tool with its arguments checked and its tokenizer chosen.
+type toolFunc func(tok *tokenizer.Tokenizer, text string, maxTokens *int) (any, error)
+
+func (s *mcpServer) toolFuncs() map[string]toolFunc {
+	return map[string]toolFunc{
+		ToolCountTokens: countTool,
+		ToolTruncate:    truncateTool,
+		ToolChunk:       chunkTool,
+		ToolNormalize:   normalizeTool,
+	}
+}
+
+func (s *mcpServer) runTool(run toolFunc, args mcpToolArgs) (any, error) {
+	if args.Text == nil {
+		return nil, fmt.Errorf(ErrMissingArgFmt, "text")
+	}
+
+	tok, err := s.tokenizer(args.Model)
+	if err != nil {
+		return nil, err
+	}
+
+	return run(tok, *args.Text, args.MaxTokens)
+}
+
+// tokenizer returns the tokenizer of a model name, or of the default model
+// when name is empty.
+func (s *mcpServer) tokenizer(name string) (*tokenizer.Tokenizer, error) {
+	if name == "" {
+		name = s.defaultModel
+	}
+
+	if name != s.defaultModel && !slices.Contains(tokenizer.Models(), name) {
+		return nil, fmt.Errorf(ErrMCPUnknownModelFs, name, s.modelNames())
+	}
+
+	return s.models.get(name)
+}
+
+func countTool(tok *tokenizer.Tokenizer, text string, _ *int) (any, error) {
+	return CountResult{Model: tok.GetModel(), Tokens: tok.EstimateTokens(text)}, nil
+}
+
+func truncateTool(tok *tokenizer.Tokenizer, text string, maxTokens *int) (any, error) {
+	if maxTokens == nil {
+		return nil, fmt.Errorf(ErrMissingArgFmt, "max_tokens")
+	}
+
+	cut := tok.Truncate(text, *maxTokens)
+
+	return TruncateResult{
+		Model:     tok.GetModel(),
+		Text:      cut,
+		Tokens:    tok.EstimateTokens(cut),
+		Truncated: len(cut) < len(text),
+	}, nil
+}
+
+func chunkTool(tok *tokenizer.Tokenizer, text string, maxTokens *int) (any, error) {
+	if maxTokens == nil {
+		return nil, fmt.Errorf(ErrMissingArgFmt, "max_tokens")
+	}
+
+	pieces, err := tok.Chunk(text, *maxTokens)
+	if err != nil {
+		return nil, err
+	}
+
+	chunks := make([]TextChunk, 0, len(pieces))
+	for _, piece := range pieces {
+		chunks = append(chunks, TextChunk{Text: piece, Tokens: tok.EstimateTokens(piece)})
+	}
+
+	return ChunkResult{Model: tok.GetModel(), Chunks: chunks}, nil
+}
+
+func normalizeTool(tok *tokenizer.Tokenizer, text string, _ *int) (any, error) {
+	return NormalizeResult{Model: tok.GetModel(), Text: tok.Normalize(text)}, nil
+}
+
+// toolResult returns a structured result with its JSON as the text
+// content, for clients that only read text.
+func toolResult(result any) (any, *rpcError) {
+	data, err := json.Marshal(result)
+	if err != nil {
+		return nil, &rpcError{Code: RPCInternalError, Message: err.Error()}
+	}
+
+	return mcpToolResult{
+		Content:           []mcpContent{{Type: MCPContentText, Text: string(data)}},
+		StructuredContent: result,
+		IsError:           false,
+	}, nil
+}
+
+func toolError(err error) mcpToolResult {
+	return mcpToolResult{
+		Content:           []mcpContent{{Type: MCPContentText, Text: err.Error()}},
+		StructuredContent: nil,
+		IsError:           true,
+	}
+}
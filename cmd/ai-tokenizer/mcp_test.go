@@ -0,0 +1,302 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"slices"
+	"strings"
+	"testing"
+)
+
+const (
+	fmtMCPResponse  = "%s: response %v, want %v"
+	fmtMCPExit      = "mcp exit code = %d, want %d; stderr: %s"
+	fmtMCPTools     = "tools/list = %v, want %v"
+	fmtMCPToolError = "%s: isError = %v, text %q"
+	fmtMCPRPCError  = "%s: error = %+v, want code %d"
+)
+
+// mcpSession drives runMain mcp through in-memory pipes.
+type mcpSession struct {
+	t      *testing.T
+	stdin  *io.PipeWriter
+	stdout *bufio.Reader
+	stderr bytes.Buffer
+	done   chan int
+	nextID int
+}
+
+func startMCP(t *testing.T, args ...string) *mcpSession {
+	t.Helper()
+
+	inR, inW := io.Pipe()
+	outR, outW := io.Pipe()
+
+	s := &mcpSession{t: t, stdin: inW, stdout: bufio.NewReader(outR), stderr: bytes.Buffer{}, done: make(chan int, 1), nextID: 0}
+
+	go func() {
+		code := runMain(append([]string{CommandMCP}, args...), cliStreams{stdin: inR, stdout: outW, stderr: &s.stderr})
+		_ = outW.Close()
+		s.done <- code
+	}()
+
+	return s
+}
+
+// send writes one raw message line.
+func (s *mcpSession) send(line string) {
+	s.t.Helper()
+
+	_, err := io.WriteString(s.stdin, line+"\n")
+	if err != nil {
+		s.t.Fatal(err)
+	}
+}
+
+// receive reads one response line.
+func (s *mcpSession) receive() rpcTestResponse {
+	s.t.Helper()
+
+	line, err := s.stdout.ReadBytes('\n')
+	if err != nil {
+		s.t.Fatal(err)
+	}
+
+	var resp rpcTestResponse
+
+	err = json.Unmarshal(line, &resp)
+	if err != nil {
+		s.t.Fatal(err)
+	}
+
+	return resp
+}
+
+// call sends a request with the next id and returns its response.
+func (s *mcpSession) call(method string, params any) rpcTestResponse {
+	s.t.Helper()
+
+	s.nextID++
+
+	data, err := json.Marshal(map[string]any{"jsonrpc": RPCVersion, "id": s.nextID, "method": method, "params": params})
+	if err != nil {
+		s.t.Fatal(err)
+	}
+
+	s.send(string(data))
+
+	resp := s.receive()
+	if resp.ID != s.nextID {
+		s.t.Fatalf(fmtMCPResponse, method, resp.ID, s.nextID)
+	}
+
+	return resp
+}
+
+// callTool calls a tool and decodes its structured result into out.
+func (s *mcpSession) callTool(name string, args map[string]any, out any) mcpTestToolResult {
+	s.t.Helper()
+
+	resp := s.call(MCPMethodToolsCall, map[string]any{"name": name, "arguments": args})
+	if resp.Error != nil {
+		s.t.Fatalf(fmtMCPRPCError, name, resp.Error, 0)
+	}
+
+	var result mcpTestToolResult
+
+	err := json.Unmarshal(resp.Result, &result)
+	if err != nil {
+		s.t.Fatal(err)
+	}
+
+	if out != nil && !result.IsError {
+		err = json.Unmarshal(result.StructuredContent, out)
+		if err != nil {
+			s.t.Fatal(err)
+		}
+	}
+
+	return result
+}
+
+// close ends stdin and waits for the server to exit.
+func (s *mcpSession) close() {
+	s.t.Helper()
+
+	_ = s.stdin.Close()
+
+	if code := <-s.done; code != ExitOK {
+		s.t.Errorf(fmtMCPExit, code, ExitOK, s.stderr.String())
+	}
+}
+
+type rpcTestResponse struct {
+	ID     int             `json:"id"`
+	Result json.RawMessage `json:"result"`
+	Error  *rpcError       `json:"error"`
+}
+
+type mcpTestToolResult struct {
+	Content           []mcpContent    `json:"content"`
+	StructuredContent json.RawMessage `json:"structuredContent"`
+	IsError           bool            `json:"isError"`
+}
+
+func TestMCPInitializeAndListTools(t *testing.T) {
+	t.Parallel()
+
+	s := startMCP(t)
+	defer s.close()
+
+	resp := s.call(MCPMethodInitialize, map[string]any{"protocolVersion": "2025-03-26", "capabilities": map[string]any{}})
+
+	var init struct {
+		ProtocolVersion string                     `json:"protocolVersion"`
+		Capabilities    map[string]json.RawMessage `json:"capabilities"`
+		ServerInfo      struct {
+			Name string `json:"name"`
+		} `json:"serverInfo"`
+	}
+
+	err := json.Unmarshal(resp.Result, &init)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if init.ProtocolVersion != "2025-03-26" || init.ServerInfo.Name != ExecutableDefault || init.Capabilities["tools"] == nil {
+		t.Errorf(fmtMCPResponse, MCPMethodInitialize, resp.Result, "2025-03-26 with tools")
+	}
+
+	// The initialized notification has no response, so the next line
+	// read answers the ping.
+	s.send(`{"jsonrpc":"2.0","method":"notifications/initialized"}`)
+	s.call(MCPMethodPing, nil)
+
+	var list struct {
+		Tools []mcpTool `json:"tools"`
+	}
+
+	err = json.Unmarshal(s.call(MCPMethodToolsList, nil).Result, &list)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var names []string
+	for _, tool := range list.Tools {
+		names = append(names, tool.Name)
+
+		if tool.InputSchema.Type != "object" || !slices.Contains(tool.InputSchema.Required, "text") {
+			t.Errorf(fmtMCPResponse, tool.Name, tool.InputSchema.Required, "text required")
+		}
+	}
+
+	if want := []string{ToolCountTokens, ToolTruncate, ToolChunk, ToolNormalize}; !slices.Equal(names, want) {
+		t.Errorf(fmtMCPTools, names, want)
+	}
+}
+
+func TestMCPTools(t *testing.T) {
+	t.Parallel()
+
+	s := startMCP(t)
+	defer s.close()
+
+	var count CountResult
+	s.callTool(ToolCountTokens, map[string]any{"text": boundInput}, &count)
+
+	if want := (CountResult{Model: "simple", Tokens: 7}); count != want {
+		t.Errorf(fmtMCPResponse, ToolCountTokens, count, want)
+	}
+
+	var cut TruncateResult
+	s.callTool(ToolTruncate, map[string]any{"text": boundInput, "max_tokens": 3}, &cut)
+
+	if want := (TruncateResult{Model: "simple", Text: "hello", Tokens: 3, Truncated: true}); cut != want {
+		t.Errorf(fmtMCPResponse, ToolTruncate, cut, want)
+	}
+
+	var chunks ChunkResult
+	s.callTool(ToolChunk, map[string]any{"text": "hello world again", "max_tokens": 6}, &chunks)
+
+	want := []TextChunk{{Text: "hello ", Tokens: 4}, {Text: "world ", Tokens: 4}, {Text: "again", Tokens: 3}}
+	if !slices.Equal(chunks.Chunks, want) {
+		t.Errorf(fmtMCPResponse, ToolChunk, chunks.Chunks, want)
+	}
+
+	var norm NormalizeResult
+	s.callTool(ToolNormalize, map[string]any{"text": "café"}, &norm)
+
+	if norm.Text != "cafe" {
+		t.Errorf(fmtMCPResponse, ToolNormalize, norm.Text, "cafe")
+	}
+}
+
+func TestMCPModelFile(t *testing.T) {
+	t.Parallel()
+
+	s := startMCP(t, "-"+FlagNameModelFile, testModelFile)
+	defer s.close()
+
+	var count CountResult
+	s.callTool(ToolCountTokens, map[string]any{"text": boundInput}, &count)
+
+	if count.Model != "unigram" {
+		t.Errorf(fmtMCPResponse, ToolCountTokens, count.Model, "unigram")
+	}
+
+	// Registered models stay available by name.
+	s.callTool(ToolCountTokens, map[string]any{"text": boundInput, "model": "simple"}, &count)
+
+	if count.Model != "simple" {
+		t.Errorf(fmtMCPResponse, ToolCountTokens, count.Model, "simple")
+	}
+}
+
+func TestMCPToolErrors(t *testing.T) {
+	t.Parallel()
+
+	s := startMCP(t)
+	defer s.close()
+
+	tests := []struct {
+		name string
+		tool string
+		args map[string]any
+		want string
+	}{
+		{name: "missing text", tool: ToolCountTokens, args: map[string]any{}, want: `missing "text"`},
+		{name: "missing budget", tool: ToolTruncate, args: map[string]any{"text": "x"}, want: `missing "max_tokens"`},
+		{name: "empty budget", tool: ToolChunk, args: map[string]any{"text": "x", "max_tokens": 0}, want: "at least one token"},
+		{name: "unknown model", tool: ToolCountTokens, args: map[string]any{"text": "x", "model": "nope"}, want: "unknown model"},
+	}
+
+	for _, tt := range tests {
+		result := s.callTool(tt.tool, tt.args, nil)
+		if !result.IsError || len(result.Content) != 1 || !strings.Contains(result.Content[0].Text, tt.want) {
+			t.Errorf(fmtMCPToolError, tt.name, result.IsError, result.Content)
+		}
+	}
+}
+
+func TestMCPProtocolErrors(t *testing.T) {
+	t.Parallel()
+
+	s := startMCP(t)
+	defer s.close()
+
+	if resp := s.call("resources/list", nil); resp.Error == nil || resp.Error.Code != RPCMethodNotFound {
+		t.Errorf(fmtMCPRPCError, "unknown method", resp.Error, RPCMethodNotFound)
+	}
+
+	if resp := s.call(MCPMethodToolsCall, map[string]any{"name": "nope"}); resp.Error == nil || resp.Error.Code != RPCInvalidParams {
+		t.Errorf(fmtMCPRPCError, "unknown tool", resp.Error, RPCInvalidParams)
+	}
+
+	s.send("{not json")
+
+	if resp := s.receive(); resp.Error == nil || resp.Error.Code != RPCParseError {
+		t.Errorf(fmtMCPRPCError, "parse error", resp.Error, RPCParseError)
+	}
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"io"
+	"time"
+
+	tokenizer "github.com/nnikolov3/ai-tokenizer"
+	"github.com/nnikolov3/ai-tokenizer/metrics"
+)
+
+// newRunMetrics returns the registry for -metrics, or nil without it.
+func newRunMetrics(flags *cliFlags) *metrics.Registry {
+	if !flags.metrics {
+		return nil
+	}
+
+	return metrics.New()
+}
+
+// observeInput records the count of one input under the primary model,
+// timed from start.
+func observeInput(reg *metrics.Registry, tok *tokenizer.Tokenizer, in inputSource, result *TokenResult, start time.Time) {
+	if reg == nil {
+		return
+	}
+
+	reg.Observe(tok.GetModel(), len(in.text), result.TokenCount, time.Since(start))
+}
+
+// writeRunMetrics prints the -metrics summary in the same exposition
+// format a /metrics endpoint serves.
+func writeRunMetrics(reg *metrics.Registry, w io.Writer) error {
+	if reg == nil {
+		return nil
+	}
+
+	_, err := reg.WriteTo(w)
+
+	return err
+}
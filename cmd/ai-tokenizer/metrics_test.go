@@ -0,0 +1,59 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRunMainMetrics(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	first := filepath.Join(dir, "a.txt")
+	second := filepath.Join(dir, "b.txt")
+
+	for path, text := range map[string]string{first: boundInput, second: boundInput + " " + boundInput} {
+		err := os.WriteFile(path, []byte(text), 0o600)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []runMainTestCase{
+		{
+			name:       "batch summary",
+			args:       []string{"-metrics", "-format", "csv", "-file", first, "-file", second},
+			wantStdout: "simple,7,hello world",
+			// 11 + 23 bytes, 7 + 15 tokens.
+			wantStderr: "ai_tokenizer_requests_total{model=\"simple\"} 2\n" +
+				"# HELP ai_tokenizer_input_bytes_total Bytes of text estimated.\n" +
+				"# TYPE ai_tokenizer_input_bytes_total counter\n" +
+				"ai_tokenizer_input_bytes_total{model=\"simple\"} 34\n" +
+				"# HELP ai_tokenizer_tokens_total Tokens counted.\n" +
+				"# TYPE ai_tokenizer_tokens_total counter\n" +
+				"ai_tokenizer_tokens_total{model=\"simple\"} 22\n",
+			wantCode: ExitOK,
+		},
+		{
+			name:       "model file",
+			args:       []string{"-metrics", "-model-file", testModelFile, boundInput},
+			wantStdout: "Token Count: 2",
+			wantStderr: "ai_tokenizer_input_size_bytes_bucket{model=\"unigram\",le=\"64\"} 1\n",
+			wantCode:   ExitOK,
+		},
+		{
+			name:       "printed before the limit check",
+			args:       []string{"-metrics", "-max-tokens", "1", boundInput},
+			wantStderr: "ai_tokenizer_tokens_total{model=\"simple\"} 7\n",
+			wantCode:   ExitLimitExceeded,
+		},
+	}
+
+	for _, testCase := range tests {
+		t.Run(testCase.name, func(t *testing.T) {
+			t.Parallel()
+			runMainTest(t, testCase)
+		})
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	tokenizer "github.com/nnikolov3/ai-tokenizer"
+)
+
+const (
+	// ModelExtSentencePiece marks a SentencePiece .model file.
+	ModelExtSentencePiece = ".model"
+	// ModelExtHuggingFace marks a Hugging Face tokenizer.json file.
+	ModelExtHuggingFace = ".json"
+	// ModelExtWordPiece marks a BERT WordPiece vocab.txt file.
+	ModelExtWordPiece = ".txt"
+
+	ErrUnknownModelFileMsg = "unsupported model file"
+	ErrUnknownModelFileFmt = "%w %q (want a SentencePiece .model, tokenizer.json or vocab.txt file)"
+)
+
+// genericModelNames are the usual base names of model files, which say
+// nothing about the model.
+var genericModelNames = []string{"tokenizer", "vocab"}
+
+// ErrUnknownModelFile is returned when -model-file has an unknown extension.
+var ErrUnknownModelFile = errors.New(ErrUnknownModelFileMsg)
+
+// newTokenizer builds the tokenizer shared by every input: the heuristic
+// estimator, or an exact model loaded from -model-file.
+func newTokenizer(flags *cliFlags) (*tokenizer.Tokenizer, error) {
+	if flags.modelFile == "" {
+		return tokenizer.NewTokenizer(tokenizerOptions(flags)...), nil
+	}
+
+	return newFileTokenizer(flags.modelFile, tokenizerOptions(flags), wordPieceOptions(flags)...)
+}
+
+// tokenizerOptions applies the input policies every model shares.
+func tokenizerOptions(flags *cliFlags) []tokenizer.Option {
+	return []tokenizer.Option{
+		tokenizer.WithInvalidUTF8Policy(flags.invalidUTF8),
+		tokenizer.WithSpecialTokenPolicy(flags.specialPolicy),
+		tokenizer.WithSpecialTokens(flags.specialTokens...),
+		tokenizer.WithUpperBoundMode(flags.boundMode),
+		tokenizer.WithSafetyMargin(flags.safetyMargin),
+		tokenizer.WithLogger(flags.logger),
+	}
+}
+
+// wordPieceOptions applies -cased to WordPiece vocabularies.
+func wordPieceOptions(flags *cliFlags) []tokenizer.WordPieceOption {
+	return []tokenizer.WordPieceOption{tokenizer.WithWordPieceLowercase(!flags.cased)}
+}
+
+// newFileTokenizer loads a model file and names the tokenizer after it.
+// wordPiece applies only to a WordPiece vocab.txt.
+func newFileTokenizer(
+	path string,
+	opts []tokenizer.Option,
+	wordPiece ...tokenizer.WordPieceOption,
+) (*tokenizer.Tokenizer, error) {
+	enc, err := loadModelFile(path, wordPiece...)
+	if err != nil {
+		return nil, modelFileError(err)
+	}
+
+	opts = append(slices.Clip(opts), tokenizer.WithEncoder(modelName(path), enc))
+
+	return tokenizer.NewTokenizer(opts...), nil
+}
+
+// isModelFile reports whether path has an extension loadModelFile knows.
+func isModelFile(path string) bool {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ModelExtSentencePiece, ModelExtHuggingFace, ModelExtWordPiece:
+		return true
+	default:
+		return false
+	}
+}
+
+// loadModelFile picks a loader from the file extension.
+func loadModelFile(path string, wordPiece ...tokenizer.WordPieceOption) (tokenizer.Encoder, error) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ModelExtSentencePiece:
+		return tokenizer.LoadSentencePiece(path)
+	case ModelExtHuggingFace:
+		return tokenizer.LoadHuggingFace(path)
+	case ModelExtWordPiece:
+		return tokenizer.LoadWordPiece(path, wordPiece...)
+	default:
+		return nil, fmt.Errorf(ErrUnknownModelFileFmt, ErrUnknownModelFile, path)
+	}
+}
+
+// modelName reports a model file by its base name without extension, or
+// by its directory when the file has a generic name such as tokenizer.json
+// or vocab.txt.
+func modelName(path string) string {
+	base := filepath.Base(path)
+	name := strings.TrimSuffix(base, filepath.Ext(base))
+
+	if slices.Contains(genericModelNames, name) {
+		if dir := filepath.Base(filepath.Dir(path)); dir != "." && dir != string(filepath.Separator) {
+			return dir
+		}
+	}
+
+	return name
+}
+
+// modelFileError classifies read failures like -file errors; anything else
+// is a malformed or unsupported model, which is an input error.
+func modelFileError(err error) error {
+	var pathErr *fs.PathError
+	if errors.As(err, &pathErr) {
+		return fileError(err)
+	}
+
+	return inputError(err)
+}
@@ -0,0 +1,98 @@
+package main
+
+import "testing"
+
+const (
+	fmtModelName     = "modelName(%q) = %q, want %q"
+	testModelFile    = "../../testdata/unigram.model"
+	missingModelFile = "../../testdata/missing.model"
+	testHFFile       = "../../testdata/hf_wordpiece.json"
+)
+
+func TestRunMainModelFile(t *testing.T) {
+	t.Parallel()
+
+	tests := []runMainTestCase{
+		{
+			name:       "sentencepiece model",
+			args:       []string{"-model-file", testModelFile, "hello world"},
+			wantStdout: "Token Count: 2\nModel: unigram",
+			wantCode:   ExitOK,
+		},
+		{
+			name:       "model normalization shown",
+			args:       []string{"-model-file", testModelFile, "-normalized", "hello world"},
+			wantStdout: "Normalized: ▁hello▁world",
+			wantCode:   ExitOK,
+		},
+		{
+			name:       "tokenizer.json model",
+			args:       []string{"-model-file", testHFFile, "Hello, World!"},
+			wantStdout: "Token Count: 6\nModel: hf_wordpiece",
+			wantCode:   ExitOK,
+		},
+		{
+			name:       "unsupported tokenizer.json component",
+			args:       []string{"-model-file", "../../testdata/hf_unsupported.json", hello},
+			wantStderr: "unsupported tokenizer component",
+			wantCode:   ExitInputError,
+		},
+		{
+			name:       "wordpiece vocab counts cls and sep",
+			args:       []string{"-model-file", "../../testdata/vocab.txt", "Hello, World!"},
+			wantStdout: "Token Count: 6\nModel: testdata",
+			wantCode:   ExitOK,
+		},
+		{
+			name:       "cased wordpiece vocab keeps capitals",
+			args:       []string{"-model-file", "../../testdata/vocab.txt", "-" + FlagNameCased, "Unaffable"},
+			wantStdout: "Token Count: 3\n",
+			wantCode:   ExitOK,
+		},
+		{
+			name:       "missing model file",
+			args:       []string{"-model-file", missingModelFile, hello},
+			wantStderr: missingModelFile,
+			wantCode:   ExitInputError,
+		},
+		{
+			name:       "unsupported model file",
+			args:       []string{"-model-file", "vocab.bin", hello},
+			wantStderr: ErrUnknownModelFileMsg,
+			wantCode:   ExitInputError,
+		},
+		{
+			name:       "malformed model file",
+			args:       []string{"-model-file", "../../testdata/bpe.model", hello},
+			wantStderr: "unsupported SentencePiece model type",
+			wantCode:   ExitInputError,
+		},
+	}
+
+	for _, testCase := range tests {
+		t.Run(testCase.name, func(t *testing.T) {
+			t.Parallel()
+			runMainTest(t, testCase)
+		})
+	}
+}
+
+func TestModelName(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"llama.model", "llama"},
+		{"models/Llama-3/tokenizer.json", "Llama-3"},
+		{"tokenizer.json", "tokenizer"},
+		{"bert-base-uncased/vocab.txt", "bert-base-uncased"},
+	}
+
+	for _, testCase := range tests {
+		if got := modelName(testCase.path); got != testCase.want {
+			t.Errorf(fmtModelName, testCase.path, got, testCase.want)
+		}
+	}
+}
@@ -0,0 +1,14 @@
+//go:build !unix
+
+package main
+
+import (
+	"io/fs"
+	"os"
+)
+
+// fileOwner reports every file as the user's: these systems have no uids,
+// and the default socket lives in the per-user temp directory.
+func fileOwner(fs.FileInfo) (int, bool) {
+	return os.Getuid(), true
+}
@@ -0,0 +1,369 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	tokenizer "github.com/nnikolov3/ai-tokenizer"
+)
+
+// BreakdownEntry is the token count for one line, paragraph, or section of
+// the input.
+type BreakdownEntry struct {
+	Label      string  `json:"label"`
+	Index      int     `json:"index"`
+	StartLine  int     `json:"startLine"`
+	EndLine    int     `json:"endLine"`
+	TokenCount int     `json:"tokenCount"`
+	Cumulative int     `json:"cumulative"`
+	Percent    float64 `json:"percent"`
+}
+
+const (
+	// Report units accepted by the -report flag.
+	ReportUnitLine      = "line"
+	ReportUnitParagraph = "paragraph"
+	ReportUnitSection   = "section"
+
+	// Sort orders accepted by the -sort flag.
+	SortOrderDocument = "order"
+	SortOrderTokens   = "tokens"
+
+	// Report labels and layout.
+	ReportLabelMax      = 48
+	ReportPreambleLabel = "(preamble)"
+	ReportPercentScale  = 100.0
+	ReportPercentRound  = 100.0
+	ReportTableMinWidth = 0
+	ReportTableTabWidth = 8
+	ReportTablePadding  = 2
+	ReportTablePadChar  = ' '
+	ReportTableHeader   = "#\tLINES\tTOKENS\tCUMULATIVE\tPERCENT\tLABEL\n"
+	ReportTableRowFmt   = "%d\t%s\t%d\t%d\t%.1f%%\t%s\n"
+	ReportTotalFmt      = "Total: %d tokens in %d %s(s)\n"
+	ReportLineRangeFmt  = "%d-%d"
+
+	// Markdown structure markers used to split sections.
+	markdownHeadingMark = '#'
+	markdownMaxHeading  = 6
+	markdownMaxIndent   = 3
+	markdownFenceTicks  = "```"
+	markdownFenceTildes = "~~~"
+
+	ErrUnknownReportUnitMsg = "unknown report unit"
+	ErrUnknownSortOrderMsg  = "unknown sort order"
+	ErrReportUnitFmt        = "%w %q (want line, paragraph or section)"
+	ErrSortOrderFmt         = "%w %q (want order or tokens)"
+	ErrWrapReportTable      = "write report table: %w"
+)
+
+var (
+	// ErrUnknownReportUnit is returned for an unsupported -report value.
+	ErrUnknownReportUnit = errors.New(ErrUnknownReportUnitMsg)
+	// ErrUnknownSortOrder is returned for an unsupported -sort value.
+	ErrUnknownSortOrder = errors.New(ErrUnknownSortOrderMsg)
+)
+
+// textUnit is a contiguous slice of the input with its 1-based line span.
+type textUnit struct {
+	text      string
+	label     string
+	startLine int
+	endLine   int
+}
+
+// buildBreakdown splits text into units and counts tokens for each with tok.
+// Units keep their trailing newlines and are counted without the tokens a
+// model adds around every input, such as [CLS] and [SEP], so they add up to
+// the whole-text count less those.
+func buildBreakdown(tok *tokenizer.Tokenizer, text, unit, order string) ([]BreakdownEntry, error) {
+	units, err := splitUnits(text, unit)
+	if err != nil {
+		return nil, err
+	}
+
+	err = validateSortOrder(order)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]BreakdownEntry, 0, len(units))
+
+	for i, u := range units {
+		entries = append(entries, BreakdownEntry{
+			Label:      truncateText(u.label, ReportLabelMax),
+			Index:      i + 1,
+			StartLine:  u.startLine,
+			EndLine:    u.endLine,
+			TokenCount: tok.EstimateContentTokens(u.text),
+			Cumulative: 0,
+			Percent:    0,
+		})
+	}
+
+	if order == SortOrderTokens {
+		sort.SliceStable(entries, func(i, j int) bool {
+			return entries[i].TokenCount > entries[j].TokenCount
+		})
+	}
+
+	fillCumulative(entries)
+
+	return entries, nil
+}
+
+func validateSortOrder(order string) error {
+	switch order {
+	case SortOrderDocument, SortOrderTokens:
+		return nil
+	default:
+		return fmt.Errorf(ErrSortOrderFmt, ErrUnknownSortOrder, order)
+	}
+}
+
+// fillCumulative sets running totals and shares in display order.
+func fillCumulative(entries []BreakdownEntry) {
+	total := 0
+	for _, e := range entries {
+		total += e.TokenCount
+	}
+
+	running := 0
+
+	for i := range entries {
+		running += entries[i].TokenCount
+		entries[i].Cumulative = running
+		entries[i].Percent = percentOf(entries[i].TokenCount, total)
+	}
+}
+
+func percentOf(part, total int) float64 {
+	if total == 0 {
+		return 0
+	}
+
+	pct := float64(part) * ReportPercentScale / float64(total)
+
+	return math.Round(pct*ReportPercentRound) / ReportPercentRound
+}
+
+// splitUnits dispatches to the splitter for the requested report unit.
+func splitUnits(text, unit string) ([]textUnit, error) {
+	lines := splitLinesKeepEnds(text)
+
+	switch unit {
+	case ReportUnitLine:
+		return splitByLine(lines), nil
+	case ReportUnitParagraph:
+		return splitByParagraph(lines), nil
+	case ReportUnitSection:
+		return splitBySection(lines), nil
+	default:
+		return nil, fmt.Errorf(ErrReportUnitFmt, ErrUnknownReportUnit, unit)
+	}
+}
+
+// splitLinesKeepEnds splits text after each newline and drops the empty
+// remainder when text ends with a newline.
+func splitLinesKeepEnds(text string) []string {
+	lines := strings.SplitAfter(text, "\n")
+	if len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+
+	return lines
+}
+
+func splitByLine(lines []string) []textUnit {
+	units := make([]textUnit, 0, len(lines))
+
+	for i, line := range lines {
+		units = append(units, textUnit{
+			text:      line,
+			label:     strings.TrimSpace(line),
+			startLine: i + 1,
+			endLine:   i + 1,
+		})
+	}
+
+	return units
+}
+
+// splitByParagraph groups lines into paragraphs separated by blank lines.
+// Blank lines stay attached to the paragraph they follow.
+func splitByParagraph(lines []string) []textUnit {
+	var (
+		units      []textUnit
+		current    unitBuilder
+		hasContent bool
+		seenBlank  bool
+	)
+
+	for i, line := range lines {
+		blank := strings.TrimSpace(line) == ""
+		if !blank && seenBlank {
+			units = current.flush(units)
+			hasContent, seenBlank = false, false
+		}
+
+		current.add(line, i+1)
+
+		if !blank && !hasContent {
+			current.label = strings.TrimSpace(line)
+			hasContent = true
+		}
+
+		if blank && hasContent {
+			seenBlank = true
+		}
+	}
+
+	return current.flush(units)
+}
+
+// splitBySection starts a new unit at every Markdown ATX heading outside
+// fenced code blocks. Text before the first heading forms a preamble.
+func splitBySection(lines []string) []textUnit {
+	var (
+		units   []textUnit
+		current unitBuilder
+		inFence bool
+	)
+
+	current.label = ReportPreambleLabel
+
+	for i, line := range lines {
+		if isFenceLine(line) {
+			inFence = !inFence
+		}
+
+		if !inFence && isHeadingLine(line) {
+			units = current.flush(units)
+			current.label = strings.TrimSpace(line)
+		}
+
+		current.add(line, i+1)
+	}
+
+	return current.flush(units)
+}
+
+// unitBuilder accumulates consecutive lines into a textUnit.
+type unitBuilder struct {
+	text      strings.Builder
+	label     string
+	startLine int
+	endLine   int
+}
+
+func (b *unitBuilder) add(line string, lineNo int) {
+	if b.text.Len() == 0 {
+		b.startLine = lineNo
+	}
+
+	b.text.WriteString(line)
+	b.endLine = lineNo
+}
+
+// flush appends the accumulated unit, if any, and resets the builder.
+func (b *unitBuilder) flush(units []textUnit) []textUnit {
+	if b.text.Len() == 0 {
+		return units
+	}
+
+	units = append(units, textUnit{
+		text:      b.text.String(),
+		label:     b.label,
+		startLine: b.startLine,
+		endLine:   b.endLine,
+	})
+
+	b.text.Reset()
+	b.label = ""
+
+	return units
+}
+
+func isFenceLine(line string) bool {
+	trimmed := strings.TrimSpace(line)
+
+	return strings.HasPrefix(trimmed, markdownFenceTicks) ||
+		strings.HasPrefix(trimmed, markdownFenceTildes)
+}
+
+// isHeadingLine reports whether line is a Markdown ATX heading: up to three
+// spaces of indent, one to six '#' characters, then a space or line end.
+func isHeadingLine(line string) bool {
+	body := strings.TrimLeft(line, " ")
+	if len(line)-len(body) > markdownMaxIndent {
+		return false
+	}
+
+	level := 0
+	for level < len(body) && body[level] == markdownHeadingMark {
+		level++
+	}
+
+	if level == 0 || level > markdownMaxHeading {
+		return false
+	}
+
+	rest := strings.TrimRight(body[level:], "\r\n")
+
+	return rest == "" || rest[0] == ' ' || rest[0] == '\t'
+}
+
+// writeBreakdownTable renders breakdown entries as an aligned table.
+func writeBreakdownTable(w io.Writer, unit string, entries []BreakdownEntry) error {
+	tw := tabwriter.NewWriter(
+		w,
+		ReportTableMinWidth,
+		ReportTableTabWidth,
+		ReportTablePadding,
+		ReportTablePadChar,
+		0,
+	)
+
+	_, err := fmt.Fprint(tw, ReportTableHeader)
+	if err != nil {
+		return fmt.Errorf(ErrWrapReportTable, err)
+	}
+
+	total := 0
+
+	for _, e := range entries {
+		total += e.TokenCount
+
+		_, err = fmt.Fprintf(tw, ReportTableRowFmt,
+			e.Index, lineRange(e), e.TokenCount, e.Cumulative, e.Percent, e.Label)
+		if err != nil {
+			return fmt.Errorf(ErrWrapReportTable, err)
+		}
+	}
+
+	err = tw.Flush()
+	if err != nil {
+		return fmt.Errorf(ErrWrapReportTable, err)
+	}
+
+	_, err = fmt.Fprintf(w, ReportTotalFmt, total, len(entries), unit)
+	if err != nil {
+		return fmt.Errorf(ErrWrapReportTable, err)
+	}
+
+	return nil
+}
+
+func lineRange(e BreakdownEntry) string {
+	if e.StartLine == e.EndLine {
+		return strconv.Itoa(e.StartLine)
+	}
+
+	return fmt.Sprintf(ReportLineRangeFmt, e.StartLine, e.EndLine)
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	tokenizer "github.com/nnikolov3/ai-tokenizer"
+)
+
+const (
+	reportMarkdown = "# Title\nIntro text.\n\n## Usage\nRun it.\n\n" +
+		"```\n# not a heading\n```\n## Notes\nDone\n"
+
+	fmtBreakdownErr   = "buildBreakdown(%q) unexpected error: %v"
+	fmtBreakdownSum   = "buildBreakdown(%q) sum = %d, want EstimateTokens = %d"
+	fmtBreakdownWrap  = "buildBreakdown(%q) sum = %d, want %d: whole text %d less %d special tokens"
+	fmtBreakdownLen   = "buildBreakdown(%q) returned %d entries, want %d"
+	fmtBreakdownLabel = "entry %d label = %q, want %q"
+	fmtBreakdownSpan  = "entry %d lines = %d-%d, want %d-%d"
+	fmtBreakdownOrder = "entry %d has %d tokens after %d; want descending order"
+	fmtBreakdownCumul = "entry %d cumulative = %d, want %d"
+	fmtBreakdownIsErr = "buildBreakdown(%q, %q) error = %v, want %v"
+	fmtTableMissing   = "report table missing %q:\n%s"
+)
+
+type breakdownTestCase struct {
+	name   string
+	unit   string
+	labels []string
+	spans  [][2]int
+}
+
+func TestBuildBreakdownUnits(t *testing.T) {
+	t.Parallel()
+
+	tests := []breakdownTestCase{
+		{
+			name:   "sections skip fenced headings",
+			unit:   ReportUnitSection,
+			labels: []string{"# Title", "## Usage", "## Notes"},
+			spans:  [][2]int{{1, 3}, {4, 9}, {10, 11}},
+		},
+		{
+			name:   "paragraphs keep trailing blank lines",
+			unit:   ReportUnitParagraph,
+			labels: []string{"# Title", "## Usage", "```"},
+			spans:  [][2]int{{1, 3}, {4, 6}, {7, 11}},
+		},
+	}
+
+	for _, testCase := range tests {
+		t.Run(testCase.name, func(t *testing.T) {
+			t.Parallel()
+			runBreakdownUnitTest(t, testCase)
+		})
+	}
+}
+
+func runBreakdownUnitTest(t *testing.T, testCase breakdownTestCase) {
+	t.Helper()
+
+	entries, err := buildBreakdown(tokenizer.NewTokenizer(), reportMarkdown, testCase.unit, SortOrderDocument)
+	if err != nil {
+		t.Fatalf(fmtBreakdownErr, testCase.unit, err)
+	}
+
+	if len(entries) != len(testCase.labels) {
+		t.Fatalf(fmtBreakdownLen, testCase.unit, len(entries), len(testCase.labels))
+	}
+
+	for i, e := range entries {
+		if e.Label != testCase.labels[i] {
+			t.Errorf(fmtBreakdownLabel, i, e.Label, testCase.labels[i])
+		}
+
+		span := testCase.spans[i]
+		if e.StartLine != span[0] || e.EndLine != span[1] {
+			t.Errorf(fmtBreakdownSpan, i, e.StartLine, e.EndLine, span[0], span[1])
+		}
+	}
+}
+
+func TestBuildBreakdownSumsToTotal(t *testing.T) {
+	t.Parallel()
+
+	want := tokenizer.NewTokenizer().EstimateTokens(reportMarkdown)
+
+	for _, unit := range []string{
+		ReportUnitLine, ReportUnitParagraph, ReportUnitSection,
+	} {
+		entries, err := buildBreakdown(tokenizer.NewTokenizer(), reportMarkdown, unit, SortOrderDocument)
+		if err != nil {
+			t.Fatalf(fmtBreakdownErr, unit, err)
+		}
+
+		last := entries[len(entries)-1].Cumulative
+		if last != want {
+			t.Errorf(fmtBreakdownSum, unit, last, want)
+		}
+	}
+}
+
+func TestBuildBreakdownWordPieceSumsToTotal(t *testing.T) {
+	t.Parallel()
+
+	tok, err := newFileTokenizer("../../testdata/vocab.txt", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Each line alone would be wrapped in [CLS] and [SEP]; the whole text is
+	// wrapped once.
+	text := "hello world\nhello!\ncafe, world\n"
+	whole, specials := tok.EstimateTokens(text), tok.SpecialTokenCount()
+
+	for _, unit := range []string{ReportUnitLine, ReportUnitParagraph} {
+		entries, err := buildBreakdown(tok, text, unit, SortOrderDocument)
+		if err != nil {
+			t.Fatalf(fmtBreakdownErr, unit, err)
+		}
+
+		sum := entries[len(entries)-1].Cumulative
+		if sum != tok.EstimateContentTokens(text) || sum != whole-specials || specials != 2 {
+			t.Errorf(fmtBreakdownWrap, unit, sum, whole-specials, whole, specials)
+		}
+	}
+}
+
+func TestBuildBreakdownSortedByTokens(t *testing.T) {
+	t.Parallel()
+
+	entries, err := buildBreakdown(tokenizer.NewTokenizer(), reportMarkdown, ReportUnitLine, SortOrderTokens)
+	if err != nil {
+		t.Fatalf(fmtBreakdownErr, ReportUnitLine, err)
+	}
+
+	running := 0
+
+	for i, e := range entries {
+		running += e.TokenCount
+		if e.Cumulative != running {
+			t.Errorf(fmtBreakdownCumul, i, e.Cumulative, running)
+		}
+
+		if i > 0 && e.TokenCount > entries[i-1].TokenCount {
+			t.Errorf(fmtBreakdownOrder, i, e.TokenCount, entries[i-1].TokenCount)
+		}
+	}
+}
+
+func TestBuildBreakdownRejectsUnknownOptions(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		unit  string
+		order string
+		want  error
+	}{
+		{unit: "chapter", order: SortOrderDocument, want: ErrUnknownReportUnit},
+		{unit: ReportUnitLine, order: "random", want: ErrUnknownSortOrder},
+	}
+
+	for _, tc := range tests {
+		_, err := buildBreakdown(tokenizer.NewTokenizer(), reportMarkdown, tc.unit, tc.order)
+		if !errors.Is(err, tc.want) {
+			t.Errorf(fmtBreakdownIsErr, tc.unit, tc.order, err, tc.want)
+		}
+	}
+}
+
+func TestWriteBreakdownTable(t *testing.T) {
+	t.Parallel()
+
+	entries, err := buildBreakdown(tokenizer.NewTokenizer(), reportMarkdown, ReportUnitSection, SortOrderDocument)
+	if err != nil {
+		t.Fatalf(fmtBreakdownErr, ReportUnitSection, err)
+	}
+
+	var buf bytes.Buffer
+
+	err = writeBreakdownTable(&buf, ReportUnitSection, entries)
+	if err != nil {
+		t.Fatalf(fmtBreakdownErr, ReportUnitSection, err)
+	}
+
+	out := buf.String()
+	for _, want := range []string{"TOKENS", "CUMULATIVE", "## Usage", "4-9", "3 section(s)"} {
+		if !strings.Contains(out, want) {
+			t.Errorf(fmtTableMissing, want, out)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+const (
+	// socketDirPerm keeps the socket directory private to its owner.
+	socketDirPerm = 0o700
+	// othersWrite are the permission bits that let other users create or
+	// remove files in a directory.
+	othersWrite = 0o022
+	// rootUID owns system directories such as /run and /tmp.
+	rootUID = 0
+)
+
+// prepareSocketDir creates the directory of a socket path, private to the
+// user, when it is missing, and checks it with checkSocketDir.
+func prepareSocketDir(path string) error {
+	dir := filepath.Dir(path)
+
+	err := os.Mkdir(dir, socketDirPerm)
+	if err != nil && !errors.Is(err, fs.ErrExist) {
+		return ioError(fmt.Errorf(ErrWrapListen, path, err))
+	}
+
+	err = checkSocketDir(dir)
+	if err != nil {
+		return inputError(fmt.Errorf(ErrWrapListen, path, err))
+	}
+
+	return nil
+}
+
+// checkSocketDir accepts a directory in which no other user can plant or
+// replace a socket: owned by the user or root, and writable by others only
+// with the sticky bit set, as /tmp is.
+func checkSocketDir(dir string) error {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return err
+	}
+
+	owner, ok := fileOwner(info)
+	mode := info.Mode()
+
+	if !ok || !info.IsDir() || (owner != os.Getuid() && owner != rootUID) ||
+		(mode.Perm()&othersWrite != 0 && mode&fs.ModeSticky == 0) {
+		return fmt.Errorf(ErrSocketDirFmt, ErrUnsafeSocket, dir)
+	}
+
+	return nil
+}
+
+// checkSocket accepts a socket the client may send text to: a socket owned
+// by the user, in a directory checkSocketDir accepts.
+func checkSocket(path string) error {
+	err := checkSocketDir(filepath.Dir(path))
+	if err != nil {
+		return err
+	}
+
+	info, err := os.Lstat(path)
+	if err != nil {
+		return err
+	}
+
+	if info.Mode().Type() != fs.ModeSocket {
+		return fmt.Errorf(ErrNotSocketFmt, path)
+	}
+
+	return checkOwner(path, info)
+}
+
+// checkOwner accepts a file owned by the user.
+func checkOwner(path string, info fs.FileInfo) error {
+	owner, ok := fileOwner(info)
+	if !ok || owner != os.Getuid() {
+		return fmt.Errorf(ErrSocketOwnerFmt, ErrUnsafeSocket, path, os.Getuid())
+	}
+
+	return nil
+}
@@ -0,0 +1,70 @@
+package main
+
+import "testing"
+
+const chatPrompt = "<|im_start|>user\nHi<|im_end|>"
+
+func TestRunMainSpecialTokens(t *testing.T) {
+	t.Parallel()
+
+	tests := []runMainTestCase{
+		{
+			name:       "markers count once",
+			args:       []string{chatPrompt},
+			wantStdout: "Token Count: 6\n",
+			wantCode:   ExitOK,
+		},
+		{
+			name:       "markers reported",
+			args:       []string{chatPrompt},
+			wantStdout: "Special Tokens: 2 map[<|im_end|>:1 <|im_start|>:1]",
+			wantCode:   ExitOK,
+		},
+		{
+			name:       "escaped markers count as text",
+			args:       []string{"-special-tokens", "escape", chatPrompt},
+			wantStdout: "Token Count: 21\n",
+			wantCode:   ExitOK,
+		},
+		{
+			name:       "rejected in untrusted input",
+			args:       []string{"-special-tokens", "error", chatPrompt},
+			wantStderr: "special token in input: \"<|im_start|>\" at byte 0",
+			wantCode:   ExitInputError,
+		},
+		{
+			name:       "extra special token",
+			args:       []string{"-special-token", "[INST]", "-json", "[INST]"},
+			wantStdout: `"specialTokenCount": 1`,
+			wantCode:   ExitOK,
+		},
+		{
+			name:       "extra special token with a model file",
+			args:       []string{"-" + FlagNameModelFile, testModelFile, "-special-token", "[INST]", "-json", "[INST] hello"},
+			wantStdout: `"tokenCount": 2`,
+			wantCode:   ExitOK,
+		},
+		{
+			name: "escaped extra special token with a model file",
+			args: []string{
+				"-" + FlagNameModelFile, testModelFile, "-special-token", "[INST]",
+				"-special-tokens", "escape", "-json", "[INST] hello",
+			},
+			wantStdout: `"tokenCount": 3`,
+			wantCode:   ExitOK,
+		},
+		{
+			name:       "unknown policy",
+			args:       []string{"-special-tokens", "keep", hello},
+			wantStderr: "unknown special-token policy",
+			wantCode:   ExitInputError,
+		},
+	}
+
+	for _, testCase := range tests {
+		t.Run(testCase.name, func(t *testing.T) {
+			t.Parallel()
+			runMainTest(t, testCase)
+		})
+	}
+}
@@ -0,0 +1,467 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+const (
+	DiffNullPath     = "/dev/null"
+	DiffGitPrefix    = "diff --git "
+	DiffCCPrefix     = "diff --cc "
+	DiffCombPrefix   = "diff --combined "
+	DiffCombinedHunk = "@@@"
+	DiffOldPrefix    = "--- "
+	DiffNewPrefix    = "+++ "
+	DiffRenameFrom   = "rename from "
+	DiffRenameTo     = "rename to "
+	DiffNewFile      = "new file mode "
+	DiffDeletedFile  = "deleted file mode "
+	DiffBinaryPrefix = "Binary files "
+	DiffBinaryPatch  = "GIT binary patch"
+
+	// Line kinds inside a hunk.
+	DiffContext   = ' '
+	DiffAdded     = '+'
+	DiffRemoved   = '-'
+	DiffNoNewline = '\\'
+
+	ErrBadDiffMsg     = "malformed unified diff"
+	ErrDiffLineFmt    = "%w: line %d: %s"
+	ErrDiffHunkNoFile = "hunk before any file header"
+	ErrDiffHunkHeader = "bad hunk header"
+	ErrDiffHunkShort  = "hunk ends before its line counts"
+	ErrWrapReadDiff   = "read diff: %w"
+)
+
+// ErrBadDiff is returned for a diff that is not a valid unified diff.
+var ErrBadDiff = errors.New(ErrBadDiffMsg)
+
+// hunkHeader matches "@@ -start[,count] +start[,count] @@ section".
+var hunkHeader = regexp.MustCompile(`^@@ -(\d+)(?:,(\d+))? \+(\d+)(?:,(\d+))? @@ ?(.*)$`)
+
+// combinedHeader matches the "@@@ -start[,count] -start[,count] +start[,count] @@@"
+// header of a combined diff of a merge, with one old range per parent.
+var combinedHeader = regexp.MustCompile(`^(@@@+) ((?:-\d+(?:,\d+)? )+)\+\d+(?:,(\d+))? @@@+`)
+
+// diffFile is one file of a unified diff. The a/ and b/ prefixes of git
+// diffs are dropped, and the path of a side that is /dev/null is empty.
+type diffFile struct {
+	oldPath string
+	newPath string
+	binary  bool
+	hunks   []diffHunk
+}
+
+// diffHunk is one @@ block. section is the text git prints after the
+// second @@, usually the enclosing function or heading.
+type diffHunk struct {
+	oldStart int
+	oldLines int
+	newStart int
+	newLines int
+	section  string
+	lines    []diffLine
+}
+
+// diffLine is one line of a hunk without its prefix. text keeps the line
+// ending, unless the diff marks the line as having none.
+type diffLine struct {
+	kind byte
+	text string
+}
+
+// path names the file by its new path, or its old one when deleted.
+func (f *diffFile) path() string {
+	if f.newPath == "" {
+		return f.oldPath
+	}
+
+	return f.newPath
+}
+
+// side joins the lines of h one side of the change consists of: context
+// and removed lines for the old side, context and added lines for the new.
+func (h *diffHunk) side(kind byte) string {
+	var b strings.Builder
+
+	for _, line := range h.lines {
+		if line.kind == DiffContext || line.kind == kind {
+			b.WriteString(line.text)
+		}
+	}
+
+	return b.String()
+}
+
+// text joins the lines of h of one kind.
+func (h *diffHunk) text(kind byte) string {
+	var b strings.Builder
+
+	for _, line := range h.lines {
+		if line.kind == kind {
+			b.WriteString(line.text)
+		}
+	}
+
+	return b.String()
+}
+
+// diffParser keeps the state of parseUnifiedDiff between lines.
+type diffParser struct {
+	files   []diffFile
+	file    *diffFile
+	git     bool
+	oldLeft int
+	newLeft int
+	lineNo  int
+	sawOld  bool
+	// combined holds the lines left of a combined hunk for each parent,
+	// then for the merge result.
+	combined []int
+}
+
+// parseUnifiedDiff reads the files and hunks of a unified diff, as printed
+// by git diff, git show, diff -u or most review tools. Lines outside of
+// file headers and hunks, such as commit messages, are skipped.
+func parseUnifiedDiff(r io.Reader) ([]diffFile, error) {
+	reader := bufio.NewReader(r)
+	p := &diffParser{files: nil, file: nil, git: false, oldLeft: 0, newLeft: 0, lineNo: 0, sawOld: false, combined: nil}
+
+	for {
+		line, err := reader.ReadString('\n')
+		if line != "" {
+			p.lineNo++
+
+			parseErr := p.parseLine(line)
+			if parseErr != nil {
+				return nil, inputError(parseErr)
+			}
+		}
+
+		if errors.Is(err, io.EOF) {
+			break
+		}
+
+		if err != nil {
+			return nil, ioError(fmt.Errorf(ErrWrapReadDiff, err))
+		}
+	}
+
+	if p.oldLeft > 0 || p.newLeft > 0 || p.combined != nil {
+		return nil, inputError(fmt.Errorf(ErrDiffLineFmt, ErrBadDiff, p.lineNo, ErrDiffHunkShort))
+	}
+
+	p.flush()
+
+	return p.files, nil
+}
+
+func (p *diffParser) parseLine(line string) error {
+	if p.oldLeft > 0 || p.newLeft > 0 {
+		return p.parseHunkLine(line)
+	}
+
+	if p.combined != nil {
+		return p.skipCombinedLine(line)
+	}
+
+	trimmed := strings.TrimRight(line, "\r\n")
+
+	switch {
+	case strings.HasPrefix(trimmed, DiffGitPrefix):
+		p.start(true)
+		p.file.oldPath, p.file.newPath = splitGitHeader(strings.TrimPrefix(trimmed, DiffGitPrefix))
+	case strings.HasPrefix(trimmed, DiffCCPrefix) || strings.HasPrefix(trimmed, DiffCombPrefix):
+		// A combined diff names the merged file once, without a/ or b/.
+		p.start(true)
+		path := unquotePath(strings.TrimPrefix(strings.TrimPrefix(trimmed, DiffCCPrefix), DiffCombPrefix))
+		p.file.oldPath, p.file.newPath = path, path
+	case strings.HasPrefix(trimmed, DiffCombinedHunk):
+		return p.startCombinedHunk(trimmed)
+	case strings.HasPrefix(trimmed, DiffOldPrefix):
+		// diff -u has no diff --git line, so --- starts a file unless it
+		// follows a diff --git line of a file without hunks yet.
+		if p.file == nil || len(p.file.hunks) > 0 || p.sawOld {
+			p.start(false)
+		}
+
+		p.file.oldPath = p.headerPath(strings.TrimPrefix(trimmed, DiffOldPrefix))
+		p.sawOld = true
+	case strings.HasPrefix(trimmed, DiffNewPrefix) && p.file != nil:
+		p.file.newPath = p.headerPath(strings.TrimPrefix(trimmed, DiffNewPrefix))
+	case strings.HasPrefix(trimmed, "@@"):
+		return p.startHunk(trimmed)
+	case strings.HasPrefix(trimmed, DiffRenameFrom) && p.file != nil:
+		p.file.oldPath = unquotePath(strings.TrimPrefix(trimmed, DiffRenameFrom))
+	case strings.HasPrefix(trimmed, DiffRenameTo) && p.file != nil:
+		p.file.newPath = unquotePath(strings.TrimPrefix(trimmed, DiffRenameTo))
+	case strings.HasPrefix(trimmed, DiffNewFile) && p.file != nil:
+		p.file.oldPath = ""
+	case strings.HasPrefix(trimmed, DiffDeletedFile) && p.file != nil:
+		p.file.newPath = ""
+	case (strings.HasPrefix(trimmed, DiffBinaryPrefix) || trimmed == DiffBinaryPatch) && p.file != nil:
+		p.file.binary = true
+	case len(trimmed) > 0 && trimmed[0] == DiffNoNewline:
+		p.trimLastNewline()
+	}
+
+	return nil
+}
+
+// parseHunkLine adds a line to the current hunk. An empty line counts as
+// blank context, since some editors strip the trailing space of one.
+func (p *diffParser) parseHunkLine(line string) error {
+	kind, text := byte(DiffContext), line
+	if line != "\n" && line != "\r\n" {
+		kind, text = line[0], line[1:]
+	}
+
+	switch kind {
+	case DiffContext:
+		p.oldLeft--
+		p.newLeft--
+	case DiffRemoved:
+		p.oldLeft--
+	case DiffAdded:
+		p.newLeft--
+	case DiffNoNewline:
+		p.trimLastNewline()
+
+		return nil
+	default:
+		return fmt.Errorf(ErrDiffLineFmt, ErrBadDiff, p.lineNo, ErrDiffHunkShort)
+	}
+
+	if p.oldLeft < 0 || p.newLeft < 0 {
+		return fmt.Errorf(ErrDiffLineFmt, ErrBadDiff, p.lineNo, ErrDiffHunkShort)
+	}
+
+	hunk := &p.file.hunks[len(p.file.hunks)-1]
+	hunk.lines = append(hunk.lines, diffLine{kind: kind, text: text})
+
+	return nil
+}
+
+func (p *diffParser) startHunk(header string) error {
+	m := hunkHeader.FindStringSubmatch(header)
+	if m == nil {
+		return fmt.Errorf(ErrDiffLineFmt, ErrBadDiff, p.lineNo, ErrDiffHunkHeader)
+	}
+
+	if p.file == nil {
+		return fmt.Errorf(ErrDiffLineFmt, ErrBadDiff, p.lineNo, ErrDiffHunkNoFile)
+	}
+
+	hunk := diffHunk{
+		oldStart: atoiOr(m[1], 0),
+		oldLines: atoiOr(m[2], 1),
+		newStart: atoiOr(m[3], 0),
+		newLines: atoiOr(m[4], 1),
+		section:  m[5],
+		lines:    nil,
+	}
+
+	p.file.hunks = append(p.file.hunks, hunk)
+	p.oldLeft, p.newLeft = hunk.oldLines, hunk.newLines
+	p.sawOld = false
+
+	return nil
+}
+
+// startCombinedHunk skips a hunk of a combined diff, which git prints for
+// merge commits. Its lines have no single old side to count, so the file
+// is kept without the hunk.
+func (p *diffParser) startCombinedHunk(header string) error {
+	m := combinedHeader.FindStringSubmatch(header)
+	if m == nil || len(m[1]) != len(strings.Fields(m[2]))+1 {
+		return fmt.Errorf(ErrDiffLineFmt, ErrBadDiff, p.lineNo, ErrDiffHunkHeader)
+	}
+
+	if p.file == nil {
+		return fmt.Errorf(ErrDiffLineFmt, ErrBadDiff, p.lineNo, ErrDiffHunkNoFile)
+	}
+
+	left := make([]int, 0, len(m[1]))
+	for _, r := range strings.Fields(m[2]) {
+		_, count, _ := strings.Cut(r, ",")
+		left = append(left, atoiOr(count, 1))
+	}
+
+	p.combined = append(left, atoiOr(m[3], 1))
+	p.sawOld = false
+	p.endCombinedHunk()
+
+	return nil
+}
+
+// skipCombinedLine consumes a line of a combined hunk, which starts with a
+// column per parent. A line with a - is only in the parents marked -; any
+// other line is in the merge result and in each parent not marked +.
+func (p *diffParser) skipCombinedLine(line string) error {
+	parents := len(p.combined) - 1
+
+	prefix := strings.TrimRight(line, "\r\n")
+	switch {
+	case prefix == "":
+		prefix = strings.Repeat(string(DiffContext), parents)
+	case prefix[0] == DiffNoNewline:
+		return nil
+	case len(prefix) < parents || strings.Trim(prefix[:parents], " +-") != "":
+		return fmt.Errorf(ErrDiffLineFmt, ErrBadDiff, p.lineNo, ErrDiffHunkShort)
+	}
+
+	columns := prefix[:parents]
+	removed := strings.IndexByte(columns, DiffRemoved) >= 0
+
+	for i := range parents {
+		if (removed && columns[i] == DiffRemoved) || (!removed && columns[i] == DiffContext) {
+			p.combined[i]--
+		}
+	}
+
+	if !removed {
+		p.combined[parents]--
+	}
+
+	if slices.Min(p.combined) < 0 {
+		return fmt.Errorf(ErrDiffLineFmt, ErrBadDiff, p.lineNo, ErrDiffHunkShort)
+	}
+
+	p.endCombinedHunk()
+
+	return nil
+}
+
+// endCombinedHunk leaves a combined hunk once all its lines are read.
+func (p *diffParser) endCombinedHunk() {
+	if slices.Max(p.combined) == 0 {
+		p.combined = nil
+	}
+}
+
+// start begins a new file, keeping the previous one.
+func (p *diffParser) start(git bool) {
+	p.flush()
+
+	p.file = &diffFile{oldPath: "", newPath: "", binary: false, hunks: nil}
+	p.git = git
+	p.sawOld = false
+}
+
+func (p *diffParser) flush() {
+	if p.file != nil {
+		p.files = append(p.files, *p.file)
+	}
+}
+
+// trimLastNewline applies a "\ No newline at end of file" marker to the
+// line before it.
+func (p *diffParser) trimLastNewline() {
+	if p.file == nil || len(p.file.hunks) == 0 {
+		return
+	}
+
+	hunk := &p.file.hunks[len(p.file.hunks)-1]
+	if len(hunk.lines) == 0 {
+		return
+	}
+
+	last := &hunk.lines[len(hunk.lines)-1]
+	last.text = strings.TrimSuffix(strings.TrimSuffix(last.text, "\n"), "\r")
+}
+
+// headerPath reads the path of a ---/+++ line, which diff -u follows with
+// a tab and a timestamp and git prefixes with a/ or b/.
+func (p *diffParser) headerPath(value string) string {
+	if i := strings.IndexByte(value, '\t'); i >= 0 {
+		value = value[:i]
+	}
+
+	value = unquotePath(value)
+	if value == DiffNullPath {
+		return ""
+	}
+
+	if p.git {
+		value = stripDiffPrefix(value)
+	}
+
+	return value
+}
+
+// splitGitHeader reads "a/old b/new" from a diff --git line. Paths with
+// spaces are ambiguous there, so the ---/+++ or rename lines that follow,
+// when present, replace these.
+func splitGitHeader(value string) (string, string) {
+	if strings.HasPrefix(value, `"`) {
+		oldPath, rest, ok := cutQuoted(value)
+		if ok {
+			return stripDiffPrefix(oldPath), stripDiffPrefix(unquotePath(strings.TrimSpace(rest)))
+		}
+	}
+
+	if i := strings.Index(value, " b/"); i >= 0 {
+		return stripDiffPrefix(value[:i]), value[i+len(" b/"):]
+	}
+
+	return value, value
+}
+
+// cutQuoted splits a leading C-quoted path from the rest of value.
+func cutQuoted(value string) (string, string, bool) {
+	prefix, err := strconv.QuotedPrefix(value)
+	if err != nil {
+		return "", "", false
+	}
+
+	path, err := strconv.Unquote(prefix)
+	if err != nil {
+		return "", "", false
+	}
+
+	return path, value[len(prefix):], true
+}
+
+// unquotePath decodes the C-style quoting git uses for unusual paths.
+func unquotePath(value string) string {
+	if !strings.HasPrefix(value, `"`) {
+		return value
+	}
+
+	path, _, ok := cutQuoted(value)
+	if !ok {
+		return value
+	}
+
+	return path
+}
+
+func stripDiffPrefix(path string) string {
+	for _, prefix := range []string{"a/", "b/"} {
+		if rest, ok := strings.CutPrefix(path, prefix); ok {
+			return rest
+		}
+	}
+
+	return path
+}
+
+func atoiOr(value string, fallback int) int {
+	if value == "" {
+		return fallback
+	}
+
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return fallback
+	}
+
+	return n
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+const (
+	// testGitDiff changes, adds, deletes, renames and a binary file. The
+	// removed line "-- dashes" starts with "--- " once prefixed.
+	testGitDiff = "commit 1234\n" +
+		"\n" +
+		"    Update prompts\n" +
+		"\n" +
+		"diff --git a/prompt.md b/prompt.md\n" +
+		"index 1111111..2222222 100644\n" +
+		"--- a/prompt.md\n" +
+		"+++ b/prompt.md\n" +
+		"@@ -1,3 +1,3 @@ # Intro\n" +
+		" hello\n" +
+		"--- dashes\n" +
+		"+world\n" +
+		" end\n" +
+		"@@ -10 +10,2 @@\n" +
+		"-last\n" +
+		"\\ No newline at end of file\n" +
+		"+last\n" +
+		"+more\n" +
+		"diff --git a/new.md b/new.md\n" +
+		"new file mode 100644\n" +
+		"--- /dev/null\n" +
+		"+++ b/new.md\n" +
+		"@@ -0,0 +1 @@\n" +
+		"+fresh\n" +
+		"diff --git a/old.md b/old.md\n" +
+		"deleted file mode 100644\n" +
+		"--- a/old.md\n" +
+		"+++ /dev/null\n" +
+		"@@ -1 +0,0 @@\n" +
+		"-gone\n" +
+		"diff --git a/a.md b/b.md\n" +
+		"similarity index 100%\n" +
+		"rename from a.md\n" +
+		"rename to b.md\n" +
+		"diff --git a/logo.png b/logo.png\n" +
+		"Binary files a/logo.png and b/logo.png differ\n"
+
+	testPlainDiff = "--- notes.txt\t2026-01-01 10:00:00\n" +
+		"+++ notes.txt\t2026-01-02 10:00:00\n" +
+		"@@ -1,2 +1,2 @@\n" +
+		" keep\n" +
+		"-old\n" +
+		"+new\n" +
+		"--- a/other.txt\n" +
+		"+++ a/other.txt\n" +
+		"@@ -1 +1 @@\n" +
+		"-x\n" +
+		"+y\n"
+
+	testMergeDiff = "diff --cc prompt.md\n" +
+		"index 1111111,2222222..3333333\n" +
+		"--- a/prompt.md\n" +
+		"+++ b/prompt.md\n" +
+		"@@@ -1,3 -1,3 +1,3 @@@\n" +
+		"  hello\n" +
+		"- ours\n" +
+		" -theirs\n" +
+		"++merged\n" +
+		"\n" +
+		"\\ No newline at end of file\n" +
+		"diff --git a/next.md b/next.md\n" +
+		"--- a/next.md\n" +
+		"+++ b/next.md\n" +
+		"@@ -1 +1 @@\n" +
+		"-a\n" +
+		"+b\n"
+
+	fmtDiffFiles = "parseUnifiedDiff files = %+v, want %v"
+	fmtDiffSide  = "file %d hunk %d side %c = %q, want %q"
+	fmtDiffErr   = "parseUnifiedDiff(%q) error = %v, want %v"
+)
+
+func TestParseUnifiedDiffGit(t *testing.T) {
+	t.Parallel()
+
+	files, err := parseUnifiedDiff(strings.NewReader(testGitDiff))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []struct {
+		oldPath string
+		newPath string
+		binary  bool
+		hunks   int
+		status  string
+	}{
+		{oldPath: "prompt.md", newPath: "prompt.md", binary: false, hunks: 2, status: FileStatusModified},
+		{oldPath: "", newPath: "new.md", binary: false, hunks: 1, status: FileStatusAdded},
+		{oldPath: "old.md", newPath: "", binary: false, hunks: 1, status: FileStatusDeleted},
+		{oldPath: "a.md", newPath: "b.md", binary: false, hunks: 0, status: FileStatusRenamed},
+		{oldPath: "logo.png", newPath: "logo.png", binary: true, hunks: 0, status: FileStatusModified},
+	}
+
+	if len(files) != len(want) {
+		t.Fatalf(fmtDiffFiles, files, want)
+	}
+
+	for i, w := range want {
+		f := files[i]
+		if f.oldPath != w.oldPath || f.newPath != w.newPath || f.binary != w.binary || len(f.hunks) != w.hunks || diffFileStatus(&f) != w.status {
+			t.Errorf(fmtDiffFiles, f, w)
+		}
+	}
+
+	sides := []struct {
+		file int
+		hunk int
+		kind byte
+		want string
+	}{
+		{file: 0, hunk: 0, kind: DiffRemoved, want: "hello\n-- dashes\nend\n"},
+		{file: 0, hunk: 0, kind: DiffAdded, want: "hello\nworld\nend\n"},
+		{file: 0, hunk: 1, kind: DiffRemoved, want: "last"},
+		{file: 0, hunk: 1, kind: DiffAdded, want: "last\nmore\n"},
+		{file: 1, hunk: 0, kind: DiffRemoved, want: ""},
+		{file: 2, hunk: 0, kind: DiffRemoved, want: "gone\n"},
+	}
+
+	for _, s := range sides {
+		if got := files[s.file].hunks[s.hunk].side(s.kind); got != s.want {
+			t.Errorf(fmtDiffSide, s.file, s.hunk, s.kind, got, s.want)
+		}
+	}
+
+	if h := files[0].hunks[0]; h.section != "# Intro" || h.oldStart != 1 || h.newLines != 3 {
+		t.Errorf(fmtDiffFiles, h, "section # Intro from line 1")
+	}
+}
+
+func TestParseUnifiedDiffPlain(t *testing.T) {
+	t.Parallel()
+
+	files, err := parseUnifiedDiff(strings.NewReader(testPlainDiff))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Without diff --git, a/ is part of the path.
+	if len(files) != 2 || files[0].path() != "notes.txt" || files[1].path() != "a/other.txt" {
+		t.Fatalf(fmtDiffFiles, files, []string{"notes.txt", "a/other.txt"})
+	}
+}
+
+func TestParseUnifiedDiffCombined(t *testing.T) {
+	t.Parallel()
+
+	files, err := parseUnifiedDiff(strings.NewReader(testMergeDiff))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The combined hunk is skipped, and the file after it is read as usual.
+	if len(files) != 2 || files[0].path() != "prompt.md" || len(files[0].hunks) != 0 || diffFileStatus(&files[0]) != FileStatusModified ||
+		files[1].path() != "next.md" || len(files[1].hunks) != 1 {
+		t.Fatalf(fmtDiffFiles, files, []string{"prompt.md without hunks", "next.md"})
+	}
+}
+
+func TestParseUnifiedDiffErrors(t *testing.T) {
+	t.Parallel()
+
+	tests := []string{
+		"@@ -1 +1 @@\n-a\n+b\n",
+		"--- a\n+++ b\n@@ -1,2 +1,2 @@\n-a\n+b\n",
+		"--- a\n+++ b\n@@ -1,2 +1 @@\n-a\n+b\nnot a diff line\n",
+		"--- a\n+++ b\n@@ -x +1 @@\n",
+		"diff --cc a\n@@@ -1 -1 +1 @@@\n",
+		"diff --cc a\n@@@ -1 -1 +1 @@@\n x\n",
+		"diff --cc a\n@@@ -1 +1 @@@\n",
+	}
+
+	for _, input := range tests {
+		_, err := parseUnifiedDiff(strings.NewReader(input))
+		if !errors.Is(err, ErrBadDiff) || exitCode(err) != ExitInputError {
+			t.Errorf(fmtDiffErr, input, err, ErrBadDiff)
+		}
+	}
+}
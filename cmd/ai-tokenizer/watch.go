@@ -0,0 +1,385 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"io/fs"
+	"maps"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
+
+	tokenizer "github.com/nnikolov3/ai-tokenizer"
+)
+
+const (
+	FlagNameWatch         = "watch"
+	FlagNameWatchInterval = "watch-interval"
+
+	FlagHelpWatch         = "Watch the -file paths or arguments, files or directories, and reprint counts when they change"
+	FlagHelpWatchInterval = "How often -watch polls for changes"
+
+	// DefaultWatchInterval is often enough to feel live in an editor
+	// without keeping a large directory tree busy.
+	DefaultWatchInterval = time.Second
+
+	// Statuses of a WatchEvent.
+	WatchStatusNew     = "new"
+	WatchStatusChanged = "changed"
+	WatchStatusRemoved = "removed"
+
+	WatchTimeLayout   = "15:04:05"
+	WatchLineFmt      = "[%s] %s: %d tokens (%s)"
+	WatchRemovedFmt   = "[%s] %s: removed (was %d tokens)\n"
+	WatchTotalFmt     = "[%s] total: %d tokens (%+d) in %d file(s)%s\n"
+	WatchDeltaFmt     = "%+d"
+	WatchUnderFmt     = ", %d under budget\n"
+	WatchOverFmt      = ", OVER budget by %d\n"
+	WatchNoBudget     = "\n"
+	WatchTotalOverFmt = ", %d file(s) over budget"
+
+	ErrWatchNoPathsMsg = "-watch needs files or directories to watch"
+	ErrWatchFormatFmt  = "%w %q with -watch (want text, json or ndjson)"
+	ErrWatchCompareMsg = "-watch cannot compare models; drop -models and -baseline"
+	ErrWrapWatch       = "watch %s: %w"
+)
+
+var (
+	// ErrWatchNoPaths is returned for -watch without paths.
+	ErrWatchNoPaths = errors.New(ErrWatchNoPathsMsg)
+	// ErrWatchCompare is returned for -watch with -models or -baseline.
+	ErrWatchCompare = errors.New(ErrWatchCompareMsg)
+)
+
+// WatchEvent reports a watched file that appeared, changed or went away.
+// Delta is relative to the previous count, which is zero for a new file
+// and TokenCount is zero for a removed one.
+type WatchEvent struct {
+	Time       time.Time `json:"time"`
+	Source     string    `json:"source"`
+	Model      string    `json:"model"`
+	Status     string    `json:"status"`
+	TokenCount int       `json:"tokenCount"`
+	Previous   int       `json:"previous"`
+	Delta      int       `json:"delta"`
+	MaxTokens  int       `json:"maxTokens,omitempty"`
+	OverBudget bool      `json:"overBudget"`
+}
+
+// watchedFile is what a poll remembers of a file to tell whether it
+// changed: its stat data, a hash of its content and its count. counted is
+// false until the file has been counted once.
+type watchedFile struct {
+	modTime time.Time
+	size    int64
+	hash    uint64
+	tokens  int
+	counted bool
+}
+
+// watcher polls a set of files and directories. Polling needs no platform
+// file notification API, so it behaves the same everywhere, including
+// network and container mounts where notifications are unreliable.
+type watcher struct {
+	flags *cliFlags
+	tok   *tokenizer.Tokenizer
+	paths []string
+	files map[string]watchedFile
+	now   func() time.Time
+}
+
+// runWatch watches until SIGINT or SIGTERM, which end it with status 0.
+func runWatch(flags *cliFlags, tok *tokenizer.Tokenizer, streams cliStreams) error {
+	w, err := newWatcher(flags, tok)
+	if err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	return w.run(ctx, flags.watchInterval, streams)
+}
+
+// newWatcher checks that every path exists before the first poll, so a
+// typo fails at once instead of waiting for the file to appear.
+func newWatcher(flags *cliFlags, tok *tokenizer.Tokenizer) (*watcher, error) {
+	paths := slices.Concat([]string(flags.inputFiles), flags.args)
+	if len(paths) == 0 {
+		return nil, inputError(ErrWatchNoPaths)
+	}
+
+	if flags.models != "" || flags.baseline != "" {
+		return nil, inputError(ErrWatchCompare)
+	}
+
+	switch flags.format {
+	case FormatText, FormatJSON, FormatNDJSON:
+	default:
+		return nil, inputError(fmt.Errorf(ErrWatchFormatFmt, ErrUnknownFormat, flags.format))
+	}
+
+	for _, path := range paths {
+		_, err := os.Stat(path)
+		if err != nil {
+			return nil, fileError(err)
+		}
+	}
+
+	return &watcher{flags: flags, tok: tok, paths: paths, files: make(map[string]watchedFile), now: time.Now}, nil
+}
+
+// run prints the first counts, then polls every interval until ctx ends.
+func (w *watcher) run(ctx context.Context, interval time.Duration, streams cliStreams) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		err := w.report(w.poll(streams.stderr), streams.stdout)
+		if err != nil {
+			return ioError(err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// poll returns the events since the last poll, in path order. Files that
+// cannot be read or counted are reported on errOut and tried again once
+// they change.
+func (w *watcher) poll(errOut io.Writer) []WatchEvent {
+	var events []WatchEvent
+
+	seen := make(map[string]bool)
+
+	for _, path := range w.list(errOut) {
+		seen[path] = true
+
+		event, ok, err := w.check(path)
+		if err != nil {
+			_, _ = fmt.Fprintln(errOut, err)
+
+			continue
+		}
+
+		if ok {
+			events = append(events, event)
+		}
+	}
+
+	for _, path := range slices.Sorted(maps.Keys(w.files)) {
+		if seen[path] {
+			continue
+		}
+
+		if w.files[path].counted {
+			events = append(events, w.event(path, WatchStatusRemoved, 0, w.files[path].tokens))
+		}
+
+		delete(w.files, path)
+	}
+
+	return events
+}
+
+// list expands directories into the regular files below them, skipping
+// hidden files and directories such as .git.
+func (w *watcher) list(errOut io.Writer) []string {
+	var files []string
+
+	for _, root := range w.paths {
+		err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+			switch {
+			case err != nil && path == root && errors.Is(err, fs.ErrNotExist):
+				return nil
+			case err != nil:
+				return err
+			case path != root && strings.HasPrefix(d.Name(), "."):
+				if d.IsDir() {
+					return filepath.SkipDir
+				}
+
+				return nil
+			case d.Type().IsRegular():
+				files = append(files, path)
+			}
+
+			return nil
+		})
+		if err != nil {
+			_, _ = fmt.Fprintln(errOut, fmt.Errorf(ErrWrapWatch, root, err))
+		}
+	}
+
+	slices.Sort(files)
+
+	return slices.Compact(files)
+}
+
+// check counts path again if its stat data and then its content changed.
+func (w *watcher) check(path string) (WatchEvent, bool, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return WatchEvent{}, false, fmt.Errorf(ErrWrapWatch, path, err)
+	}
+
+	prev, seen := w.files[path]
+	if seen && info.ModTime().Equal(prev.modTime) && info.Size() == prev.size {
+		return WatchEvent{}, false, nil
+	}
+
+	// Remember the stat data first, so a file that fails is retried only
+	// after it changes again.
+	prev.modTime, prev.size = info.ModTime(), info.Size()
+	w.files[path] = prev
+
+	raw, err := readFile(path)
+	if err != nil {
+		return WatchEvent{}, false, err
+	}
+
+	hash := fnv.New64a()
+	_, _ = io.WriteString(hash, raw)
+
+	sum := hash.Sum64()
+	if prev.counted && sum == prev.hash {
+		return WatchEvent{}, false, nil
+	}
+
+	in, err := decodeSource(path, raw, w.flags.encoding)
+	if err != nil {
+		return WatchEvent{}, false, err
+	}
+
+	result, err := processInput(w.flags, w.tok, nil, nil, in)
+	if err != nil {
+		return WatchEvent{}, false, err
+	}
+
+	w.files[path] = watchedFile{modTime: info.ModTime(), size: info.Size(), hash: sum, tokens: result.TokenCount, counted: true}
+
+	if !prev.counted {
+		return w.event(path, WatchStatusNew, result.TokenCount, 0), true, nil
+	}
+
+	return w.event(path, WatchStatusChanged, result.TokenCount, prev.tokens), true, nil
+}
+
+func (w *watcher) event(path, status string, tokens, previous int) WatchEvent {
+	return WatchEvent{
+		Time:       w.now(),
+		Source:     path,
+		Model:      w.tok.GetModel(),
+		Status:     status,
+		TokenCount: tokens,
+		Previous:   previous,
+		Delta:      tokens - previous,
+		MaxTokens:  w.flags.maxTokens,
+		OverBudget: w.flags.maxTokens > 0 && tokens > w.flags.maxTokens,
+	}
+}
+
+// report prints the events of a poll, and a total line when more than one
+// file is watched. JSON formats print one event object per line.
+func (w *watcher) report(events []WatchEvent, out io.Writer) error {
+	if len(events) == 0 {
+		return nil
+	}
+
+	if w.flags.format != FormatText {
+		enc := json.NewEncoder(out)
+
+		for _, e := range events {
+			err := enc.Encode(e)
+			if err != nil {
+				return fmt.Errorf(ErrWrapWriteOutput, err)
+			}
+		}
+
+		return nil
+	}
+
+	var b strings.Builder
+
+	for _, e := range events {
+		writeWatchEvent(&b, e)
+	}
+
+	if len(w.files) > 1 {
+		w.writeTotal(&b, events)
+	}
+
+	_, err := io.WriteString(out, b.String())
+	if err != nil {
+		return fmt.Errorf(ErrWrapWriteOutput, err)
+	}
+
+	return nil
+}
+
+func writeWatchEvent(b *strings.Builder, e WatchEvent) {
+	stamp := e.Time.Format(WatchTimeLayout)
+
+	if e.Status == WatchStatusRemoved {
+		fmt.Fprintf(b, WatchRemovedFmt, stamp, e.Source, e.Previous)
+
+		return
+	}
+
+	delta := WatchStatusNew
+	if e.Status == WatchStatusChanged {
+		delta = fmt.Sprintf(WatchDeltaFmt, e.Delta)
+	}
+
+	fmt.Fprintf(b, WatchLineFmt, stamp, e.Source, e.TokenCount, delta)
+
+	switch {
+	case e.MaxTokens == 0:
+		b.WriteString(WatchNoBudget)
+	case e.OverBudget:
+		fmt.Fprintf(b, WatchOverFmt, e.TokenCount-e.MaxTokens)
+	default:
+		fmt.Fprintf(b, WatchUnderFmt, e.MaxTokens-e.TokenCount)
+	}
+}
+
+// writeTotal sums every watched file, with the change this poll made.
+func (w *watcher) writeTotal(b *strings.Builder, events []WatchEvent) {
+	total, delta, over, files := 0, 0, 0, 0
+
+	for _, f := range w.files {
+		if !f.counted {
+			continue
+		}
+
+		total += f.tokens
+		files++
+
+		if w.flags.maxTokens > 0 && f.tokens > w.flags.maxTokens {
+			over++
+		}
+	}
+
+	for _, e := range events {
+		delta += e.Delta
+	}
+
+	suffix := ""
+	if over > 0 {
+		suffix = fmt.Sprintf(WatchTotalOverFmt, over)
+	}
+
+	fmt.Fprintf(b, WatchTotalFmt, w.now().Format(WatchTimeLayout), total, delta, files, suffix)
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	tokenizer "github.com/nnikolov3/ai-tokenizer"
+)
+
+const (
+	fmtWatchEvents = "poll %d events = %+v, want %+v"
+	fmtWatchOutput = "report output = %q, want it to contain %q"
+	fmtWatchRun    = "run() = %v, want nil"
+)
+
+// watchClock is a fixed time for the report timestamps.
+var watchClock = time.Date(2026, 1, 2, 15, 4, 5, 0, time.UTC)
+
+// newTestWatcher watches paths with -max-tokens budget.
+func newTestWatcher(t *testing.T, budget int, paths ...string) *watcher {
+	t.Helper()
+
+	args := append([]string{"-" + FlagNameWatch, "-" + FlagNameMaxTokens, strconv.Itoa(budget)}, paths...)
+
+	flags, _, err := parseFlags(args, io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w, err := newWatcher(flags, tokenizer.NewTokenizer())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w.now = func() time.Time { return watchClock }
+
+	return w
+}
+
+// writeWatched writes a file and moves its modification time forward, so
+// a poll sees the change even within the file system's time granularity.
+func writeWatched(t *testing.T, path, content string, age time.Duration) {
+	t.Helper()
+
+	err := os.WriteFile(path, []byte(content), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stamp := time.Now().Add(age)
+
+	err = os.Chtimes(path, stamp, stamp)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// eventSummary keeps the fields of an event a test compares.
+type eventSummary struct {
+	source string
+	status string
+	tokens int
+	delta  int
+	over   bool
+}
+
+func summarize(events []WatchEvent) []eventSummary {
+	out := make([]eventSummary, 0, len(events))
+	for _, e := range events {
+		out = append(out, eventSummary{source: filepath.Base(e.Source), status: e.Status, tokens: e.TokenCount, delta: e.Delta, over: e.OverBudget})
+	}
+
+	return out
+}
+
+func TestWatcherPoll(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	a := filepath.Join(dir, "a.md")
+	b := filepath.Join(dir, "b.md")
+
+	writeWatched(t, a, boundInput, -time.Hour)
+	writeWatched(t, b, "hi", -time.Hour)
+	writeWatched(t, filepath.Join(dir, ".hidden"), "ignored", -time.Hour)
+
+	w := newTestWatcher(t, 8, dir)
+
+	// "hello world" is 7 tokens, "hi" 1 and "hello world again" 11.
+	steps := []struct {
+		change func()
+		want   []eventSummary
+	}{
+		{
+			change: func() {},
+			want: []eventSummary{
+				{source: "a.md", status: WatchStatusNew, tokens: 7, delta: 7, over: false},
+				{source: "b.md", status: WatchStatusNew, tokens: 1, delta: 1, over: false},
+			},
+		},
+		{
+			change: func() { writeWatched(t, a, boundInput+" again", -time.Minute) },
+			want:   []eventSummary{{source: "a.md", status: WatchStatusChanged, tokens: 11, delta: 4, over: true}},
+		},
+		{
+			// A touch without a content change is not reported.
+			change: func() { writeWatched(t, a, boundInput+" again", 0) },
+			want:   []eventSummary{},
+		},
+		{
+			change: func() {
+				err := os.Remove(b)
+				if err != nil {
+					t.Fatal(err)
+				}
+			},
+			want: []eventSummary{{source: "b.md", status: WatchStatusRemoved, tokens: 0, delta: -1, over: false}},
+		},
+	}
+
+	for i, step := range steps {
+		step.change()
+
+		got := summarize(w.poll(io.Discard))
+		if len(got) != len(step.want) {
+			t.Fatalf(fmtWatchEvents, i, got, step.want)
+		}
+
+		for j := range got {
+			if got[j] != step.want[j] {
+				t.Errorf(fmtWatchEvents, i, got, step.want)
+			}
+		}
+	}
+}
+
+func TestWatcherReport(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	a := filepath.Join(dir, "a.md")
+	b := filepath.Join(dir, "b.md")
+
+	writeWatched(t, a, boundInput, -time.Hour)
+	writeWatched(t, b, "hi", -time.Hour)
+
+	w := newTestWatcher(t, 8, a, b)
+
+	var out bytes.Buffer
+
+	err := w.report(w.poll(io.Discard), &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	writeWatched(t, a, boundInput+" again", 0)
+
+	err = w.report(w.poll(io.Discard), &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{
+		"[15:04:05] " + a + ": 7 tokens (new), 1 under budget\n",
+		"[15:04:05] total: 8 tokens (+8) in 2 file(s)\n",
+		a + ": 11 tokens (+4), OVER budget by 3\n",
+		"total: 12 tokens (+4) in 2 file(s), 1 file(s) over budget\n",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf(fmtWatchOutput, out.String(), want)
+		}
+	}
+}
+
+func TestWatcherRunStopsWithContext(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "a.md")
+	writeWatched(t, path, boundInput, -time.Hour)
+
+	w := newTestWatcher(t, 0, path)
+	w.flags.format = FormatNDJSON
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	var stdout bytes.Buffer
+
+	err := w.run(ctx, time.Millisecond, cliStreams{stdin: nil, stdout: &stdout, stderr: io.Discard})
+	if err != nil {
+		t.Errorf(fmtWatchRun, err)
+	}
+
+	// The first poll is printed before the canceled context is seen.
+	if want := `"status":"new","tokenCount":7`; !strings.Contains(stdout.String(), want) {
+		t.Errorf(fmtWatchOutput, stdout.String(), want)
+	}
+}
+
+func TestRunMainWatchErrors(t *testing.T) {
+	t.Parallel()
+
+	tests := []runMainTestCase{
+		{
+			name:       "no paths",
+			args:       []string{"-" + FlagNameWatch},
+			wantStderr: ErrWatchNoPathsMsg,
+			wantCode:   ExitInputError,
+		},
+		{
+			name:     "missing path",
+			args:     []string{"-" + FlagNameWatch, missingModelFile},
+			wantCode: ExitInputError,
+		},
+		{
+			name:       "tabular format",
+			args:       []string{"-" + FlagNameWatch, "-" + FlagNameFormat, FormatCSV, testModelFile},
+			wantStderr: "with -watch",
+			wantCode:   ExitInputError,
+		},
+		{
+			name:       "models",
+			args:       []string{"-" + FlagNameWatch, "-" + FlagNameModels, "simple," + testModelFile, testModelFile},
+			wantStderr: ErrWatchCompareMsg,
+			wantCode:   ExitInputError,
+		},
+		{
+			name:       "baseline",
+			args:       []string{"-" + FlagNameWatch, "-" + FlagNameBaseline, "simple", testModelFile},
+			wantStderr: ErrWatchCompareMsg,
+			wantCode:   ExitInputError,
+		},
+	}
+
+	for _, tt := range tests {
+		runMainTest(t, tt)
+	}
+}
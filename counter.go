@@ -0,0 +1,292 @@
+package tokenizer
+
+import (
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// Counter counts the tokens of text that arrives in pieces, such as a
+// streamed chat transcript. Count always equals EstimateTokens of
+// everything appended since the last Reset, but appending costs time
+// proportional to the new data only. A Counter is not safe for concurrent
+// use.
+//
+// The heuristic is incremental because NFD and the ASCII folding that
+// follows map each rune on its own: the combining marks that NFD reorders
+// across rune boundaries are always dropped. A Counter keeps only the state
+// that spans pieces: an unfinished letter and digit run, the bytes of an
+// incomplete UTF-8 sequence, whether the last byte was invalid, and text
+// that may be the start of a special token. A tokenizer with a model
+// encoder cannot count incrementally, so its Counter keeps the text and
+// encodes it again when Count is called after new data. Like EstimateTokens,
+// it counts the tokens a model adds around every input before any data.
+type Counter struct {
+	tok       *Tokenizer
+	tokens    int
+	run       int
+	pending   [utf8.UTFMax]byte
+	npending  int
+	inInvalid bool
+	held      string
+	text      []byte
+	counted   int
+	dirty     bool
+}
+
+// specialMatchState is the outcome of trying to match special tokens at
+// one position of streamed text.
+type specialMatchState int
+
+const (
+	specialNoMatch specialMatchState = iota
+	specialFullMatch
+	// specialPartialMatch means the text so far is a prefix of a special
+	// token that would take precedence, so more data is needed.
+	specialPartialMatch
+)
+
+// NewCounter returns an empty Counter that counts like t.
+func (t *Tokenizer) NewCounter() *Counter {
+	return &Counter{
+		tok:       t,
+		tokens:    0,
+		run:       0,
+		pending:   [utf8.UTFMax]byte{},
+		npending:  0,
+		inInvalid: false,
+		held:      "",
+		text:      nil,
+		counted:   0,
+		dirty:     t.encoder != nil,
+	}
+}
+
+// Write appends p. It never fails, so a Counter can be the target of
+// io.Copy or io.MultiWriter.
+func (c *Counter) Write(p []byte) (int, error) {
+	c.Append(string(p))
+
+	return len(p), nil
+}
+
+// Append appends s.
+func (c *Counter) Append(s string) {
+	if s == "" {
+		return
+	}
+
+	if c.tok.encoder != nil {
+		c.text = append(c.text, s...)
+		c.dirty = true
+
+		return
+	}
+
+	if !c.splitsSpecialTokens() {
+		c.feed(s)
+
+		return
+	}
+
+	c.scanSpecialTokens(c.held+s, false)
+}
+
+// Count returns EstimateTokens of everything appended so far. Text held
+// back because it may still become a special token or a UTF-8 sequence is
+// counted as if the input ended here.
+func (c *Counter) Count() int {
+	if c.tok.encoder != nil {
+		if c.dirty {
+			c.counted = c.tok.EstimateTokens(string(c.text))
+			c.dirty = false
+		}
+
+		return c.counted
+	}
+
+	final := *c
+	if final.splitsSpecialTokens() {
+		final.scanSpecialTokens(final.held, true)
+	}
+
+	final.endSegment()
+
+	return final.tokens
+}
+
+// countComplete is Count without the bytes of a trailing UTF-8 sequence that
+// later data may still complete. Count treats them as invalid, one at a
+// time, which under InvalidUTF8CountBytes counts a rune split across writes
+// as several tokens until its last byte arrives.
+func (c *Counter) countComplete() int {
+	if c.tok.encoder != nil {
+		tail := incompleteUTF8Tail(c.text)
+		if tail == 0 {
+			return c.Count()
+		}
+
+		return c.tok.EstimateTokens(string(c.text[:len(c.text)-tail]))
+	}
+
+	final := *c
+	final.npending = 0
+
+	return final.Count()
+}
+
+// incompleteUTF8Tail returns the length of the UTF-8 sequence that b ends
+// in when more bytes could still complete it, or 0.
+func incompleteUTF8Tail(b []byte) int {
+	for i := len(b) - 1; i >= 0 && i > len(b)-utf8.UTFMax; i-- {
+		if utf8.RuneStart(b[i]) {
+			if utf8.FullRune(b[i:]) {
+				return 0
+			}
+
+			return len(b) - i
+		}
+	}
+
+	return 0
+}
+
+// Reset empties the Counter for reuse with the same tokenizer.
+func (c *Counter) Reset() {
+	*c = *c.tok.NewCounter()
+}
+
+func (c *Counter) splitsSpecialTokens() bool {
+	return c.tok.specialPolicy != SpecialTokenEscape && len(c.tok.specials) > 0
+}
+
+// scanSpecialTokens counts text, splitting it at special tokens the way
+// findSpecialTokens does. Unless atEOF, it stops at text that may still
+// grow into a special token and holds it for the next call.
+func (c *Counter) scanSpecialTokens(text string, atEOF bool) {
+	last := 0
+
+	for pos := 0; pos < len(text); {
+		token, state := c.matchSpecialToken(text[pos:], atEOF)
+
+		switch state {
+		case specialPartialMatch:
+			c.feed(text[last:pos])
+			c.held = text[pos:]
+
+			return
+		case specialFullMatch:
+			c.feed(text[last:pos])
+			c.endSegment()
+			c.tokens++
+			pos += len(token)
+			last = pos
+		default:
+			pos++
+		}
+	}
+
+	c.feed(text[last:])
+	c.held = ""
+}
+
+// matchSpecialToken tries the special tokens at the start of text, longest
+// first. A longer token that text could still grow into wins over a
+// shorter one that already matches, so it reports a partial match.
+func (c *Counter) matchSpecialToken(text string, atEOF bool) (string, specialMatchState) {
+	for _, token := range c.tok.specials {
+		if strings.HasPrefix(text, token) {
+			return token, specialFullMatch
+		}
+
+		if !atEOF && len(text) < len(token) && strings.HasPrefix(token, text) {
+			return token, specialPartialMatch
+		}
+	}
+
+	return "", specialNoMatch
+}
+
+// feed decodes s after any bytes left from an incomplete UTF-8 sequence and
+// keeps a new incomplete tail for the next call.
+func (c *Counter) feed(s string) {
+	if c.npending > 0 {
+		s = string(c.pending[:c.npending]) + s
+		c.npending = 0
+	}
+
+	for i := 0; i < len(s); {
+		if !utf8.FullRuneInString(s[i:]) {
+			c.npending = copy(c.pending[:], s[i:])
+
+			return
+		}
+
+		r, size := utf8.DecodeRuneInString(s[i:])
+		if r == utf8.RuneError && size == 1 {
+			c.addInvalidByte()
+		} else {
+			c.addRune(r)
+		}
+
+		i += size
+	}
+}
+
+// endSegment finishes the text before a special token or at the end of
+// input: an incomplete UTF-8 sequence is invalid, one byte at a time, and
+// the letter and digit run is rounded up to whole tokens.
+func (c *Counter) endSegment() {
+	for range c.npending {
+		c.addInvalidByte()
+	}
+
+	c.npending = 0
+	c.inInvalid = false
+	c.tokens += c.tok.addAccumulatedCharTokens(c.run)
+	c.run = 0
+}
+
+// addInvalidByte applies the invalid UTF-8 policy to one undecodable byte.
+func (c *Counter) addInvalidByte() {
+	switch c.tok.invalidUTF8 {
+	case InvalidUTF8Replace:
+		if !c.inInvalid {
+			c.addNormalized(rune(InvalidUTF8Replacement[0]))
+		}
+	case InvalidUTF8CountBytes:
+		c.addNormalized(rune(InvalidUTF8Replacement[0]))
+	case InvalidUTF8Skip, InvalidUTF8Reject:
+	}
+
+	c.inInvalid = true
+}
+
+// addRune normalizes one decoded rune as Normalize would.
+func (c *Counter) addRune(r rune) {
+	c.inInvalid = false
+
+	if r <= maxASCII {
+		c.addNormalized(r)
+
+		return
+	}
+
+	for _, out := range c.tok.processText(norm.NFD.String(string(r))) {
+		c.addNormalized(out)
+	}
+}
+
+// addNormalized counts one rune of normalized text as
+// countTokensFromNormalizedText does.
+func (c *Counter) addNormalized(r rune) {
+	if isSpecialChar(r) {
+		c.tokens += c.tok.addAccumulatedCharTokens(c.run) + 1
+		c.run = 0
+
+		return
+	}
+
+	c.run++
+}
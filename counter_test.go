@@ -0,0 +1,133 @@
+package tokenizer_test
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+
+	tokenizer "github.com/nnikolov3/ai-tokenizer"
+)
+
+const (
+	CounterSplitFormat = "%s: Count() after %q + %q = %d, want %d"
+	CounterBytesFormat = "%s: Count() after %q byte by byte = %d, want %d"
+	CounterResetFormat = "Count() after Reset = %d, want 0"
+)
+
+// getCounterTexts are inputs whose counts depend on state that spans
+// pieces: letter runs, multi-byte runes, combining marks, invalid bytes and
+// special tokens.
+func getCounterTexts() []string {
+	return []string{
+		"",
+		HelloWorld,
+		"café naïve Straße",
+		"é́́x",
+		"ǄǅǊ ﬁ ½",
+		"한국어 日本語 😀👍",
+		"ab\xff\xfecd",
+		"a\xe4\xbdA\xe4",
+		"\xff�\xff",
+		ChatPrompt,
+		"x<|im_end|>y<|endoftext|>z<|im_st",
+		"<|im_start|><|im_end|><|im_start|",
+		"<|<|im_start|>|>",
+		"[INST] hi [/INST]",
+		strings.Repeat("abc ", 50),
+	}
+}
+
+func getCounterTokenizers(t *testing.T) map[string]*tokenizer.Tokenizer {
+	t.Helper()
+
+	return map[string]*tokenizer.Tokenizer{
+		"default": tokenizer.NewTokenizer(),
+		"replace": tokenizer.NewTokenizer(tokenizer.WithInvalidUTF8Policy(tokenizer.InvalidUTF8Replace)),
+		"bytes":   tokenizer.NewTokenizer(tokenizer.WithInvalidUTF8Policy(tokenizer.InvalidUTF8CountBytes)),
+		"escape":  tokenizer.NewTokenizer(tokenizer.WithSpecialTokenPolicy(tokenizer.SpecialTokenEscape)),
+		"nested":  tokenizer.NewTokenizer(tokenizer.WithSpecialTokens("[INST]", "[INST] hi", "|>")),
+		"bert":    tokenizer.NewTokenizer(tokenizer.WithEncoder("bert", loadWordPiece(t))),
+	}
+}
+
+func TestCounterMatchesEstimateAtEverySplit(t *testing.T) {
+	t.Parallel()
+
+	for name, tok := range getCounterTokenizers(t) {
+		for _, text := range getCounterTexts() {
+			want := tok.EstimateTokens(text)
+
+			for split := range len(text) + 1 {
+				counter := tok.NewCounter()
+				counter.Append(text[:split])
+
+				// Counting midway must not disturb the final count.
+				_ = counter.Count()
+
+				counter.Append(text[split:])
+
+				if got := counter.Count(); got != want {
+					t.Errorf(CounterSplitFormat, name, text[:split], text[split:], got, want)
+				}
+			}
+		}
+	}
+}
+
+func TestCounterByteByByte(t *testing.T) {
+	t.Parallel()
+
+	for name, tok := range getCounterTokenizers(t) {
+		for _, text := range getCounterTexts() {
+			counter := tok.NewCounter()
+
+			for i := range len(text) {
+				_, _ = counter.Write([]byte{text[i]})
+			}
+
+			if got, want := counter.Count(), tok.EstimateTokens(text); got != want {
+				t.Errorf(CounterBytesFormat, name, text, got, want)
+			}
+		}
+	}
+}
+
+func TestCounterPrefixCounts(t *testing.T) {
+	t.Parallel()
+
+	tok := tokenizer.NewTokenizer()
+	counter := tok.NewCounter()
+	transcript := ""
+
+	for i := range 20 {
+		chunk := fmt.Sprintf("turn %d: <|im_start|>user\nné%d<|im_end|> ", i, i*i)
+		transcript += chunk
+
+		_, err := io.WriteString(counter, chunk)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got, want := counter.Count(), tok.EstimateTokens(transcript); got != want {
+			t.Errorf(CounterSplitFormat, "default", transcript, "", got, want)
+		}
+	}
+
+	counter.Reset()
+
+	if got := counter.Count(); got != 0 {
+		t.Errorf(CounterResetFormat, got)
+	}
+}
+
+func BenchmarkCounterAppend(b *testing.B) {
+	counter := tokenizer.NewTokenizer().NewCounter()
+
+	b.ResetTimer()
+
+	for range b.N {
+		counter.Append(BenchmarkEstimateText)
+		_ = counter.Count()
+	}
+}
@@ -0,0 +1,161 @@
+package tokenizer
+
+import (
+	"errors"
+	"io"
+	"slices"
+	"sync"
+)
+
+// CountingOption configures a CountingWriter or CountingReader.
+type CountingOption func(*meter)
+
+// threshold is a callback that fires once, when the count first reaches
+// limit.
+type threshold struct {
+	limit int
+	fn    func(tokens int)
+	fired bool
+}
+
+// meter is the running count shared by CountingWriter and CountingReader.
+// pending is the number of thresholds that have not fired yet.
+type meter struct {
+	mu         sync.Mutex
+	counter    *Counter
+	thresholds []*threshold
+	pending    int
+}
+
+// WithThreshold calls fn once, with the running count, after the data that
+// brings the count to limit or more has passed through. It can be given
+// several times. Callbacks run on the goroutine calling Write or Read,
+// without locks held, so they may call Tokens.
+func WithThreshold(limit int, fn func(tokens int)) CountingOption {
+	return func(m *meter) {
+		m.thresholds = append(m.thresholds, &threshold{limit: limit, fn: fn, fired: false})
+	}
+}
+
+func (m *meter) init(tok *Tokenizer, opts []CountingOption) {
+	m.counter = tok.NewCounter()
+
+	for _, opt := range opts {
+		opt(m)
+	}
+
+	m.pending = len(m.thresholds)
+}
+
+// add counts p and fires the thresholds it crosses, in limit order. The
+// count is only taken while a threshold is pending: with a model encoder it
+// encodes everything written so far. Until eof, the bytes of a UTF-8
+// sequence that p leaves incomplete are not counted, so a rune split across
+// writes cannot fire a threshold early as invalid bytes.
+func (m *meter) add(p []byte, eof bool) {
+	if len(p) == 0 && !eof {
+		return
+	}
+
+	m.mu.Lock()
+
+	_, _ = m.counter.Write(p)
+
+	if m.pending == 0 {
+		m.mu.Unlock()
+
+		return
+	}
+
+	tokens := m.counter.countComplete()
+	if eof {
+		tokens = m.counter.Count()
+	}
+
+	var due []*threshold
+
+	for _, th := range m.thresholds {
+		if !th.fired && tokens >= th.limit {
+			th.fired = true
+			due = append(due, th)
+		}
+	}
+
+	m.pending -= len(due)
+
+	m.mu.Unlock()
+
+	slices.SortStableFunc(due, func(a, b *threshold) int { return a.limit - b.limit })
+
+	for _, th := range due {
+		th.fn(tokens)
+	}
+}
+
+// Tokens returns the count of the data that has passed through so far.
+func (m *meter) Tokens() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.counter.Count()
+}
+
+// CountingWriter passes writes through to another writer unchanged and
+// counts the tokens of the bytes it accepted, with the same rules as
+// EstimateTokens. Tokens matches EstimateTokens of everything written, even
+// when writes split words or UTF-8 sequences; thresholds wait for the rest
+// of a split sequence, which an incomplete final write never gets. It is
+// safe for concurrent use. With a model encoder, Tokens and every Write
+// made while a threshold is pending encode the whole text again, so a
+// stream of n writes with a threshold that never fires costs O(n²); the
+// heuristic counts incrementally.
+type CountingWriter struct {
+	meter
+
+	w io.Writer
+}
+
+// NewCountingWriter returns a CountingWriter that writes to w and counts
+// like tok.
+func NewCountingWriter(w io.Writer, tok *Tokenizer, opts ...CountingOption) *CountingWriter {
+	cw := &CountingWriter{meter: meter{}, w: w}
+	cw.init(tok, opts)
+
+	return cw
+}
+
+// Write writes p to the underlying writer and counts the bytes it wrote.
+func (cw *CountingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.add(p[:n], false)
+
+	return n, err
+}
+
+// CountingReader counts the tokens of the bytes read through it, with the
+// same rules as EstimateTokens. Thresholds wait for the rest of a UTF-8
+// sequence split across reads, or for EOF. It is safe for concurrent use,
+// though the order of concurrent reads decides what is counted. Reads cost
+// what writes to a CountingWriter do.
+type CountingReader struct {
+	meter
+
+	r io.Reader
+}
+
+// NewCountingReader returns a CountingReader that reads from r and counts
+// like tok.
+func NewCountingReader(r io.Reader, tok *Tokenizer, opts ...CountingOption) *CountingReader {
+	cr := &CountingReader{meter: meter{}, r: r}
+	cr.init(tok, opts)
+
+	return cr
+}
+
+// Read reads from the underlying reader and counts the bytes returned.
+func (cr *CountingReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	cr.add(p[:n], errors.Is(err, io.EOF))
+
+	return n, err
+}
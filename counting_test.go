@@ -0,0 +1,178 @@
+package tokenizer_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"slices"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	tokenizer "github.com/nnikolov3/ai-tokenizer"
+)
+
+const (
+	CountingTokensFormat   = "Tokens() = %d, want %d"
+	CountingPassFormat     = "passed through %q, want %q"
+	CountingFiredFormat    = "thresholds fired %v, want %v"
+	CountingWriteErrFormat = "Write() = %d, %v; want %d, %v"
+
+	// StreamText is 40 tokens: 20 one-letter words and 20 spaces.
+	StreamText = "a b c d e f g h i j k l m n o p q r s t "
+)
+
+// shortWriter accepts at most limit bytes in total, then fails.
+type shortWriter struct {
+	buf   bytes.Buffer
+	limit int
+}
+
+var errShortWrite = errors.New("short write")
+
+func (w *shortWriter) Write(p []byte) (int, error) {
+	n := min(len(p), w.limit-w.buf.Len())
+	w.buf.Write(p[:n])
+
+	if n < len(p) {
+		return n, errShortWrite
+	}
+
+	return n, nil
+}
+
+func TestCountingWriter(t *testing.T) {
+	t.Parallel()
+
+	tok := tokenizer.NewTokenizer()
+
+	var (
+		out   bytes.Buffer
+		fired []int
+	)
+
+	cw := tokenizer.NewCountingWriter(&out, tok,
+		tokenizer.WithThreshold(12, func(n int) { fired = append(fired, 12, n) }),
+		tokenizer.WithThreshold(10, func(n int) { fired = append(fired, 10, n) }),
+		tokenizer.WithThreshold(1000, func(n int) { fired = append(fired, 1000, n) }),
+	)
+
+	// Chunks of 7 bytes count 7, 14, 21 and so on.
+	for chunk := range slices.Chunk([]byte(StreamText), 7) {
+		_, err := cw.Write(chunk)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if out.String() != StreamText {
+		t.Errorf(CountingPassFormat, out.String(), StreamText)
+	}
+
+	if got := cw.Tokens(); got != 40 {
+		t.Errorf(CountingTokensFormat, got, 40)
+	}
+
+	// Both low thresholds fire once, lowest limit first, on the write that
+	// reaches 14. The count never reaches 1000.
+	if want := []int{10, 14, 12, 14}; !slices.Equal(fired, want) {
+		t.Errorf(CountingFiredFormat, fired, want)
+	}
+}
+
+func TestCountingWriterShortWrite(t *testing.T) {
+	t.Parallel()
+
+	sw := &shortWriter{buf: bytes.Buffer{}, limit: 5}
+	cw := tokenizer.NewCountingWriter(sw, tokenizer.NewTokenizer())
+
+	n, err := cw.Write([]byte(HelloWorld))
+	if n != 5 || !errors.Is(err, errShortWrite) {
+		t.Errorf(CountingWriteErrFormat, n, err, 5, errShortWrite)
+	}
+
+	// Only "hello" reached the writer.
+	if got := cw.Tokens(); got != 3 {
+		t.Errorf(CountingTokensFormat, got, 3)
+	}
+}
+
+func TestCountingThresholdsWaitForSplitRunes(t *testing.T) {
+	t.Parallel()
+
+	// Under InvalidUTF8CountBytes the first three bytes of the emoji count
+	// as three tokens on their own, but "ok 🙂" is only three tokens.
+	const text = "ok 🙂"
+
+	for _, tok := range []*tokenizer.Tokenizer{
+		tokenizer.NewTokenizer(tokenizer.WithInvalidUTF8Policy(tokenizer.InvalidUTF8CountBytes)),
+		tokenizer.NewTokenizer(tokenizer.WithEncoder("bert", loadWordPiece(t)), tokenizer.WithInvalidUTF8Policy(tokenizer.InvalidUTF8CountBytes)),
+	} {
+		limit := tok.EstimateTokens(text) + 1
+
+		var fired []int
+
+		cw := tokenizer.NewCountingWriter(io.Discard, tok,
+			tokenizer.WithThreshold(limit, func(n int) { fired = append(fired, n) }),
+		)
+
+		for _, b := range []byte(text) {
+			_, err := cw.Write([]byte{b})
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if len(fired) != 0 {
+			t.Errorf(CountingFiredFormat, fired, []int{})
+		}
+
+		// A reader that ends inside the rune counts its bytes at EOF.
+		truncated := text[:len(text)-1]
+		want := tok.EstimateTokens(truncated)
+		fired = nil
+
+		cr := tokenizer.NewCountingReader(iotest.OneByteReader(strings.NewReader(truncated)), tok,
+			tokenizer.WithThreshold(want, func(n int) { fired = append(fired, n) }),
+		)
+
+		_, err := io.ReadAll(cr)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !slices.Equal(fired, []int{want}) {
+			t.Errorf(CountingFiredFormat, fired, []int{want})
+		}
+	}
+}
+
+func TestCountingReader(t *testing.T) {
+	t.Parallel()
+
+	tok := tokenizer.NewTokenizer()
+	text := strings.Repeat("naïve <|im_end|> ", 10)
+
+	var fired []int
+
+	cr := tokenizer.NewCountingReader(iotest.OneByteReader(strings.NewReader(text)), tok,
+		tokenizer.WithThreshold(5, func(n int) { fired = append(fired, n) }),
+	)
+
+	got, err := io.ReadAll(cr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(got) != text {
+		t.Errorf(CountingPassFormat, got, text)
+	}
+
+	if want := tok.EstimateTokens(text); cr.Tokens() != want {
+		t.Errorf(CountingTokensFormat, cr.Tokens(), want)
+	}
+
+	if want := []int{5}; !slices.Equal(fired, want) {
+		t.Errorf(CountingFiredFormat, fired, want)
+	}
+}
@@ -2,4 +2,4 @@ module github.com/nnikolov3/ai-tokenizer
 
 go 1.25.0
 
-require golang.org/x/text v0.28.0 // indirect
+require golang.org/x/text v0.28.0
@@ -0,0 +1,375 @@
+package tokenizer
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Component kinds named in ErrUnsupportedComponent errors.
+const (
+	componentNormalizer    = "normalizer"
+	componentPreTokenizer  = "pre-tokenizer"
+	componentModel         = "model"
+	componentPostProcessor = "post-processor"
+
+	jsonNull = "null"
+
+	errInvalidHFMsg        = "invalid tokenizer.json"
+	errUnsupportedCompMsg  = "unsupported tokenizer component"
+	errUnsupportedCompFmt  = "%w: %s %q"
+	errUnsupportedRegexFmt = "%w: %s %q: %w"
+	errInvalidHFWrapFmt    = "%w: %w"
+	errLoadHFFmt           = "load tokenizer %q: %w"
+	errHFComponentWrapFmt  = "%s: %w"
+)
+
+var (
+	// ErrInvalidHFTokenizer wraps malformed tokenizer.json content.
+	ErrInvalidHFTokenizer = errors.New(errInvalidHFMsg)
+	// ErrUnsupportedComponent is returned for normalizers, pre-tokenizers,
+	// models or post-processors this package does not implement. The
+	// message names the component kind and type.
+	ErrUnsupportedComponent = errors.New(errUnsupportedCompMsg)
+)
+
+// HFTokenizer is a tokenizer loaded from a Hugging Face tokenizer.json. It
+// runs the same pipeline as the Rust tokenizers library: added tokens,
+// normalizer, pre-tokenizer, model, then post-processor. Truncation,
+// padding and the decoder are ignored. It implements Encoder, Normalizer,
+// OrdinaryEncoder, SpecialTokenLister and SpecialTokenCounter and is safe
+// for concurrent use.
+type HFTokenizer struct {
+	normalizer    hfNormalizer
+	preTokenizer  hfPreTokenizer
+	model         hfModel
+	postProcessor hfPostProcessor
+	added         addedTokens
+	ordinary      addedTokens
+}
+
+// addedTokens splits added tokens by where they are matched.
+type addedTokens struct {
+	raw        addedTokenSet
+	normalized addedTokenSet
+}
+
+type hfFile struct {
+	Normalizer    json.RawMessage `json:"normalizer"`
+	PreTokenizer  json.RawMessage `json:"pre_tokenizer"`
+	Model         json.RawMessage `json:"model"`
+	PostProcessor json.RawMessage `json:"post_processor"`
+	AddedTokens   []hfAddedToken  `json:"added_tokens"`
+}
+
+// hfAddedToken is one entry of added_tokens. Tokens with Normalized set are
+// matched after normalization, the others against the raw input.
+type hfAddedToken struct {
+	Content    string `json:"content"`
+	ID         int    `json:"id"`
+	SingleWord bool   `json:"single_word"`
+	LStrip     bool   `json:"lstrip"`
+	RStrip     bool   `json:"rstrip"`
+	Normalized bool   `json:"normalized"`
+	Special    bool   `json:"special"`
+}
+
+// addedTokenSet is ordered longest content first for leftmost-longest
+// matching.
+type addedTokenSet []hfAddedToken
+
+// addedSegment is a span of input; id is the added token it matched, or -1.
+type addedSegment struct {
+	text  string
+	start int
+	id    int
+}
+
+// LoadHuggingFace reads a tokenizer.json file.
+func LoadHuggingFace(path string) (*HFTokenizer, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf(errLoadHFFmt, path, err)
+	}
+
+	tok, err := ParseHuggingFace(data)
+	if err != nil {
+		return nil, fmt.Errorf(errLoadHFFmt, path, err)
+	}
+
+	return tok, nil
+}
+
+// ParseHuggingFace builds a tokenizer from tokenizer.json content.
+func ParseHuggingFace(data []byte) (*HFTokenizer, error) {
+	var file hfFile
+
+	err := json.Unmarshal(data, &file)
+	if err != nil {
+		return nil, fmt.Errorf(errInvalidHFWrapFmt, ErrInvalidHFTokenizer, err)
+	}
+
+	tok := &HFTokenizer{
+		normalizer:    nil,
+		preTokenizer:  nil,
+		model:         nil,
+		postProcessor: nil,
+		added:         splitAddedTokens(file.AddedTokens),
+		ordinary:      splitAddedTokens(ordinaryTokens(file.AddedTokens)),
+	}
+
+	tok.normalizer, err = parseNormalizer(file.Normalizer)
+	if err != nil {
+		return nil, err
+	}
+
+	tok.preTokenizer, err = parsePreTokenizer(file.PreTokenizer)
+	if err != nil {
+		return nil, err
+	}
+
+	tok.model, err = parseModel(file.Model)
+	if err != nil {
+		return nil, err
+	}
+
+	tok.postProcessor, err = parsePostProcessor(file.PostProcessor)
+	if err != nil {
+		return nil, err
+	}
+
+	return tok, nil
+}
+
+// Encode returns token IDs for text, including any special tokens the
+// post-processor adds, as the Rust library does by default.
+func (h *HFTokenizer) Encode(text string) []int {
+	return h.encode(text, h.added)
+}
+
+// EncodeOrdinary is Encode with special added tokens treated as plain
+// text. Non-special added tokens and the post-processor still apply.
+func (h *HFTokenizer) EncodeOrdinary(text string) []int {
+	return h.encode(text, h.ordinary)
+}
+
+// SpecialTokens lists the added tokens marked special.
+func (h *HFTokenizer) SpecialTokens() []string {
+	var tokens []string
+
+	for _, set := range []addedTokenSet{h.added.raw, h.added.normalized} {
+		for _, tok := range set {
+			if tok.Special {
+				tokens = append(tokens, tok.Content)
+			}
+		}
+	}
+
+	slices.Sort(tokens)
+
+	return tokens
+}
+
+func (h *HFTokenizer) encode(text string, added addedTokens) []int {
+	var ids []int
+
+	for _, seg := range added.raw.split(text) {
+		if seg.id >= 0 {
+			ids = append(ids, seg.id)
+
+			continue
+		}
+
+		for _, sub := range added.normalized.split(h.Normalize(seg.text)) {
+			if sub.id >= 0 {
+				ids = append(ids, sub.id)
+
+				continue
+			}
+
+			ids = h.encodeWords(ids, sub.text, seg.start == 0 && sub.start == 0)
+		}
+	}
+
+	if h.postProcessor == nil {
+		return ids
+	}
+
+	return h.postProcessor(ids)
+}
+
+// SpecialTokenCount reports how many tokens the post-processor adds around
+// the text, such as [CLS] and [SEP] or <s> and </s>.
+func (h *HFTokenizer) SpecialTokenCount() int {
+	if h.postProcessor == nil {
+		return 0
+	}
+
+	return len(h.postProcessor(nil))
+}
+
+// Normalize applies the normalizer alone.
+func (h *HFTokenizer) Normalize(text string) string {
+	if h.normalizer == nil {
+		return text
+	}
+
+	return h.normalizer(text)
+}
+
+// encodeWords pre-tokenizes normalized text and appends the model's IDs.
+// first reports whether text starts the original input.
+func (h *HFTokenizer) encodeWords(ids []int, text string, first bool) []int {
+	if text == "" {
+		return ids
+	}
+
+	words := []string{text}
+	if h.preTokenizer != nil {
+		words = h.preTokenizer(words, first)
+	}
+
+	for _, word := range words {
+		if word != "" {
+			ids = append(ids, h.model.tokenize(word)...)
+		}
+	}
+
+	return ids
+}
+
+// splitAddedTokens sorts tokens into those matched on raw input and those
+// matched after normalization.
+func splitAddedTokens(tokens []hfAddedToken) addedTokens {
+	var raw, normalized addedTokenSet
+
+	for _, tok := range tokens {
+		if tok.Content == "" {
+			continue
+		}
+
+		if tok.Normalized {
+			normalized = append(normalized, tok)
+		} else {
+			raw = append(raw, tok)
+		}
+	}
+
+	longestFirst := func(a, b hfAddedToken) int { return len(b.Content) - len(a.Content) }
+	slices.SortStableFunc(raw, longestFirst)
+	slices.SortStableFunc(normalized, longestFirst)
+
+	return addedTokens{raw: raw, normalized: normalized}
+}
+
+// ordinaryTokens drops the special tokens from an added_tokens list.
+func ordinaryTokens(tokens []hfAddedToken) []hfAddedToken {
+	return slices.DeleteFunc(slices.Clone(tokens), func(tok hfAddedToken) bool { return tok.Special })
+}
+
+// split cuts text around added tokens, honouring lstrip, rstrip and
+// single_word.
+func (a addedTokenSet) split(text string) []addedSegment {
+	if len(a) == 0 {
+		return []addedSegment{{text: text, start: 0, id: -1}}
+	}
+
+	var segments []addedSegment
+
+	last := 0
+
+	for pos := 0; pos < len(text); {
+		tok, ok := a.match(text, pos)
+		if !ok {
+			_, size := utf8.DecodeRuneInString(text[pos:])
+			pos += size
+
+			continue
+		}
+
+		start, end := pos, pos+len(tok.Content)
+		if tok.LStrip {
+			start = last + len(strings.TrimRightFunc(text[last:start], unicode.IsSpace))
+		}
+
+		if tok.RStrip {
+			end = len(text) - len(strings.TrimLeftFunc(text[end:], unicode.IsSpace))
+		}
+
+		if start > last {
+			segments = append(segments, addedSegment{text: text[last:start], start: last, id: -1})
+		}
+
+		segments = append(segments, addedSegment{text: text[start:end], start: start, id: tok.ID})
+		last, pos = end, end
+	}
+
+	if last < len(text) {
+		segments = append(segments, addedSegment{text: text[last:], start: last, id: -1})
+	}
+
+	return segments
+}
+
+func (a addedTokenSet) match(text string, pos int) (hfAddedToken, bool) {
+	for _, tok := range a {
+		end := pos + len(tok.Content)
+		if !strings.HasPrefix(text[pos:], tok.Content) {
+			continue
+		}
+
+		if tok.SingleWord && !isWordBoundary(text, pos, end) {
+			continue
+		}
+
+		return tok, true
+	}
+
+	return hfAddedToken{}, false
+}
+
+// isWordBoundary reports whether text[start:end] is not glued to word
+// characters on either side.
+func isWordBoundary(text string, start, end int) bool {
+	before, _ := utf8.DecodeLastRuneInString(text[:start])
+	after, _ := utf8.DecodeRuneInString(text[end:])
+
+	return (start == 0 || !isWordRune(before)) && (end == len(text) || !isWordRune(after))
+}
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || unicode.IsMark(r) || r == '_'
+}
+
+// isNullJSON reports whether a component is absent or JSON null.
+func isNullJSON(raw json.RawMessage) bool {
+	return len(raw) == 0 || string(raw) == jsonNull
+}
+
+// decodeComponent unmarshals a component, naming it in any error.
+func decodeComponent(kind string, raw json.RawMessage, spec any) error {
+	err := json.Unmarshal(raw, spec)
+	if err != nil {
+		return invalidComponent(kind, err)
+	}
+
+	return nil
+}
+
+func invalidComponent(kind string, err error) error {
+	return fmt.Errorf(errInvalidHFWrapFmt, ErrInvalidHFTokenizer, fmt.Errorf(errHFComponentWrapFmt, kind, err))
+}
+
+func unsupportedComponent(kind, name string) error {
+	return fmt.Errorf(errUnsupportedCompFmt, ErrUnsupportedComponent, kind, name)
+}
+
+// unsupportedRegex reports a pattern RE2 cannot run, such as lookaround.
+func unsupportedRegex(kind, name string, err error) error {
+	return fmt.Errorf(errUnsupportedRegexFmt, ErrUnsupportedComponent, kind, name, err)
+}
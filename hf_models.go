@@ -0,0 +1,446 @@
+package tokenizer
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	modelTypeBPE       = "BPE"
+	modelTypeWordPiece = "WordPiece"
+	modelTypeUnigram   = "Unigram"
+
+	defaultWordPiecePrefix   = "##"
+	defaultWordPieceMaxChars = 100
+	bpeMergeParts            = 2
+
+	errMergeMsg         = "malformed merge"
+	errVocabTokenMsg    = "token not in vocabulary"
+	errUnigramEntryMsg  = "malformed unigram vocabulary entry"
+	errMergeFmt         = "%w %s"
+	errVocabTokenFmt    = "%w: %q"
+	bpeDropoutComponent = "BPE dropout"
+)
+
+var (
+	errMerge        = errors.New(errMergeMsg)
+	errVocabToken   = errors.New(errVocabTokenMsg)
+	errUnigramEntry = errors.New(errUnigramEntryMsg)
+)
+
+// hfModel splits one pre-tokenized word into token IDs.
+type hfModel interface {
+	tokenize(word string) []int
+}
+
+type hfModelSpec struct {
+	UnkToken                *string           `json:"unk_token"`
+	UnkID                   *int              `json:"unk_id"`
+	ContinuingSubwordPrefix *string           `json:"continuing_subword_prefix"`
+	EndOfWordSuffix         *string           `json:"end_of_word_suffix"`
+	MaxInputCharsPerWord    *int              `json:"max_input_chars_per_word"`
+	Dropout                 *float64          `json:"dropout"`
+	Type                    string            `json:"type"`
+	Vocab                   json.RawMessage   `json:"vocab"`
+	Merges                  []json.RawMessage `json:"merges"`
+	FuseUnk                 bool              `json:"fuse_unk"`
+	ByteFallback            bool              `json:"byte_fallback"`
+	IgnoreMerges            bool              `json:"ignore_merges"`
+}
+
+// bpeMerge is the rank of a merge rule and the ID it produces.
+type bpeMerge struct {
+	rank int
+	id   int
+}
+
+// hfBPE is a byte-pair encoding model.
+type hfBPE struct {
+	vocab        map[string]int
+	merges       map[[2]int]bpeMerge
+	prefix       string
+	suffix       string
+	unknownID    int
+	fuseUnknown  bool
+	byteFallback bool
+	ignoreMerges bool
+}
+
+// hfWordPiece is a greedy longest-match-first model.
+type hfWordPiece struct {
+	vocab     map[string]int
+	prefix    string
+	unknownID int
+	maxChars  int
+}
+
+// hfUnigram wraps the shared Viterbi segmenter.
+type hfUnigram struct {
+	unigram
+}
+
+func parseModel(raw json.RawMessage) (hfModel, error) {
+	var spec hfModelSpec
+
+	err := decodeComponent(componentModel, raw, &spec)
+	if err != nil {
+		return nil, err
+	}
+
+	switch inferModelType(spec) {
+	case modelTypeBPE:
+		return newHFBPE(spec)
+	case modelTypeWordPiece:
+		return newHFWordPiece(spec)
+	case modelTypeUnigram:
+		return newHFUnigram(spec)
+	default:
+		return nil, unsupportedComponent(componentModel, spec.Type)
+	}
+}
+
+// inferModelType fills in the type older files leave out.
+func inferModelType(spec hfModelSpec) string {
+	switch {
+	case spec.Type != "":
+		return spec.Type
+	case spec.Merges != nil:
+		return modelTypeBPE
+	case strings.HasPrefix(strings.TrimSpace(string(spec.Vocab)), "["):
+		return modelTypeUnigram
+	default:
+		return modelTypeWordPiece
+	}
+}
+
+func decodeVocab(spec hfModelSpec) (map[string]int, error) {
+	var vocab map[string]int
+
+	err := json.Unmarshal(spec.Vocab, &vocab)
+	if err != nil {
+		return nil, modelError(spec.Type, err)
+	}
+
+	return vocab, nil
+}
+
+func modelError(modelType string, err error) error {
+	return invalidComponent(componentModel, fmt.Errorf(errHFComponentWrapFmt, modelType, err))
+}
+
+// lookupUnknown returns the vocabulary ID of token, or -1 when unset.
+func lookupUnknown(vocab map[string]int, token *string, modelType string) (int, error) {
+	if token == nil {
+		return -1, nil
+	}
+
+	id, ok := vocab[*token]
+	if !ok {
+		return -1, modelError(modelType, fmt.Errorf(errVocabTokenFmt, errVocabToken, *token))
+	}
+
+	return id, nil
+}
+
+func stringOr(value *string, fallback string) string {
+	if value == nil {
+		return fallback
+	}
+
+	return *value
+}
+
+func newHFBPE(spec hfModelSpec) (*hfBPE, error) {
+	if spec.Dropout != nil && *spec.Dropout > 0 {
+		return nil, unsupportedComponent(componentModel, bpeDropoutComponent)
+	}
+
+	vocab, err := decodeVocab(spec)
+	if err != nil {
+		return nil, err
+	}
+
+	unknownID, err := lookupUnknown(vocab, spec.UnkToken, modelTypeBPE)
+	if err != nil {
+		return nil, err
+	}
+
+	model := &hfBPE{
+		vocab:        vocab,
+		merges:       make(map[[2]int]bpeMerge, len(spec.Merges)),
+		prefix:       stringOr(spec.ContinuingSubwordPrefix, ""),
+		suffix:       stringOr(spec.EndOfWordSuffix, ""),
+		unknownID:    unknownID,
+		fuseUnknown:  spec.FuseUnk,
+		byteFallback: spec.ByteFallback,
+		ignoreMerges: spec.IgnoreMerges,
+	}
+
+	for rank, raw := range spec.Merges {
+		err = model.addMerge(rank, raw)
+		if err != nil {
+			return nil, modelError(modelTypeBPE, err)
+		}
+	}
+
+	return model, nil
+}
+
+// addMerge accepts both the "a b" and ["a", "b"] merge encodings.
+func (b *hfBPE) addMerge(rank int, raw json.RawMessage) error {
+	var parts []string
+
+	var joined string
+	if json.Unmarshal(raw, &joined) == nil {
+		parts = strings.SplitN(joined, asciiSpace, bpeMergeParts)
+	} else if json.Unmarshal(raw, &parts) != nil {
+		return fmt.Errorf(errMergeFmt, errMerge, raw)
+	}
+
+	if len(parts) != bpeMergeParts {
+		return fmt.Errorf(errMergeFmt, errMerge, raw)
+	}
+
+	merged := parts[0] + strings.TrimPrefix(parts[1], b.prefix)
+
+	ids := [3]int{}
+	for i, token := range []string{parts[0], parts[1], merged} {
+		id, ok := b.vocab[token]
+		if !ok {
+			return fmt.Errorf(errVocabTokenFmt, errVocabToken, token)
+		}
+
+		ids[i] = id
+	}
+
+	b.merges[[2]int{ids[0], ids[1]}] = bpeMerge{rank: rank, id: ids[2]}
+
+	return nil
+}
+
+func (b *hfBPE) tokenize(word string) []int {
+	if b.ignoreMerges {
+		if id, ok := b.vocab[word]; ok {
+			return []int{id}
+		}
+	}
+
+	symbols := b.initialSymbols(word)
+
+	for {
+		best, merge := -1, bpeMerge{rank: math.MaxInt, id: 0}
+
+		for i := 0; i+1 < len(symbols); i++ {
+			candidate, ok := b.merges[[2]int{symbols[i], symbols[i+1]}]
+			if ok && candidate.rank < merge.rank {
+				best, merge = i, candidate
+			}
+		}
+
+		if best < 0 {
+			return symbols
+		}
+
+		symbols[best] = merge.id
+		symbols = append(symbols[:best+1], symbols[best+2:]...)
+	}
+}
+
+// initialSymbols maps each character to its vocabulary ID, adding the
+// subword prefix and word suffix. Unknown characters become byte pieces
+// under byte fallback, else the unknown token, else they are dropped.
+func (b *hfBPE) initialSymbols(word string) []int {
+	var symbols []int
+
+	prevUnknown := false
+
+	for i, r := range word {
+		char := string(r)
+		if i > 0 {
+			char = b.prefix + char
+		}
+
+		if i+utf8.RuneLen(r) == len(word) {
+			char += b.suffix
+		}
+
+		if id, ok := b.vocab[char]; ok {
+			symbols = append(symbols, id)
+			prevUnknown = false
+
+			continue
+		}
+
+		if bytes, ok := b.bytePieces(string(r)); ok {
+			symbols = append(symbols, bytes...)
+			prevUnknown = false
+
+			continue
+		}
+
+		if b.unknownID >= 0 && !(b.fuseUnknown && prevUnknown) {
+			symbols = append(symbols, b.unknownID)
+		}
+
+		prevUnknown = b.unknownID >= 0
+	}
+
+	return symbols
+}
+
+// bytePieces returns the <0xXX> IDs for char when all of them exist.
+func (b *hfBPE) bytePieces(char string) ([]int, bool) {
+	if !b.byteFallback {
+		return nil, false
+	}
+
+	ids := make([]int, 0, len(char))
+
+	for i := range len(char) {
+		id, ok := b.vocab[fmt.Sprintf(spBytePieceFmt, char[i])]
+		if !ok {
+			return nil, false
+		}
+
+		ids = append(ids, id)
+	}
+
+	return ids, true
+}
+
+func newHFWordPiece(spec hfModelSpec) (*hfWordPiece, error) {
+	vocab, err := decodeVocab(spec)
+	if err != nil {
+		return nil, err
+	}
+
+	unknownID, err := lookupUnknown(vocab, spec.UnkToken, modelTypeWordPiece)
+	if err != nil {
+		return nil, err
+	}
+
+	maxChars := defaultWordPieceMaxChars
+	if spec.MaxInputCharsPerWord != nil {
+		maxChars = *spec.MaxInputCharsPerWord
+	}
+
+	return &hfWordPiece{
+		vocab:     vocab,
+		prefix:    stringOr(spec.ContinuingSubwordPrefix, defaultWordPiecePrefix),
+		unknownID: unknownID,
+		maxChars:  maxChars,
+	}, nil
+}
+
+// tokenize takes the longest vocabulary prefix at each step. A word with
+// any unmatched remainder becomes a single unknown token.
+func (w *hfWordPiece) tokenize(word string) []int {
+	if utf8.RuneCountInString(word) > w.maxChars {
+		return w.unknown()
+	}
+
+	var ids []int
+
+	for start := 0; start < len(word); {
+		id, end := w.longestPrefix(word, start)
+		if end < 0 {
+			return w.unknown()
+		}
+
+		ids = append(ids, id)
+		start = end
+	}
+
+	return ids
+}
+
+func (w *hfWordPiece) longestPrefix(word string, start int) (int, int) {
+	for end := len(word); end > start; {
+		piece := word[start:end]
+		if start > 0 {
+			piece = w.prefix + piece
+		}
+
+		if id, ok := w.vocab[piece]; ok {
+			return id, end
+		}
+
+		_, size := utf8.DecodeLastRuneInString(word[start:end])
+		end -= size
+	}
+
+	return 0, -1
+}
+
+func (w *hfWordPiece) unknown() []int {
+	if w.unknownID < 0 {
+		return nil
+	}
+
+	return []int{w.unknownID}
+}
+
+func newHFUnigram(spec hfModelSpec) (*hfUnigram, error) {
+	var entries [][]json.RawMessage
+
+	err := json.Unmarshal(spec.Vocab, &entries)
+	if err != nil {
+		return nil, modelError(modelTypeUnigram, err)
+	}
+
+	model := &hfUnigram{unigram: newUnigram()}
+	model.byteFallback = spec.ByteFallback
+
+	for _, entry := range entries {
+		piece, err := unigramEntry(entry, spec.ByteFallback)
+		if err != nil {
+			return nil, modelError(modelTypeUnigram, err)
+		}
+
+		model.add(piece)
+	}
+
+	if spec.UnkID != nil {
+		model.unknownID = *spec.UnkID
+	}
+
+	err = model.validate()
+	if err != nil {
+		return nil, modelError(modelTypeUnigram, err)
+	}
+
+	return model, nil
+}
+
+// unigramEntry decodes a [piece, score] pair. Under byte fallback, <0xXX>
+// pieces are reserved for unknown bytes.
+func unigramEntry(entry []json.RawMessage, byteFallback bool) (spPiece, error) {
+	piece := spPiece{text: "", score: 0, kind: spPieceNormal}
+
+	if len(entry) != bpeMergeParts {
+		return piece, errUnigramEntry
+	}
+
+	var score float64
+
+	if json.Unmarshal(entry[0], &piece.text) != nil || json.Unmarshal(entry[1], &score) != nil {
+		return piece, errUnigramEntry
+	}
+
+	piece.score = float32(score)
+
+	var value byte
+	if _, err := fmt.Sscanf(piece.text, spBytePieceFmt, &value); byteFallback && err == nil {
+		piece.kind = spPieceByte
+	}
+
+	return piece, nil
+}
+
+func (u *hfUnigram) tokenize(word string) []int {
+	return u.encode(word)
+}
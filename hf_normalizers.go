@@ -0,0 +1,269 @@
+package tokenizer
+
+import (
+	"encoding/json"
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// hfNormalizer rewrites text before pre-tokenization.
+type hfNormalizer func(text string) string
+
+// hfPattern is a tokenizer.json pattern: a literal String or a Regex.
+type hfPattern struct {
+	String *string `json:"String"`
+	Regex  *string `json:"Regex"`
+}
+
+type hfNormalizerSpec struct {
+	StripAccents        *bool             `json:"strip_accents"`
+	Pattern             hfPattern         `json:"pattern"`
+	Type                string            `json:"type"`
+	Content             string            `json:"content"`
+	Prepend             string            `json:"prepend"`
+	Normalizers         []json.RawMessage `json:"normalizers"`
+	PrecompiledCharsmap []byte            `json:"precompiled_charsmap"`
+	StripLeft           bool              `json:"strip_left"`
+	StripRight          bool              `json:"strip_right"`
+	CleanText           bool              `json:"clean_text"`
+	HandleChineseChars  bool              `json:"handle_chinese_chars"`
+	Lowercase           bool              `json:"lowercase"`
+}
+
+// cjkRanges are the code point blocks BERT pads with spaces.
+var cjkRanges = &unicode.RangeTable{
+	R16: []unicode.Range16{
+		{Lo: 0x3400, Hi: 0x4DBF, Stride: 1},
+		{Lo: 0x4E00, Hi: 0x9FFF, Stride: 1},
+		{Lo: 0xF900, Hi: 0xFAFF, Stride: 1},
+	},
+	R32: []unicode.Range32{
+		{Lo: 0x20000, Hi: 0x2A6DF, Stride: 1},
+		{Lo: 0x2A700, Hi: 0x2B73F, Stride: 1},
+		{Lo: 0x2B740, Hi: 0x2B81F, Stride: 1},
+		{Lo: 0x2B920, Hi: 0x2CEAF, Stride: 1},
+		{Lo: 0x2F800, Hi: 0x2FA1F, Stride: 1},
+	},
+}
+
+// compile builds the matcher for a pattern.
+func (p hfPattern) compile() (*hfRegex, error) {
+	if p.Regex != nil {
+		return compileHFRegex(*p.Regex)
+	}
+
+	if p.String != nil {
+		return literalHFRegex(*p.String), nil
+	}
+
+	return literalHFRegex(""), nil
+}
+
+func parseNormalizer(raw json.RawMessage) (hfNormalizer, error) {
+	if isNullJSON(raw) {
+		return nil, nil
+	}
+
+	var spec hfNormalizerSpec
+
+	err := decodeComponent(componentNormalizer, raw, &spec)
+	if err != nil {
+		return nil, err
+	}
+
+	switch spec.Type {
+	case "NFC":
+		return norm.NFC.String, nil
+	case "NFD":
+		return norm.NFD.String, nil
+	case "NFKC":
+		return norm.NFKC.String, nil
+	case "NFKD":
+		return norm.NFKD.String, nil
+	case "Lowercase":
+		return strings.ToLower, nil
+	case "StripAccents":
+		return removeMarks, nil
+	case "Strip":
+		return stripNormalizer(spec.StripLeft, spec.StripRight), nil
+	case "Prepend":
+		return prependNormalizer(spec.Prepend), nil
+	case "Replace":
+		return replaceNormalizer(spec)
+	case "Precompiled":
+		return precompiledNormalizer(spec.PrecompiledCharsmap)
+	case "BertNormalizer":
+		return bertNormalizer(spec), nil
+	case "Sequence":
+		return sequenceNormalizer(spec.Normalizers)
+	default:
+		return nil, unsupportedComponent(componentNormalizer, spec.Type)
+	}
+}
+
+func sequenceNormalizer(raws []json.RawMessage) (hfNormalizer, error) {
+	steps := make([]hfNormalizer, 0, len(raws))
+
+	for _, raw := range raws {
+		step, err := parseNormalizer(raw)
+		if err != nil {
+			return nil, err
+		}
+
+		if step != nil {
+			steps = append(steps, step)
+		}
+	}
+
+	return func(text string) string {
+		for _, step := range steps {
+			text = step(text)
+		}
+
+		return text
+	}, nil
+}
+
+func stripNormalizer(left, right bool) hfNormalizer {
+	return func(text string) string {
+		if left {
+			text = strings.TrimLeftFunc(text, unicode.IsSpace)
+		}
+
+		if right {
+			text = strings.TrimRightFunc(text, unicode.IsSpace)
+		}
+
+		return text
+	}
+}
+
+func prependNormalizer(prefix string) hfNormalizer {
+	return func(text string) string {
+		if text == "" {
+			return ""
+		}
+
+		return prefix + text
+	}
+}
+
+func replaceNormalizer(spec hfNormalizerSpec) (hfNormalizer, error) {
+	re, err := spec.Pattern.compile()
+	if err != nil {
+		return nil, unsupportedRegex(componentNormalizer, spec.Type, err)
+	}
+
+	return func(text string) string {
+		return re.replaceAll(text, spec.Content)
+	}, nil
+}
+
+func precompiledNormalizer(blob []byte) (hfNormalizer, error) {
+	if len(blob) == 0 {
+		return nil, nil
+	}
+
+	charsMap, err := parseCharsMap(blob)
+	if err != nil {
+		return nil, invalidComponent(componentNormalizer, err)
+	}
+
+	normalizer := &spNormalizer{
+		charsMap:               charsMap,
+		addDummyPrefix:         false,
+		removeExtraWhitespaces: false,
+		escapeWhitespaces:      false,
+	}
+
+	return normalizer.mapChars, nil
+}
+
+// bertNormalizer mirrors BertNormalizer: clean control characters, pad CJK
+// ideographs, strip accents, lowercase. Accents follow lowercase unless
+// strip_accents is set explicitly.
+func bertNormalizer(spec hfNormalizerSpec) hfNormalizer {
+	stripAccents := spec.Lowercase
+	if spec.StripAccents != nil {
+		stripAccents = *spec.StripAccents
+	}
+
+	return func(text string) string {
+		if spec.CleanText {
+			text = cleanText(text)
+		}
+
+		if spec.HandleChineseChars {
+			text = padCJK(text)
+		}
+
+		if stripAccents {
+			text = removeMarks(norm.NFD.String(text))
+		}
+
+		if spec.Lowercase {
+			text = strings.ToLower(text)
+		}
+
+		return text
+	}
+}
+
+// cleanText drops NUL, U+FFFD and control characters and turns every
+// whitespace character into a plain space.
+func cleanText(text string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r == 0 || r == unicode.ReplacementChar || isBertControl(r):
+			return -1
+		case isBertWhitespace(r):
+			return ' '
+		default:
+			return r
+		}
+	}, text)
+}
+
+func isBertControl(r rune) bool {
+	if r == '\t' || r == '\n' || r == '\r' {
+		return false
+	}
+
+	return unicode.In(r, unicode.Cc, unicode.Cf, unicode.Co, unicode.Cs)
+}
+
+func isBertWhitespace(r rune) bool {
+	return r == '\t' || r == '\n' || r == '\r' || r == ' ' || unicode.Is(unicode.Zs, r)
+}
+
+func padCJK(text string) string {
+	var builder strings.Builder
+	builder.Grow(len(text))
+
+	for _, r := range text {
+		if unicode.Is(cjkRanges, r) {
+			builder.WriteByte(' ')
+			builder.WriteRune(r)
+			builder.WriteByte(' ')
+
+			continue
+		}
+
+		builder.WriteRune(r)
+	}
+
+	return builder.String()
+}
+
+// removeMarks drops nonspacing marks, as StripAccents does after NFD.
+func removeMarks(text string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.Is(unicode.Mn, r) {
+			return -1
+		}
+
+		return r
+	}, text)
+}
@@ -0,0 +1,160 @@
+package tokenizer
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+const (
+	errTemplateTokenMsg = "template references unknown special token"
+	errSpecialPairMsg   = "malformed special token pair"
+	errTemplateTokenFmt = "%w %q"
+)
+
+var (
+	errTemplateToken = errors.New(errTemplateTokenMsg)
+	errSpecialPair   = errors.New(errSpecialPairMsg)
+)
+
+// hfPostProcessor adds special tokens around the model's IDs for a single
+// sequence.
+type hfPostProcessor func(ids []int) []int
+
+type hfPostProcessorSpec struct {
+	Type          string                    `json:"type"`
+	Single        []hfTemplatePiece         `json:"single"`
+	SpecialTokens map[string]hfSpecialToken `json:"special_tokens"`
+	Cls           []json.RawMessage         `json:"cls"`
+	Sep           []json.RawMessage         `json:"sep"`
+	Processors    []json.RawMessage         `json:"processors"`
+}
+
+// hfTemplatePiece is one item of a TemplateProcessing template: either a
+// special token or the input sequence.
+type hfTemplatePiece struct {
+	SpecialToken *hfTemplateID `json:"SpecialToken"`
+	Sequence     *hfTemplateID `json:"Sequence"`
+}
+
+type hfTemplateID struct {
+	ID string `json:"id"`
+}
+
+type hfSpecialToken struct {
+	IDs []int `json:"ids"`
+}
+
+func parsePostProcessor(raw json.RawMessage) (hfPostProcessor, error) {
+	if isNullJSON(raw) {
+		return nil, nil
+	}
+
+	var spec hfPostProcessorSpec
+
+	err := decodeComponent(componentPostProcessor, raw, &spec)
+	if err != nil {
+		return nil, err
+	}
+
+	switch spec.Type {
+	case "TemplateProcessing":
+		return templateProcessor(spec)
+	case "BertProcessing", "RobertaProcessing":
+		return wrapProcessor(spec)
+	case "ByteLevel":
+		return nil, nil
+	case "Sequence":
+		return sequenceProcessor(spec.Processors)
+	default:
+		return nil, unsupportedComponent(componentPostProcessor, spec.Type)
+	}
+}
+
+func sequenceProcessor(raws []json.RawMessage) (hfPostProcessor, error) {
+	steps := make([]hfPostProcessor, 0, len(raws))
+
+	for _, raw := range raws {
+		step, err := parsePostProcessor(raw)
+		if err != nil {
+			return nil, err
+		}
+
+		if step != nil {
+			steps = append(steps, step)
+		}
+	}
+
+	return func(ids []int) []int {
+		for _, step := range steps {
+			ids = step(ids)
+		}
+
+		return ids
+	}, nil
+}
+
+// templateProcessor expands the single-sequence template. Pair templates
+// are ignored because Encode takes one input.
+func templateProcessor(spec hfPostProcessorSpec) (hfPostProcessor, error) {
+	var before, after []int
+
+	seen := false
+
+	for _, piece := range spec.Single {
+		switch {
+		case piece.Sequence != nil:
+			seen = true
+		case piece.SpecialToken != nil:
+			special, ok := spec.SpecialTokens[piece.SpecialToken.ID]
+			if !ok {
+				return nil, invalidComponent(componentPostProcessor,
+					fmt.Errorf(errTemplateTokenFmt, errTemplateToken, piece.SpecialToken.ID))
+			}
+
+			if seen {
+				after = append(after, special.IDs...)
+			} else {
+				before = append(before, special.IDs...)
+			}
+		}
+	}
+
+	return surroundProcessor(before, after), nil
+}
+
+// wrapProcessor handles BertProcessing and RobertaProcessing, whose cls and
+// sep are [token, id] pairs.
+func wrapProcessor(spec hfPostProcessorSpec) (hfPostProcessor, error) {
+	cls, err := specialPairID(spec.Cls)
+	if err != nil {
+		return nil, invalidComponent(componentPostProcessor, err)
+	}
+
+	sep, err := specialPairID(spec.Sep)
+	if err != nil {
+		return nil, invalidComponent(componentPostProcessor, err)
+	}
+
+	return surroundProcessor([]int{cls}, []int{sep}), nil
+}
+
+func specialPairID(pair []json.RawMessage) (int, error) {
+	var id int
+
+	if len(pair) != bpeMergeParts || json.Unmarshal(pair[1], &id) != nil {
+		return 0, errSpecialPair
+	}
+
+	return id, nil
+}
+
+func surroundProcessor(before, after []int) hfPostProcessor {
+	return func(ids []int) []int {
+		out := make([]int, 0, len(before)+len(ids)+len(after))
+		out = append(out, before...)
+		out = append(out, ids...)
+
+		return append(out, after...)
+	}
+}
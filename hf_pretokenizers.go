@@ -0,0 +1,455 @@
+package tokenizer
+
+import (
+	"encoding/json"
+	"slices"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// hfPreTokenizer splits normalized text into words for the model. first
+// reports whether the text starts the original input.
+type hfPreTokenizer func(words []string, first bool) []string
+
+// Split behaviours from tokenizer.json.
+const (
+	behaviorRemoved            = "Removed"
+	behaviorIsolated           = "Isolated"
+	behaviorMergedWithPrevious = "MergedWithPrevious"
+	behaviorMergedWithNext     = "MergedWithNext"
+	behaviorContiguous         = "Contiguous"
+
+	// Metaspace prepend schemes.
+	prependAlways = "always"
+	prependFirst  = "first"
+	prependNever  = "never"
+
+	// hfWhitespacePattern is the Whitespace pre-tokenizer's word pattern.
+	hfWhitespacePattern = `\w+|[^\w\s]+`
+	byteLevelSpace      = " "
+)
+
+type hfPreTokenizerSpec struct {
+	AddPrefixSpace   *bool             `json:"add_prefix_space"`
+	UseRegex         *bool             `json:"use_regex"`
+	Split            *bool             `json:"split"`
+	Pattern          hfPattern         `json:"pattern"`
+	Type             string            `json:"type"`
+	Replacement      string            `json:"replacement"`
+	PrependScheme    string            `json:"prepend_scheme"`
+	Behavior         string            `json:"behavior"`
+	Delimiter        string            `json:"delimiter"`
+	PreTokenizers    []json.RawMessage `json:"pretokenizers"`
+	Invert           bool              `json:"invert"`
+	IndividualDigits bool              `json:"individual_digits"`
+}
+
+// splitPart is a span of a word and whether it is a delimiter.
+type splitPart struct {
+	start int
+	end   int
+	delim bool
+}
+
+// matchFinder returns the byte ranges of delimiter matches in text.
+type matchFinder func(text string) [][2]int
+
+// byteLevelRunes maps each byte to the printable rune GPT-2 uses for it.
+var byteLevelRunes = buildByteLevelRunes()
+
+func parsePreTokenizer(raw json.RawMessage) (hfPreTokenizer, error) {
+	if isNullJSON(raw) {
+		return nil, nil
+	}
+
+	var spec hfPreTokenizerSpec
+
+	err := decodeComponent(componentPreTokenizer, raw, &spec)
+	if err != nil {
+		return nil, err
+	}
+
+	switch spec.Type {
+	case "Whitespace":
+		re, err := compileHFRegex(hfWhitespacePattern)
+		if err != nil {
+			return nil, unsupportedRegex(componentPreTokenizer, spec.Type, err)
+		}
+
+		return splitPreTokenizer(re.findAll, behaviorRemoved, true), nil
+	case "WhitespaceSplit":
+		return splitPreTokenizer(runeFinder(unicode.IsSpace), behaviorRemoved, false), nil
+	case "BertPreTokenizer":
+		return sequencePreTokenizer(
+			splitPreTokenizer(runeFinder(unicode.IsSpace), behaviorRemoved, false),
+			splitPreTokenizer(runeFinder(isHFPunctuation), behaviorIsolated, false),
+		), nil
+	case "Punctuation":
+		return behaviorPreTokenizer(spec, runeFinder(isHFPunctuation), behaviorIsolated)
+	case "Digits":
+		return digitsPreTokenizer(spec.IndividualDigits), nil
+	case "CharDelimiterSplit":
+		return charDelimiterPreTokenizer(spec)
+	case "Split":
+		return patternPreTokenizer(spec)
+	case "Metaspace":
+		return metaspacePreTokenizer(spec)
+	case "ByteLevel":
+		return byteLevelPreTokenizer(spec)
+	case "Sequence":
+		return parsePreTokenizerSequence(spec.PreTokenizers)
+	default:
+		return nil, unsupportedComponent(componentPreTokenizer, spec.Type)
+	}
+}
+
+func parsePreTokenizerSequence(raws []json.RawMessage) (hfPreTokenizer, error) {
+	steps := make([]hfPreTokenizer, 0, len(raws))
+
+	for _, raw := range raws {
+		step, err := parsePreTokenizer(raw)
+		if err != nil {
+			return nil, err
+		}
+
+		if step != nil {
+			steps = append(steps, step)
+		}
+	}
+
+	return sequencePreTokenizer(steps...), nil
+}
+
+func sequencePreTokenizer(steps ...hfPreTokenizer) hfPreTokenizer {
+	return func(words []string, first bool) []string {
+		for _, step := range steps {
+			words = step(words, first)
+		}
+
+		return words
+	}
+}
+
+func splitPreTokenizer(find matchFinder, behavior string, invert bool) hfPreTokenizer {
+	return func(words []string, _ bool) []string {
+		var out []string
+
+		for _, word := range words {
+			out = append(out, splitWord(word, find, behavior, invert)...)
+		}
+
+		return out
+	}
+}
+
+// behaviorPreTokenizer validates spec.Behavior, defaulting to fallback.
+func behaviorPreTokenizer(spec hfPreTokenizerSpec, find matchFinder, fallback string) (hfPreTokenizer, error) {
+	behavior := spec.Behavior
+	if behavior == "" {
+		behavior = fallback
+	}
+
+	if !isSplitBehavior(behavior) {
+		return nil, unsupportedComponent(componentPreTokenizer, spec.Type+" behavior "+behavior)
+	}
+
+	return splitPreTokenizer(find, behavior, spec.Invert), nil
+}
+
+func patternPreTokenizer(spec hfPreTokenizerSpec) (hfPreTokenizer, error) {
+	re, err := spec.Pattern.compile()
+	if err != nil {
+		return nil, unsupportedRegex(componentPreTokenizer, spec.Type, err)
+	}
+
+	return behaviorPreTokenizer(spec, re.findAll, behaviorIsolated)
+}
+
+func digitsPreTokenizer(individual bool) hfPreTokenizer {
+	if individual {
+		return splitPreTokenizer(runeFinder(unicode.IsNumber), behaviorIsolated, false)
+	}
+
+	return splitPreTokenizer(runeFinder(unicode.IsNumber), behaviorContiguous, false)
+}
+
+func charDelimiterPreTokenizer(spec hfPreTokenizerSpec) (hfPreTokenizer, error) {
+	delimiter, size := utf8.DecodeRuneInString(spec.Delimiter)
+	if size == 0 || size != len(spec.Delimiter) {
+		return nil, unsupportedComponent(componentPreTokenizer, spec.Type+" delimiter "+spec.Delimiter)
+	}
+
+	isDelimiter := func(r rune) bool { return r == delimiter }
+
+	return splitPreTokenizer(runeFinder(isDelimiter), behaviorRemoved, false), nil
+}
+
+// metaspacePreTokenizer replaces spaces with the replacement character,
+// optionally prepends it, then splits before each one.
+func metaspacePreTokenizer(spec hfPreTokenizerSpec) (hfPreTokenizer, error) {
+	replacement := spec.Replacement
+	if replacement == "" {
+		replacement = spaceSymbol
+	}
+
+	scheme, err := metaspaceScheme(spec)
+	if err != nil {
+		return nil, err
+	}
+
+	split := spec.Split == nil || *spec.Split
+	find := literalHFRegex(replacement).findAll
+
+	return func(words []string, first bool) []string {
+		var out []string
+
+		for i, word := range words {
+			word = strings.ReplaceAll(word, asciiSpace, replacement)
+
+			prepend := scheme == prependAlways || (scheme == prependFirst && first && i == 0)
+			if prepend && !strings.HasPrefix(word, replacement) {
+				word = replacement + word
+			}
+
+			if !split {
+				out = append(out, word)
+
+				continue
+			}
+
+			out = append(out, splitWord(word, find, behaviorMergedWithNext, false)...)
+		}
+
+		return out
+	}, nil
+}
+
+// metaspaceScheme reads prepend_scheme, falling back to the older
+// add_prefix_space flag.
+func metaspaceScheme(spec hfPreTokenizerSpec) (string, error) {
+	switch spec.PrependScheme {
+	case prependAlways, prependFirst, prependNever:
+		return spec.PrependScheme, nil
+	case "":
+		if spec.AddPrefixSpace == nil || *spec.AddPrefixSpace {
+			return prependAlways, nil
+		}
+
+		return prependNever, nil
+	default:
+		return "", unsupportedComponent(componentPreTokenizer, spec.Type+" prepend_scheme "+spec.PrependScheme)
+	}
+}
+
+// byteLevelPreTokenizer optionally adds a leading space, splits with the
+// GPT-2 pattern, then maps every byte to its printable rune.
+func byteLevelPreTokenizer(spec hfPreTokenizerSpec) (hfPreTokenizer, error) {
+	addPrefixSpace := spec.AddPrefixSpace == nil || *spec.AddPrefixSpace
+	useRegex := spec.UseRegex == nil || *spec.UseRegex
+
+	re, err := compileHFRegex(hfGPT2Pattern)
+	if err != nil {
+		return nil, unsupportedRegex(componentPreTokenizer, spec.Type, err)
+	}
+
+	return func(words []string, _ bool) []string {
+		var out []string
+
+		for _, word := range words {
+			if addPrefixSpace && !strings.HasPrefix(word, byteLevelSpace) {
+				word = byteLevelSpace + word
+			}
+
+			pieces := []string{word}
+			if useRegex {
+				pieces = splitWord(word, re.findAll, behaviorIsolated, false)
+			}
+
+			for _, piece := range pieces {
+				out = append(out, byteLevelEncode(piece))
+			}
+		}
+
+		return out
+	}, nil
+}
+
+func byteLevelEncode(text string) string {
+	var builder strings.Builder
+	builder.Grow(len(text) * 2)
+
+	for i := range len(text) {
+		builder.WriteRune(byteLevelRunes[text[i]])
+	}
+
+	return builder.String()
+}
+
+// buildByteLevelRunes reproduces GPT-2's bytes_to_unicode: printable
+// Latin-1 bytes map to themselves, the rest to code points from U+0100.
+func buildByteLevelRunes() [byteValues]rune {
+	var table [byteValues]rune
+
+	next := rune(byteValues)
+
+	for b := range byteValues {
+		if isByteLevelPrintable(b) {
+			table[b] = rune(b)
+
+			continue
+		}
+
+		table[b] = next
+		next++
+	}
+
+	return table
+}
+
+func isByteLevelPrintable(b int) bool {
+	return ('!' <= b && b <= '~') || (0xA1 <= b && b <= 0xAC) || (0xAE <= b && b <= 0xFF)
+}
+
+// isHFPunctuation matches ASCII punctuation and Unicode punctuation.
+func isHFPunctuation(r rune) bool {
+	return (r < utf8.RuneSelf && unicode.In(r, unicode.P, unicode.S)) || unicode.IsPunct(r)
+}
+
+// runeFinder reports every rune matching keep as its own match.
+func runeFinder(keep func(rune) bool) matchFinder {
+	return func(text string) [][2]int {
+		var matches [][2]int
+
+		for i, r := range text {
+			if keep(r) {
+				matches = append(matches, [2]int{i, i + utf8.RuneLen(r)})
+			}
+		}
+
+		return matches
+	}
+}
+
+func isSplitBehavior(behavior string) bool {
+	switch behavior {
+	case behaviorRemoved, behaviorIsolated, behaviorMergedWithPrevious,
+		behaviorMergedWithNext, behaviorContiguous:
+		return true
+	default:
+		return false
+	}
+}
+
+// splitWord cuts word at matches and applies a split behaviour. With invert
+// the matches are kept and the text between them is the delimiter.
+func splitWord(word string, find matchFinder, behavior string, invert bool) []string {
+	var parts []splitPart
+
+	last := 0
+
+	for _, match := range find(word) {
+		if match[0] > last {
+			parts = append(parts, splitPart{start: last, end: match[0], delim: invert})
+		}
+
+		parts = append(parts, splitPart{start: match[0], end: match[1], delim: !invert})
+		last = match[1]
+	}
+
+	if last < len(word) {
+		parts = append(parts, splitPart{start: last, end: len(word), delim: invert})
+	}
+
+	out := make([]string, 0, len(parts))
+
+	for _, part := range applyBehavior(parts, behavior) {
+		if part.end > part.start {
+			out = append(out, word[part.start:part.end])
+		}
+	}
+
+	return out
+}
+
+// applyBehavior merges or drops delimiter parts the way the Rust library's
+// SplitDelimiterBehavior does.
+func applyBehavior(parts []splitPart, behavior string) []splitPart {
+	switch behavior {
+	case behaviorRemoved:
+		kept := parts[:0:0]
+
+		for _, part := range parts {
+			if !part.delim {
+				kept = append(kept, part)
+			}
+		}
+
+		return kept
+	case behaviorMergedWithPrevious:
+		return mergeWithPrevious(parts)
+	case behaviorMergedWithNext:
+		return mergeWithNext(parts)
+	case behaviorContiguous:
+		return mergeContiguous(parts)
+	default:
+		return parts
+	}
+}
+
+// mergeWithPrevious folds each delimiter that follows a non-delimiter into
+// the part before it.
+func mergeWithPrevious(parts []splitPart) []splitPart {
+	var acc []splitPart
+
+	prevDelim := false
+
+	for _, part := range parts {
+		if part.delim && !prevDelim && len(acc) > 0 {
+			acc[len(acc)-1].end = part.end
+		} else {
+			acc = append(acc, part)
+		}
+
+		prevDelim = part.delim
+	}
+
+	return acc
+}
+
+// mergeWithNext is mergeWithPrevious run from the end of the word.
+func mergeWithNext(parts []splitPart) []splitPart {
+	var acc []splitPart
+
+	prevDelim := false
+
+	for _, part := range slices.Backward(parts) {
+		if part.delim && !prevDelim && len(acc) > 0 {
+			acc[len(acc)-1].start = part.start
+		} else {
+			acc = append(acc, part)
+		}
+
+		prevDelim = part.delim
+	}
+
+	slices.Reverse(acc)
+
+	return acc
+}
+
+func mergeContiguous(parts []splitPart) []splitPart {
+	var acc []splitPart
+
+	for i, part := range parts {
+		if i > 0 && part.delim == parts[i-1].delim {
+			acc[len(acc)-1].end = part.end
+
+			continue
+		}
+
+		acc = append(acc, part)
+	}
+
+	return acc
+}
@@ -0,0 +1,183 @@
+package tokenizer
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	// hfTrailingSpaceLookahead is the one lookaround the common GPT-2 and
+	// Llama 3 split patterns use: a whitespace run that leaves its last
+	// character to the next word. RE2 has no lookahead, so it is matched as
+	// a plain run and shortened afterwards.
+	hfTrailingSpaceLookahead = `\s+(?!\S)`
+	hfLookaheadGroupName     = "hfws"
+	hfLookaheadGroup         = `(?P<` + hfLookaheadGroupName + `>\s+)`
+
+	// Unicode-aware replacements for escapes that RE2 treats as ASCII.
+	hfSpaceClass = `\t\n\v\f\r \x{85}\p{Z}`
+	hfWordClass  = `\p{L}\p{M}\p{Nd}\p{Pc}`
+	hfDigitClass = `\p{Nd}`
+	hfNonDigit   = `\P{Nd}`
+
+	// hfGPT2Pattern is the ByteLevel pre-tokenizer's split pattern.
+	hfGPT2Pattern = `'s|'t|'re|'ve|'m|'ll|'d| ?\p{L}+| ?\p{N}+| ?[^\s\p{L}\p{N}]+|\s+(?!\S)|\s+`
+
+	errLookaroundMsg = "regex lookaround is not supported"
+	errHFRegexFmt    = "regex %q: %w"
+)
+
+var errLookaround = errors.New(errLookaroundMsg)
+
+// hfRegex is a tokenizer.json pattern compiled for RE2. lookahead is the
+// submatch index of the emulated trailing-space lookahead, or -1.
+type hfRegex struct {
+	re        *regexp.Regexp
+	lookahead int
+}
+
+// compileHFRegex translates an Oniguruma pattern from tokenizer.json.
+func compileHFRegex(pattern string) (*hfRegex, error) {
+	translated := strings.ReplaceAll(pattern, hfTrailingSpaceLookahead, hfLookaheadGroup)
+
+	for _, lookaround := range []string{"(?=", "(?!", "(?<=", "(?<!"} {
+		if strings.Contains(translated, lookaround) {
+			return nil, fmt.Errorf(errHFRegexFmt, pattern, errLookaround)
+		}
+	}
+
+	re, err := regexp.Compile(translateRegexClasses(translated))
+	if err != nil {
+		return nil, fmt.Errorf(errHFRegexFmt, pattern, err)
+	}
+
+	return &hfRegex{re: re, lookahead: re.SubexpIndex(hfLookaheadGroupName)}, nil
+}
+
+// literalHFRegex matches text exactly.
+func literalHFRegex(text string) *hfRegex {
+	return &hfRegex{re: regexp.MustCompile(regexp.QuoteMeta(text)), lookahead: -1}
+}
+
+// translateRegexClasses rewrites \s, \w and \d to their Unicode meaning.
+// \S and \W inside brackets keep RE2's ASCII meaning.
+func translateRegexClasses(pattern string) string {
+	var builder strings.Builder
+
+	inClass := false
+
+	for i := 0; i < len(pattern); i++ {
+		c := pattern[i]
+
+		switch {
+		case c == '\\' && i+1 < len(pattern):
+			i++
+			builder.WriteString(translateEscape(pattern[i], inClass))
+		case c == '[' && !inClass:
+			inClass = true
+
+			builder.WriteByte(c)
+		case c == ']' && inClass:
+			inClass = false
+
+			builder.WriteByte(c)
+		default:
+			builder.WriteByte(c)
+		}
+	}
+
+	return builder.String()
+}
+
+func translateEscape(c byte, inClass bool) string {
+	switch {
+	case c == 'd':
+		return hfDigitClass
+	case c == 'D':
+		return hfNonDigit
+	case c == 's' && inClass:
+		return hfSpaceClass
+	case c == 'w' && inClass:
+		return hfWordClass
+	case c == 's':
+		return "[" + hfSpaceClass + "]"
+	case c == 'S' && !inClass:
+		return "[^" + hfSpaceClass + "]"
+	case c == 'w':
+		return "[" + hfWordClass + "]"
+	case c == 'W' && !inClass:
+		return "[^" + hfWordClass + "]"
+	default:
+		return `\` + string(c)
+	}
+}
+
+// findAll returns the byte ranges of successive non-empty matches.
+func (r *hfRegex) findAll(text string) [][2]int {
+	var matches [][2]int
+
+	for pos := 0; pos < len(text); {
+		loc := r.re.FindStringSubmatchIndex(text[pos:])
+		if loc == nil {
+			break
+		}
+
+		start, end := pos+loc[0], pos+loc[1]
+		if r.lookahead >= 0 && loc[2*r.lookahead] >= 0 {
+			end = trimTrailingSpaceRun(text, start, end)
+		}
+
+		if end == start {
+			_, size := utf8.DecodeRuneInString(text[start:])
+			pos = start + size
+
+			continue
+		}
+
+		matches = append(matches, [2]int{start, end})
+		pos = end
+	}
+
+	return matches
+}
+
+// trimTrailingSpaceRun emulates \s+(?!\S): a run followed by a non-space
+// gives up its last character, unless that would leave it empty, in which
+// case the pattern's plain \s+ alternative takes the whole run.
+func trimTrailingSpaceRun(text string, start, end int) int {
+	if end == len(text) {
+		return end
+	}
+
+	_, size := utf8.DecodeLastRuneInString(text[start:end])
+	if end-size == start {
+		return end
+	}
+
+	return end - size
+}
+
+// replaceAll substitutes content for every match.
+func (r *hfRegex) replaceAll(text, content string) string {
+	matches := r.findAll(text)
+	if len(matches) == 0 {
+		return text
+	}
+
+	var builder strings.Builder
+
+	last := 0
+
+	for _, match := range matches {
+		builder.WriteString(text[last:match[0]])
+		builder.WriteString(content)
+		last = match[1]
+	}
+
+	builder.WriteString(text[last:])
+
+	return builder.String()
+}
@@ -0,0 +1,164 @@
+package tokenizer_test
+
+import (
+	"errors"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+
+	tokenizer "github.com/nnikolov3/ai-tokenizer"
+)
+
+// Hand-written tokenizer.json fixtures.
+const (
+	HFBPEPath         = "testdata/hf_bpe.json"
+	HFWordPiecePath   = "testdata/hf_wordpiece.json"
+	HFUnigramPath     = "testdata/hf_unigram.json"
+	HFUnsupportedPath = "testdata/hf_unsupported.json"
+	HFMissingPath     = "testdata/missing.json"
+
+	LoadHFFormat       = "LoadHuggingFace(%q) error = %v"
+	LoadHFErrFormat    = "LoadHuggingFace(%q) error = %v, want %v"
+	ParseHFErrFormat   = "ParseHuggingFace(%q) error = %v, want %v"
+	HFErrNameFormat    = "LoadHuggingFace(%q) error = %q, want it to name %q"
+	HFNormalizeFormat  = "Normalize(%q) = %q, want %q"
+	HFSpecialFormat    = "%s SpecialTokenCount() = %d, want %d"
+	UnsupportedHFType  = `pre-tokenizer "UnicodeScripts"`
+	MalformedHFContent = `{"model": {"type": "BPE", "vocab": {"a": 0}, "merges": ["a b"]}}`
+)
+
+type HFTestCase struct {
+	name     string
+	input    string
+	expected []int
+}
+
+// IDs refer to the vocabulary in testdata/hf_bpe.json: 7 !, 8 Ġ,
+// 12 hello, 17 Ġworld, 18 <|endoftext|>.
+func getHFBPETestCases() []HFTestCase {
+	return []HFTestCase{
+		{"merges by rank", "hello world!", []int{12, 17, 7}},
+		{"added token split out", "hello<|endoftext|>", []int{12, 18}},
+		{"space run keeps last space for the word", "hello  world", []int{12, 8, 17}},
+		{"trailing spaces", "hello  ", []int{12, 8, 8}},
+		{"unknown bytes dropped without unk", "hello é", []int{12, 8}},
+		{"empty", "", nil},
+	}
+}
+
+// IDs refer to testdata/hf_wordpiece.json: 1 [UNK], 2 [CLS], 3 [SEP],
+// 4 [MASK], 5 hello, 6 world, 7 !, 8 un, 9 ##aff, 10 ##able, 11 cafe,
+// 12 中, 13 ",".
+func getHFWordPieceTestCases() []HFTestCase {
+	return []HFTestCase{
+		{"lowercase and punctuation", "Hello, World!", []int{2, 5, 13, 6, 7, 3}},
+		{"subwords and stripped accents", "unaffable café", []int{2, 8, 9, 10, 11, 3}},
+		{"chinese characters split", "中文", []int{2, 12, 1, 3}},
+		{"special token kept", "[MASK] hello", []int{2, 4, 5, 3}},
+		{"empty still wrapped", "", []int{2, 3}},
+	}
+}
+
+// IDs refer to testdata/hf_unigram.json: 0 <unk>, 1 </s>, 2 ▁hello,
+// 3 ▁world, 4 ▁, 5 he.
+func getHFUnigramTestCases() []HFTestCase {
+	return []HFTestCase{
+		{"metaspace words", "hello   world", []int{2, 3, 1}},
+		{"nfkc folds width", "ｈｅｌｌｏ", []int{2, 1}},
+		{"unknown character", "hex", []int{4, 5, 0, 1}},
+	}
+}
+
+func loadHF(t *testing.T, path string) *tokenizer.HFTokenizer {
+	t.Helper()
+
+	tok, err := tokenizer.LoadHuggingFace(path)
+	if err != nil {
+		t.Fatalf(LoadHFFormat, path, err)
+	}
+
+	return tok
+}
+
+func runHFTestCases(t *testing.T, path string, testCases []HFTestCase) {
+	t.Helper()
+
+	tok := loadHF(t, path)
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			t.Parallel()
+
+			if got := tok.Encode(testCase.input); !slices.Equal(got, testCase.expected) {
+				t.Errorf(EncodeFormat, testCase.input, got, testCase.expected)
+			}
+		})
+	}
+}
+
+func TestHFBPE(t *testing.T) {
+	t.Parallel()
+	runHFTestCases(t, HFBPEPath, getHFBPETestCases())
+}
+
+func TestHFWordPiece(t *testing.T) {
+	t.Parallel()
+	runHFTestCases(t, HFWordPiecePath, getHFWordPieceTestCases())
+}
+
+func TestHFUnigram(t *testing.T) {
+	t.Parallel()
+	runHFTestCases(t, HFUnigramPath, getHFUnigramTestCases())
+}
+
+func TestHFNormalize(t *testing.T) {
+	t.Parallel()
+
+	tok := loadHF(t, HFWordPiecePath)
+
+	input, want := "Café 中", "cafe  中 "
+	if got := tok.Normalize(input); got != want {
+		t.Errorf(HFNormalizeFormat, input, got, want)
+	}
+}
+
+func TestHFSpecialTokenCount(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		path string
+		want int
+	}{
+		{path: HFBPEPath, want: 0},
+		{path: HFWordPiecePath, want: 2},
+		{path: HFUnigramPath, want: 1},
+	}
+
+	for _, tt := range tests {
+		if got := loadHF(t, tt.path).SpecialTokenCount(); got != tt.want {
+			t.Errorf(HFSpecialFormat, tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestHFRejectsBadFiles(t *testing.T) {
+	t.Parallel()
+
+	_, err := tokenizer.LoadHuggingFace(HFUnsupportedPath)
+	if !errors.Is(err, tokenizer.ErrUnsupportedComponent) {
+		t.Errorf(LoadHFErrFormat, HFUnsupportedPath, err, tokenizer.ErrUnsupportedComponent)
+	} else if !strings.Contains(err.Error(), UnsupportedHFType) {
+		t.Errorf(HFErrNameFormat, HFUnsupportedPath, err, UnsupportedHFType)
+	}
+
+	_, err = tokenizer.ParseHuggingFace([]byte(MalformedHFContent))
+	if !errors.Is(err, tokenizer.ErrInvalidHFTokenizer) {
+		t.Errorf(ParseHFErrFormat, MalformedHFContent, err, tokenizer.ErrInvalidHFTokenizer)
+	}
+
+	_, err = tokenizer.LoadHuggingFace(HFMissingPath)
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Errorf(LoadHFErrFormat, HFMissingPath, err, os.ErrNotExist)
+	}
+}
@@ -0,0 +1,98 @@
+package tokenizer
+
+import (
+	"context"
+	"log/slog"
+)
+
+// Attribute keys of the debug records a tokenizer logs. They are stable, so
+// log queries keep working across releases.
+const (
+	LogKeyModel           = "model"
+	LogKeyBytes           = "bytes"
+	LogKeyTokens          = "tokens"
+	LogKeyMethod          = "method"
+	LogKeyCache           = "cache"
+	LogKeySpecialTokens   = "special_tokens"
+	LogKeyNormalizedBytes = "normalized_bytes"
+	LogKeyInvalidUTF8     = "invalid_utf8"
+)
+
+// Values of LogKeyMethod and LogKeyCache.
+const (
+	LogMethodEncoder       = "encoder"
+	LogMethodHeuristic     = "heuristic"
+	LogMethodSpecialTokens = "special_tokens"
+	LogCacheHit            = "hit"
+	LogCacheMiss           = "miss"
+
+	logMsgEstimate  = "estimate tokens"
+	logMsgNormalize = "normalize text"
+	logMsgCache     = "cache lookup"
+)
+
+// WithLogger traces counting decisions to logger at slog.LevelDebug: which
+// method counted a text, how many special tokens it held, cache hits and
+// misses, and how normalization changed its size. Records are only built
+// when the logger is enabled for debug, so an info-level logger costs a
+// level check per call. A nil logger disables tracing, the default.
+func WithLogger(logger *slog.Logger) Option {
+	return func(t *Tokenizer) {
+		t.logger = logger
+	}
+}
+
+// tracing reports whether debug records would be written.
+func (t *Tokenizer) tracing() bool {
+	return t.logger != nil && t.logger.Enabled(context.Background(), slog.LevelDebug)
+}
+
+func (t *Tokenizer) trace(msg string, attrs ...slog.Attr) {
+	attrs = append(attrs, slog.String(LogKeyModel, t.model))
+	t.logger.LogAttrs(context.Background(), slog.LevelDebug, msg, attrs...)
+}
+
+// traceEstimate logs how estimateTokens counted text.
+func (t *Tokenizer) traceEstimate(text, method string, specials, tokens int) {
+	if !t.tracing() {
+		return
+	}
+
+	t.trace(logMsgEstimate,
+		slog.String(LogKeyMethod, method),
+		slog.Int(LogKeyBytes, len(text)),
+		slog.Int(LogKeySpecialTokens, specials),
+		slog.Int(LogKeyTokens, tokens),
+	)
+}
+
+// traceNormalize logs the size change of a normalization.
+func (t *Tokenizer) traceNormalize(text, normalized string) {
+	if !t.tracing() {
+		return
+	}
+
+	t.trace(logMsgNormalize,
+		slog.Int(LogKeyBytes, len(text)),
+		slog.Int(LogKeyNormalizedBytes, len(normalized)),
+		slog.String(LogKeyInvalidUTF8, t.invalidUTF8.String()),
+	)
+}
+
+// traceCache logs a cache lookup.
+func (t *Tokenizer) traceCache(text string, found bool, tokens int) {
+	if !t.tracing() {
+		return
+	}
+
+	result := LogCacheMiss
+	if found {
+		result = LogCacheHit
+	}
+
+	t.trace(logMsgCache,
+		slog.String(LogKeyCache, result),
+		slog.Int(LogKeyBytes, len(text)),
+		slog.Int(LogKeyTokens, tokens),
+	)
+}
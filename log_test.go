@@ -0,0 +1,137 @@
+package tokenizer_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"slices"
+	"strings"
+	"testing"
+
+	tokenizer "github.com/nnikolov3/ai-tokenizer"
+)
+
+const (
+	LogRecordsFormat = "%s: logged %v, want %v"
+	LogDecodeFormat  = "decoding log line %q: %v"
+	LogCountFormat   = "%s: EstimateTokens() = %d with logging, want %d"
+)
+
+// logRecord is the part of a JSON log line the tests check.
+type logRecord struct {
+	Msg           string `json:"msg"`
+	Model         string `json:"model"`
+	Method        string `json:"method"`
+	Cache         string `json:"cache"`
+	Tokens        int    `json:"tokens"`
+	SpecialTokens int    `json:"special_tokens"`
+}
+
+func decodeLog(t *testing.T, buf *bytes.Buffer) []logRecord {
+	t.Helper()
+
+	var records []logRecord
+
+	for line := range strings.Lines(buf.String()) {
+		var rec logRecord
+
+		err := json.Unmarshal([]byte(line), &rec)
+		if err != nil {
+			t.Fatalf(LogDecodeFormat, line, err)
+		}
+
+		records = append(records, rec)
+	}
+
+	return records
+}
+
+func newTestLogger(buf *bytes.Buffer, level slog.Level) *slog.Logger {
+	return slog.New(slog.NewJSONHandler(buf, &slog.HandlerOptions{AddSource: false, Level: level, ReplaceAttr: nil}))
+}
+
+func TestWithLoggerTracesEstimates(t *testing.T) {
+	t.Parallel()
+
+	plain := tokenizer.NewTokenizer()
+
+	tests := []struct {
+		name   string
+		text   string
+		method string
+		specs  int
+	}{
+		{name: "heuristic", text: HelloWorld, method: tokenizer.LogMethodHeuristic, specs: 0},
+		{name: "special tokens", text: ChatPrompt, method: tokenizer.LogMethodSpecialTokens, specs: 2},
+	}
+
+	for _, tt := range tests {
+		var buf bytes.Buffer
+
+		tok := tokenizer.NewTokenizer(tokenizer.WithLogger(newTestLogger(&buf, slog.LevelDebug)))
+
+		if got, want := tok.EstimateTokens(tt.text), plain.EstimateTokens(tt.text); got != want {
+			t.Errorf(LogCountFormat, tt.name, got, want)
+		}
+
+		records := decodeLog(t, &buf)
+		last := records[len(records)-1]
+
+		want := logRecord{
+			Msg:           "estimate tokens",
+			Model:         tokenizer.DefaultModel,
+			Method:        tt.method,
+			Cache:         "",
+			Tokens:        plain.EstimateTokens(tt.text),
+			SpecialTokens: tt.specs,
+		}
+		if last != want {
+			t.Errorf(LogRecordsFormat, tt.name, last, want)
+		}
+
+		// Normalization of each text segment is traced before the estimate.
+		if records[0].Msg != "normalize text" {
+			t.Errorf(LogRecordsFormat, tt.name, records[0], "normalize text")
+		}
+	}
+}
+
+func TestWithLoggerTracesCache(t *testing.T) {
+	t.Parallel()
+
+	var buf bytes.Buffer
+
+	tok := tokenizer.NewTokenizer(
+		tokenizer.WithCache(tokenizer.NewCache(tokenizer.DefaultCacheEntries)),
+		tokenizer.WithLogger(newTestLogger(&buf, slog.LevelDebug)),
+	)
+
+	tok.EstimateTokens(HelloWorld)
+	tok.EstimateTokens(HelloWorld)
+
+	var caches []string
+
+	for _, rec := range decodeLog(t, &buf) {
+		if rec.Cache != "" {
+			caches = append(caches, rec.Cache)
+		}
+	}
+
+	if want := []string{tokenizer.LogCacheMiss, tokenizer.LogCacheHit}; !slices.Equal(caches, want) {
+		t.Errorf(LogRecordsFormat, "cache", caches, want)
+	}
+}
+
+func TestWithLoggerSilentAboveDebug(t *testing.T) {
+	t.Parallel()
+
+	var buf bytes.Buffer
+
+	tok := tokenizer.NewTokenizer(tokenizer.WithLogger(newTestLogger(&buf, slog.LevelInfo)))
+	tok.EstimateTokens(ChatPrompt)
+	tok.Normalize(HelloWorld)
+
+	if buf.Len() != 0 {
+		t.Errorf(LogRecordsFormat, "info level", buf.String(), "nothing")
+	}
+}
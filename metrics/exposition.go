@@ -0,0 +1,216 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"maps"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+
+	tokenizer "github.com/nnikolov3/ai-tokenizer"
+)
+
+// Metric names, all under the ai_tokenizer_ namespace.
+const (
+	MetricRequests       = "ai_tokenizer_requests_total"
+	MetricInputBytes     = "ai_tokenizer_input_bytes_total"
+	MetricTokens         = "ai_tokenizer_tokens_total"
+	MetricDuration       = "ai_tokenizer_estimate_duration_seconds"
+	MetricInputSize      = "ai_tokenizer_input_size_bytes"
+	MetricCacheHits      = "ai_tokenizer_cache_hits_total"
+	MetricCacheMisses    = "ai_tokenizer_cache_misses_total"
+	MetricCacheEvictions = "ai_tokenizer_cache_evictions_total"
+	MetricCacheEntries   = "ai_tokenizer_cache_entries"
+	MetricCacheHitRatio  = "ai_tokenizer_cache_hit_ratio"
+
+	helpRequests       = "Texts estimated."
+	helpInputBytes     = "Bytes of text estimated."
+	helpTokens         = "Tokens counted."
+	helpDuration       = "Time to estimate one text."
+	helpInputSize      = "Size of the estimated texts."
+	helpCacheHits      = "Estimates answered from the cache."
+	helpCacheMisses    = "Estimates computed because the cache had no entry."
+	helpCacheEvictions = "Entries dropped to make room."
+	helpCacheEntries   = "Entries in the cache."
+	helpCacheHitRatio  = "Fraction of cache lookups that were hits."
+
+	typeCounter   = "counter"
+	typeGauge     = "gauge"
+	typeHistogram = "histogram"
+
+	labelModel = "model"
+	labelCache = "cache"
+	labelLE    = "le"
+	leInf      = "+Inf"
+
+	suffixBucket = "_bucket"
+	suffixSum    = "_sum"
+	suffixCount  = "_count"
+
+	headerFmt       = "# HELP %s %s\n# TYPE %s %s\n"
+	sampleFmt       = "%s{%s} %s\n"
+	labelSeparator  = ","
+	errWriteMetrics = "write metrics: %w"
+)
+
+// labelEscaper escapes label values as the exposition format requires:
+// backslash, double quote and newline, and nothing else.
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// snapshot is a consistent copy of a Registry, taken under its lock.
+type snapshot struct {
+	models map[string]modelStats
+	caches map[string]tokenizer.CacheStats
+}
+
+// WriteTo writes every metric in the text exposition format, models and
+// caches sorted by name.
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	snap := r.snapshot()
+	ew := &expositionWriter{w: w, n: 0, err: nil}
+	models := slices.Sorted(maps.Keys(snap.models))
+	caches := slices.Sorted(maps.Keys(snap.caches))
+
+	ew.counter(MetricRequests, helpRequests, labelModel, models,
+		func(name string) uint64 { return snap.models[name].requests })
+	ew.counter(MetricInputBytes, helpInputBytes, labelModel, models,
+		func(name string) uint64 { return snap.models[name].bytes })
+	ew.counter(MetricTokens, helpTokens, labelModel, models,
+		func(name string) uint64 { return snap.models[name].tokens })
+	ew.histogram(MetricDuration, helpDuration, models,
+		func(name string) histogram { return snap.models[name].duration })
+	ew.histogram(MetricInputSize, helpInputSize, models,
+		func(name string) histogram { return snap.models[name].size })
+
+	if len(caches) > 0 {
+		ew.counter(MetricCacheHits, helpCacheHits, labelCache, caches,
+			func(name string) uint64 { return snap.caches[name].Hits })
+		ew.counter(MetricCacheMisses, helpCacheMisses, labelCache, caches,
+			func(name string) uint64 { return snap.caches[name].Misses })
+		ew.counter(MetricCacheEvictions, helpCacheEvictions, labelCache, caches,
+			func(name string) uint64 { return snap.caches[name].Evictions })
+		ew.gauge(MetricCacheEntries, helpCacheEntries, labelCache, caches,
+			func(name string) float64 { return float64(snap.caches[name].Entries) })
+		ew.gauge(MetricCacheHitRatio, helpCacheHitRatio, labelCache, caches,
+			func(name string) float64 { return snap.caches[name].HitRate() })
+	}
+
+	if ew.err != nil {
+		return ew.n, fmt.Errorf(errWriteMetrics, ew.err)
+	}
+
+	return ew.n, nil
+}
+
+func (r *Registry) snapshot() snapshot {
+	r.mu.Lock()
+
+	snap := snapshot{
+		models: make(map[string]modelStats, len(r.models)),
+		caches: make(map[string]tokenizer.CacheStats, len(r.caches)),
+	}
+
+	for name, stats := range r.models {
+		copied := *stats
+		copied.duration.counts = slices.Clone(stats.duration.counts)
+		copied.size.counts = slices.Clone(stats.size.counts)
+		snap.models[name] = copied
+	}
+
+	caches := maps.Clone(r.caches)
+
+	r.mu.Unlock()
+
+	// Caches have their own locks; read them without holding ours.
+	for name, c := range caches {
+		snap.caches[name] = c.Stats()
+	}
+
+	return snap
+}
+
+// expositionWriter writes samples until the first error, which it keeps.
+type expositionWriter struct {
+	w   io.Writer
+	n   int64
+	err error
+}
+
+func (ew *expositionWriter) printf(format string, args ...any) {
+	if ew.err != nil {
+		return
+	}
+
+	n, err := fmt.Fprintf(ew.w, format, args...)
+	ew.n += int64(n)
+	ew.err = err
+}
+
+func (ew *expositionWriter) header(name, help, kind string) {
+	ew.printf(headerFmt, name, help, name, kind)
+}
+
+func (ew *expositionWriter) sample(name, labels, value string) {
+	ew.printf(sampleFmt, name, labels, value)
+}
+
+// counter writes one sample per key, labelled label="key".
+func (ew *expositionWriter) counter(name, help, label string, keys []string, value func(string) uint64) {
+	ew.header(name, help, typeCounter)
+
+	for _, key := range keys {
+		ew.sample(name, labels(label, key), formatUint(value(key)))
+	}
+}
+
+func (ew *expositionWriter) gauge(name, help, label string, keys []string, value func(string) float64) {
+	ew.header(name, help, typeGauge)
+
+	for _, key := range keys {
+		ew.sample(name, labels(label, key), formatFloat(value(key)))
+	}
+}
+
+// histogram writes cumulative buckets, then the sum and count.
+func (ew *expositionWriter) histogram(name, help string, models []string, value func(string) histogram) {
+	ew.header(name, help, typeHistogram)
+
+	for _, model := range models {
+		h := value(model)
+		cumulative := uint64(0)
+
+		for i, bound := range h.bounds {
+			cumulative += h.counts[i]
+			ew.sample(name+suffixBucket, labels(labelModel, model, labelLE, formatFloat(bound)), formatUint(cumulative))
+		}
+
+		ew.sample(name+suffixBucket, labels(labelModel, model, labelLE, leInf), formatUint(h.count))
+		ew.sample(name+suffixSum, labels(labelModel, model), formatFloat(h.sum))
+		ew.sample(name+suffixCount, labels(labelModel, model), formatUint(h.count))
+	}
+}
+
+// labels formats name/value pairs as the inside of a label set.
+func labels(pairs ...string) string {
+	parts := make([]string, 0, len(pairs)/2)
+
+	for i := 0; i+1 < len(pairs); i += 2 {
+		parts = append(parts, pairs[i]+`="`+labelEscaper.Replace(pairs[i+1])+`"`)
+	}
+
+	return strings.Join(parts, labelSeparator)
+}
+
+func formatUint(v uint64) string {
+	return strconv.FormatUint(v, 10)
+}
+
+func formatFloat(v float64) string {
+	if math.IsInf(v, 1) {
+		return leInf
+	}
+
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
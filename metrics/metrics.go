@@ -0,0 +1,132 @@
+// Package metrics collects token-estimation metrics and serves them in the
+// Prometheus text exposition format, without a client library or an
+// external service. A Registry is an http.Handler for a /metrics endpoint
+// and an io.WriterTo for end-of-run summaries.
+package metrics
+
+import (
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	tokenizer "github.com/nnikolov3/ai-tokenizer"
+)
+
+const (
+	// ContentType is the media type of the text exposition format.
+	ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+	contentTypeHeader = "Content-Type"
+)
+
+var (
+	// DurationBuckets are the upper bounds, in seconds, of the estimation
+	// latency histogram: 1µs to 1s by powers of ten.
+	DurationBuckets = []float64{1e-6, 1e-5, 1e-4, 1e-3, 1e-2, 1e-1, 1}
+	// SizeBuckets are the upper bounds, in bytes, of the input size
+	// histogram: 64 B to 16 MiB by powers of four.
+	SizeBuckets = []float64{64, 256, 1024, 4096, 16384, 65536, 262144, 1048576, 4194304, 16777216}
+)
+
+// Registry holds the metrics of one process. It is safe for concurrent use.
+type Registry struct {
+	mu     sync.Mutex
+	models map[string]*modelStats
+	caches map[string]*tokenizer.Cache
+}
+
+// modelStats are the counters and histograms of one model.
+type modelStats struct {
+	requests uint64
+	bytes    uint64
+	tokens   uint64
+	duration histogram
+	size     histogram
+}
+
+// histogram counts observations per bucket; counts[i] is the number of
+// observations at most bounds[i] and above bounds[i-1]. The last count is
+// the +Inf bucket.
+type histogram struct {
+	bounds []float64
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+// New returns an empty Registry.
+func New() *Registry {
+	return &Registry{
+		mu:     sync.Mutex{},
+		models: map[string]*modelStats{},
+		caches: map[string]*tokenizer.Cache{},
+	}
+}
+
+// Observe records one estimation of inputBytes bytes into tokens tokens by
+// model, which took elapsed.
+func (r *Registry) Observe(model string, inputBytes, tokens int, elapsed time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stats, ok := r.models[model]
+	if !ok {
+		stats = &modelStats{
+			requests: 0,
+			bytes:    0,
+			tokens:   0,
+			duration: newHistogram(DurationBuckets),
+			size:     newHistogram(SizeBuckets),
+		}
+		r.models[model] = stats
+	}
+
+	stats.requests++
+	stats.bytes += uint64(max(inputBytes, 0))
+	stats.tokens += uint64(max(tokens, 0))
+	stats.duration.observe(elapsed.Seconds())
+	stats.size.observe(float64(inputBytes))
+}
+
+// Estimate returns tok.EstimateTokens(text) and records it.
+func (r *Registry) Estimate(tok *tokenizer.Tokenizer, text string) int {
+	start := time.Now()
+	tokens := tok.EstimateTokens(text)
+	r.Observe(tok.GetModel(), len(text), tokens, time.Since(start))
+
+	return tokens
+}
+
+// AddCache reports the counters and hit ratio of c under name. Adding a
+// name again replaces its cache.
+func (r *Registry) AddCache(name string, c *tokenizer.Cache) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.caches[name] = c
+}
+
+// ServeHTTP serves the metrics in the text exposition format.
+func (r *Registry) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set(contentTypeHeader, ContentType)
+
+	// The status is already sent; a failed write has no one to report to.
+	_, _ = r.WriteTo(w)
+}
+
+func newHistogram(bounds []float64) histogram {
+	return histogram{
+		bounds: bounds,
+		counts: make([]uint64, len(bounds)+1),
+		sum:    0,
+		count:  0,
+	}
+}
+
+func (h *histogram) observe(value float64) {
+	i, _ := slices.BinarySearch(h.bounds, value)
+	h.counts[i]++
+	h.sum += value
+	h.count++
+}
@@ -0,0 +1,139 @@
+package metrics_test
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	tokenizer "github.com/nnikolov3/ai-tokenizer"
+	"github.com/nnikolov3/ai-tokenizer/metrics"
+)
+
+const (
+	MissingFormat     = "%s: output lacks %q:\n%s"
+	UnexpectedFormat  = "%s: output has %q:\n%s"
+	ContentTypeFormat = "Content-Type = %q, want %q"
+	WriteErrFormat    = "WriteTo() error = %v, want %v"
+	WriteLenFormat    = "WriteTo() = %d, wrote %d bytes"
+	EstimateFormat    = "Estimate() = %d, want %d"
+)
+
+func TestRegistryExposition(t *testing.T) {
+	t.Parallel()
+
+	reg := metrics.New()
+	reg.Observe("simple", 100, 30, 50*time.Microsecond)
+	reg.Observe("simple", 5000, 1200, 2*time.Millisecond)
+	reg.Observe(`odd "name"`, 64, 10, time.Microsecond)
+
+	var out strings.Builder
+
+	n, err := reg.WriteTo(&out)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if int(n) != out.Len() {
+		t.Errorf(WriteLenFormat, n, out.Len())
+	}
+
+	for _, want := range []string{
+		"# TYPE ai_tokenizer_requests_total counter\n",
+		`ai_tokenizer_requests_total{model="simple"} 2` + "\n",
+		`ai_tokenizer_requests_total{model="odd \"name\""} 1` + "\n",
+		`ai_tokenizer_input_bytes_total{model="simple"} 5100` + "\n",
+		`ai_tokenizer_tokens_total{model="simple"} 1230` + "\n",
+		"# TYPE ai_tokenizer_estimate_duration_seconds histogram\n",
+		`ai_tokenizer_estimate_duration_seconds_bucket{model="simple",le="1e-05"} 0` + "\n",
+		`ai_tokenizer_estimate_duration_seconds_bucket{model="simple",le="0.0001"} 1` + "\n",
+		`ai_tokenizer_estimate_duration_seconds_bucket{model="simple",le="0.01"} 2` + "\n",
+		`ai_tokenizer_estimate_duration_seconds_bucket{model="simple",le="+Inf"} 2` + "\n",
+		`ai_tokenizer_estimate_duration_seconds_count{model="simple"} 2` + "\n",
+		// Bounds are inclusive: 64 bytes falls in the le="64" bucket.
+		`ai_tokenizer_input_size_bytes_bucket{model="odd \"name\"",le="64"} 1` + "\n",
+		`ai_tokenizer_input_size_bytes_bucket{model="simple",le="1024"} 1` + "\n",
+		`ai_tokenizer_input_size_bytes_sum{model="simple"} 5100` + "\n",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf(MissingFormat, "exposition", want, out.String())
+		}
+	}
+
+	// Without a cache there are no cache metrics.
+	if strings.Contains(out.String(), metrics.MetricCacheHitRatio) {
+		t.Errorf(UnexpectedFormat, "no cache", metrics.MetricCacheHitRatio, out.String())
+	}
+}
+
+func TestRegistryEstimateAndCache(t *testing.T) {
+	t.Parallel()
+
+	cache := tokenizer.NewCache(tokenizer.DefaultCacheEntries)
+	tok := tokenizer.NewTokenizer(tokenizer.WithCache(cache))
+	reg := metrics.New()
+	reg.AddCache("estimates", cache)
+
+	for range 4 {
+		if got, want := reg.Estimate(tok, "hello world"), tokenizer.NewTokenizer().EstimateTokens("hello world"); got != want {
+			t.Errorf(EstimateFormat, got, want)
+		}
+	}
+
+	var out strings.Builder
+
+	_, err := reg.WriteTo(&out)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{
+		`ai_tokenizer_requests_total{model="simple"} 4` + "\n",
+		`ai_tokenizer_input_bytes_total{model="simple"} 44` + "\n",
+		`ai_tokenizer_cache_hits_total{cache="estimates"} 3` + "\n",
+		`ai_tokenizer_cache_misses_total{cache="estimates"} 1` + "\n",
+		`ai_tokenizer_cache_entries{cache="estimates"} 1` + "\n",
+		`ai_tokenizer_cache_hit_ratio{cache="estimates"} 0.75` + "\n",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf(MissingFormat, "cache", want, out.String())
+		}
+	}
+}
+
+func TestRegistryServeHTTP(t *testing.T) {
+	t.Parallel()
+
+	reg := metrics.New()
+	reg.Observe("simple", 10, 3, time.Millisecond)
+
+	w := httptest.NewRecorder()
+	reg.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	if got := w.Header().Get("Content-Type"); got != metrics.ContentType {
+		t.Errorf(ContentTypeFormat, got, metrics.ContentType)
+	}
+
+	if want := `ai_tokenizer_tokens_total{model="simple"} 3`; !strings.Contains(w.Body.String(), want) {
+		t.Errorf(MissingFormat, "http", want, w.Body.String())
+	}
+}
+
+type failingWriter struct{}
+
+var errWriteFailed = errors.New("write failed")
+
+func (failingWriter) Write([]byte) (int, error) {
+	return 0, errWriteFailed
+}
+
+func TestRegistryWriteError(t *testing.T) {
+	t.Parallel()
+
+	_, err := metrics.New().WriteTo(failingWriter{})
+	if !errors.Is(err, errWriteFailed) {
+		t.Errorf(WriteErrFormat, err, errWriteFailed)
+	}
+}
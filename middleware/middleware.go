@@ -0,0 +1,345 @@
+// Package middleware provides net/http middleware that estimates the prompt
+// tokens of OpenAI-style chat/completions and completions requests and
+// rejects requests over a token budget before they reach the handler.
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	tokenizer "github.com/nnikolov3/ai-tokenizer"
+	"github.com/nnikolov3/ai-tokenizer/metrics"
+)
+
+const (
+	// HeaderPromptTokens carries the estimated prompt tokens on the
+	// response, and on the request passed to the next handler.
+	HeaderPromptTokens = "X-Prompt-Tokens"
+	// HeaderTokenLimit carries the budget when one is set.
+	HeaderTokenLimit = "X-Token-Limit"
+	// HeaderTokenModel names the model that estimated the count.
+	HeaderTokenModel = "X-Token-Model"
+
+	// DefaultMaxBodyBytes bounds the body read for counting.
+	DefaultMaxBodyBytes = 10 << 20
+
+	// Error types and codes, in the shape OpenAI clients already handle.
+	ErrorTypeInvalidRequest = "invalid_request_error"
+	ErrorCodeTooManyTokens  = "context_length_exceeded"
+	ErrorCodeBodyTooLarge   = "request_too_large"
+	ErrorCodeInvalidJSON    = "invalid_json"
+
+	contentTypeHeader = "Content-Type"
+	contentTypeJSON   = "application/json"
+	jsonSuffix        = "+json"
+	jsonNull          = "null"
+
+	errNoPromptMsg      = "request has neither messages nor prompt"
+	errTooManyTokensFmt = "prompt has about %d tokens, over the limit of %d"
+	errBodyTooLargeFmt  = "request body is over %d bytes"
+	errInvalidBodyFmt   = "cannot read prompt: %v"
+
+	// Log record messages and the keys added to the tokenizer's.
+	LogMsgCounted  = "request counted"
+	LogMsgRejected = "request rejected"
+	LogKeyPath     = "path"
+	LogKeyStatus   = "status"
+	LogKeyCode     = "code"
+	LogKeyLimit    = "limit"
+)
+
+// Count is the estimate attached to the request context.
+type Count struct {
+	// Model is the "model" field of the request, if any.
+	Model string
+	// Estimator is the tokenizer model that produced PromptTokens.
+	Estimator    string
+	PromptTokens int
+	MaxTokens    int
+}
+
+// ErrorBody is the JSON body of rejected requests.
+type ErrorBody struct {
+	Error ErrorDetail `json:"error"`
+}
+
+// ErrorDetail follows the OpenAI error object, with the counts added.
+type ErrorDetail struct {
+	Message      string `json:"message"`
+	Type         string `json:"type"`
+	Code         string `json:"code"`
+	PromptTokens int    `json:"prompt_tokens,omitempty"`
+	MaxTokens    int    `json:"max_tokens,omitempty"`
+}
+
+// Option configures a Budget.
+type Option func(*Budget)
+
+// Budget is the token-budget middleware. It is safe for concurrent use.
+type Budget struct {
+	tok          *tokenizer.Tokenizer
+	maxTokens    int
+	maxBodyBytes int64
+	upperBound   bool
+	paths        []string
+	metrics      *metrics.Registry
+	logger       *slog.Logger
+}
+
+// countKey is the context key of the Count.
+type countKey struct{}
+
+// WithMaxTokens rejects prompts over n tokens with 413. Zero, the default,
+// only counts.
+func WithMaxTokens(n int) Option {
+	return func(b *Budget) {
+		b.maxTokens = max(n, 0)
+	}
+}
+
+// WithMaxBodyBytes rejects bodies over n bytes with 413 without counting
+// them. The default is DefaultMaxBodyBytes.
+func WithMaxBodyBytes(n int64) Option {
+	return func(b *Budget) {
+		b.maxBodyBytes = n
+	}
+}
+
+// WithUpperBound checks the budget against the tokenizer's UpperBound
+// instead of its estimate, for budgets that must not be exceeded.
+func WithUpperBound() Option {
+	return func(b *Budget) {
+		b.upperBound = true
+	}
+}
+
+// WithPaths counts only requests to the given URL paths, such as
+// "/v1/chat/completions"; others pass through untouched. By default every
+// request with a JSON body is a candidate.
+func WithPaths(paths ...string) Option {
+	return func(b *Budget) {
+		b.paths = append(b.paths, paths...)
+	}
+}
+
+// WithMetrics records every counted request in reg, under the tokenizer's
+// model name. Serve reg on /metrics to expose them.
+func WithMetrics(reg *metrics.Registry) Option {
+	return func(b *Budget) {
+		b.metrics = reg
+	}
+}
+
+// WithLogger logs each counted request at debug and each rejection at
+// warn. The tokenizer's own tracing is configured separately, with
+// tokenizer.WithLogger.
+func WithLogger(logger *slog.Logger) Option {
+	return func(b *Budget) {
+		b.logger = logger
+	}
+}
+
+// New returns a Budget that estimates prompts with tok.
+func New(tok *tokenizer.Tokenizer, opts ...Option) *Budget {
+	b := &Budget{
+		tok:          tok,
+		maxTokens:    0,
+		maxBodyBytes: DefaultMaxBodyBytes,
+		upperBound:   false,
+		paths:        nil,
+		metrics:      nil,
+		logger:       nil,
+	}
+
+	for _, opt := range opts {
+		opt(b)
+	}
+
+	return b
+}
+
+// CountFromContext returns the Count the middleware attached to a request.
+func CountFromContext(ctx context.Context) (Count, bool) {
+	count, ok := ctx.Value(countKey{}).(Count)
+
+	return count, ok
+}
+
+// Handler wraps next. Requests without a body, with a Content-Type other
+// than JSON, or to paths outside WithPaths pass through untouched. A JSON
+// body is read and, when it has "messages" or "prompt", counted; either
+// way it is restored for next. Malformed JSON gets 400, and bodies or
+// prompts over the limits get 413, each with an ErrorBody.
+func (b *Budget) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Body == nil || r.Body == http.NoBody || !b.counts(r) {
+			next.ServeHTTP(w, r)
+
+			return
+		}
+
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, b.maxBodyBytes))
+		if err != nil {
+			b.rejectBody(w, r, err)
+
+			return
+		}
+
+		start := time.Now()
+
+		model, tokens, err := countPrompt(body, counter(b.tok, b.upperBound))
+		if errors.Is(err, ErrNoPrompt) {
+			// Embeddings, moderations and the like have no prompt to count.
+			restoreBody(r, body)
+			next.ServeHTTP(w, r)
+
+			return
+		}
+
+		if err != nil {
+			b.rejectInvalid(w, r, err)
+
+			return
+		}
+
+		if b.metrics != nil {
+			b.metrics.Observe(b.tok.GetModel(), len(body), tokens, time.Since(start))
+		}
+
+		count := Count{Model: model, Estimator: b.tok.GetModel(), PromptTokens: tokens, MaxTokens: b.maxTokens}
+		b.setHeaders(w.Header(), count)
+
+		if b.maxTokens > 0 && tokens > b.maxTokens {
+			b.reject(w, r, http.StatusRequestEntityTooLarge, ErrorDetail{
+				Message:      fmt.Sprintf(errTooManyTokensFmt, tokens, b.maxTokens),
+				Type:         ErrorTypeInvalidRequest,
+				Code:         ErrorCodeTooManyTokens,
+				PromptTokens: tokens,
+				MaxTokens:    b.maxTokens,
+			})
+
+			return
+		}
+
+		b.logCount(r, count, len(body))
+
+		r = r.WithContext(context.WithValue(r.Context(), countKey{}, count))
+		restoreBody(r, body)
+		b.setHeaders(r.Header, count)
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// counts reports whether r is a candidate for counting: a JSON body, or
+// one without a Content-Type, to a path WithPaths allows.
+func (b *Budget) counts(r *http.Request) bool {
+	if len(b.paths) > 0 && !slices.Contains(b.paths, r.URL.Path) {
+		return false
+	}
+
+	value := r.Header.Get(contentTypeHeader)
+	if value == "" {
+		return true
+	}
+
+	mediaType, _, err := mime.ParseMediaType(value)
+
+	return err == nil && (mediaType == contentTypeJSON || strings.HasSuffix(mediaType, jsonSuffix))
+}
+
+// restoreBody gives next the body the middleware already read.
+func restoreBody(r *http.Request, body []byte) {
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	r.ContentLength = int64(len(body))
+}
+
+func (b *Budget) setHeaders(h http.Header, count Count) {
+	h.Set(HeaderPromptTokens, strconv.Itoa(count.PromptTokens))
+	h.Set(HeaderTokenModel, count.Estimator)
+
+	if count.MaxTokens > 0 {
+		h.Set(HeaderTokenLimit, strconv.Itoa(count.MaxTokens))
+	}
+}
+
+// logCount logs a request that is within the budget.
+func (b *Budget) logCount(r *http.Request, count Count, size int) {
+	if b.logger == nil {
+		return
+	}
+
+	b.logger.LogAttrs(r.Context(), slog.LevelDebug, LogMsgCounted,
+		slog.String(LogKeyPath, r.URL.Path),
+		slog.String(tokenizer.LogKeyModel, count.Estimator),
+		slog.Int(tokenizer.LogKeyBytes, size),
+		slog.Int(tokenizer.LogKeyTokens, count.PromptTokens),
+	)
+}
+
+// reject sends detail with status and logs the rejection.
+func (b *Budget) reject(w http.ResponseWriter, r *http.Request, status int, detail ErrorDetail) {
+	writeError(w, status, detail)
+
+	if b.logger == nil {
+		return
+	}
+
+	b.logger.LogAttrs(r.Context(), slog.LevelWarn, LogMsgRejected,
+		slog.String(LogKeyPath, r.URL.Path),
+		slog.Int(LogKeyStatus, status),
+		slog.String(LogKeyCode, detail.Code),
+		slog.Int(tokenizer.LogKeyTokens, detail.PromptTokens),
+		slog.Int(LogKeyLimit, b.maxTokens),
+	)
+}
+
+// rejectBody answers a body that could not be read: 413 when it is over
+// the size limit, 400 otherwise.
+func (b *Budget) rejectBody(w http.ResponseWriter, r *http.Request, err error) {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		b.reject(w, r, http.StatusRequestEntityTooLarge, ErrorDetail{
+			Message:      fmt.Sprintf(errBodyTooLargeFmt, b.maxBodyBytes),
+			Type:         ErrorTypeInvalidRequest,
+			Code:         ErrorCodeBodyTooLarge,
+			PromptTokens: 0,
+			MaxTokens:    b.maxTokens,
+		})
+
+		return
+	}
+
+	b.rejectInvalid(w, r, err)
+}
+
+// rejectInvalid answers malformed JSON, or a prompt of the wrong type, with
+// 400.
+func (b *Budget) rejectInvalid(w http.ResponseWriter, r *http.Request, err error) {
+	b.reject(w, r, http.StatusBadRequest, ErrorDetail{
+		Message:      fmt.Sprintf(errInvalidBodyFmt, err),
+		Type:         ErrorTypeInvalidRequest,
+		Code:         ErrorCodeInvalidJSON,
+		PromptTokens: 0,
+		MaxTokens:    0,
+	})
+}
+
+func writeError(w http.ResponseWriter, status int, detail ErrorDetail) {
+	w.Header().Set(contentTypeHeader, contentTypeJSON)
+	w.WriteHeader(status)
+
+	// The status is already sent; a failed write has no one to report to.
+	_ = json.NewEncoder(w).Encode(ErrorBody{Error: detail})
+}
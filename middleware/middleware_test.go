@@ -0,0 +1,436 @@
+package middleware_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	tokenizer "github.com/nnikolov3/ai-tokenizer"
+	"github.com/nnikolov3/ai-tokenizer/metrics"
+	"github.com/nnikolov3/ai-tokenizer/middleware"
+)
+
+const (
+	StatusFormat  = "%s: status = %d, want %d"
+	HeaderFormat  = "%s: header %s = %q, want %q"
+	CodeFormat    = "%s: error code = %q, want %q"
+	CountFormat   = "%s: context count = %+v, want %+v"
+	BodyFormat    = "%s: next read body %q, want %q"
+	NoCountFormat = "%s: context has a count, want none"
+	CalledFormat  = "%s: next called = %v, want %v"
+	DecodeFormat  = "decoding error body %q: %v"
+	LimitsFormat  = "%s: error counts = %d/%d, want %d/%d"
+	MetricsFormat = "metrics lack %q:\n%s"
+	LogFormat     = "log lacks %q:\n%s"
+
+	ChatBody = `{"model":"gpt-4o","messages":[` +
+		`{"role":"system","content":"You are terse."},` +
+		`{"role":"user","name":"ann","content":"hello world"}]}`
+	PartsBody = `{"model":"gpt-4o","messages":[{"role":"user","content":[` +
+		`{"type":"text","text":"hello"},` +
+		`{"type":"image_url","image_url":{"url":"https://example.com/a.png"}},` +
+		`{"type":"text","text":"world"}]}]}`
+	ToolsBody = `{"messages":[{"role":"user","content":"hi"}],` +
+		`"tools":[{"type":"function","function":{"name":"f"}}]}`
+	PromptBody      = `{"model":"gpt-3.5-turbo-instruct","prompt":"hello world"}`
+	PromptArrayBody = `{"prompt":["hello","world"]}`
+	UnicodeBody     = `{"prompt":"Привет мир"}`
+	TokenIDsBody    = `{"prompt":[1,2]}`
+	TokenBatchBody  = `{"prompt":[[1,2],[3]]}`
+)
+
+// chatTokens is the expected count of ChatBody: per-message framing, the
+// reply priming, and the role, name and content of each message.
+func chatTokens(tok *tokenizer.Tokenizer) int {
+	return middleware.TokensReplyPriming +
+		2*middleware.TokensPerMessage + tok.EstimateTokens("system") + tok.EstimateTokens("You are terse.") +
+		tok.EstimateTokens("user") + tok.EstimateTokens("hello world") +
+		middleware.TokensPerName + tok.EstimateTokens("ann")
+}
+
+// recorder is the next handler; it records what the middleware passed on.
+type recorder struct {
+	called bool
+	body   string
+	count  middleware.Count
+	ok     bool
+	header string
+}
+
+func (rec *recorder) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rec.called = true
+	rec.count, rec.ok = middleware.CountFromContext(r.Context())
+	rec.header = r.Header.Get(middleware.HeaderPromptTokens)
+
+	body, err := io.ReadAll(r.Body)
+	if err == nil {
+		rec.body = string(body)
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func serve(t *testing.T, budget *middleware.Budget, body string) (*httptest.ResponseRecorder, *recorder) {
+	t.Helper()
+
+	rec := &recorder{called: false, body: "", count: middleware.Count{}, ok: false, header: ""}
+	req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(body))
+	w := httptest.NewRecorder()
+
+	budget.Handler(rec).ServeHTTP(w, req)
+
+	return w, rec
+}
+
+func decodeError(t *testing.T, w *httptest.ResponseRecorder) middleware.ErrorDetail {
+	t.Helper()
+
+	var body middleware.ErrorBody
+
+	err := json.NewDecoder(w.Body).Decode(&body)
+	if err != nil {
+		t.Fatalf(DecodeFormat, w.Body.String(), err)
+	}
+
+	return body.Error
+}
+
+func TestBudgetCountsPrompts(t *testing.T) {
+	t.Parallel()
+
+	tok := tokenizer.NewTokenizer()
+
+	tests := []struct {
+		name  string
+		body  string
+		model string
+		want  int
+	}{
+		{name: "chat", body: ChatBody, model: "gpt-4o", want: chatTokens(tok)},
+		{
+			name:  "content parts",
+			body:  PartsBody,
+			model: "gpt-4o",
+			want: middleware.TokensReplyPriming + middleware.TokensPerMessage + tok.EstimateTokens("user") +
+				tok.EstimateTokens("hello") + tok.EstimateTokens("world"),
+		},
+		{
+			name:  "tools",
+			body:  ToolsBody,
+			model: "",
+			want: middleware.TokensReplyPriming + middleware.TokensPerMessage + tok.EstimateTokens("user") +
+				tok.EstimateTokens("hi") + tok.EstimateTokens(`[{"type":"function","function":{"name":"f"}}]`),
+		},
+		{name: "prompt", body: PromptBody, model: "gpt-3.5-turbo-instruct", want: tok.EstimateTokens("hello world")},
+		{
+			name:  "prompt array",
+			body:  PromptArrayBody,
+			model: "",
+			want:  tok.EstimateTokens("hello") + tok.EstimateTokens("world"),
+		},
+		{name: "prompt token ids", body: TokenIDsBody, model: "", want: 2},
+		{name: "prompt token id batches", body: TokenBatchBody, model: "", want: 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			w, rec := serve(t, middleware.New(tok, middleware.WithMaxTokens(1000)), tt.body)
+
+			if w.Code != http.StatusOK {
+				t.Fatalf(StatusFormat, tt.name, w.Code, http.StatusOK)
+			}
+
+			want := middleware.Count{
+				Model:        tt.model,
+				Estimator:    tok.GetModel(),
+				PromptTokens: tt.want,
+				MaxTokens:    1000,
+			}
+			if !rec.ok || rec.count != want {
+				t.Errorf(CountFormat, tt.name, rec.count, want)
+			}
+
+			if rec.body != tt.body {
+				t.Errorf(BodyFormat, tt.name, rec.body, tt.body)
+			}
+
+			wantTokens := strconv.Itoa(tt.want)
+			if got := w.Header().Get(middleware.HeaderPromptTokens); got != wantTokens {
+				t.Errorf(HeaderFormat, tt.name, middleware.HeaderPromptTokens, got, wantTokens)
+			}
+
+			if rec.header != wantTokens {
+				t.Errorf(HeaderFormat, tt.name, "request "+middleware.HeaderPromptTokens, rec.header, wantTokens)
+			}
+
+			if got := w.Header().Get(middleware.HeaderTokenLimit); got != "1000" {
+				t.Errorf(HeaderFormat, tt.name, middleware.HeaderTokenLimit, got, "1000")
+			}
+
+			if got := w.Header().Get(middleware.HeaderTokenModel); got != tok.GetModel() {
+				t.Errorf(HeaderFormat, tt.name, middleware.HeaderTokenModel, got, tok.GetModel())
+			}
+		})
+	}
+}
+
+func TestBudgetRejects(t *testing.T) {
+	t.Parallel()
+
+	tok := tokenizer.NewTokenizer()
+	limit := chatTokens(tok) - 1
+
+	tests := []struct {
+		name   string
+		budget *middleware.Budget
+		body   string
+		status int
+		code   string
+	}{
+		{
+			name:   "over budget",
+			budget: middleware.New(tok, middleware.WithMaxTokens(limit)),
+			body:   ChatBody,
+			status: http.StatusRequestEntityTooLarge,
+			code:   middleware.ErrorCodeTooManyTokens,
+		},
+		{
+			name:   "body too large",
+			budget: middleware.New(tok, middleware.WithMaxBodyBytes(16)),
+			body:   ChatBody,
+			status: http.StatusRequestEntityTooLarge,
+			code:   middleware.ErrorCodeBodyTooLarge,
+		},
+		{
+			name:   "invalid json",
+			budget: middleware.New(tok),
+			body:   `{"messages":`,
+			status: http.StatusBadRequest,
+			code:   middleware.ErrorCodeInvalidJSON,
+		},
+		{
+			name:   "bad prompt",
+			budget: middleware.New(tok),
+			body:   `{"prompt":[true]}`,
+			status: http.StatusBadRequest,
+			code:   middleware.ErrorCodeInvalidJSON,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			w, rec := serve(t, tt.budget, tt.body)
+
+			if w.Code != tt.status {
+				t.Errorf(StatusFormat, tt.name, w.Code, tt.status)
+			}
+
+			if rec.called {
+				t.Errorf(CalledFormat, tt.name, rec.called, false)
+			}
+
+			if got := decodeError(t, w); got.Code != tt.code || got.Type != middleware.ErrorTypeInvalidRequest {
+				t.Errorf(CodeFormat, tt.name, got.Code, tt.code)
+			}
+		})
+	}
+}
+
+func TestBudgetOverBudgetReportsCounts(t *testing.T) {
+	t.Parallel()
+
+	tok := tokenizer.NewTokenizer()
+	want := chatTokens(tok)
+
+	w, _ := serve(t, middleware.New(tok, middleware.WithMaxTokens(want-1)), ChatBody)
+
+	detail := decodeError(t, w)
+	if detail.PromptTokens != want || detail.MaxTokens != want-1 {
+		t.Errorf(LimitsFormat, "over budget", detail.PromptTokens, detail.MaxTokens, want, want-1)
+	}
+
+	if got := w.Header().Get(middleware.HeaderPromptTokens); got != strconv.Itoa(want) {
+		t.Errorf(HeaderFormat, "over budget", middleware.HeaderPromptTokens, got, strconv.Itoa(want))
+	}
+}
+
+func TestBudgetUpperBound(t *testing.T) {
+	t.Parallel()
+
+	tok := tokenizer.NewTokenizer()
+	estimate := tok.EstimateTokens("Привет мир")
+	bound := tok.UpperBound("Привет мир")
+
+	// The estimate fits the budget; the upper bound does not.
+	w, rec := serve(t, middleware.New(tok, middleware.WithMaxTokens(estimate)), UnicodeBody)
+	if w.Code != http.StatusOK || rec.count.PromptTokens != estimate {
+		t.Errorf(StatusFormat, "estimate", w.Code, http.StatusOK)
+	}
+
+	w, _ = serve(t, middleware.New(tok, middleware.WithMaxTokens(estimate), middleware.WithUpperBound()), UnicodeBody)
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf(StatusFormat, "upper bound", w.Code, http.StatusRequestEntityTooLarge)
+	}
+
+	if got := decodeError(t, w); got.PromptTokens != bound {
+		t.Errorf(LimitsFormat, "upper bound", got.PromptTokens, got.MaxTokens, bound, estimate)
+	}
+}
+
+func TestBudgetPassesBodylessRequests(t *testing.T) {
+	t.Parallel()
+
+	rec := &recorder{called: false, body: "", count: middleware.Count{}, ok: false, header: ""}
+	w := httptest.NewRecorder()
+
+	middleware.New(tokenizer.NewTokenizer()).Handler(rec).
+		ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/v1/models", nil))
+
+	if !rec.called {
+		t.Errorf(CalledFormat, "get", rec.called, true)
+	}
+
+	if rec.ok {
+		t.Errorf(NoCountFormat, "get")
+	}
+
+	if got := w.Header().Get(middleware.HeaderPromptTokens); got != "" {
+		t.Errorf(HeaderFormat, "get", middleware.HeaderPromptTokens, got, "")
+	}
+}
+
+func TestBudgetPassesUncountedRequests(t *testing.T) {
+	t.Parallel()
+
+	tok := tokenizer.NewTokenizer()
+
+	tests := []struct {
+		name        string
+		budget      *middleware.Budget
+		path        string
+		contentType string
+		body        string
+		counted     bool
+	}{
+		{
+			name:        "embeddings without a prompt",
+			budget:      middleware.New(tok, middleware.WithMaxTokens(1)),
+			path:        "/v1/embeddings",
+			contentType: "application/json",
+			body:        `{"model":"text-embedding-3-small","input":"hello world"}`,
+			counted:     false,
+		},
+		{
+			name:        "multipart upload",
+			budget:      middleware.New(tok, middleware.WithMaxTokens(1)),
+			path:        "/v1/audio/transcriptions",
+			contentType: "multipart/form-data; boundary=x",
+			body:        "--x\r\n\r\nnot json\r\n--x--\r\n",
+			counted:     false,
+		},
+		{
+			name:        "path outside WithPaths",
+			budget:      middleware.New(tok, middleware.WithPaths("/v1/completions")),
+			path:        "/v1/chat/completions",
+			contentType: "application/json",
+			body:        `{"messages":`,
+			counted:     false,
+		},
+		{
+			name:        "json with parameters",
+			budget:      middleware.New(tok, middleware.WithPaths("/v1/completions")),
+			path:        "/v1/completions",
+			contentType: "application/json; charset=utf-8",
+			body:        PromptBody,
+			counted:     true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			rec := &recorder{called: false, body: "", count: middleware.Count{}, ok: false, header: ""}
+			req := httptest.NewRequest(http.MethodPost, tt.path, strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", tt.contentType)
+			w := httptest.NewRecorder()
+
+			tt.budget.Handler(rec).ServeHTTP(w, req)
+
+			if w.Code != http.StatusOK || !rec.called {
+				t.Errorf(StatusFormat, tt.name, w.Code, http.StatusOK)
+			}
+
+			if rec.body != tt.body {
+				t.Errorf(BodyFormat, tt.name, rec.body, tt.body)
+			}
+
+			if rec.ok != tt.counted {
+				t.Errorf(CountFormat, tt.name, rec.count, tt.counted)
+			}
+		})
+	}
+}
+
+func TestBudgetRecordsMetrics(t *testing.T) {
+	t.Parallel()
+
+	tok := tokenizer.NewTokenizer()
+	reg := metrics.New()
+	budget := middleware.New(tok, middleware.WithMetrics(reg))
+
+	serve(t, budget, ChatBody)
+	serve(t, budget, PromptBody)
+	serve(t, budget, `{"messages":`)
+
+	var out strings.Builder
+
+	_, err := reg.WriteTo(&out)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Malformed bodies are not counted.
+	for _, want := range []string{
+		`ai_tokenizer_requests_total{model="simple"} 2`,
+		`ai_tokenizer_input_bytes_total{model="simple"} ` + strconv.Itoa(len(ChatBody)+len(PromptBody)),
+		`ai_tokenizer_tokens_total{model="simple"} ` + strconv.Itoa(chatTokens(tok)+tok.EstimateTokens("hello world")),
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf(MetricsFormat, want, out.String())
+		}
+	}
+}
+
+func TestBudgetLogs(t *testing.T) {
+	t.Parallel()
+
+	var buf bytes.Buffer
+
+	tok := tokenizer.NewTokenizer()
+	logger := slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{AddSource: false, Level: slog.LevelDebug, ReplaceAttr: nil}))
+	budget := middleware.New(tok, middleware.WithMaxTokens(chatTokens(tok)-1), middleware.WithLogger(logger))
+
+	serve(t, budget, PromptBody)
+	serve(t, budget, ChatBody)
+
+	for _, want := range []string{
+		`level=DEBUG msg="request counted" path=/v1/chat/completions model=simple bytes=` + strconv.Itoa(len(PromptBody)),
+		`level=WARN msg="request rejected" path=/v1/chat/completions status=413 code=context_length_exceeded tokens=` +
+			strconv.Itoa(chatTokens(tok)),
+	} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf(LogFormat, want, buf.String())
+		}
+	}
+}
@@ -0,0 +1,192 @@
+package middleware
+
+import (
+	"encoding/json"
+	"errors"
+
+	tokenizer "github.com/nnikolov3/ai-tokenizer"
+)
+
+const (
+	// TokensPerMessage is the per-message framing OpenAI chat models add
+	// around each message's role and content.
+	TokensPerMessage = 3
+	// TokensPerName is added when a message has a name.
+	TokensPerName = 1
+	// TokensReplyPriming is the framing that starts the assistant's reply.
+	TokensReplyPriming = 3
+
+	contentTypeText = "text"
+)
+
+// ErrNoPrompt marks a JSON body with neither "messages" nor "prompt",
+// which the middleware passes through uncounted.
+var ErrNoPrompt = errors.New(errNoPromptMsg)
+
+// completionRequest holds the fields of OpenAI chat/completions and
+// completions bodies that make up the prompt. Other fields are ignored.
+type completionRequest struct {
+	Model    string          `json:"model"`
+	Messages []chatMessage   `json:"messages"`
+	Tools    json.RawMessage `json:"tools"`
+	Prompt   json.RawMessage `json:"prompt"`
+}
+
+type chatMessage struct {
+	Role      string          `json:"role"`
+	Name      string          `json:"name"`
+	Content   json.RawMessage `json:"content"`
+	ToolCalls json.RawMessage `json:"tool_calls"`
+}
+
+// contentPart is one element of an array message content. Parts other
+// than text, such as images, are not counted.
+type contentPart struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+// countPrompt estimates the prompt tokens of a request body with count.
+func countPrompt(body []byte, count func(string) int) (string, int, error) {
+	var req completionRequest
+
+	err := json.Unmarshal(body, &req)
+	if err != nil {
+		return "", 0, err
+	}
+
+	switch {
+	case req.Messages != nil:
+		return req.Model, countMessages(req.Messages, count) + countJSON(req.Tools, count), nil
+	case req.Prompt != nil:
+		tokens, err := countPromptValue(req.Prompt, count)
+
+		return req.Model, tokens, err
+	default:
+		return req.Model, 0, ErrNoPrompt
+	}
+}
+
+// countMessages follows OpenAI's accounting for chat prompts: framing per
+// message and for the reply, plus the role, name and content text.
+func countMessages(messages []chatMessage, count func(string) int) int {
+	total := TokensReplyPriming
+
+	for _, msg := range messages {
+		total += TokensPerMessage + count(msg.Role) + countContent(msg.Content, count)
+		total += countJSON(msg.ToolCalls, count)
+
+		if msg.Name != "" {
+			total += TokensPerName + count(msg.Name)
+		}
+	}
+
+	return total
+}
+
+// countContent counts string content, or the text parts of array content.
+func countContent(raw json.RawMessage, count func(string) int) int {
+	var text string
+	if json.Unmarshal(raw, &text) == nil {
+		return count(text)
+	}
+
+	var parts []contentPart
+	if json.Unmarshal(raw, &parts) != nil {
+		return 0
+	}
+
+	total := 0
+
+	for _, part := range parts {
+		if part.Type == contentTypeText {
+			total += count(part.Text)
+		}
+	}
+
+	return total
+}
+
+// countJSON counts tool definitions and calls as their JSON text, which is
+// roughly what models see.
+func countJSON(raw json.RawMessage, count func(string) int) int {
+	if len(raw) == 0 || string(raw) == jsonNull {
+		return 0
+	}
+
+	return count(string(raw))
+}
+
+// countPromptValue counts a "prompt" of text with count, or one of token
+// IDs, which completions also accept, as one token per ID.
+func countPromptValue(raw json.RawMessage, count func(string) int) (int, error) {
+	texts, err := decodeStrings(raw)
+	if err == nil {
+		return countTexts(texts, count), nil
+	}
+
+	ids, idErr := decodeTokenIDs(raw)
+	if idErr != nil {
+		return 0, err
+	}
+
+	return ids, nil
+}
+
+// decodeStrings accepts a "prompt" that is a string or an array of strings.
+func decodeStrings(raw json.RawMessage) ([]string, error) {
+	var text string
+	if json.Unmarshal(raw, &text) == nil {
+		return []string{text}, nil
+	}
+
+	var texts []string
+
+	err := json.Unmarshal(raw, &texts)
+	if err != nil {
+		return nil, err
+	}
+
+	return texts, nil
+}
+
+// decodeTokenIDs accepts a "prompt" that is an array of token IDs or an
+// array of such arrays, and returns how many IDs it holds.
+func decodeTokenIDs(raw json.RawMessage) (int, error) {
+	var ids []int
+	if json.Unmarshal(raw, &ids) == nil {
+		return len(ids), nil
+	}
+
+	var batches [][]int
+
+	err := json.Unmarshal(raw, &batches)
+	if err != nil {
+		return 0, err
+	}
+
+	total := 0
+	for _, batch := range batches {
+		total += len(batch)
+	}
+
+	return total, nil
+}
+
+func countTexts(texts []string, count func(string) int) int {
+	total := 0
+	for _, text := range texts {
+		total += count(text)
+	}
+
+	return total
+}
+
+// counter picks EstimateTokens or, with WithUpperBound, UpperBound.
+func counter(tok *tokenizer.Tokenizer, upperBound bool) func(string) int {
+	if upperBound {
+		return tok.UpperBound
+	}
+
+	return tok.EstimateTokens
+}
@@ -0,0 +1,136 @@
+package tokenizer
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+)
+
+const (
+	errUnknownModelMsg   = "unknown model"
+	errDuplicateModelMsg = "model already registered"
+	errNoEncoderMsg      = "model has no encoder"
+	errEmptyModelMsg     = "model name is empty"
+	errModelNameFmt      = "%w: %q"
+)
+
+var (
+	// ErrUnknownModel is returned by NewTokenizerForModel for unregistered names.
+	ErrUnknownModel = errors.New(errUnknownModelMsg)
+	// ErrDuplicateModel is returned by RegisterModel when the name is taken.
+	ErrDuplicateModel = errors.New(errDuplicateModelMsg)
+	// ErrNoEncoder is returned by Encode for the heuristic model, which
+	// estimates counts without producing token IDs.
+	ErrNoEncoder = errors.New(errNoEncoderMsg)
+	// ErrEmptyModelName is returned by RegisterModel for an empty name.
+	ErrEmptyModelName = errors.New(errEmptyModelMsg)
+)
+
+// Encoder turns text into a model's token IDs. Implementations must be safe
+// for concurrent use.
+type Encoder interface {
+	Encode(text string) []int
+}
+
+// Normalizer is implemented by encoders that rewrite text before splitting
+// it. Normalize uses it in place of the heuristic ASCII folding.
+type Normalizer interface {
+	Normalize(text string) string
+}
+
+// registry holds the models added with RegisterModel.
+var (
+	registryMu sync.RWMutex
+	registry   = map[string]Encoder{}
+)
+
+// RegisterModel makes enc available to NewTokenizerForModel under name.
+// DefaultModel is reserved for the heuristic estimator.
+func RegisterModel(name string, enc Encoder) error {
+	if name == "" {
+		return ErrEmptyModelName
+	}
+
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	if _, taken := registry[name]; taken || name == DefaultModel {
+		return fmt.Errorf(errModelNameFmt, ErrDuplicateModel, name)
+	}
+
+	registry[name] = enc
+
+	return nil
+}
+
+// Models lists the registered model names, including DefaultModel, sorted.
+func Models() []string {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	names := make([]string, 0, len(registry)+1)
+	names = append(names, DefaultModel)
+
+	for name := range registry {
+		names = append(names, name)
+	}
+
+	slices.Sort(names)
+
+	return names
+}
+
+// NewTokenizerForModel returns a tokenizer for a registered model.
+// DefaultModel yields the heuristic estimator.
+func NewTokenizerForModel(name string, opts ...Option) (*Tokenizer, error) {
+	if name == DefaultModel {
+		return NewTokenizer(opts...), nil
+	}
+
+	registryMu.RLock()
+	enc, ok := registry[name]
+	registryMu.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf(errModelNameFmt, ErrUnknownModel, name)
+	}
+
+	return NewTokenizer(append([]Option{WithEncoder(name, enc)}, opts...)...), nil
+}
+
+// WithEncoder makes the tokenizer count with enc and report name as its
+// model, without registering it.
+func WithEncoder(name string, enc Encoder) Option {
+	return func(t *Tokenizer) {
+		t.model = name
+		t.encoder = enc
+	}
+}
+
+// Encode returns the model's token IDs for text. Invalid UTF-8 and special
+// tokens are handled as in EstimateTokens, except that SpecialTokenReject
+// returns *SpecialTokenError. The heuristic model returns ErrNoEncoder.
+func (t *Tokenizer) Encode(text string) ([]int, error) {
+	if t.encoder == nil {
+		return nil, ErrNoEncoder
+	}
+
+	err := t.rejectSpecialTokens(t.findSpecialTokens(text))
+	if err != nil {
+		return nil, err
+	}
+
+	return t.encodeWithPolicy(t.encoderInput(text)), nil
+}
+
+// encoderInput applies the invalid UTF-8 policy before an encoder sees the
+// text. Skip and Reject drop invalid bytes, matching the heuristic count.
+func (t *Tokenizer) encoderInput(text string) string {
+	if t.invalidUTF8 == InvalidUTF8Skip || t.invalidUTF8 == InvalidUTF8Reject {
+		return strings.ToValidUTF8(text, "")
+	}
+
+	return t.sanitizeUTF8(text)
+}
@@ -0,0 +1,92 @@
+package tokenizer_test
+
+import (
+	"errors"
+	"slices"
+	"testing"
+
+	tokenizer "github.com/nnikolov3/ai-tokenizer"
+)
+
+const (
+	// RegisteredModelName is unique to this file; the registry is global.
+	RegisteredModelName = "test-unigram"
+
+	RegisterErrFormat  = "RegisterModel(%q) error = %v, want %v"
+	NewForModelFormat  = "NewTokenizerForModel(%q) error = %v, want %v"
+	ModelsFormat       = "Models() = %v, want it to contain %q"
+	EncodeErrFormat    = "Encode(%q) = %v, %v; want %v, %v"
+	ModelEstimateFmt   = "model %q: EstimateTokens(%q) = %d, want %d"
+	ModelNormalizedFmt = "model %q: Normalize(%q) = %q, want %q"
+)
+
+func TestRegisteredModel(t *testing.T) {
+	t.Parallel()
+
+	err := tokenizer.RegisterModel(RegisteredModelName, loadModel(t, UnigramModelPath))
+	if err != nil {
+		t.Fatalf(RegisterErrFormat, RegisteredModelName, err, nil)
+	}
+
+	tok, err := tokenizer.NewTokenizerForModel(RegisteredModelName)
+	if err != nil {
+		t.Fatalf(NewForModelFormat, RegisteredModelName, err, nil)
+	}
+
+	validateModelTokenizer(t, tok)
+
+	if !slices.Contains(tokenizer.Models(), RegisteredModelName) {
+		t.Errorf(ModelsFormat, tokenizer.Models(), RegisteredModelName)
+	}
+
+	err = tokenizer.RegisterModel(RegisteredModelName, loadModel(t, UnigramModelPath))
+	if !errors.Is(err, tokenizer.ErrDuplicateModel) {
+		t.Errorf(RegisterErrFormat, RegisteredModelName, err, tokenizer.ErrDuplicateModel)
+	}
+}
+
+func validateModelTokenizer(t *testing.T, tok *tokenizer.Tokenizer) {
+	t.Helper()
+
+	if tok.GetModel() != RegisteredModelName {
+		t.Errorf(GetModelErrorFormat, tok.GetModel(), RegisteredModelName)
+	}
+
+	if got := tok.EstimateTokens(HelloWorld); got != 2 {
+		t.Errorf(ModelEstimateFmt, RegisteredModelName, HelloWorld, got, 2)
+	}
+
+	// The invalid byte is dropped under the default policy before encoding.
+	ids, err := tok.Encode("hello \xffworld")
+	if err != nil || !slices.Equal(ids, []int{5, 9}) {
+		t.Errorf(EncodeErrFormat, "hello \xffworld", ids, err, []int{5, 9}, nil)
+	}
+
+	if got := tok.Normalize(HelloWorld); got != "▁hello▁world" {
+		t.Errorf(ModelNormalizedFmt, RegisteredModelName, HelloWorld, got, "▁hello▁world")
+	}
+}
+
+func TestModelRegistryErrors(t *testing.T) {
+	t.Parallel()
+
+	_, err := tokenizer.NewTokenizerForModel("no-such-model")
+	if !errors.Is(err, tokenizer.ErrUnknownModel) {
+		t.Errorf(NewForModelFormat, "no-such-model", err, tokenizer.ErrUnknownModel)
+	}
+
+	err = tokenizer.RegisterModel(tokenizer.DefaultModel, nil)
+	if !errors.Is(err, tokenizer.ErrDuplicateModel) {
+		t.Errorf(RegisterErrFormat, tokenizer.DefaultModel, err, tokenizer.ErrDuplicateModel)
+	}
+
+	_, err = tokenizer.NewTokenizer().Encode(HelloWorld)
+	if !errors.Is(err, tokenizer.ErrNoEncoder) {
+		t.Errorf(EncodeErrFormat, HelloWorld, nil, err, nil, tokenizer.ErrNoEncoder)
+	}
+
+	tok, err := tokenizer.NewTokenizerForModel(tokenizer.DefaultModel)
+	if err != nil || tok.GetModel() != tokenizer.DefaultModel {
+		t.Errorf(NewForModelFormat, tokenizer.DefaultModel, err, nil)
+	}
+}
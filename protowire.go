@@ -0,0 +1,130 @@
+package tokenizer
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+)
+
+// Protobuf wire types used by SentencePiece model files.
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+	wireFixed32 = 5
+
+	wireTypeBits  = 3
+	wireTypeMask  = 1<<wireTypeBits - 1
+	varintMaxLen  = 10
+	varintPayload = 0x7F
+	varintMore    = 0x80
+	varintShift   = 7
+	fixed32Len    = 4
+	fixed64Len    = 8
+
+	errTruncatedProtoMsg = "truncated protobuf message"
+	errWireTypeMsg       = "unsupported protobuf wire type"
+)
+
+var (
+	errTruncatedProto = errors.New(errTruncatedProtoMsg)
+	errWireType       = errors.New(errWireTypeMsg)
+)
+
+// protoField is one decoded field. Varint and fixed values are in num;
+// length-delimited payloads are in data.
+type protoField struct {
+	data     []byte
+	number   int
+	wireType int
+	num      uint64
+}
+
+// float32 interprets a fixed32 field as an IEEE 754 float.
+func (f protoField) float32() float32 {
+	return math.Float32frombits(uint32(f.num))
+}
+
+// forEachProtoField decodes a message and calls fn for every field in order.
+func forEachProtoField(msg []byte, fn func(protoField) error) error {
+	for len(msg) > 0 {
+		field, rest, err := nextProtoField(msg)
+		if err != nil {
+			return err
+		}
+
+		err = fn(field)
+		if err != nil {
+			return err
+		}
+
+		msg = rest
+	}
+
+	return nil
+}
+
+func nextProtoField(msg []byte) (protoField, []byte, error) {
+	var field protoField
+
+	key, msg, err := readVarint(msg)
+	if err != nil {
+		return field, nil, err
+	}
+
+	field.number = int(key >> wireTypeBits)
+	field.wireType = int(key & wireTypeMask)
+
+	switch field.wireType {
+	case wireVarint:
+		field.num, msg, err = readVarint(msg)
+	case wireFixed32:
+		field.num, msg, err = readFixed(msg, fixed32Len)
+	case wireFixed64:
+		field.num, msg, err = readFixed(msg, fixed64Len)
+	case wireBytes:
+		field.data, msg, err = readBytes(msg)
+	default:
+		err = errWireType
+	}
+
+	return field, msg, err
+}
+
+func readVarint(msg []byte) (uint64, []byte, error) {
+	var value uint64
+
+	for i := 0; i < len(msg) && i < varintMaxLen; i++ {
+		value |= uint64(msg[i]&varintPayload) << (varintShift * i)
+		if msg[i]&varintMore == 0 {
+			return value, msg[i+1:], nil
+		}
+	}
+
+	return 0, nil, errTruncatedProto
+}
+
+func readFixed(msg []byte, size int) (uint64, []byte, error) {
+	if len(msg) < size {
+		return 0, nil, errTruncatedProto
+	}
+
+	if size == fixed32Len {
+		return uint64(binary.LittleEndian.Uint32(msg)), msg[size:], nil
+	}
+
+	return binary.LittleEndian.Uint64(msg), msg[size:], nil
+}
+
+func readBytes(msg []byte) ([]byte, []byte, error) {
+	length, msg, err := readVarint(msg)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if length > uint64(len(msg)) {
+		return nil, nil, errTruncatedProto
+	}
+
+	return msg[:length], msg[length:], nil
+}
@@ -0,0 +1,140 @@
+package ratelimit
+
+import (
+	"math"
+	"time"
+)
+
+const (
+	// AlgorithmNameTokenBucket and AlgorithmNameSlidingWindow are the
+	// names Algorithm.String returns.
+	AlgorithmNameTokenBucket   = "token-bucket"
+	AlgorithmNameSlidingWindow = "sliding-window"
+)
+
+// Algorithm is how a quota turns spent tokens into a budget.
+type Algorithm int
+
+const (
+	// TokenBucket refills continuously at Tokens per Per, up to Burst. It
+	// is the default; it smooths usage and allows short bursts.
+	TokenBucket Algorithm = iota
+	// SlidingWindow allows Tokens over any Per-long window, estimated
+	// from the current and previous fixed windows, with the previous one
+	// weighted by how much of it still overlaps the sliding window. It
+	// matches how providers state per-minute quotas.
+	SlidingWindow
+)
+
+// String returns the algorithm name.
+func (a Algorithm) String() string {
+	if a == SlidingWindow {
+		return AlgorithmNameSlidingWindow
+	}
+
+	return AlgorithmNameTokenBucket
+}
+
+// outcome is the result of applying a debit to a State.
+type outcome struct {
+	state      State
+	allowed    bool
+	remaining  float64
+	retryAfter time.Duration
+}
+
+// takeFromBucket debits tokens from a bucket that holds quota.capacity()
+// and drains what was used at quota.rate() tokens per second.
+func takeFromBucket(state State, quota Quota, now time.Time, tokens int) outcome {
+	used := state.Used
+	if !state.Start.IsZero() {
+		used = max(0, used-now.Sub(state.Start).Seconds()*quota.rate())
+	}
+
+	capacity := float64(quota.capacity())
+	need := used + float64(tokens)
+
+	if need > capacity {
+		return outcome{
+			state:      State{Start: now, Used: used, Previous: 0},
+			allowed:    false,
+			remaining:  capacity - used,
+			retryAfter: seconds((need - capacity) / quota.rate()),
+		}
+	}
+
+	return outcome{
+		state:      State{Start: now, Used: need, Previous: 0},
+		allowed:    true,
+		remaining:  capacity - need,
+		retryAfter: 0,
+	}
+}
+
+// takeFromWindow debits tokens from a sliding window of quota.Per that
+// allows quota.Tokens.
+func takeFromWindow(state State, quota Quota, now time.Time, tokens int) outcome {
+	state = advanceWindow(state, quota.Per, now)
+
+	limit := float64(quota.Tokens)
+	elapsed := float64(now.Sub(state.Start)) / float64(quota.Per)
+	used := state.Previous*(1-elapsed) + state.Used
+	need := float64(tokens)
+
+	if used+need > limit {
+		return outcome{
+			state:      state,
+			allowed:    false,
+			remaining:  limit - used,
+			retryAfter: windowWait(state, quota, now, need),
+		}
+	}
+
+	state.Used += need
+
+	return outcome{state: state, allowed: true, remaining: limit - used - need, retryAfter: 0}
+}
+
+// advanceWindow moves state to the fixed window that contains now.
+// Windows are aligned to multiples of per.
+func advanceWindow(state State, per time.Duration, now time.Time) State {
+	start := now.Truncate(per)
+
+	switch {
+	case state.Start.Equal(start):
+		return state
+	case state.Start.Add(per).Equal(start):
+		return State{Start: start, Used: 0, Previous: state.Used}
+	default:
+		return State{Start: start, Used: 0, Previous: 0}
+	}
+}
+
+// windowWait returns how long until need more tokens fit. The previous
+// window's weight falls linearly, so the wait solves
+// previous*(1-x) + used + need = limit for the fraction x of a window.
+func windowWait(state State, quota Quota, now time.Time, need float64) time.Duration {
+	limit := float64(quota.Tokens)
+	previous, used, start := state.Previous, state.Used, state.Start
+
+	if used+need > limit {
+		// Not before the next window, where this window becomes the
+		// previous one.
+		previous, used, start = used, 0, start.Add(quota.Per)
+	}
+
+	fraction := 0.0
+	if previous > 0 {
+		fraction = max(0, 1-(limit-used-need)/previous)
+	}
+
+	at := start.Add(seconds(fraction * quota.Per.Seconds()))
+
+	return max(at.Sub(now), 0)
+}
+
+// seconds converts to a Duration, rounding up so that a caller who waits
+// that long finds the tokens available.
+func seconds(s float64) time.Duration {
+	return time.Duration(math.Ceil(s * float64(time.Second)))
+}
@@ -0,0 +1,285 @@
+// Package ratelimit enforces per-tenant token quotas, such as tokens per
+// minute per API key. A Limiter debits token counts, from any estimator or
+// registered model, from a token-bucket or sliding-window budget kept in a
+// pluggable Store, and says how long a rejected request should wait.
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	tokenizer "github.com/nnikolov3/ai-tokenizer"
+)
+
+const (
+	errInvalidQuotaMsg   = "invalid quota"
+	errExceedsQuotaMsg   = "request exceeds quota"
+	errNegativeTokensMsg = "token count is negative"
+	errInvalidQuotaFmt   = "%w: %d tokens per %v, burst %d"
+	errTenantQuotaFmt    = "tenant %q: %w"
+	errExceedsQuotaFmt   = "%w: %d tokens, tenant %q allows at most %d"
+	errNegativeTokensFmt = "%w: %d"
+	errTokenizerFmt      = "rate limit tokenizer: %w"
+	errStoreUpdateFmt    = "rate limit store: %w"
+)
+
+var (
+	// ErrInvalidQuota is returned by New for a quota without a positive
+	// token count and period, or with a negative burst.
+	ErrInvalidQuota = errors.New(errInvalidQuotaMsg)
+	// ErrExceedsQuota is returned for a request larger than the tenant
+	// could ever spend at once; waiting would not help.
+	ErrExceedsQuota = errors.New(errExceedsQuotaMsg)
+	// ErrNegativeTokens is returned for a negative token count.
+	ErrNegativeTokens = errors.New(errNegativeTokensMsg)
+)
+
+// Quota allows Tokens per Per, such as 90000 tokens per time.Minute.
+type Quota struct {
+	Tokens int
+	Per    time.Duration
+	// Burst is the token-bucket capacity. Zero means Tokens. The sliding
+	// window ignores it.
+	Burst int
+}
+
+// Decision is the result of debiting a tenant.
+type Decision struct {
+	Tenant string
+	// Tokens is the count that was, or would have been, debited.
+	Tokens  int
+	Allowed bool
+	// Remaining is what the tenant can still spend right now, after the
+	// debit if it was allowed.
+	Remaining int
+	// Limit is the most the tenant can spend at once.
+	Limit int
+	// RetryAfter is how long a rejected request should wait before the
+	// same debit would be allowed, if nobody else spends meanwhile. It is
+	// zero when Allowed.
+	RetryAfter time.Duration
+}
+
+// Option configures a Limiter.
+type Option func(*Limiter)
+
+// Limiter debits token counts from per-tenant quotas. It is safe for
+// concurrent use.
+type Limiter struct {
+	store     Store
+	algorithm Algorithm
+	quota     Quota
+	tenants   map[string]Quota
+	now       func() time.Time
+	tokOpts   []tokenizer.Option
+
+	mu         sync.Mutex
+	tokenizers map[string]*tokenizer.Tokenizer
+}
+
+// WithStore keeps budgets in store instead of a new MemoryStore.
+func WithStore(store Store) Option {
+	return func(l *Limiter) {
+		l.store = store
+	}
+}
+
+// WithAlgorithm selects the budget algorithm. The default is TokenBucket.
+func WithAlgorithm(algorithm Algorithm) Option {
+	return func(l *Limiter) {
+		l.algorithm = algorithm
+	}
+}
+
+// WithTenantQuota gives tenant its own quota instead of the default one.
+func WithTenantQuota(tenant string, quota Quota) Option {
+	return func(l *Limiter) {
+		l.tenants[tenant] = quota
+	}
+}
+
+// WithClock replaces time.Now, for tests and simulations.
+func WithClock(now func() time.Time) Option {
+	return func(l *Limiter) {
+		l.now = now
+	}
+}
+
+// WithTokenizerOptions configures the tokenizers TakeText builds for each
+// model, for example to set a special-token policy.
+func WithTokenizerOptions(opts ...tokenizer.Option) Option {
+	return func(l *Limiter) {
+		l.tokOpts = append(l.tokOpts, opts...)
+	}
+}
+
+// New returns a Limiter that applies quota to every tenant without its own.
+func New(quota Quota, opts ...Option) (*Limiter, error) {
+	l := &Limiter{
+		store:      nil,
+		algorithm:  TokenBucket,
+		quota:      quota,
+		tenants:    map[string]Quota{},
+		now:        time.Now,
+		tokOpts:    nil,
+		mu:         sync.Mutex{},
+		tokenizers: map[string]*tokenizer.Tokenizer{},
+	}
+
+	for _, opt := range opts {
+		opt(l)
+	}
+
+	if l.store == nil {
+		l.store = NewMemoryStore()
+	}
+
+	err := quota.validate()
+	if err != nil {
+		return nil, err
+	}
+
+	for tenant, q := range l.tenants {
+		err = q.validate()
+		if err != nil {
+			return nil, fmt.Errorf(errTenantQuotaFmt, tenant, err)
+		}
+	}
+
+	return l, nil
+}
+
+// Take debits tokens from tenant's budget if they fit. Otherwise it debits
+// nothing and the Decision says how long to wait. A count over the
+// tenant's Limit returns ErrExceedsQuota. Counts can come from any
+// estimator: EstimateTokens, UpperBound, an exact encoder, or the
+// middleware package's request count.
+func (l *Limiter) Take(ctx context.Context, tenant string, tokens int) (Decision, error) {
+	quota := l.quotaFor(tenant)
+	limit := l.limit(quota)
+
+	decision := Decision{
+		Tenant:     tenant,
+		Tokens:     tokens,
+		Allowed:    false,
+		Remaining:  0,
+		Limit:      limit,
+		RetryAfter: 0,
+	}
+
+	if tokens < 0 {
+		return decision, fmt.Errorf(errNegativeTokensFmt, ErrNegativeTokens, tokens)
+	}
+
+	if tokens > limit {
+		return decision, fmt.Errorf(errExceedsQuotaFmt, ErrExceedsQuota, tokens, tenant, limit)
+	}
+
+	now := l.now()
+
+	var result outcome
+
+	err := l.store.Update(ctx, tenant, func(state State) (State, error) {
+		if l.algorithm == SlidingWindow {
+			result = takeFromWindow(state, quota, now, tokens)
+		} else {
+			result = takeFromBucket(state, quota, now, tokens)
+		}
+
+		return result.state, nil
+	})
+	if err != nil {
+		return decision, fmt.Errorf(errStoreUpdateFmt, err)
+	}
+
+	decision.Allowed = result.allowed
+	decision.Remaining = max(0, int(math.Floor(result.remaining)))
+	decision.RetryAfter = result.retryAfter
+
+	return decision, nil
+}
+
+// TakeText estimates text with the registered model and debits the count
+// from tenant's budget. An empty model means the heuristic estimator.
+func (l *Limiter) TakeText(ctx context.Context, tenant, model, text string) (Decision, error) {
+	tok, err := l.tokenizer(model)
+	if err != nil {
+		return Decision{Tenant: tenant, Tokens: 0, Allowed: false, Remaining: 0, Limit: 0, RetryAfter: 0}, err
+	}
+
+	return l.Take(ctx, tenant, tok.EstimateTokens(text))
+}
+
+// tokenizer returns the cached tokenizer for a registered model.
+func (l *Limiter) tokenizer(model string) (*tokenizer.Tokenizer, error) {
+	if model == "" {
+		model = tokenizer.DefaultModel
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if tok, ok := l.tokenizers[model]; ok {
+		return tok, nil
+	}
+
+	tok, err := tokenizer.NewTokenizerForModel(model, l.tokOpts...)
+	if err != nil {
+		return nil, fmt.Errorf(errTokenizerFmt, err)
+	}
+
+	l.tokenizers[model] = tok
+
+	return tok, nil
+}
+
+func (l *Limiter) quotaFor(tenant string) Quota {
+	if quota, ok := l.tenants[tenant]; ok {
+		return quota
+	}
+
+	return l.quota
+}
+
+// limit is the most a tenant with quota can spend at once.
+func (l *Limiter) limit(quota Quota) int {
+	if l.algorithm == SlidingWindow {
+		return quota.Tokens
+	}
+
+	return quota.capacity()
+}
+
+// IdleAfter is how long after its State.Start a tenant's state stops
+// mattering: the token bucket has drained Burst tokens at Tokens per Per,
+// and the sliding window has moved past both windows it weighs.
+func (q Quota) IdleAfter() time.Duration {
+	refill := time.Duration(math.Ceil(float64(q.capacity()) / float64(q.Tokens) * float64(q.Per)))
+
+	return max(refill, 2*q.Per)
+}
+
+func (q Quota) validate() error {
+	if q.Tokens <= 0 || q.Per <= 0 || q.Burst < 0 {
+		return fmt.Errorf(errInvalidQuotaFmt, ErrInvalidQuota, q.Tokens, q.Per, q.Burst)
+	}
+
+	return nil
+}
+
+func (q Quota) capacity() int {
+	if q.Burst > 0 {
+		return q.Burst
+	}
+
+	return q.Tokens
+}
+
+// rate is the bucket refill rate in tokens per second.
+func (q Quota) rate() float64 {
+	return float64(q.Tokens) / q.Per.Seconds()
+}
@@ -0,0 +1,283 @@
+package ratelimit_test
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	tokenizer "github.com/nnikolov3/ai-tokenizer"
+	"github.com/nnikolov3/ai-tokenizer/ratelimit"
+)
+
+const (
+	DecisionFormat = "%s: Take(%d) at +%v = allowed %v, remaining %d, retry %v; want %v, %d, %v"
+	ErrorFormat    = "%s: error = %v, want %v"
+	TokensFormat   = "%s: debited %d tokens, want %d"
+	PruneFormat    = "Prune() = %d, Len() = %d; want %d, %d"
+	IdleFormat     = "%+v.IdleAfter() = %v, want %v"
+	StoreFormat    = "store saw %v, want %v"
+
+	Tenant = "key-1"
+)
+
+// epoch is aligned to a minute, so sliding windows start on it.
+var epoch = time.Date(2026, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+// clock is a settable time source.
+type clock struct {
+	now time.Time
+}
+
+func (c *clock) Now() time.Time {
+	return c.now
+}
+
+type step struct {
+	at        time.Duration
+	tokens    int
+	allowed   bool
+	remaining int
+	retry     time.Duration
+}
+
+func runSteps(t *testing.T, name string, quota ratelimit.Quota, opts []ratelimit.Option, steps []step) {
+	t.Helper()
+
+	clk := &clock{now: epoch}
+
+	limiter, err := ratelimit.New(quota, append(opts, ratelimit.WithClock(clk.Now))...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, s := range steps {
+		clk.now = epoch.Add(s.at)
+
+		got, err := limiter.Take(context.Background(), Tenant, s.tokens)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got.Allowed != s.allowed || got.Remaining != s.remaining || got.RetryAfter != s.retry {
+			t.Errorf(DecisionFormat, name, s.tokens, s.at, got.Allowed, got.Remaining, got.RetryAfter,
+				s.allowed, s.remaining, s.retry)
+		}
+	}
+}
+
+func TestTokenBucket(t *testing.T) {
+	t.Parallel()
+
+	// 60 tokens per minute refill at one token per second.
+	perMinute := ratelimit.Quota{Tokens: 60, Per: time.Minute, Burst: 0}
+
+	runSteps(t, "bucket", perMinute, nil, []step{
+		{at: 0, tokens: 60, allowed: true, remaining: 0, retry: 0},
+		{at: 0, tokens: 10, allowed: false, remaining: 0, retry: 10 * time.Second},
+		{at: 5 * time.Second, tokens: 10, allowed: false, remaining: 5, retry: 5 * time.Second},
+		{at: 10 * time.Second, tokens: 10, allowed: true, remaining: 0, retry: 0},
+		{at: 2 * time.Minute, tokens: 0, allowed: true, remaining: 60, retry: 0},
+	})
+
+	burst := ratelimit.Quota{Tokens: 60, Per: time.Minute, Burst: 100}
+
+	runSteps(t, "burst", burst, nil, []step{
+		{at: 0, tokens: 100, allowed: true, remaining: 0, retry: 0},
+		{at: 30 * time.Second, tokens: 40, allowed: false, remaining: 30, retry: 10 * time.Second},
+	})
+}
+
+func TestSlidingWindow(t *testing.T) {
+	t.Parallel()
+
+	quota := ratelimit.Quota{Tokens: 100, Per: time.Minute, Burst: 0}
+	opts := []ratelimit.Option{ratelimit.WithAlgorithm(ratelimit.SlidingWindow)}
+
+	runSteps(t, "window", quota, opts, []step{
+		{at: 30 * time.Second, tokens: 80, allowed: true, remaining: 20, retry: 0},
+		// 110 does not fit this window. In the next one the 80 weigh
+		// 80*(1-x); 80*(1-x) + 30 <= 100 once x = 1/8, 7.5s in.
+		{at: 40 * time.Second, tokens: 30, allowed: false, remaining: 20, retry: 27500 * time.Millisecond},
+		{at: 67500 * time.Millisecond, tokens: 30, allowed: true, remaining: 0, retry: 0},
+		// Now 80 weigh less each second: 80*(1-x) + 30 + 10 <= 100 at x = 1/4.
+		{at: 70 * time.Second, tokens: 10, allowed: false, remaining: 3, retry: 5 * time.Second},
+		{at: 75 * time.Second, tokens: 10, allowed: true, remaining: 0, retry: 0},
+		// Two windows later nothing counts.
+		{at: 3 * time.Minute, tokens: 100, allowed: true, remaining: 0, retry: 0},
+	})
+}
+
+func TestTakeErrors(t *testing.T) {
+	t.Parallel()
+
+	limiter, err := ratelimit.New(ratelimit.Quota{Tokens: 100, Per: time.Minute, Burst: 0},
+		ratelimit.WithTenantQuota("big", ratelimit.Quota{Tokens: 1000, Per: time.Minute, Burst: 0}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		tenant  string
+		tokens  int
+		wantErr error
+	}{
+		{name: "over limit", tenant: Tenant, tokens: 101, wantErr: ratelimit.ErrExceedsQuota},
+		{name: "tenant quota", tenant: "big", tokens: 1000, wantErr: nil},
+		{name: "negative", tenant: Tenant, tokens: -1, wantErr: ratelimit.ErrNegativeTokens},
+	}
+
+	for _, tt := range tests {
+		_, err := limiter.Take(context.Background(), tt.tenant, tt.tokens)
+		if !errors.Is(err, tt.wantErr) {
+			t.Errorf(ErrorFormat, tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestNewRejectsInvalidQuotas(t *testing.T) {
+	t.Parallel()
+
+	valid := ratelimit.Quota{Tokens: 1, Per: time.Second, Burst: 0}
+
+	tests := []struct {
+		name  string
+		quota ratelimit.Quota
+		opts  []ratelimit.Option
+	}{
+		{name: "no tokens", quota: ratelimit.Quota{Tokens: 0, Per: time.Second, Burst: 0}, opts: nil},
+		{name: "no period", quota: ratelimit.Quota{Tokens: 1, Per: 0, Burst: 0}, opts: nil},
+		{name: "negative burst", quota: ratelimit.Quota{Tokens: 1, Per: time.Second, Burst: -1}, opts: nil},
+		{
+			name:  "tenant",
+			quota: valid,
+			opts:  []ratelimit.Option{ratelimit.WithTenantQuota(Tenant, ratelimit.Quota{Tokens: -5, Per: time.Second, Burst: 0})},
+		},
+	}
+
+	for _, tt := range tests {
+		_, err := ratelimit.New(tt.quota, tt.opts...)
+		if !errors.Is(err, ratelimit.ErrInvalidQuota) {
+			t.Errorf(ErrorFormat, tt.name, err, ratelimit.ErrInvalidQuota)
+		}
+	}
+}
+
+func TestTakeText(t *testing.T) {
+	t.Parallel()
+
+	limiter, err := ratelimit.New(ratelimit.Quota{Tokens: 1000, Per: time.Minute, Burst: 0})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	text := "hello world"
+	want := tokenizer.NewTokenizer().EstimateTokens(text)
+
+	for _, model := range []string{"", tokenizer.DefaultModel} {
+		got, err := limiter.TakeText(context.Background(), Tenant, model, text)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got.Tokens != want {
+			t.Errorf(TokensFormat, model, got.Tokens, want)
+		}
+	}
+
+	_, err = limiter.TakeText(context.Background(), Tenant, "no-such-model", text)
+	if !errors.Is(err, tokenizer.ErrUnknownModel) {
+		t.Errorf(ErrorFormat, "unknown model", err, tokenizer.ErrUnknownModel)
+	}
+}
+
+// recordingStore wraps a MemoryStore and records the keys it updates.
+type recordingStore struct {
+	*ratelimit.MemoryStore
+
+	keys []string
+	fail error
+}
+
+func (s *recordingStore) Update(ctx context.Context, key string, fn func(ratelimit.State) (ratelimit.State, error)) error {
+	s.keys = append(s.keys, key)
+	if s.fail != nil {
+		return s.fail
+	}
+
+	return s.MemoryStore.Update(ctx, key, fn)
+}
+
+var errStoreDown = errors.New("store down")
+
+func TestCustomStore(t *testing.T) {
+	t.Parallel()
+
+	store := &recordingStore{MemoryStore: ratelimit.NewMemoryStore(), keys: nil, fail: nil}
+
+	limiter, err := ratelimit.New(ratelimit.Quota{Tokens: 10, Per: time.Minute, Burst: 0}, ratelimit.WithStore(store))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tenant := range []string{"a", "b", "a"} {
+		_, err = limiter.Take(context.Background(), tenant, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if want := []string{"a", "b", "a"}; !slices.Equal(store.keys, want) {
+		t.Errorf(StoreFormat, store.keys, want)
+	}
+
+	store.fail = errStoreDown
+
+	_, err = limiter.Take(context.Background(), "a", 1)
+	if !errors.Is(err, errStoreDown) {
+		t.Errorf(ErrorFormat, "store down", err, errStoreDown)
+	}
+}
+
+func TestQuotaIdleAfter(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		quota ratelimit.Quota
+		want  time.Duration
+	}{
+		{ratelimit.Quota{Tokens: 10, Per: time.Minute, Burst: 0}, 2 * time.Minute},
+		{ratelimit.Quota{Tokens: 10, Per: time.Minute, Burst: 15}, 2 * time.Minute},
+		{ratelimit.Quota{Tokens: 10, Per: time.Minute, Burst: 50}, 5 * time.Minute},
+	}
+
+	for _, tt := range tests {
+		if got := tt.quota.IdleAfter(); got != tt.want {
+			t.Errorf(IdleFormat, tt.quota, got, tt.want)
+		}
+	}
+}
+
+func TestMemoryStorePrune(t *testing.T) {
+	t.Parallel()
+
+	clk := &clock{now: epoch}
+	store := ratelimit.NewMemoryStore()
+
+	limiter, err := ratelimit.New(ratelimit.Quota{Tokens: 10, Per: time.Minute, Burst: 0},
+		ratelimit.WithStore(store), ratelimit.WithClock(clk.Now))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, _ = limiter.Take(context.Background(), "idle", 1)
+	clk.now = epoch.Add(time.Hour)
+	_, _ = limiter.Take(context.Background(), "active", 1)
+
+	pruned := store.Prune(epoch.Add(30 * time.Minute))
+	if pruned != 1 || store.Len() != 1 {
+		t.Errorf(PruneFormat, pruned, store.Len(), 1, 1)
+	}
+}
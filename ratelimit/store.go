@@ -0,0 +1,89 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// State is the budget of one tenant as the limiter stores it. Its meaning
+// depends on the Algorithm:
+//   - TokenBucket: Used is the tokens taken from the bucket as of Start;
+//     it drains at the quota rate.
+//   - SlidingWindow: Used is the tokens spent in the window that began at
+//     Start, and Previous those spent in the window before it.
+//
+// A zero State is a tenant that has spent nothing.
+type State struct {
+	Start    time.Time
+	Used     float64
+	Previous float64
+}
+
+// Store keeps per-tenant State. Implementations must be safe for concurrent
+// use, and Update must be atomic for a key: no other Update of the same key
+// may run between reading the state passed to fn and saving its result.
+// A shared store, such as Redis behind a transaction, lets several gateway
+// replicas enforce one quota.
+type Store interface {
+	// Update calls fn with the current state of key and saves the state fn
+	// returns. It saves nothing when fn returns an error, and returns that
+	// error.
+	Update(ctx context.Context, key string, fn func(State) (State, error)) error
+}
+
+// MemoryStore is a Store in process memory.
+type MemoryStore struct {
+	mu     sync.Mutex
+	states map[string]State
+}
+
+// NewMemoryStore returns an empty MemoryStore.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{mu: sync.Mutex{}, states: map[string]State{}}
+}
+
+// Update implements Store. It holds one lock for all keys while fn runs.
+func (s *MemoryStore) Update(_ context.Context, key string, fn func(State) (State, error)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	state, err := fn(s.states[key])
+	if err != nil {
+		return err
+	}
+
+	s.states[key] = state
+
+	return nil
+}
+
+// Prune drops the tenants whose State.Start is before cutoff, and they
+// start over with a full budget. Pass now minus the longest
+// Quota.IdleAfter of the limiter's quotas: such tenants have a full budget
+// already, so pruning them hands out no tokens. Call it from time to time
+// to bound memory.
+func (s *MemoryStore) Prune(cutoff time.Time) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	pruned := 0
+
+	for key, state := range s.states {
+		if state.Start.Before(cutoff) {
+			delete(s.states, key)
+
+			pruned++
+		}
+	}
+
+	return pruned
+}
+
+// Len returns the number of tenants with stored state.
+func (s *MemoryStore) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return len(s.states)
+}
@@ -0,0 +1,232 @@
+package tokenizer
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+)
+
+// SentencePiece piece types from sentencepiece_model.proto.
+const (
+	spPieceNormal      = 1
+	spPieceUnknown     = 2
+	spPieceControl     = 3
+	spPieceUserDefined = 4
+	spPieceUnused      = 5
+	spPieceByte        = 6
+)
+
+// Field numbers in sentencepiece_model.proto.
+const (
+	spModelPieces     = 1
+	spModelTrainer    = 2
+	spModelNormalizer = 3
+
+	spPieceText  = 1
+	spPieceScore = 2
+	spPieceType  = 3
+
+	spTrainerModelType    = 3
+	spTrainerByteFallback = 35
+
+	spNormalizerCharsMap     = 2
+	spNormalizerDummyPrefix  = 3
+	spNormalizerRemoveSpaces = 4
+	spNormalizerEscapeSpaces = 5
+
+	spModelTypeUnigram = 1
+)
+
+const (
+	// spBytePieceFmt names the byte-fallback piece for one byte.
+	spBytePieceFmt = "<0x%02X>"
+
+	errSentencePieceMsg      = "invalid SentencePiece model"
+	errUnsupportedSPTypeMsg  = "unsupported SentencePiece model type"
+	errSentencePieceWrapFmt  = "%w: %w"
+	errUnsupportedSPTypeFmt  = "%w %d (only unigram is supported)"
+	errLoadSentencePieceFmt  = "load SentencePiece model %q: %w"
+	errNormalizerCharsMapFmt = "normalizer: %w"
+)
+
+var (
+	// ErrInvalidSentencePiece wraps every SentencePiece parse failure.
+	ErrInvalidSentencePiece = errors.New(errSentencePieceMsg)
+	// ErrUnsupportedModelType is returned for BPE, word and char models.
+	ErrUnsupportedModelType = errors.New(errUnsupportedSPTypeMsg)
+)
+
+type spPiece struct {
+	text  string
+	score float32
+	kind  int
+}
+
+// SentencePieceModel is a unigram SentencePiece model loaded from a .model
+// file. It implements Encoder, Normalizer and SpecialTokenLister and is safe
+// for concurrent use.
+type SentencePieceModel struct {
+	normalizer spNormalizer
+	unigram
+}
+
+// LoadSentencePiece reads a SentencePiece .model file.
+func LoadSentencePiece(path string) (*SentencePieceModel, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf(errLoadSentencePieceFmt, path, err)
+	}
+
+	model, err := ParseSentencePiece(data)
+	if err != nil {
+		return nil, fmt.Errorf(errLoadSentencePieceFmt, path, err)
+	}
+
+	return model, nil
+}
+
+// ParseSentencePiece decodes a serialized SentencePiece ModelProto.
+func ParseSentencePiece(data []byte) (*SentencePieceModel, error) {
+	model := &SentencePieceModel{
+		normalizer: spNormalizer{
+			charsMap:               nil,
+			addDummyPrefix:         true,
+			removeExtraWhitespaces: true,
+			escapeWhitespaces:      true,
+		},
+		unigram: newUnigram(),
+	}
+
+	modelType := spModelTypeUnigram
+
+	err := forEachProtoField(data, func(field protoField) error {
+		switch field.number {
+		case spModelPieces:
+			return model.addPiece(field.data)
+		case spModelTrainer:
+			return parseTrainerSpec(field.data, &modelType, &model.byteFallback)
+		case spModelNormalizer:
+			return model.normalizer.parse(field.data)
+		default:
+			return nil
+		}
+	})
+	if err != nil {
+		return nil, fmt.Errorf(errSentencePieceWrapFmt, ErrInvalidSentencePiece, err)
+	}
+
+	if modelType != spModelTypeUnigram {
+		return nil, fmt.Errorf(errUnsupportedSPTypeFmt, ErrUnsupportedModelType, modelType)
+	}
+
+	err = model.validate()
+	if err != nil {
+		return nil, fmt.Errorf(errSentencePieceWrapFmt, ErrInvalidSentencePiece, err)
+	}
+
+	return model, nil
+}
+
+func (m *SentencePieceModel) addPiece(msg []byte) error {
+	piece := spPiece{text: "", score: 0, kind: spPieceNormal}
+
+	err := forEachProtoField(msg, func(field protoField) error {
+		switch field.number {
+		case spPieceText:
+			piece.text = string(field.data)
+		case spPieceScore:
+			piece.score = field.float32()
+		case spPieceType:
+			piece.kind = int(field.num)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	m.add(piece)
+
+	return nil
+}
+
+func parseTrainerSpec(msg []byte, modelType *int, byteFallback *bool) error {
+	return forEachProtoField(msg, func(field protoField) error {
+		switch field.number {
+		case spTrainerModelType:
+			*modelType = int(field.num)
+		case spTrainerByteFallback:
+			*byteFallback = field.num != 0
+		}
+
+		return nil
+	})
+}
+
+func (n *spNormalizer) parse(msg []byte) error {
+	return forEachProtoField(msg, func(field protoField) error {
+		var err error
+
+		switch field.number {
+		case spNormalizerCharsMap:
+			if len(field.data) > 0 {
+				n.charsMap, err = parseCharsMap(field.data)
+			}
+		case spNormalizerDummyPrefix:
+			n.addDummyPrefix = field.num != 0
+		case spNormalizerRemoveSpaces:
+			n.removeExtraWhitespaces = field.num != 0
+		case spNormalizerEscapeSpaces:
+			n.escapeWhitespaces = field.num != 0
+		}
+
+		if err != nil {
+			return fmt.Errorf(errNormalizerCharsMapFmt, err)
+		}
+
+		return nil
+	})
+}
+
+// VocabSize returns the number of pieces, including control pieces.
+func (m *SentencePieceModel) VocabSize() int {
+	return len(m.pieces)
+}
+
+// IDToPiece returns the piece text for id, or "" when out of range.
+func (m *SentencePieceModel) IDToPiece(id int) string {
+	if id < 0 || id >= len(m.pieces) {
+		return ""
+	}
+
+	return m.pieces[id].text
+}
+
+// SpecialTokens lists the control pieces, such as <s> and </s>. Encode
+// never produces them from text.
+func (m *SentencePieceModel) SpecialTokens() []string {
+	var tokens []string
+
+	for _, piece := range m.pieces {
+		if piece.kind == spPieceControl {
+			tokens = append(tokens, piece.text)
+		}
+	}
+
+	slices.Sort(tokens)
+
+	return tokens
+}
+
+// Normalize returns text as the model sees it, with spaces as U+2581.
+func (m *SentencePieceModel) Normalize(text string) string {
+	return m.normalizer.normalize(text)
+}
+
+// Encode returns the most likely segmentation of text under the unigram
+// model. No BOS or EOS pieces are added.
+func (m *SentencePieceModel) Encode(text string) []int {
+	return m.encode(m.normalizer.normalize(text))
+}
@@ -0,0 +1,121 @@
+package tokenizer_test
+
+import (
+	"errors"
+	"os"
+	"slices"
+	"testing"
+
+	tokenizer "github.com/nnikolov3/ai-tokenizer"
+)
+
+// Models written by testdata/gen_sentencepiece.go.
+const (
+	UnigramModelPath      = "testdata/unigram.model"
+	ByteFallbackModelPath = "testdata/unigram_bytefallback.model"
+	BPEModelPath          = "testdata/bpe.model"
+	InvalidModelPath      = "testdata/missing.model"
+
+	LoadModelFormat     = "LoadSentencePiece(%q) error = %v"
+	LoadModelErrFormat  = "LoadSentencePiece(%q) error = %v, want %v"
+	ParseModelErrFormat = "ParseSentencePiece(truncated) error = %v, want %v"
+	EncodeFormat        = "Encode(%q) = %v, want %v"
+	SPNormalizeFormat   = "Normalize(%q) = %q, want %q"
+)
+
+type SentencePieceTestCase struct {
+	name       string
+	input      string
+	normalized string
+	expected   []int
+}
+
+// Piece IDs refer to unigramModel in testdata/gen_sentencepiece.go:
+// 0 <unk>, 3 <sep>, 4 ▁, 5 ▁hello, 9 ▁world, 11 orld, 12 ▁fi, 13 ne,
+// 14 !, 19 w, 26 ▁A.
+func getSentencePieceTestCases() []SentencePieceTestCase {
+	return []SentencePieceTestCase{
+		{"best path beats shorter pieces", "hello world", "▁hello▁world", []int{5, 9}},
+		{"extra whitespace removed", "  hello   world  ", "▁hello▁world", []int{5, 9}},
+		{"charsmap folds to vocabulary", "Ａ ﬁne!", "▁A▁fine!", []int{26, 12, 13, 14}},
+		{"charsmap maps ideographic space", "hello　world", "▁hello▁world", []int{5, 9}},
+		{"adjacent unknowns merge", "hello 中文", "▁hello▁中文", []int{5, 4, 0}},
+		{"user-defined piece preferred", "hello<sep>world", "▁hello<sep>world", []int{5, 3, 19, 11}},
+		{"unused piece never matched", "xyz", "▁xyz", []int{4, 0}},
+		{"whitespace only", "   ", "", nil},
+	}
+}
+
+func loadModel(t *testing.T, path string) *tokenizer.SentencePieceModel {
+	t.Helper()
+
+	model, err := tokenizer.LoadSentencePiece(path)
+	if err != nil {
+		t.Fatalf(LoadModelFormat, path, err)
+	}
+
+	return model
+}
+
+func TestSentencePieceEncode(t *testing.T) {
+	t.Parallel()
+
+	model := loadModel(t, UnigramModelPath)
+
+	for _, testCase := range getSentencePieceTestCases() {
+		t.Run(testCase.name, func(t *testing.T) {
+			t.Parallel()
+			validateSentencePiece(t, model, testCase)
+		})
+	}
+}
+
+func validateSentencePiece(t *testing.T, model *tokenizer.SentencePieceModel, testCase SentencePieceTestCase) {
+	t.Helper()
+
+	if got := model.Normalize(testCase.input); got != testCase.normalized {
+		t.Errorf(SPNormalizeFormat, testCase.input, got, testCase.normalized)
+	}
+
+	if got := model.Encode(testCase.input); !slices.Equal(got, testCase.expected) {
+		t.Errorf(EncodeFormat, testCase.input, got, testCase.expected)
+	}
+}
+
+func TestSentencePieceByteFallback(t *testing.T) {
+	t.Parallel()
+
+	model := loadModel(t, ByteFallbackModelPath)
+
+	// ▁hi, ▁, then é as <0xC3> <0xA9>; byte pieces start at ID 3.
+	input := "hi é"
+	want := []int{260, 259, 3 + 0xC3, 3 + 0xA9}
+
+	if got := model.Encode(input); !slices.Equal(got, want) {
+		t.Errorf(EncodeFormat, input, got, want)
+	}
+}
+
+func TestSentencePieceRejectsBadModels(t *testing.T) {
+	t.Parallel()
+
+	_, err := tokenizer.LoadSentencePiece(BPEModelPath)
+	if !errors.Is(err, tokenizer.ErrUnsupportedModelType) {
+		t.Errorf(LoadModelErrFormat, BPEModelPath, err, tokenizer.ErrUnsupportedModelType)
+	}
+
+	data, err := os.ReadFile(UnigramModelPath)
+	if err != nil {
+		t.Fatalf(LoadModelFormat, UnigramModelPath, err)
+	}
+
+	_, err = tokenizer.ParseSentencePiece(data[:len(data)-1])
+	if !errors.Is(err, tokenizer.ErrInvalidSentencePiece) {
+		t.Errorf(ParseModelErrFormat, err, tokenizer.ErrInvalidSentencePiece)
+	}
+
+	_, err = tokenizer.LoadSentencePiece(InvalidModelPath)
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Errorf(LoadModelErrFormat, InvalidModelPath, err, os.ErrNotExist)
+	}
+}
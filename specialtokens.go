@@ -0,0 +1,310 @@
+package tokenizer
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// SpecialTokenPolicy selects how registered special tokens such as
+// <|endoftext|> are treated when they appear in input text.
+type SpecialTokenPolicy int
+
+const (
+	// SpecialTokenAllow counts each special token as one token. This is the
+	// default. Model encoders apply their own special-token vocabulary, and
+	// other special tokens are counted around the encoder.
+	SpecialTokenAllow SpecialTokenPolicy = iota
+	// SpecialTokenReject makes Analyze and Encode fail with
+	// *SpecialTokenError, for untrusted input that must not carry control
+	// tokens. EstimateTokens cannot fail and counts as under Allow.
+	SpecialTokenReject
+	// SpecialTokenEscape counts special tokens as ordinary text, the way
+	// they are encoded when a model is told not to recognise them.
+	SpecialTokenEscape
+)
+
+const (
+	// Policy names accepted by ParseSpecialTokenPolicy. "error" is
+	// PolicyNameError, shared with the invalid UTF-8 policies.
+	PolicyNameAllow  = "allow"
+	PolicyNameEscape = "escape"
+
+	errSpecialTokenMsg       = "special token in input"
+	errEmptySpecialTokenMsg  = "special token is empty"
+	errUnknownSpecialPolMsg  = "unknown special-token policy"
+	errSpecialTokenDetailFmt = "%s: %q at byte %d"
+	errUnknownSpecialPolFmt  = "%w %q (want allow, error or escape)"
+)
+
+var (
+	// ErrSpecialToken matches every *SpecialTokenError via errors.Is.
+	ErrSpecialToken = errors.New(errSpecialTokenMsg)
+	// ErrEmptySpecialToken is returned by RegisterSpecialTokens for "".
+	ErrEmptySpecialToken = errors.New(errEmptySpecialTokenMsg)
+	// ErrUnknownSpecialTokenPolicy is returned by ParseSpecialTokenPolicy.
+	ErrUnknownSpecialTokenPolicy = errors.New(errUnknownSpecialPolMsg)
+)
+
+// SpecialTokenError reports the first special token found in rejected input.
+type SpecialTokenError struct {
+	Token  string
+	Offset int
+}
+
+func (e *SpecialTokenError) Error() string {
+	return fmt.Sprintf(errSpecialTokenDetailFmt, errSpecialTokenMsg, e.Token, e.Offset)
+}
+
+// Unwrap lets errors.Is match ErrSpecialToken.
+func (e *SpecialTokenError) Unwrap() error { return ErrSpecialToken }
+
+// SpecialTokenLister is implemented by encoders that define their own
+// special tokens. Those tokens join the ones registered for the model.
+type SpecialTokenLister interface {
+	SpecialTokens() []string
+}
+
+// OrdinaryEncoder is implemented by encoders that can encode text without
+// recognising special tokens. SpecialTokenEscape uses it.
+type OrdinaryEncoder interface {
+	EncodeOrdinary(text string) []int
+}
+
+// SpecialTokenCounter is implemented by encoders that add special tokens
+// around every sequence, such as BERT's [CLS] and [SEP].
+type SpecialTokenCounter interface {
+	SpecialTokenCount() int
+}
+
+// specialMatch is one occurrence of a special token in text.
+type specialMatch struct {
+	token  string
+	offset int
+}
+
+// specialRegistry holds the special tokens known for each model name.
+var (
+	specialMu       sync.RWMutex
+	specialRegistry = map[string][]string{DefaultModel: defaultSpecialTokens()}
+)
+
+// defaultSpecialTokens are the chat and completion markers of widely used
+// model families, known to the heuristic model.
+func defaultSpecialTokens() []string {
+	return []string{
+		"<|endoftext|>", "<|startoftext|>", "<|endofprompt|>",
+		"<|im_start|>", "<|im_end|>", "<|im_sep|>",
+		"<|fim_prefix|>", "<|fim_middle|>", "<|fim_suffix|>", "<|fim_pad|>",
+		"<|begin_of_text|>", "<|end_of_text|>", "<|start_header_id|>",
+		"<|end_header_id|>", "<|eot_id|>", "<|eom_id|>", "<|python_tag|>",
+	}
+}
+
+// RegisterSpecialTokens adds special tokens for a model name. The model does
+// not need to be registered yet, so names used with WithEncoder work too.
+func RegisterSpecialTokens(model string, tokens ...string) error {
+	if model == "" {
+		return ErrEmptyModelName
+	}
+
+	if slices.Contains(tokens, "") {
+		return ErrEmptySpecialToken
+	}
+
+	specialMu.Lock()
+	defer specialMu.Unlock()
+
+	specialRegistry[model] = append(specialRegistry[model], tokens...)
+
+	return nil
+}
+
+// SpecialTokens returns the special tokens registered for a model, sorted.
+func SpecialTokens(model string) []string {
+	specialMu.RLock()
+	defer specialMu.RUnlock()
+
+	tokens := slices.Clone(specialRegistry[model])
+	slices.Sort(tokens)
+
+	return slices.Compact(tokens)
+}
+
+// WithSpecialTokenPolicy sets how special tokens in input are treated.
+func WithSpecialTokenPolicy(policy SpecialTokenPolicy) Option {
+	return func(t *Tokenizer) {
+		t.specialPolicy = policy
+	}
+}
+
+// WithSpecialTokens adds special tokens for this tokenizer only, on top of
+// those registered for its model. Empty strings are ignored.
+func WithSpecialTokens(tokens ...string) Option {
+	return func(t *Tokenizer) {
+		t.specials = append(t.specials, tokens...)
+	}
+}
+
+// ParseSpecialTokenPolicy converts a policy name to its value.
+func ParseSpecialTokenPolicy(name string) (SpecialTokenPolicy, error) {
+	switch name {
+	case PolicyNameAllow:
+		return SpecialTokenAllow, nil
+	case PolicyNameError:
+		return SpecialTokenReject, nil
+	case PolicyNameEscape:
+		return SpecialTokenEscape, nil
+	default:
+		return SpecialTokenAllow, fmt.Errorf(errUnknownSpecialPolFmt, ErrUnknownSpecialTokenPolicy, name)
+	}
+}
+
+// String returns the name accepted by ParseSpecialTokenPolicy.
+func (p SpecialTokenPolicy) String() string {
+	switch p {
+	case SpecialTokenReject:
+		return PolicyNameError
+	case SpecialTokenEscape:
+		return PolicyNameEscape
+	default:
+		return PolicyNameAllow
+	}
+}
+
+// SpecialTokens returns every special token this tokenizer recognises,
+// sorted.
+func (t *Tokenizer) SpecialTokens() []string {
+	tokens := slices.Clone(t.specials)
+	slices.Sort(tokens)
+
+	return tokens
+}
+
+// SpecialTokenCount returns how many tokens the model adds around every
+// input, such as [CLS] and [SEP]. It is 0 for the heuristic model and for
+// encoders that do not implement SpecialTokenCounter.
+func (t *Tokenizer) SpecialTokenCount() int {
+	if counter, ok := t.encoder.(SpecialTokenCounter); ok {
+		return counter.SpecialTokenCount()
+	}
+
+	return 0
+}
+
+// resolveSpecialTokens merges the model's registered tokens, the encoder's
+// own and any added with WithSpecialTokens, longest first so matching
+// prefers <|im_start|> over a shorter token it contains. Those the encoder
+// does not list itself are also kept as extras, which EstimateTokens
+// counts around the encoder.
+func (t *Tokenizer) resolveSpecialTokens() {
+	tokens := append(SpecialTokens(t.model), t.specials...)
+
+	var own []string
+	if lister, ok := t.encoder.(SpecialTokenLister); ok {
+		own = lister.SpecialTokens()
+	}
+
+	t.extras = sortSpecialTokens(slices.DeleteFunc(slices.Clone(tokens), func(token string) bool {
+		return slices.Contains(own, token)
+	}))
+	t.specials = sortSpecialTokens(append(tokens, own...))
+}
+
+// sortSpecialTokens drops empty and duplicate tokens and orders the rest
+// longest first.
+func sortSpecialTokens(tokens []string) []string {
+	tokens = slices.DeleteFunc(tokens, func(token string) bool { return token == "" })
+	slices.SortFunc(tokens, func(a, b string) int {
+		return cmp.Or(len(b)-len(a), strings.Compare(a, b))
+	})
+
+	return slices.Compact(tokens)
+}
+
+// findSpecialTokens returns the leftmost-longest special-token matches in
+// text, without overlaps.
+func (t *Tokenizer) findSpecialTokens(text string) []specialMatch {
+	return findTokens(text, t.specials)
+}
+
+// findTokens returns the leftmost matches of tokens, ordered longest first,
+// in text, without overlaps.
+func findTokens(text string, tokens []string) []specialMatch {
+	if len(tokens) == 0 {
+		return nil
+	}
+
+	var matches []specialMatch
+
+	for pos := 0; pos < len(text); pos++ {
+		for _, token := range tokens {
+			if strings.HasPrefix(text[pos:], token) {
+				matches = append(matches, specialMatch{token: token, offset: pos})
+				pos += len(token) - 1
+
+				break
+			}
+		}
+	}
+
+	return matches
+}
+
+// countSpecialTokens tallies matches by token, or returns nil for none.
+func countSpecialTokens(matches []specialMatch) map[string]int {
+	if len(matches) == 0 {
+		return nil
+	}
+
+	counts := make(map[string]int, len(matches))
+	for _, match := range matches {
+		counts[match.token]++
+	}
+
+	return counts
+}
+
+// rejectSpecialTokens returns *SpecialTokenError under SpecialTokenReject
+// when matches is not empty.
+func (t *Tokenizer) rejectSpecialTokens(matches []specialMatch) error {
+	if t.specialPolicy != SpecialTokenReject || len(matches) == 0 {
+		return nil
+	}
+
+	return &SpecialTokenError{Token: matches[0].token, Offset: matches[0].offset}
+}
+
+// estimateWithSpecialTokens counts each special token as one token and the
+// text between them with the heuristic.
+func (t *Tokenizer) estimateWithSpecialTokens(text string, matches []specialMatch) int {
+	return countAroundSpecialTokens(text, matches, func(s string) int {
+		return t.countTokensFromNormalizedText(t.Normalize(s))
+	})
+}
+
+// countAroundSpecialTokens counts each special token as one token and the
+// text between them with count.
+func countAroundSpecialTokens(text string, matches []specialMatch, count func(string) int) int {
+	total, last := len(matches), 0
+
+	for _, match := range matches {
+		total += count(text[last:match.offset])
+		last = match.offset + len(match.token)
+	}
+
+	return total + count(text[last:])
+}
+
+// encodeWithPolicy encodes prepared input, bypassing the encoder's special
+// tokens under SpecialTokenEscape when it supports that.
+func (t *Tokenizer) encodeWithPolicy(input string) []int {
+	if ordinary, ok := t.encoder.(OrdinaryEncoder); ok && t.specialPolicy == SpecialTokenEscape {
+		return ordinary.EncodeOrdinary(input)
+	}
+
+	return t.encoder.Encode(input)
+}
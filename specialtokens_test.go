@@ -0,0 +1,209 @@
+package tokenizer_test
+
+import (
+	"errors"
+	"maps"
+	"slices"
+	"testing"
+
+	tokenizer "github.com/nnikolov3/ai-tokenizer"
+)
+
+const (
+	// SpecialModelName is unique to this file; the registry is global.
+	SpecialModelName = "test-special-tokens"
+	ChatPrompt       = "<|im_start|>user\nHi<|im_end|>"
+	TurnMarker       = "<start_of_turn>"
+
+	SpecialEstimateFormat = "policy %v: EstimateTokens(%q) = %d, want %d"
+	SpecialAnalyzeFormat  = "Analyze(%q) error = %v, want %v"
+	SpecialOffsetFormat   = "SpecialTokenError = %+v, want token %q at %d"
+	SpecialCountsFormat   = "Analyze(%q).SpecialTokens = %v, want %v"
+	SpecialListFormat     = "SpecialTokens() = %v, want it to contain %q"
+	SpecialPolicyFormat   = "ParseSpecialTokenPolicy(%q) = %v, %v; want %v"
+	SpecialRegisterFormat = "RegisterSpecialTokens(%q) error = %v, want %v"
+)
+
+type SpecialEstimateTestCase struct {
+	name     string
+	input    string
+	policy   tokenizer.SpecialTokenPolicy
+	expected int
+}
+
+func getSpecialEstimateTestCases() []SpecialEstimateTestCase {
+	return []SpecialEstimateTestCase{
+		// Two markers plus "user\nHi" (4 tokens).
+		{"allowed markers count once", ChatPrompt, tokenizer.SpecialTokenAllow, 6},
+		{"rejecting policy still estimates", ChatPrompt, tokenizer.SpecialTokenReject, 6},
+		{"escaped markers count as text", ChatPrompt, tokenizer.SpecialTokenEscape, 21},
+		{"unregistered marker is text", "<|not_special|>", tokenizer.SpecialTokenAllow, 11},
+		{"no markers", "user\nHi", tokenizer.SpecialTokenAllow, 4},
+	}
+}
+
+func TestSpecialTokenEstimate(t *testing.T) {
+	t.Parallel()
+
+	for _, testCase := range getSpecialEstimateTestCases() {
+		t.Run(testCase.name, func(t *testing.T) {
+			t.Parallel()
+
+			tok := tokenizer.NewTokenizer(tokenizer.WithSpecialTokenPolicy(testCase.policy))
+			if got := tok.EstimateTokens(testCase.input); got != testCase.expected {
+				t.Errorf(SpecialEstimateFormat, testCase.policy, testCase.input, got, testCase.expected)
+			}
+		})
+	}
+}
+
+func TestSpecialTokenAnalyze(t *testing.T) {
+	t.Parallel()
+
+	analysis, err := tokenizer.NewTokenizer().Analyze(ChatPrompt + ChatPrompt)
+	if err != nil {
+		t.Fatalf(SpecialAnalyzeFormat, ChatPrompt, err, nil)
+	}
+
+	want := map[string]int{"<|im_start|>": 2, "<|im_end|>": 2}
+	if !maps.Equal(analysis.SpecialTokens, want) {
+		t.Errorf(SpecialCountsFormat, ChatPrompt, analysis.SpecialTokens, want)
+	}
+
+	reject := tokenizer.NewTokenizer(tokenizer.WithSpecialTokenPolicy(tokenizer.SpecialTokenReject))
+
+	input := "hi " + ChatPrompt
+
+	_, err = reject.Analyze(input)
+
+	var specialErr *tokenizer.SpecialTokenError
+	if !errors.As(err, &specialErr) || !errors.Is(err, tokenizer.ErrSpecialToken) {
+		t.Fatalf(SpecialAnalyzeFormat, input, err, tokenizer.ErrSpecialToken)
+	}
+
+	if specialErr.Token != "<|im_start|>" || specialErr.Offset != 3 {
+		t.Errorf(SpecialOffsetFormat, specialErr, "<|im_start|>", 3)
+	}
+}
+
+func TestSpecialTokensPerTokenizer(t *testing.T) {
+	t.Parallel()
+
+	tok := tokenizer.NewTokenizer(tokenizer.WithSpecialTokens("[INST]"))
+	if !slices.Contains(tok.SpecialTokens(), "[INST]") {
+		t.Errorf(SpecialListFormat, tok.SpecialTokens(), "[INST]")
+	}
+
+	// "[INST]" as text is 3 tokens; as a special token it is 1.
+	input := "[INST]"
+	if got := tok.EstimateTokens(input); got != 1 {
+		t.Errorf(SpecialEstimateFormat, tokenizer.SpecialTokenAllow, input, got, 1)
+	}
+}
+
+func TestSpecialTokenRegistry(t *testing.T) {
+	t.Parallel()
+
+	err := tokenizer.RegisterSpecialTokens(SpecialModelName, TurnMarker)
+	if err != nil {
+		t.Fatalf(SpecialRegisterFormat, SpecialModelName, err, nil)
+	}
+
+	err = tokenizer.RegisterSpecialTokens(SpecialModelName, "")
+	if !errors.Is(err, tokenizer.ErrEmptySpecialToken) {
+		t.Errorf(SpecialRegisterFormat, SpecialModelName, err, tokenizer.ErrEmptySpecialToken)
+	}
+
+	tok := tokenizer.NewTokenizer(
+		tokenizer.WithEncoder(SpecialModelName, loadHF(t, HFBPEPath)),
+		tokenizer.WithSpecialTokenPolicy(tokenizer.SpecialTokenReject),
+	)
+
+	// Registered for the model name, and listed by the encoder itself.
+	for _, want := range []string{TurnMarker, "<|endoftext|>"} {
+		if !slices.Contains(tok.SpecialTokens(), want) {
+			t.Errorf(SpecialListFormat, tok.SpecialTokens(), want)
+		}
+	}
+
+	_, err = tok.Encode("hello" + TurnMarker)
+	if !errors.Is(err, tokenizer.ErrSpecialToken) {
+		t.Errorf(EncodeErrFormat, "hello"+TurnMarker, nil, err, nil, tokenizer.ErrSpecialToken)
+	}
+}
+
+func TestSpecialTokenEscapeWithEncoders(t *testing.T) {
+	t.Parallel()
+
+	escape := tokenizer.WithSpecialTokenPolicy(tokenizer.SpecialTokenEscape)
+
+	// hf_bpe.json lacks "<", "|", "n", "f", "t" and "x", so the escaped
+	// marker leaves only e, d, o, e after hello.
+	hf := tokenizer.NewTokenizer(tokenizer.WithEncoder("bpe", loadHF(t, HFBPEPath)), escape)
+	validateEncode(t, hf, "hello<|endoftext|>", []int{12, 1, 6, 3, 1})
+
+	bert := tokenizer.NewTokenizer(tokenizer.WithEncoder("bert", loadWordPiece(t)), escape)
+	validateEncode(t, bert, "[MASK] hello", []int{2, 1, 1, 1, 5, 3})
+}
+
+func TestSpecialTokenExtrasWithEncoders(t *testing.T) {
+	t.Parallel()
+
+	input := "[INST] hello"
+
+	tests := []struct {
+		name   string
+		enc    tokenizer.Encoder
+		allow  int
+		escape int
+	}{
+		// Escaped, the marker is encoded as text.
+		{name: "unigram", enc: loadModel(t, UnigramModelPath), allow: 2, escape: 3},
+		// [CLS] [INST] hello [SEP], or [ inst ] as three [UNK]s.
+		{name: "bert", enc: loadWordPiece(t), allow: 4, escape: 6},
+	}
+
+	for _, tt := range tests {
+		for policy, want := range map[tokenizer.SpecialTokenPolicy]int{
+			tokenizer.SpecialTokenAllow:  tt.allow,
+			tokenizer.SpecialTokenEscape: tt.escape,
+		} {
+			tok := tokenizer.NewTokenizer(
+				tokenizer.WithEncoder(tt.name, tt.enc),
+				tokenizer.WithSpecialTokens("[INST]"),
+				tokenizer.WithSpecialTokenPolicy(policy),
+			)
+
+			if got := tok.EstimateTokens(input); got != want {
+				t.Errorf(SpecialEstimateFormat, policy, input, got, want)
+			}
+		}
+	}
+}
+
+func validateEncode(t *testing.T, tok *tokenizer.Tokenizer, input string, want []int) {
+	t.Helper()
+
+	got, err := tok.Encode(input)
+	if err != nil || !slices.Equal(got, want) {
+		t.Errorf(EncodeErrFormat, input, got, err, want, nil)
+	}
+}
+
+func TestParseSpecialTokenPolicy(t *testing.T) {
+	t.Parallel()
+
+	for _, policy := range []tokenizer.SpecialTokenPolicy{
+		tokenizer.SpecialTokenAllow, tokenizer.SpecialTokenReject, tokenizer.SpecialTokenEscape,
+	} {
+		got, err := tokenizer.ParseSpecialTokenPolicy(policy.String())
+		if err != nil || got != policy {
+			t.Errorf(SpecialPolicyFormat, policy.String(), got, err, policy)
+		}
+	}
+
+	_, err := tokenizer.ParseSpecialTokenPolicy("keep")
+	if !errors.Is(err, tokenizer.ErrUnknownSpecialTokenPolicy) {
+		t.Errorf(SpecialPolicyFormat, "keep", nil, err, tokenizer.ErrUnknownSpecialTokenPolicy)
+	}
+}
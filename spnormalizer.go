@@ -0,0 +1,216 @@
+package tokenizer
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	// spaceSymbol replaces spaces in SentencePiece-normalized text.
+	spaceSymbol = "▁"
+	asciiSpace  = " "
+
+	// Bit layout of a darts-clone double-array unit.
+	dartsHasLeafShift = 8
+	dartsOffsetShift  = 10
+	dartsExtendBit    = 1 << 9
+	dartsExtendShift  = 6
+	dartsValueMask    = 1<<31 - 1
+	dartsLabelMask    = 1<<31 | 0xFF
+	dartsUnitBytes    = 4
+
+	errCharsMapMsg = "malformed precompiled charsmap"
+)
+
+var errCharsMap = errors.New(errCharsMapMsg)
+
+// charsMap is SentencePiece's precompiled normalization table: a darts-clone
+// double-array trie over input bytes whose values index NUL-terminated
+// replacement strings.
+type charsMap struct {
+	units      []uint32
+	normalized []byte
+}
+
+// spNormalizer mirrors SentencePiece's NormalizerSpec. User-defined symbols
+// are normalized like any other text.
+type spNormalizer struct {
+	charsMap               *charsMap
+	addDummyPrefix         bool
+	removeExtraWhitespaces bool
+	escapeWhitespaces      bool
+}
+
+// parseCharsMap decodes a precompiled charsmap blob: a little-endian uint32
+// trie size, the trie units, then the replacement strings.
+func parseCharsMap(blob []byte) (*charsMap, error) {
+	if len(blob) < dartsUnitBytes {
+		return nil, errCharsMap
+	}
+
+	trieSize := int(binary.LittleEndian.Uint32(blob))
+	blob = blob[dartsUnitBytes:]
+
+	if trieSize > len(blob) || trieSize%dartsUnitBytes != 0 {
+		return nil, errCharsMap
+	}
+
+	units := make([]uint32, trieSize/dartsUnitBytes)
+	for i := range units {
+		units[i] = binary.LittleEndian.Uint32(blob[i*dartsUnitBytes:])
+	}
+
+	return &charsMap{units: units, normalized: blob[trieSize:]}, nil
+}
+
+// longestMatch returns the replacement for the longest key that prefixes
+// input and the number of input bytes it covers, or 0 when none does.
+func (c *charsMap) longestMatch(input string) (string, int) {
+	if len(c.units) == 0 {
+		return "", 0
+	}
+
+	var (
+		replacement string
+		matched     int
+	)
+
+	pos := dartsOffset(c.units[0])
+
+	for i := range len(input) {
+		pos ^= uint32(input[i])
+		if int(pos) >= len(c.units) {
+			break
+		}
+
+		unit := c.units[pos]
+		if unit&dartsLabelMask != uint32(input[i]) {
+			break
+		}
+
+		pos ^= dartsOffset(unit)
+
+		if unit>>dartsHasLeafShift&1 == 1 && int(pos) < len(c.units) {
+			replacement = c.replacement(c.units[pos] & dartsValueMask)
+			matched = i + 1
+		}
+	}
+
+	return replacement, matched
+}
+
+func (c *charsMap) replacement(offset uint32) string {
+	if int(offset) >= len(c.normalized) {
+		return ""
+	}
+
+	tail := c.normalized[offset:]
+	if end := bytes.IndexByte(tail, 0); end >= 0 {
+		tail = tail[:end]
+	}
+
+	return string(tail)
+}
+
+func dartsOffset(unit uint32) uint32 {
+	return (unit >> dartsOffsetShift) << ((unit & dartsExtendBit) >> dartsExtendShift)
+}
+
+// normalizePrefix rewrites the first character or charsmap key of input and
+// reports how many bytes it consumed. Malformed UTF-8 becomes U+FFFD.
+func (n *spNormalizer) normalizePrefix(input string) (string, int) {
+	if n.charsMap != nil {
+		if replacement, matched := n.charsMap.longestMatch(input); matched > 0 {
+			return replacement, matched
+		}
+	}
+
+	r, size := utf8.DecodeRuneInString(input)
+	if r == utf8.RuneError && size == 1 {
+		return string(utf8.RuneError), 1
+	}
+
+	return input[:size], size
+}
+
+// mapChars applies only the charsmap, as the tokenizer.json Precompiled
+// normalizer does.
+func (n *spNormalizer) mapChars(input string) string {
+	var builder strings.Builder
+	builder.Grow(len(input))
+
+	for input != "" {
+		piece, consumed := n.normalizePrefix(input)
+		builder.WriteString(piece)
+		input = input[consumed:]
+	}
+
+	return builder.String()
+}
+
+// normalize applies the charsmap and whitespace rules the way
+// SentencePiece's Normalizer does before encoding.
+func (n *spNormalizer) normalize(input string) string {
+	if n.removeExtraWhitespaces {
+		input = n.skipLeadingSpaces(input)
+	}
+
+	if input == "" {
+		return ""
+	}
+
+	space := asciiSpace
+	if n.escapeWhitespaces {
+		space = spaceSymbol
+	}
+
+	var builder strings.Builder
+	builder.Grow(len(input) + len(space))
+
+	if n.addDummyPrefix {
+		builder.WriteString(space)
+	}
+
+	prevSpace := n.removeExtraWhitespaces
+
+	for input != "" {
+		piece, consumed := n.normalizePrefix(input)
+		input = input[consumed:]
+
+		if prevSpace {
+			piece = strings.TrimLeft(piece, asciiSpace)
+		}
+
+		if piece != "" {
+			builder.WriteString(strings.ReplaceAll(piece, asciiSpace, space))
+			prevSpace = strings.HasSuffix(piece, asciiSpace)
+		}
+
+		prevSpace = prevSpace && n.removeExtraWhitespaces
+	}
+
+	normalized := builder.String()
+	if n.removeExtraWhitespaces {
+		for strings.HasSuffix(normalized, space) {
+			normalized = strings.TrimSuffix(normalized, space)
+		}
+	}
+
+	return normalized
+}
+
+func (n *spNormalizer) skipLeadingSpaces(input string) string {
+	for input != "" {
+		piece, consumed := n.normalizePrefix(input)
+		if piece != asciiSpace {
+			break
+		}
+
+		input = input[consumed:]
+	}
+
+	return input
+}
@@ -0,0 +1,307 @@
+//go:build ignore
+
+// gen_sentencepiece writes the small SentencePiece models used by the tests.
+// SentencePiece itself is not needed: the protobuf and the precompiled
+// charsmap trie are encoded by hand.
+//
+//	go run testdata/gen_sentencepiece.go
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+)
+
+const (
+	pieceNormal      = 1
+	pieceUnknown     = 2
+	pieceControl     = 3
+	pieceUserDefined = 4
+	pieceUnused      = 5
+	pieceByte        = 6
+
+	modelTypeUnigram = 1
+	modelTypeBPE     = 2
+
+	wireVarint  = 0
+	wireBytes   = 2
+	wireFixed32 = 5
+)
+
+type piece struct {
+	text  string
+	score float32
+	kind  int
+}
+
+type model struct {
+	charsMap     map[string]string
+	pieces       []piece
+	modelType    int
+	byteFallback bool
+}
+
+func main() {
+	dir := "testdata"
+
+	models := map[string]model{
+		"unigram.model":              unigramModel(),
+		"unigram_bytefallback.model": byteFallbackModel(),
+		"bpe.model":                  {pieces: []piece{{"<unk>", 0, pieceUnknown}}, modelType: modelTypeBPE},
+	}
+
+	for name, m := range models {
+		err := os.WriteFile(filepath.Join(dir, name), m.marshal(), 0o644)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+}
+
+func unigramModel() model {
+	pieces := []piece{
+		{"<unk>", 0, pieceUnknown},
+		{"<s>", 0, pieceControl},
+		{"</s>", 0, pieceControl},
+		{"<sep>", 0, pieceUserDefined},
+		{"▁", -2, pieceNormal},
+		{"▁hello", -3, pieceNormal},
+		{"▁he", -4, pieceNormal},
+		{"llo", -4.5, pieceNormal},
+		{"hello", -5, pieceNormal},
+		{"▁world", -3.5, pieceNormal},
+		{"▁w", -5, pieceNormal},
+		{"orld", -5.5, pieceNormal},
+		{"▁fi", -4, pieceNormal},
+		{"ne", -4.5, pieceNormal},
+		{"!", -3, pieceNormal},
+	}
+
+	for _, char := range "helowrdfin" {
+		pieces = append(pieces, piece{string(char), -6, pieceNormal})
+	}
+
+	pieces = append(pieces,
+		piece{"xyz", 0, pieceUnused},
+		piece{"▁A", -4, pieceNormal},
+	)
+
+	return model{
+		charsMap: map[string]string{
+			"\uFF21": "A",  // FULLWIDTH LATIN CAPITAL LETTER A
+			"\uFB01": "fi", // LATIN SMALL LIGATURE FI
+			"\u3000": " ",  // IDEOGRAPHIC SPACE
+		},
+
+		pieces:    pieces,
+		modelType: modelTypeUnigram,
+	}
+}
+
+func byteFallbackModel() model {
+	pieces := []piece{
+		{"<unk>", 0, pieceUnknown},
+		{"<s>", 0, pieceControl},
+		{"</s>", 0, pieceControl},
+	}
+
+	for value := range 256 {
+		pieces = append(pieces, piece{fmt.Sprintf("<0x%02X>", value), 0, pieceByte})
+	}
+
+	pieces = append(pieces,
+		piece{"▁", -2, pieceNormal},
+		piece{"▁hi", -3, pieceNormal},
+	)
+
+	return model{pieces: pieces, modelType: modelTypeUnigram, byteFallback: true}
+}
+
+func (m model) marshal() []byte {
+	var out []byte
+
+	for _, p := range m.pieces {
+		var msg []byte
+		msg = appendBytes(msg, 1, []byte(p.text))
+		msg = appendFixed32(msg, 2, math.Float32bits(p.score))
+		msg = appendVarint(msg, 3, uint64(p.kind))
+		out = appendBytes(out, 1, msg)
+	}
+
+	var trainer []byte
+	trainer = appendVarint(trainer, 3, uint64(m.modelType))
+
+	if m.byteFallback {
+		trainer = appendVarint(trainer, 35, 1)
+	}
+
+	out = appendBytes(out, 2, trainer)
+
+	var normalizer []byte
+	normalizer = appendBytes(normalizer, 1, []byte("nmt_nfkc"))
+
+	if m.charsMap != nil {
+		normalizer = appendBytes(normalizer, 2, buildCharsMap(m.charsMap))
+	}
+
+	normalizer = appendVarint(normalizer, 3, 1)
+	normalizer = appendVarint(normalizer, 4, 1)
+	normalizer = appendVarint(normalizer, 5, 1)
+
+	return appendBytes(out, 3, normalizer)
+}
+
+func appendTag(b []byte, field, wireType int) []byte {
+	return binary.AppendUvarint(b, uint64(field<<3|wireType))
+}
+
+func appendVarint(b []byte, field int, v uint64) []byte {
+	return binary.AppendUvarint(appendTag(b, field, wireVarint), v)
+}
+
+func appendFixed32(b []byte, field int, v uint32) []byte {
+	return binary.LittleEndian.AppendUint32(appendTag(b, field, wireFixed32), v)
+}
+
+func appendBytes(b []byte, field int, data []byte) []byte {
+	b = binary.AppendUvarint(appendTag(b, field, wireBytes), uint64(len(data)))
+
+	return append(b, data...)
+}
+
+// trieNode is a byte trie node; value is an offset into the replacement
+// blob, or -1.
+type trieNode struct {
+	children map[byte]*trieNode
+	value    int
+}
+
+// buildCharsMap encodes replacements as SentencePiece's precompiled
+// charsmap: a darts-clone double array followed by NUL-terminated strings.
+func buildCharsMap(replacements map[string]string) []byte {
+	root := &trieNode{children: map[byte]*trieNode{}, value: -1}
+
+	var blob []byte
+
+	keys := make([]string, 0, len(replacements))
+	for key := range replacements {
+		keys = append(keys, key)
+	}
+
+	slices.Sort(keys)
+
+	for _, key := range keys {
+		node := root
+		for i := range len(key) {
+			child, ok := node.children[key[i]]
+			if !ok {
+				child = &trieNode{children: map[byte]*trieNode{}, value: -1}
+				node.children[key[i]] = child
+			}
+
+			node = child
+		}
+
+		node.value = len(blob)
+		blob = append(append(blob, replacements[key]...), 0)
+	}
+
+	builder := &dartsBuilder{units: []uint32{0}, used: map[uint32]bool{0: true}, bases: map[uint32]bool{}}
+	builder.place(root, 0)
+
+	out := binary.LittleEndian.AppendUint32(nil, uint32(len(builder.units)*4))
+	for _, unit := range builder.units {
+		out = binary.LittleEndian.AppendUint32(out, unit)
+	}
+
+	return append(out, blob...)
+}
+
+type dartsBuilder struct {
+	used  map[uint32]bool
+	bases map[uint32]bool
+	units []uint32
+}
+
+// place assigns slots to node's children. A child labelled c lives at
+// base^c; a leaf value lives at base itself (label 0).
+func (b *dartsBuilder) place(node *trieNode, pos uint32) {
+	labels := make([]byte, 0, len(node.children)+1)
+	if node.value >= 0 {
+		labels = append(labels, 0)
+	}
+
+	for label := range node.children {
+		labels = append(labels, label)
+	}
+
+	slices.Sort(labels)
+
+	if len(labels) == 0 {
+		return
+	}
+
+	base := b.findBase(pos, labels)
+	b.units[pos] |= (pos ^ base) << 10
+
+	if node.value >= 0 {
+		b.set(base, uint32(node.value)|1<<31)
+	}
+
+	for _, label := range labels {
+		if label == 0 && node.value >= 0 {
+			continue
+		}
+
+		child := node.children[label]
+		unit := uint32(label)
+
+		if child.value >= 0 {
+			unit |= 1 << 8
+		}
+
+		b.set(base^uint32(label), unit)
+		b.place(child, base^uint32(label))
+	}
+}
+
+func (b *dartsBuilder) findBase(pos uint32, labels []byte) uint32 {
+	for base := uint32(1); ; base++ {
+		if b.bases[base] || pos^base == 0 {
+			continue
+		}
+
+		free := true
+
+		for _, label := range labels {
+			if b.used[base^uint32(label)] {
+				free = false
+
+				break
+			}
+		}
+
+		if free {
+			b.bases[base] = true
+
+			for _, label := range labels {
+				b.used[base^uint32(label)] = true
+			}
+
+			return base
+		}
+	}
+}
+
+func (b *dartsBuilder) set(pos, unit uint32) {
+	for uint32(len(b.units)) <= pos {
+		b.units = append(b.units, 0)
+	}
+
+	b.units[pos] = unit
+}
@@ -4,6 +4,7 @@
 package tokenizer
 
 import (
+	"log/slog"
 	"math"
 	"strings"
 	"unicode"
@@ -11,11 +12,25 @@ import (
 	"golang.org/x/text/unicode/norm"
 )
 
-// Tokenizer implements simple token estimation.
+// Tokenizer implements simple token estimation, or exact counting when it
+// wraps a model Encoder.
 type Tokenizer struct {
-	model string
+	encoder       Encoder
+	model         string
+	specials      []string
+	extras        []string
+	invalidUTF8   InvalidUTF8Policy
+	specialPolicy SpecialTokenPolicy
+	boundMode     UpperBoundMode
+	safetyMargin  float64
+	cache         *Cache
+	scope         string
+	logger        *slog.Logger
 }
 
+// Option configures a Tokenizer created by NewTokenizer.
+type Option func(*Tokenizer)
+
 const (
 	// DefaultModel is the default tokenizer model name.
 	DefaultModel = "simple"
@@ -33,29 +48,141 @@ const (
 	ligatureD      = "d"
 )
 
-// NewTokenizer creates a new simple tokenizer instance.
-func NewTokenizer() *Tokenizer {
-	return &Tokenizer{model: DefaultModel}
+// NewTokenizer creates a new simple tokenizer instance. Without options it
+// drops malformed UTF-8, as it always has, and counts each special token
+// registered for the model as one token.
+func NewTokenizer(opts ...Option) *Tokenizer {
+	t := &Tokenizer{
+		encoder:       nil,
+		model:         DefaultModel,
+		specials:      nil,
+		extras:        nil,
+		invalidUTF8:   InvalidUTF8Skip,
+		specialPolicy: SpecialTokenAllow,
+		boundMode:     UpperBoundConfident,
+		safetyMargin:  0,
+		cache:         nil,
+		scope:         "",
+		logger:        nil,
+	}
+
+	for _, opt := range opts {
+		opt(t)
+	}
+
+	t.resolveSpecialTokens()
+
+	if t.cache != nil {
+		t.scope = t.cacheScope()
+	}
+
+	return t
 }
 
 // EstimateTokens estimates tokens using: 2 chars = 1 token, special chars = 1 token each.
+// It cannot fail, so under InvalidUTF8Reject it counts like InvalidUTF8Skip;
+// use Analyze to detect malformed input. With a model encoder the count is
+// exact. Special tokens follow the tokenizer's SpecialTokenPolicy. With
+// WithCache, repeated texts are counted once.
+//
+// The count is always len(Encode(text)), so empty text counts the tokens
+// the model adds around every input, such as [CLS] and [SEP], and 0 for
+// models that add none. EstimateContentTokens counts empty text as 0.
 func (t *Tokenizer) EstimateTokens(text string) int {
+	if text == "" && t.encoder == nil {
+		return 0
+	}
+
+	if t.cache != nil {
+		return t.cachedEstimate(text)
+	}
+
+	return t.estimateTokens(text)
+}
+
+// EstimateContentTokens counts text as one part of a longer input, without
+// the tokens the model adds around every input (see SpecialTokenCount). The
+// parts of a text split at line ends, such as its lines or sections, add up
+// to this count of the whole, unless the model merges tokens across a split.
+func (t *Tokenizer) EstimateContentTokens(text string) int {
 	if text == "" {
 		return 0
 	}
 
+	return max(t.EstimateTokens(text)-t.SpecialTokenCount(), 0)
+}
+
+func (t *Tokenizer) estimateTokens(text string) int {
+	if t.encoder != nil {
+		return t.estimateWithEncoder(text)
+	}
+
+	if t.specialPolicy != SpecialTokenEscape {
+		if matches := t.findSpecialTokens(text); len(matches) > 0 {
+			tokens := t.estimateWithSpecialTokens(text, matches)
+			t.traceEstimate(text, LogMethodSpecialTokens, len(matches), tokens)
+
+			return tokens
+		}
+	}
+
 	normalized := t.Normalize(text)
+	tokens := t.countTokensFromNormalizedText(normalized)
+	t.traceEstimate(text, LogMethodHeuristic, 0, tokens)
+
+	return tokens
+}
+
+// estimateWithEncoder counts text with the model encoder. Special tokens
+// the encoder does not know itself, registered for the model or added with
+// WithSpecialTokens, count as one token each unless escaped, and the text
+// between them is encoded on its own.
+func (t *Tokenizer) estimateWithEncoder(text string) int {
+	input := t.encoderInput(text)
+
+	if t.specialPolicy != SpecialTokenEscape {
+		if matches := findTokens(input, t.extras); len(matches) > 0 {
+			tokens := countAroundSpecialTokens(input, matches, t.encodedContentTokens) + t.SpecialTokenCount()
+			t.traceEstimate(text, LogMethodSpecialTokens, len(matches), tokens)
+
+			return tokens
+		}
+	}
 
-	return t.countTokensFromNormalizedText(normalized)
+	tokens := len(t.encodeWithPolicy(input))
+	t.traceEstimate(text, LogMethodEncoder, 0, tokens)
+
+	return tokens
+}
+
+// encodedContentTokens encodes prepared input and leaves out the tokens the
+// model adds around it.
+func (t *Tokenizer) encodedContentTokens(input string) int {
+	if input == "" {
+		return 0
+	}
+
+	return max(len(t.encodeWithPolicy(input))-t.SpecialTokenCount(), 0)
 }
 
-// Normalize converts non-ASCII characters to their ASCII equivalents.
+// Normalize converts non-ASCII characters to their ASCII equivalents. Models
+// whose encoder implements Normalizer return their own normalized form.
 func (t *Tokenizer) Normalize(text string) string {
 	if text == "" {
 		return ""
 	}
 
-	return t.processText(norm.NFD.String(text))
+	var normalized string
+
+	if n, ok := t.encoder.(Normalizer); ok {
+		normalized = n.Normalize(t.encoderInput(text))
+	} else {
+		normalized = t.processText(norm.NFD.String(t.sanitizeUTF8(text)))
+	}
+
+	t.traceNormalize(text, normalized)
+
+	return normalized
 }
 
 // GetModel returns the tokenizer model name.
@@ -0,0 +1,141 @@
+package tokenizer
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	// gallopBytesPerToken is the first prefix length tried per token of
+	// budget when searching long texts, so they are not counted whole.
+	gallopBytesPerToken = 8
+
+	paragraphBreak = "\n\n"
+	lineBreak      = "\n"
+	wordBreaks     = " \t"
+
+	errInvalidChunkSizeMsg = "chunk size must be at least one token"
+	errInvalidChunkSizeFmt = "%w: %d"
+)
+
+// ErrInvalidChunkSize is returned by Chunk for a budget below one token.
+var ErrInvalidChunkSize = errors.New(errInvalidChunkSizeMsg)
+
+// Truncate returns a prefix of text that EstimateTokens counts as at most
+// maxTokens, cut at a rune boundary. Text within the budget is returned
+// whole; a budget of zero or less, or below the count of empty text for a
+// model that adds tokens around every input, returns "". Prefix counts grow
+// with length except where a cut splits a special token, so the prefix is
+// the longest one within budget up to such a split.
+func (t *Tokenizer) Truncate(text string, maxTokens int) string {
+	if maxTokens <= 0 {
+		return ""
+	}
+
+	return text[:t.fitPrefix(text, maxTokens)]
+}
+
+// Chunk splits text into consecutive pieces of at most maxTokens each, as
+// EstimateTokens counts them, that join back into text. A piece ends after
+// the last paragraph break, line break or space in its second half, or at
+// a rune boundary when it has none. A single rune that counts more than
+// maxTokens, such as a ligature with a budget of one, is a piece of its own.
+func (t *Tokenizer) Chunk(text string, maxTokens int) ([]string, error) {
+	if maxTokens < 1 {
+		return nil, fmt.Errorf(errInvalidChunkSizeFmt, ErrInvalidChunkSize, maxTokens)
+	}
+
+	var chunks []string
+
+	for text != "" {
+		end := t.fitPrefix(text, maxTokens)
+
+		if end < len(text) {
+			end = breakPoint(text[:end])
+		}
+
+		if end == 0 {
+			_, end = utf8.DecodeRuneInString(text)
+		}
+
+		chunks = append(chunks, text[:end])
+		text = text[end:]
+	}
+
+	return chunks, nil
+}
+
+// fitPrefix returns the length of the longest prefix of text within
+// maxTokens that a search over rune boundaries finds. It gallops up from a
+// short prefix, then bisects between the last prefix that fit and the
+// first that did not.
+func (t *Tokenizer) fitPrefix(text string, maxTokens int) int {
+	fits := func(end int) bool { return t.EstimateTokens(text[:end]) <= maxTokens }
+	lo, hi := 0, len(text)
+
+	for step := min(maxTokens, len(text)) * gallopBytesPerToken; step < len(text); step *= 2 {
+		end := runeStart(text, step)
+		if !fits(end) {
+			hi = end
+
+			break
+		}
+
+		lo = end
+	}
+
+	if hi == len(text) && fits(hi) {
+		return hi
+	}
+
+	for {
+		mid := runeStart(text, lo+(hi-lo)/2)
+		if mid <= lo {
+			_, size := utf8.DecodeRuneInString(text[lo:])
+			mid = lo + size
+		}
+
+		if mid >= hi {
+			return lo
+		}
+
+		if fits(mid) {
+			lo = mid
+		} else {
+			hi = mid
+		}
+	}
+}
+
+// runeStart moves i back to the start of the rune it falls in.
+func runeStart(text string, i int) int {
+	for back := 0; i > 0 && back < utf8.UTFMax-1 && !utf8.RuneStart(text[i]); back++ {
+		i--
+	}
+
+	return i
+}
+
+// breakPoint returns where to end a piece that must not be longer than
+// prefix: after its last paragraph break, line break or word break, the
+// first of these found in the second half of prefix. It returns
+// len(prefix) when there is none.
+func breakPoint(prefix string) int {
+	half := len(prefix) / 2
+
+	if i := strings.LastIndex(prefix, paragraphBreak); i >= half {
+		return i + len(paragraphBreak)
+	}
+
+	if i := strings.LastIndex(prefix, lineBreak); i >= half {
+		return i + len(lineBreak)
+	}
+
+	if i := strings.LastIndexAny(prefix, wordBreaks); i >= half {
+		return i + 1
+	}
+
+	return len(prefix)
+}
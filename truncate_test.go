@@ -0,0 +1,143 @@
+package tokenizer_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	tokenizer "github.com/nnikolov3/ai-tokenizer"
+)
+
+const (
+	TruncateFormat      = "Truncate(%q, %d) = %q, want %q"
+	TruncateFitFormat   = "%s: Truncate(%d) = %q (%d tokens), over budget or not a prefix"
+	TruncateShortFormat = "%s: Truncate(%d) = %q, but %q also fits"
+	ChunkJoinFormat     = "%s: chunks %q do not join back into the text"
+	ChunkSizeFormat     = "%s: chunk %q has %d tokens, budget %d"
+	ChunkWantFormat     = "Chunk(%q, %d) = %q, want %q"
+	ChunkErrFormat      = "Chunk(%d) error = %v, want %v"
+)
+
+func TestTruncate(t *testing.T) {
+	t.Parallel()
+
+	tok := tokenizer.NewTokenizer()
+
+	tests := []struct {
+		text string
+		max  int
+		want string
+	}{
+		// "hello" is 3 tokens and the space after it is the fourth.
+		{text: "hello world", max: 3, want: "hello"},
+		{text: "hello world", max: 4, want: "hello "},
+		{text: "hello world", max: 7, want: "hello world"},
+		{text: "hello world", max: 100, want: "hello world"},
+		{text: "hello world", max: 0, want: ""},
+		{text: "héllo", max: 1, want: "hé"},
+		{text: "", max: 5, want: ""},
+	}
+
+	for _, tt := range tests {
+		if got := tok.Truncate(tt.text, tt.max); got != tt.want {
+			t.Errorf(TruncateFormat, tt.text, tt.max, got, tt.want)
+		}
+	}
+}
+
+func TestTruncateFitsEveryBudget(t *testing.T) {
+	t.Parallel()
+
+	text := strings.Repeat("naïve café, 日本語 text; ", 40) + ChatPrompt
+
+	for name, tok := range getCounterTokenizers(t) {
+		total := tok.EstimateTokens(text)
+
+		// Below the count of empty text, such as [CLS] [SEP], nothing fits.
+		for budget := max(1, tok.EstimateTokens("")); budget <= total; budget += max(1, total/37) {
+			got := tok.Truncate(text, budget)
+			n := tok.EstimateTokens(got)
+
+			if n > budget || !strings.HasPrefix(text, got) {
+				t.Fatalf(TruncateFitFormat, name, budget, got, n)
+			}
+
+			// Counts of prefixes of this text only grow, so the
+			// prefix one rune longer must not fit.
+			if rest := text[len(got):]; rest != "" {
+				longer := got + string([]rune(rest)[0])
+				if tok.EstimateTokens(longer) <= budget {
+					t.Errorf(TruncateShortFormat, name, budget, got, longer)
+				}
+			}
+		}
+	}
+}
+
+func TestChunk(t *testing.T) {
+	t.Parallel()
+
+	tok := tokenizer.NewTokenizer()
+
+	tests := []struct {
+		text string
+		max  int
+		want []string
+	}{
+		{text: "hello world", max: 100, want: []string{"hello world"}},
+		// "hello world " is 8 tokens.
+		{text: "hello world again", max: 8, want: []string{"hello world ", "again"}},
+		{text: "hello world again", max: 6, want: []string{"hello ", "world ", "again"}},
+		// A paragraph break wins over a later space.
+		{text: "aa bb\n\ncc dd ee", max: 6, want: []string{"aa bb\n\n", "cc dd ee"}},
+		// No break in the second half: cut at the budget.
+		{text: "abcdefghij", max: 2, want: []string{"abcd", "efgh", "ij"}},
+		// A ligature folds to two letters and still is one chunk.
+		{text: "ﬃ", max: 1, want: []string{"ﬃ"}},
+	}
+
+	for _, tt := range tests {
+		got, err := tok.Chunk(tt.text, tt.max)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if strings.Join(got, "|") != strings.Join(tt.want, "|") {
+			t.Errorf(ChunkWantFormat, tt.text, tt.max, got, tt.want)
+		}
+	}
+}
+
+func TestChunkCoversText(t *testing.T) {
+	t.Parallel()
+
+	text := strings.Repeat("A paragraph of prose, with naïve words.\nAnother line here.\n\n", 50)
+
+	for name, tok := range getCounterTokenizers(t) {
+		for _, budget := range []int{1, 5, 17, 100, 1000} {
+			chunks, err := tok.Chunk(text, budget)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if strings.Join(chunks, "") != text {
+				t.Fatalf(ChunkJoinFormat, name, chunks)
+			}
+
+			for _, chunk := range chunks {
+				if n := tok.EstimateTokens(chunk); n > budget && len([]rune(chunk)) > 1 {
+					t.Errorf(ChunkSizeFormat, name, chunk, n, budget)
+				}
+			}
+		}
+	}
+}
+
+func TestChunkRejectsEmptyBudget(t *testing.T) {
+	t.Parallel()
+
+	_, err := tokenizer.NewTokenizer().Chunk(HelloWorld, 0)
+	if !errors.Is(err, tokenizer.ErrInvalidChunkSize) {
+		t.Errorf(ChunkErrFormat, 0, err, tokenizer.ErrInvalidChunkSize)
+	}
+}
@@ -0,0 +1,206 @@
+package tokenizer
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"unicode/utf8"
+)
+
+const (
+	// spUnknownPenalty lowers the score of unknown characters below every
+	// vocabulary piece, as SentencePiece does.
+	spUnknownPenalty = 10.0
+	// spUserDefinedDiscount keeps user-defined pieces just below a perfect
+	// score so equal-length normal pieces do not tie with them.
+	spUserDefinedDiscount = 0.1
+	// spFloatMin is C's FLT_MIN, SentencePiece's starting maximum score.
+	spFloatMin = 1.17549435e-38
+	byteValues = 256
+
+	errMissingUnknownMsg   = "no unknown piece"
+	errMissingBytePieceMsg = "byte fallback enabled but piece missing"
+	errMissingBytePieceFmt = "%s %s"
+)
+
+// unigram is a scored vocabulary segmented by Viterbi search, shared by
+// SentencePiece models and tokenizer.json Unigram models.
+type unigram struct {
+	index        map[string]int
+	pieces       []spPiece
+	byteIDs      [byteValues]int
+	unknownID    int
+	maxPieceLen  int
+	minScore     float32
+	maxScore     float32
+	byteFallback bool
+}
+
+// spBestPath is the best lattice path ending at one byte position.
+type spBestPath struct {
+	id       int
+	score    float32
+	startsAt int
+}
+
+func newUnigram() unigram {
+	model := unigram{
+		index:        map[string]int{},
+		pieces:       nil,
+		byteIDs:      [byteValues]int{},
+		unknownID:    -1,
+		maxPieceLen:  0,
+		minScore:     math.MaxFloat32,
+		maxScore:     spFloatMin,
+		byteFallback: false,
+	}
+
+	for value := range model.byteIDs {
+		model.byteIDs[value] = -1
+	}
+
+	return model
+}
+
+// add appends a piece with the next ID.
+func (m *unigram) add(piece spPiece) {
+	m.indexPiece(len(m.pieces), piece)
+	m.pieces = append(m.pieces, piece)
+}
+
+// indexPiece records where the encoder can find a piece. Normal,
+// user-defined and unused pieces are matched against text; unused ones are
+// then skipped during encoding.
+func (m *unigram) indexPiece(id int, piece spPiece) {
+	switch piece.kind {
+	case spPieceNormal:
+		m.minScore = min(m.minScore, piece.score)
+		m.maxScore = max(m.maxScore, piece.score)
+	case spPieceUnknown:
+		m.unknownID = id
+
+		return
+	case spPieceByte:
+		var value byte
+		if _, err := fmt.Sscanf(piece.text, spBytePieceFmt, &value); err == nil {
+			m.byteIDs[value] = id
+		}
+
+		return
+	case spPieceControl:
+		return
+	}
+
+	m.index[piece.text] = id
+	m.maxPieceLen = max(m.maxPieceLen, len(piece.text))
+}
+
+func (m *unigram) validate() error {
+	if m.unknownID < 0 {
+		return errors.New(errMissingUnknownMsg)
+	}
+
+	if !m.byteFallback {
+		return nil
+	}
+
+	for value, id := range m.byteIDs {
+		if id < 0 {
+			return fmt.Errorf(errMissingBytePieceFmt, errMissingBytePieceMsg, fmt.Sprintf(spBytePieceFmt, value))
+		}
+	}
+
+	return nil
+}
+
+// encode segments normalized text into piece IDs.
+func (m *unigram) encode(normalized string) []int {
+	if normalized == "" {
+		return nil
+	}
+
+	return m.backtrack(normalized, m.viterbi(normalized))
+}
+
+// viterbi fills the best path ending at every byte offset and returns it.
+func (m *unigram) viterbi(normalized string) []spBestPath {
+	size := len(normalized)
+	best := make([]spBestPath, size+1)
+
+	for i := range best {
+		best[i].startsAt = -1
+	}
+
+	unknownScore := m.minScore - spUnknownPenalty
+
+	for start := 0; start < size; {
+		_, charLen := utf8.DecodeRuneInString(normalized[start:])
+		base := best[start].score
+		hasSingleChar := false
+
+		for end := start + 1; end <= min(size, start+m.maxPieceLen); end++ {
+			id, ok := m.index[normalized[start:end]]
+			if !ok || m.pieces[id].kind == spPieceUnused {
+				continue
+			}
+
+			best[end].offer(id, start, base+m.pieceScore(id, end-start))
+			hasSingleChar = hasSingleChar || end-start == charLen
+		}
+
+		if !hasSingleChar {
+			best[start+charLen].offer(m.unknownID, start, base+unknownScore)
+		}
+
+		start += charLen
+	}
+
+	return best
+}
+
+func (p *spBestPath) offer(id, startsAt int, score float32) {
+	if p.startsAt == -1 || score > p.score {
+		p.id = id
+		p.startsAt = startsAt
+		p.score = score
+	}
+}
+
+func (m *unigram) pieceScore(id, length int) float32 {
+	if m.pieces[id].kind == spPieceUserDefined {
+		return float32(float64(length)*float64(m.maxScore) - spUserDefinedDiscount)
+	}
+
+	return m.pieces[id].score
+}
+
+// backtrack walks the best path from the end of the text. Unknown spans become byte
+// pieces under byte fallback; otherwise adjacent unknowns merge into one.
+func (m *unigram) backtrack(normalized string, best []spBestPath) []int {
+	var ids []int
+
+	prevUnknown := false
+
+	for end := len(normalized); end > 0; end = best[end].startsAt {
+		node := best[end]
+		unknown := node.id == m.unknownID
+
+		switch {
+		case unknown && m.byteFallback:
+			for i := end - 1; i >= node.startsAt; i-- {
+				ids = append(ids, m.byteIDs[normalized[i]])
+			}
+		case unknown && prevUnknown:
+			// Merged into the unknown piece already emitted.
+		default:
+			ids = append(ids, node.id)
+		}
+
+		prevUnknown = unknown
+	}
+
+	slices.Reverse(ids)
+
+	return ids
+}
@@ -0,0 +1,208 @@
+package tokenizer
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// InvalidUTF8Policy selects how malformed UTF-8 input is counted.
+type InvalidUTF8Policy int
+
+const (
+	// InvalidUTF8Skip drops invalid bytes. This is the default and matches
+	// earlier releases.
+	InvalidUTF8Skip InvalidUTF8Policy = iota
+	// InvalidUTF8Replace substitutes one InvalidUTF8Replacement per maximal
+	// run of invalid bytes, so each run counts as one special-character token.
+	InvalidUTF8Replace
+	// InvalidUTF8CountBytes counts every invalid byte as one token, the way
+	// byte-level BPE models encode them.
+	InvalidUTF8CountBytes
+	// InvalidUTF8Reject makes Analyze fail with *InvalidUTF8Error.
+	InvalidUTF8Reject
+)
+
+const (
+	// InvalidUTF8Replacement stands in for invalid input. It must be ASCII
+	// to survive normalization.
+	InvalidUTF8Replacement = "?"
+
+	// Policy names accepted by ParseInvalidUTF8Policy.
+	PolicyNameSkip    = "skip"
+	PolicyNameReplace = "replace"
+	PolicyNameBytes   = "bytes"
+	PolicyNameError   = "error"
+
+	errInvalidUTF8Msg       = "invalid UTF-8"
+	errUnknownPolicyMsg     = "unknown invalid UTF-8 policy"
+	errInvalidUTF8DetailFmt = "%s: %d invalid run(s), first at byte %d"
+	errUnknownPolicyFmt     = "%w %q (want skip, replace, bytes or error)"
+)
+
+var (
+	// ErrInvalidUTF8 matches every *InvalidUTF8Error via errors.Is.
+	ErrInvalidUTF8 = errors.New(errInvalidUTF8Msg)
+	// ErrUnknownPolicy is returned by ParseInvalidUTF8Policy.
+	ErrUnknownPolicy = errors.New(errUnknownPolicyMsg)
+)
+
+// InvalidUTF8Error reports the starting byte offsets of the runs of
+// malformed UTF-8.
+type InvalidUTF8Error struct {
+	Offsets []int
+}
+
+func (e *InvalidUTF8Error) Error() string {
+	return fmt.Sprintf(errInvalidUTF8DetailFmt, errInvalidUTF8Msg, len(e.Offsets), e.Offsets[0])
+}
+
+// Unwrap lets errors.Is match ErrInvalidUTF8.
+func (e *InvalidUTF8Error) Unwrap() error { return ErrInvalidUTF8 }
+
+// Analysis is the detailed result of Analyze.
+type Analysis struct {
+	// InvalidUTF8Runs holds the starting byte offset of each maximal run
+	// of invalid UTF-8 bytes.
+	InvalidUTF8Runs []int
+	// SpecialTokens counts each special token found, whatever the policy.
+	SpecialTokens map[string]int
+	TokenCount    int
+}
+
+// WithInvalidUTF8Policy sets how malformed UTF-8 is treated.
+func WithInvalidUTF8Policy(policy InvalidUTF8Policy) Option {
+	return func(t *Tokenizer) {
+		t.invalidUTF8 = policy
+	}
+}
+
+// ParseInvalidUTF8Policy converts a policy name to its value.
+func ParseInvalidUTF8Policy(name string) (InvalidUTF8Policy, error) {
+	switch name {
+	case PolicyNameSkip:
+		return InvalidUTF8Skip, nil
+	case PolicyNameReplace:
+		return InvalidUTF8Replace, nil
+	case PolicyNameBytes:
+		return InvalidUTF8CountBytes, nil
+	case PolicyNameError:
+		return InvalidUTF8Reject, nil
+	default:
+		return InvalidUTF8Skip, fmt.Errorf(errUnknownPolicyFmt, ErrUnknownPolicy, name)
+	}
+}
+
+// String returns the name accepted by ParseInvalidUTF8Policy.
+func (p InvalidUTF8Policy) String() string {
+	switch p {
+	case InvalidUTF8Replace:
+		return PolicyNameReplace
+	case InvalidUTF8CountBytes:
+		return PolicyNameBytes
+	case InvalidUTF8Reject:
+		return PolicyNameError
+	default:
+		return PolicyNameSkip
+	}
+}
+
+// Analyze estimates tokens and reports where the input is not valid UTF-8
+// and which special tokens it contains. Under InvalidUTF8Reject it returns
+// *InvalidUTF8Error for malformed input, and under SpecialTokenReject
+// *SpecialTokenError for input with special tokens.
+func (t *Tokenizer) Analyze(text string) (Analysis, error) {
+	matches := t.findSpecialTokens(text)
+	analysis := Analysis{
+		InvalidUTF8Runs: InvalidUTF8Runs(text),
+		SpecialTokens:   countSpecialTokens(matches),
+		TokenCount:      0,
+	}
+
+	if len(analysis.InvalidUTF8Runs) > 0 && t.invalidUTF8 == InvalidUTF8Reject {
+		return analysis, &InvalidUTF8Error{Offsets: analysis.InvalidUTF8Runs}
+	}
+
+	err := t.rejectSpecialTokens(matches)
+	if err != nil {
+		return analysis, err
+	}
+
+	analysis.TokenCount = t.EstimateTokens(text)
+
+	return analysis, nil
+}
+
+// InvalidUTF8Runs returns the starting byte offset of each maximal run of
+// bytes that do not decode as UTF-8, so "ab\xff\xfecd" has one run at 2.
+// It returns nil for valid input.
+func InvalidUTF8Runs(text string) []int {
+	var offsets []int
+
+	forEachInvalidRun(text, func(start, _ int) {
+		offsets = append(offsets, start)
+	})
+
+	return offsets
+}
+
+// sanitizeUTF8 rewrites invalid sequences according to the policy. Skip and
+// Reject leave them in place; normalization drops them as U+FFFD.
+func (t *Tokenizer) sanitizeUTF8(text string) string {
+	if t.invalidUTF8 != InvalidUTF8Replace && t.invalidUTF8 != InvalidUTF8CountBytes {
+		return text
+	}
+
+	if utf8.ValidString(text) {
+		return text
+	}
+
+	var builder strings.Builder
+	builder.Grow(len(text))
+
+	last := 0
+	perByte := t.invalidUTF8 == InvalidUTF8CountBytes
+
+	forEachInvalidRun(text, func(start, end int) {
+		builder.WriteString(text[last:start])
+
+		count := 1
+		if perByte {
+			count = end - start
+		}
+
+		builder.WriteString(strings.Repeat(InvalidUTF8Replacement, count))
+		last = end
+	})
+
+	builder.WriteString(text[last:])
+
+	return builder.String()
+}
+
+// forEachInvalidRun calls fn with the [start, end) byte range of every
+// maximal run of undecodable bytes. A well-formed U+FFFD is not a run.
+func forEachInvalidRun(text string, fn func(start, end int)) {
+	runStart := -1
+
+	for i := 0; i < len(text); {
+		r, size := utf8.DecodeRuneInString(text[i:])
+		invalid := r == utf8.RuneError && size == 1
+
+		switch {
+		case invalid && runStart < 0:
+			runStart = i
+		case !invalid && runStart >= 0:
+			fn(runStart, i)
+
+			runStart = -1
+		}
+
+		i += size
+	}
+
+	if runStart >= 0 {
+		fn(runStart, len(text))
+	}
+}
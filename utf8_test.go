@@ -0,0 +1,130 @@
+package tokenizer_test
+
+import (
+	"errors"
+	"slices"
+	"testing"
+
+	tokenizer "github.com/nnikolov3/ai-tokenizer"
+)
+
+const (
+	// "ab", two invalid bytes, "cd", a valid U+FFFD, then one invalid byte.
+	InvalidUTF8Text = "ab\xff\xfecd�\x80"
+
+	PolicyEstimateErrorFormat = "policy %v: EstimateTokens(%q) = %d, want %d"
+	PolicyNormalizeFormat     = "policy %v: Normalize(%q) = %q, want %q"
+	InvalidRunsFormat         = "InvalidUTF8Runs(%q) = %v, want %v"
+	AnalyzeErrorFormat        = "Analyze(%q) error = %v, want %v"
+	AnalyzeRunsFormat         = "Analyze(%q) runs = %v, want %v"
+	ParsePolicyFormat         = "ParseInvalidUTF8Policy(%q) = %v, %v; want %v"
+)
+
+type InvalidUTF8TestCase struct {
+	name       string
+	normalized string
+	policy     tokenizer.InvalidUTF8Policy
+	expected   int
+}
+
+func getInvalidUTF8TestCases() []InvalidUTF8TestCase {
+	return []InvalidUTF8TestCase{
+		// "abcd" -> 2 tokens; invalid bytes and U+FFFD are dropped.
+		{"skip drops invalid bytes", "abcd", tokenizer.InvalidUTF8Skip, 2},
+		// ab ? cd ? -> 1 + 1 + 1 + 1.
+		{"replace counts each sequence", "ab?cd?", tokenizer.InvalidUTF8Replace, 4},
+		// ab ?? cd ? -> 1 + 2 + 1 + 1.
+		{"bytes counts each byte", "ab??cd?", tokenizer.InvalidUTF8CountBytes, 5},
+		{"reject counts like skip", "abcd", tokenizer.InvalidUTF8Reject, 2},
+	}
+}
+
+func TestInvalidUTF8Policies(t *testing.T) {
+	t.Parallel()
+
+	for _, testCase := range getInvalidUTF8TestCases() {
+		t.Run(testCase.name, func(t *testing.T) {
+			t.Parallel()
+			validateInvalidUTF8Policy(t, testCase)
+		})
+	}
+}
+
+func validateInvalidUTF8Policy(t *testing.T, testCase InvalidUTF8TestCase) {
+	t.Helper()
+
+	tok := tokenizer.NewTokenizer(tokenizer.WithInvalidUTF8Policy(testCase.policy))
+
+	normalized := tok.Normalize(InvalidUTF8Text)
+	if normalized != testCase.normalized {
+		t.Errorf(PolicyNormalizeFormat, testCase.policy, InvalidUTF8Text, normalized, testCase.normalized)
+	}
+
+	count := tok.EstimateTokens(InvalidUTF8Text)
+	if count != testCase.expected {
+		t.Errorf(PolicyEstimateErrorFormat, testCase.policy, InvalidUTF8Text, count, testCase.expected)
+	}
+}
+
+func TestInvalidUTF8Runs(t *testing.T) {
+	t.Parallel()
+
+	want := []int{2, 9}
+
+	got := tokenizer.InvalidUTF8Runs(InvalidUTF8Text)
+	if !slices.Equal(got, want) {
+		t.Errorf(InvalidRunsFormat, InvalidUTF8Text, got, want)
+	}
+
+	if got := tokenizer.InvalidUTF8Runs(CafeUnicode); got != nil {
+		t.Errorf(InvalidRunsFormat, CafeUnicode, got, nil)
+	}
+}
+
+func TestAnalyzeReportsInvalidUTF8(t *testing.T) {
+	t.Parallel()
+
+	strict := tokenizer.NewTokenizer(tokenizer.WithInvalidUTF8Policy(tokenizer.InvalidUTF8Reject))
+
+	analysis, err := strict.Analyze(InvalidUTF8Text)
+	if !errors.Is(err, tokenizer.ErrInvalidUTF8) {
+		t.Errorf(AnalyzeErrorFormat, InvalidUTF8Text, err, tokenizer.ErrInvalidUTF8)
+	}
+
+	var invalidErr *tokenizer.InvalidUTF8Error
+	if !errors.As(err, &invalidErr) || !slices.Equal(invalidErr.Offsets, analysis.InvalidUTF8Runs) {
+		t.Errorf(AnalyzeRunsFormat, InvalidUTF8Text, invalidErr, analysis.InvalidUTF8Runs)
+	}
+
+	lenient := tokenizer.NewTokenizer()
+
+	analysis, err = lenient.Analyze(InvalidUTF8Text)
+	if err != nil {
+		t.Errorf(AnalyzeErrorFormat, InvalidUTF8Text, err, nil)
+	}
+
+	if len(analysis.InvalidUTF8Runs) != 2 {
+		t.Errorf(AnalyzeRunsFormat, InvalidUTF8Text, analysis.InvalidUTF8Runs, []int{2, 9})
+	}
+}
+
+func TestParseInvalidUTF8Policy(t *testing.T) {
+	t.Parallel()
+
+	for _, policy := range []tokenizer.InvalidUTF8Policy{
+		tokenizer.InvalidUTF8Skip,
+		tokenizer.InvalidUTF8Replace,
+		tokenizer.InvalidUTF8CountBytes,
+		tokenizer.InvalidUTF8Reject,
+	} {
+		got, err := tokenizer.ParseInvalidUTF8Policy(policy.String())
+		if err != nil || got != policy {
+			t.Errorf(ParsePolicyFormat, policy.String(), got, err, policy)
+		}
+	}
+
+	_, err := tokenizer.ParseInvalidUTF8Policy("ignore")
+	if !errors.Is(err, tokenizer.ErrUnknownPolicy) {
+		t.Errorf(ParsePolicyFormat, "ignore", nil, err, tokenizer.ErrUnknownPolicy)
+	}
+}
@@ -0,0 +1,244 @@
+package tokenizer
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"unicode"
+)
+
+// Special tokens every BERT vocab.txt defines.
+const (
+	WordPieceUnknown = "[UNK]"
+	WordPieceCLS     = "[CLS]"
+	WordPieceSEP     = "[SEP]"
+	WordPiecePad     = "[PAD]"
+	WordPieceMask    = "[MASK]"
+
+	errWordPieceMsg       = "invalid WordPiece vocabulary"
+	errWordPieceTokenFmt  = "%w: missing %s"
+	errWordPieceEmptyFmt  = "%w: empty"
+	errLoadWordPieceFmt   = "load WordPiece vocabulary %q: %w"
+	wordPieceSpecialCount = 2
+)
+
+// ErrInvalidWordPiece is returned for vocabularies that lack the special
+// tokens a BERT model needs.
+var ErrInvalidWordPiece = errors.New(errWordPieceMsg)
+
+// WordPieceModel is a BERT-family tokenizer built from a vocab.txt file: the
+// BERT basic tokenizer, greedy longest-match-first WordPiece with ##
+// continuations, and [CLS] ... [SEP] around every sequence. It implements
+// Encoder, Normalizer, OrdinaryEncoder, SpecialTokenLister and
+// SpecialTokenCounter and is safe for concurrent use.
+type WordPieceModel struct {
+	normalizer   hfNormalizer
+	preTokenizer hfPreTokenizer
+	model        *hfWordPiece
+	special      addedTokenSet
+	clsID        int
+	sepID        int
+}
+
+// WordPieceOption configures LoadWordPiece and ParseWordPiece.
+type WordPieceOption func(*wordPieceConfig)
+
+type wordPieceConfig struct {
+	lowercase bool
+	maxChars  int
+}
+
+// WithWordPieceLowercase selects uncased (the default) or cased models.
+// Uncased models lowercase and strip accents; cased models do neither.
+func WithWordPieceLowercase(lowercase bool) WordPieceOption {
+	return func(c *wordPieceConfig) {
+		c.lowercase = lowercase
+	}
+}
+
+// WithWordPieceMaxChars sets the word length beyond which a word becomes
+// [UNK]. The default is 100, as in BERT.
+func WithWordPieceMaxChars(maxChars int) WordPieceOption {
+	return func(c *wordPieceConfig) {
+		c.maxChars = maxChars
+	}
+}
+
+// LoadWordPiece reads a vocab.txt file with one token per line.
+func LoadWordPiece(path string, opts ...WordPieceOption) (*WordPieceModel, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf(errLoadWordPieceFmt, path, err)
+	}
+
+	model, err := ParseWordPiece(data, opts...)
+	if err != nil {
+		return nil, fmt.Errorf(errLoadWordPieceFmt, path, err)
+	}
+
+	return model, nil
+}
+
+// ParseWordPiece builds a model from vocab.txt content. A token's ID is its
+// zero-based line number.
+func ParseWordPiece(data []byte, opts ...WordPieceOption) (*WordPieceModel, error) {
+	config := wordPieceConfig{lowercase: true, maxChars: defaultWordPieceMaxChars}
+	for _, opt := range opts {
+		opt(&config)
+	}
+
+	vocab := parseVocabLines(string(data))
+	if len(vocab) == 0 {
+		return nil, fmt.Errorf(errWordPieceEmptyFmt, ErrInvalidWordPiece)
+	}
+
+	ids := make(map[string]int, wordPieceSpecialCount+1)
+
+	for _, token := range []string{WordPieceUnknown, WordPieceCLS, WordPieceSEP} {
+		id, ok := vocab[token]
+		if !ok {
+			return nil, fmt.Errorf(errWordPieceTokenFmt, ErrInvalidWordPiece, token)
+		}
+
+		ids[token] = id
+	}
+
+	return &WordPieceModel{
+		normalizer:   basicNormalizer(config.lowercase),
+		preTokenizer: basicPreTokenizer(),
+		model: &hfWordPiece{
+			vocab:     vocab,
+			prefix:    defaultWordPiecePrefix,
+			unknownID: ids[WordPieceUnknown],
+			maxChars:  config.maxChars,
+		},
+		special: specialTokens(vocab),
+		clsID:   ids[WordPieceCLS],
+		sepID:   ids[WordPieceSEP],
+	}, nil
+}
+
+// parseVocabLines maps each line to its index. Later duplicates win, as in
+// the reference loader.
+func parseVocabLines(text string) map[string]int {
+	if text == "" {
+		return nil
+	}
+
+	lines := strings.Split(strings.TrimSuffix(text, "\n"), "\n")
+
+	vocab := make(map[string]int, len(lines))
+	for id, line := range lines {
+		vocab[strings.TrimSuffix(line, "\r")] = id
+	}
+
+	return vocab
+}
+
+// basicNormalizer is BERT's basic tokenizer cleanup: control characters
+// dropped, CJK ideographs padded, and for uncased models lowercase and
+// accents stripped.
+func basicNormalizer(lowercase bool) hfNormalizer {
+	return bertNormalizer(hfNormalizerSpec{
+		StripAccents:        nil,
+		Pattern:             hfPattern{String: nil, Regex: nil},
+		Type:                "",
+		Content:             "",
+		Prepend:             "",
+		Normalizers:         nil,
+		PrecompiledCharsmap: nil,
+		StripLeft:           false,
+		StripRight:          false,
+		CleanText:           true,
+		HandleChineseChars:  true,
+		Lowercase:           lowercase,
+	})
+}
+
+// basicPreTokenizer splits on whitespace and isolates punctuation.
+func basicPreTokenizer() hfPreTokenizer {
+	return sequencePreTokenizer(
+		splitPreTokenizer(runeFinder(unicode.IsSpace), behaviorRemoved, false),
+		splitPreTokenizer(runeFinder(isHFPunctuation), behaviorIsolated, false),
+	)
+}
+
+// specialTokens keeps the standard special tokens present in vocab as single
+// tokens when they appear in input text.
+func specialTokens(vocab map[string]int) addedTokenSet {
+	var tokens []hfAddedToken
+
+	for _, content := range []string{WordPieceUnknown, WordPieceCLS, WordPieceSEP, WordPiecePad, WordPieceMask} {
+		if id, ok := vocab[content]; ok {
+			tokens = append(tokens, hfAddedToken{
+				Content: content, ID: id, SingleWord: false, LStrip: false,
+				RStrip: false, Normalized: false, Special: true,
+			})
+		}
+	}
+
+	return splitAddedTokens(tokens).raw
+}
+
+// Encode returns the WordPiece IDs of text wrapped in [CLS] and [SEP], the
+// sequence a BERT model sees. Empty text encodes to [CLS] [SEP], as with
+// the same vocabulary in a tokenizer.json.
+func (m *WordPieceModel) Encode(text string) []int {
+	return m.encode(text, m.special)
+}
+
+// EncodeOrdinary is Encode with special tokens in text treated as plain
+// text, so [MASK] becomes "[", "mask", "]".
+func (m *WordPieceModel) EncodeOrdinary(text string) []int {
+	return m.encode(text, nil)
+}
+
+// SpecialTokens lists the special tokens present in the vocabulary.
+func (m *WordPieceModel) SpecialTokens() []string {
+	tokens := make([]string, 0, len(m.special))
+	for _, tok := range m.special {
+		tokens = append(tokens, tok.Content)
+	}
+
+	slices.Sort(tokens)
+
+	return tokens
+}
+
+func (m *WordPieceModel) encode(text string, special addedTokenSet) []int {
+	ids := []int{m.clsID}
+
+	for _, seg := range special.split(text) {
+		if seg.id >= 0 {
+			ids = append(ids, seg.id)
+
+			continue
+		}
+
+		for _, word := range m.preTokenizer([]string{m.Normalize(seg.text)}, seg.start == 0) {
+			if word != "" {
+				ids = append(ids, m.model.tokenize(word)...)
+			}
+		}
+	}
+
+	return append(ids, m.sepID)
+}
+
+// Normalize applies the basic tokenizer's cleanup, casing and accent rules.
+func (m *WordPieceModel) Normalize(text string) string {
+	return m.normalizer(text)
+}
+
+// SpecialTokenCount reports how many tokens Encode adds around the text:
+// one [CLS] and one [SEP]. Subtract it from a model's limit to size chunks.
+func (m *WordPieceModel) SpecialTokenCount() int {
+	return wordPieceSpecialCount
+}
+
+// VocabSize returns the number of vocabulary lines.
+func (m *WordPieceModel) VocabSize() int {
+	return len(m.model.vocab)
+}
@@ -0,0 +1,121 @@
+package tokenizer_test
+
+import (
+	"errors"
+	"os"
+	"slices"
+	"testing"
+
+	tokenizer "github.com/nnikolov3/ai-tokenizer"
+)
+
+const (
+	VocabPath        = "testdata/vocab.txt"
+	MissingVocabPath = "testdata/missing.txt"
+	NoCLSVocab       = "[UNK]\n[SEP]\nhello\n"
+
+	LoadVocabFormat      = "LoadWordPiece(%q) error = %v"
+	LoadVocabErrFormat   = "LoadWordPiece(%q) error = %v, want %v"
+	ParseVocabErrFormat  = "ParseWordPiece(%q) error = %v, want %v"
+	SpecialCountFormat   = "SpecialTokenCount() = %d, want %d"
+	WordPieceCountFormat = "EstimateTokens(%q) = %d, want %d"
+	ContentCountFormat   = "EstimateContentTokens(%q) = %d, want %d"
+)
+
+// IDs refer to testdata/vocab.txt: 1 [UNK], 2 [CLS], 3 [SEP], 4 [MASK],
+// 5 hello, 6 world, 7 !, 8 un, 9 ##aff, 10 ##able, 11 cafe, 12 中,
+// 13 ",", 14 Hello, 15 ##s.
+func getWordPieceTestCases() []HFTestCase {
+	return []HFTestCase{
+		{"lowercase and punctuation", "Hello, World!", []int{2, 5, 13, 6, 7, 3}},
+		{"continuations", "unaffable café", []int{2, 8, 9, 10, 11, 3}},
+		{"suffix piece", "hellos", []int{2, 5, 15, 3}},
+		{"unmatched word is unknown", "xyz", []int{2, 1, 3}},
+		{"chinese characters split", "中文", []int{2, 12, 1, 3}},
+		{"special token kept", "[MASK] hello", []int{2, 4, 5, 3}},
+		{"empty", "", []int{2, 3}},
+	}
+}
+
+func loadWordPiece(t *testing.T, opts ...tokenizer.WordPieceOption) *tokenizer.WordPieceModel {
+	t.Helper()
+
+	model, err := tokenizer.LoadWordPiece(VocabPath, opts...)
+	if err != nil {
+		t.Fatalf(LoadVocabFormat, VocabPath, err)
+	}
+
+	return model
+}
+
+func runWordPieceTestCases(t *testing.T, model *tokenizer.WordPieceModel, testCases []HFTestCase) {
+	t.Helper()
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			t.Parallel()
+
+			if got := model.Encode(testCase.input); !slices.Equal(got, testCase.expected) {
+				t.Errorf(EncodeFormat, testCase.input, got, testCase.expected)
+			}
+		})
+	}
+}
+
+func TestWordPieceEncode(t *testing.T) {
+	t.Parallel()
+	runWordPieceTestCases(t, loadWordPiece(t), getWordPieceTestCases())
+}
+
+func TestWordPieceCased(t *testing.T) {
+	t.Parallel()
+
+	runWordPieceTestCases(t, loadWordPiece(t, tokenizer.WithWordPieceLowercase(false)), []HFTestCase{
+		{"case kept", "Hello hello", []int{2, 14, 5, 3}},
+		{"accents kept", "café", []int{2, 1, 3}},
+	})
+}
+
+func TestWordPieceMaxChars(t *testing.T) {
+	t.Parallel()
+
+	runWordPieceTestCases(t, loadWordPiece(t, tokenizer.WithWordPieceMaxChars(3)), []HFTestCase{
+		{"long word is unknown", "hello un", []int{2, 1, 8, 3}},
+	})
+}
+
+func TestWordPieceCountsSpecialTokens(t *testing.T) {
+	t.Parallel()
+
+	model := loadWordPiece(t)
+	if got := model.SpecialTokenCount(); got != 2 {
+		t.Errorf(SpecialCountFormat, got, 2)
+	}
+
+	tok := tokenizer.NewTokenizer(tokenizer.WithEncoder("bert", model))
+
+	input := "Hello, World!"
+	if got := tok.EstimateTokens(input); got != 6 {
+		t.Errorf(WordPieceCountFormat, input, got, 6)
+	}
+
+	if got := tok.EstimateContentTokens(input); got != 4 || tok.SpecialTokenCount() != 2 {
+		t.Errorf(ContentCountFormat, input, got, 4)
+	}
+}
+
+func TestWordPieceRejectsBadVocab(t *testing.T) {
+	t.Parallel()
+
+	for _, content := range []string{NoCLSVocab, ""} {
+		_, err := tokenizer.ParseWordPiece([]byte(content))
+		if !errors.Is(err, tokenizer.ErrInvalidWordPiece) {
+			t.Errorf(ParseVocabErrFormat, content, err, tokenizer.ErrInvalidWordPiece)
+		}
+	}
+
+	_, err := tokenizer.LoadWordPiece(MissingVocabPath)
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Errorf(LoadVocabErrFormat, MissingVocabPath, err, os.ErrNotExist)
+	}
+}